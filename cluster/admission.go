@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var errMemPressure = errors.New("node is under memory pressure, retry later")
+
+type MemStat struct {
+	RSS       uint64 `json:"rss"`       // resident set size of this process, in bytes
+	Available uint64 `json:"available"` // available memory of the host, in bytes
+}
+
+// readMemStat reads process RSS from /proc/self/statm and host available memory from /proc/meminfo.
+func readMemStat() (ms MemStat, err error) {
+	var statm []byte
+	if statm, err = ioutil.ReadFile("/proc/self/statm"); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		err = errors.Errorf("invalid /proc/self/statm: %v", string(statm))
+		return
+	}
+	var pages uint64
+	if pages, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	ms.RSS = pages * uint64(os.Getpagesize())
+
+	var meminfo []byte
+	if meminfo, err = ioutil.ReadFile("/proc/meminfo"); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(meminfo))
+	for scanner.Scan() {
+		// MemAvailable:   12345678 kB
+		fields = strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		var kb uint64
+		if kb, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+		ms.Available = kb << 10
+		return
+	}
+	err = errors.Errorf("MemAvailable is absent in /proc/meminfo")
+	return
+}
+
+// underMemPressure returns true if the process RSS goes above MaxRSSMB, or the host available memory goes below MinAvailMemMB.
+// A zero watermark is disabled.
+func (ctl *Controller) underMemPressure() (pressure bool, ms MemStat) {
+	if ctl.conf.MaxRSSMB == 0 && ctl.conf.MinAvailMemMB == 0 {
+		return
+	}
+	var err error
+	if ms, err = ctl.readMemStat(); err != nil {
+		log.Errorf("got error %+v", err)
+		return
+	}
+	if ctl.conf.MaxRSSMB != 0 && ms.RSS > uint64(ctl.conf.MaxRSSMB)<<20 {
+		pressure = true
+	}
+	if ctl.conf.MinAvailMemMB != 0 && ms.Available < uint64(ctl.conf.MinAvailMemMB)<<20 {
+		pressure = true
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// APIKeyHeader carries an API key of a request, as an alternative to the bearer token in the Authorization header.
+const APIKeyHeader = "X-API-Key"
+
+// MinMgmtKeyLen is the minimal length of mgmt keys, which are stronger credentials than API keys since they move and wipe vectodblites.
+const MinMgmtKeyLen = 32
+
+var errUnauthorized = errors.New("missing or invalid credential")
+
+// validateKeys checks that mgmt keys are required along with API keys, long enough, and not usable as API keys.
+func (conf *ControllerConf) validateKeys() (err error) {
+	if len(conf.APIKeys) != 0 && len(conf.MgmtKeys) == 0 {
+		err = errors.New("invalid auth config, mgmt keys are required along with API keys")
+		return
+	}
+	for _, mgmtKey := range conf.MgmtKeys {
+		if len(mgmtKey) < MinMgmtKeyLen {
+			err = errors.Errorf("invalid mgmt key of length %v, want >= %v", len(mgmtKey), MinMgmtKeyLen)
+			return
+		}
+		if matchKey(conf.APIKeys, mgmtKey) {
+			err = errors.New("invalid auth config, a mgmt key is also an API key")
+			return
+		}
+	}
+	for _, apiKey := range conf.APIKeys {
+		if apiKey == "" {
+			err = errors.New("invalid auth config, empty API key")
+			return
+		}
+	}
+	return
+}
+
+// requestKey returns the bearer token of the request, or the API key header if there's no bearer token.
+func requestKey(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return c.GetHeader(APIKeyHeader)
+}
+
+// matchKey compares the key with each of keys in constant time.
+func matchKey(keys []string, key string) (ok bool) {
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			ok = true
+		}
+	}
+	return
+}
+
+// authAPI is a middleware of data endpoints which requires one of APIKeys, or a mgmt key since other nodes forward data requests with it.
+// All requests are allowed if no API key is configured.
+func (ctl *Controller) authAPI(c *gin.Context) {
+	if len(ctl.conf.APIKeys) == 0 {
+		c.Next()
+		return
+	}
+	key := requestKey(c)
+	if !matchKey(ctl.conf.APIKeys, key) && !matchKey(ctl.conf.MgmtKeys, key) {
+		log.Infof("rejected %s from %s, error %+v", c.Request.URL.Path, c.ClientIP(), errUnauthorized)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"err": errUnauthorized.Error()})
+		return
+	}
+	c.Next()
+}
+
+// authMgmt is a middleware of mgmt and debug endpoints which requires one of MgmtKeys. API keys are rejected.
+// All requests are allowed if no mgmt key is configured.
+func (ctl *Controller) authMgmt(c *gin.Context) {
+	if len(ctl.conf.MgmtKeys) == 0 {
+		c.Next()
+		return
+	}
+	if !matchKey(ctl.conf.MgmtKeys, requestKey(c)) {
+		log.Infof("rejected %s from %s, error %+v", c.Request.URL.Path, c.ClientIP(), errUnauthorized)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"err": errUnauthorized.Error()})
+		return
+	}
+	c.Next()
+}
+
+// authTransport authenticates inter-node requests to data and mgmt endpoints with the first mgmt key.
+type authTransport struct {
+	base    http.RoundTripper
+	mgmtKey string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Path, "/api/") || strings.HasPrefix(req.URL.Path, "/mgmt/") {
+		// RoundTrip shall not modify the request
+		req = req.WithContext(req.Context())
+		req.Header = cloneHeader(req.Header)
+		req.Header.Set("Authorization", "Bearer "+t.mgmtKey)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, vv := range h {
+		h2[k] = append([]string(nil), vv...)
+	}
+	return h2
+}
@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Load headers of data responses. Clients are expected to slow down as they grow.
+const (
+	FlatBacklogHeader = "X-Vectodb-Flat-Backlog" // number of vectors searched by brute force on this node
+	BuildQueueHeader  = "X-Vectodb-Build-Queue"  // number of vectodblites on this node which are due to rebuild their indexes
+	InFlightHeader    = "X-Vectodb-InFlight"     // number of data requests being served by this node, including the current one
+)
+
+// backpressure is a middleware of data endpoints which counts requests and in-flight ones, and adds load headers if BackpressureHeaders.
+// The headers reflect the load at most BacklogInterval before the request arrives, since they have to be written before the body.
+func (ctl *Controller) backpressure(c *gin.Context) {
+	atomic.AddInt64(&ctl.numRequests, 1)
+	inFlight := atomic.AddInt64(&ctl.inFlight, 1)
+	defer atomic.AddInt64(&ctl.inFlight, -1)
+	if ctl.conf.BackpressureHeaders {
+		flatBacklog, buildQueue := ctl.getBacklog()
+		c.Header(FlatBacklogHeader, strconv.Itoa(flatBacklog))
+		c.Header(BuildQueueHeader, strconv.Itoa(buildQueue))
+		c.Header(InFlightHeader, strconv.FormatInt(inFlight, 10))
+	}
+	c.Next()
+}
+
+// BacklogInterval is how often the load counters of BackpressureHeaders are refreshed. Summing them up takes the locks of
+// all owned vectodblites, which shall not be on the path of every request.
+const BacklogInterval = time.Second
+
+// getBacklog returns the flat backlog of vectodblites owned by this node, and the number of the ones which are due to rebuild.
+// They're maintained by refreshBacklog, which is started in the background at most once per BacklogInterval.
+func (ctl *Controller) getBacklog() (flatBacklog, buildQueue int) {
+	now := time.Now().UnixNano()
+	if refreshAt := atomic.LoadInt64(&ctl.backlogRefreshAt); now >= refreshAt &&
+		atomic.CompareAndSwapInt64(&ctl.backlogRefreshAt, refreshAt, now+int64(BacklogInterval)) {
+		go ctl.refreshBacklog()
+	}
+	flatBacklog = int(atomic.LoadInt64(&ctl.flatBacklog))
+	buildQueue = int(atomic.LoadInt64(&ctl.buildQueue))
+	return
+}
+
+// refreshBacklog sums up the flat backlog of vectodblites owned by this node, and counts the ones which are due to rebuild.
+func (ctl *Controller) refreshBacklog() {
+	var flatBacklog, buildQueue int64
+	ctl.rwlock.RLock()
+	for _, dbl := range ctl.dbls {
+		flatBacklog += int64(dbl.FlatBacklog())
+		if dbl.RebuildPending() {
+			buildQueue++
+		}
+	}
+	ctl.rwlock.RUnlock()
+	atomic.StoreInt64(&ctl.flatBacklog, flatBacklog)
+	atomic.StoreInt64(&ctl.buildQueue, buildQueue)
+}
@@ -0,0 +1,327 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type ReqBatchAdd struct {
+	DbID int         `json:"dbID"`
+	Xbs  [][]float32 `json:"xbs"`
+	Xids []uint64    `json:"xids"`
+}
+
+type RspBatchAdd struct {
+	Xids []uint64 `json:"xids"`
+	Err  string   `json:"err"`
+}
+
+type ReqBatchSearch struct {
+	DbID           int         `json:"dbID"`
+	Xqs            [][]float32 `json:"xqs"`
+	Nprobe         int         `json:"nprobe"`
+	IncludeVectors bool        `json:"includeVectors"`
+	MinResults     int         `json:"minResults"`
+}
+
+type RspBatchSearch struct {
+	Results []RspSearch `json:"results"`
+	Err     string      `json:"err"`
+}
+
+// @Description Add multiple vectors to the given vectodblite in one request
+// @Accept  json
+// @Produce  json
+// @Param   batch_add	body	cluster.ReqBatchAdd	true 	"ReqBatchAdd. xbs has at most the configured max batch size vectors. xids is either empty or as long as xbs. A xid of 0 or ^uint64(0) is generated with the configured id strategy. If the vectodblite is split, the vectors which belong to the sub-shard are forwarded to it the same as add."
+// @Success 200 {object} cluster.RspBatchAdd "RspBatchAdd. xids[i] is the xid of xbs[i]. They're valid only if err is empty."
+// @Failure 307 "redirection"
+// @Failure 400
+// @Failure 503 "memory pressure or draining"
+// @Router /api/v1/batch_add [post]
+func (ctl *Controller) HandleBatchAdd(c *gin.Context) {
+	var reqBatchAdd ReqBatchAdd
+	var err error
+	if err = c.ShouldBind(&reqBatchAdd); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(reqBatchAdd.Xbs) > ctl.conf.MaxBatchSize {
+		err = errors.Errorf("invalid length of xbs %v, want <= %v", len(reqBatchAdd.Xbs), ctl.conf.MaxBatchSize)
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(reqBatchAdd.Xids) == 0 {
+		reqBatchAdd.Xids = make([]uint64, len(reqBatchAdd.Xbs))
+	} else if len(reqBatchAdd.Xids) != len(reqBatchAdd.Xbs) {
+		err = errors.Errorf("invalid length of xids, want %v, have %v", len(reqBatchAdd.Xbs), len(reqBatchAdd.Xids))
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if pressure, _ := ctl.underMemPressure(); pressure {
+		c.String(http.StatusServiceUnavailable, errMemPressure.Error())
+		return
+	}
+	rspBatchAdd := RspBatchAdd{Xids: reqBatchAdd.Xids}
+	rl := ctl.routeLock(reqBatchAdd.DbID)
+	rl.RLock()
+	localIdx, shardIdx, shard := ctl.batchAddShard(&reqBatchAdd)
+	if len(shardIdx) != 0 {
+		rl.RUnlock()
+		// the sub-shard is forwarded without RLock, since it may be served by this node
+		shardReq := pickBatchAdd(&reqBatchAdd, shardIdx)
+		shardReq.DbID = shard
+		var rspShard RspBatchAdd
+		if rspShard, err = ctl.forwardBatchAdd(c.Request.Context(), shardReq); err == nil && rspShard.Err != "" {
+			err = errors.Errorf("sub-shard %v, error %v", shard, rspShard.Err)
+		} else if err == nil && len(rspShard.Xids) != len(shardIdx) {
+			err = errors.Errorf("sub-shard %v, invalid length of xids, want %v, have %v", shard, len(shardIdx), len(rspShard.Xids))
+		}
+		if err != nil {
+			rspBatchAdd.Err = err.Error()
+			log.Errorf("got error %+v", err)
+			c.JSON(200, rspBatchAdd)
+			return
+		}
+		for i, idx := range shardIdx {
+			rspBatchAdd.Xids[idx] = rspShard.Xids[i]
+		}
+		if len(localIdx) == 0 {
+			c.JSON(200, rspBatchAdd)
+			return
+		}
+		// the split is known now, so the rest stays in the vectodblite itself
+		rl.RLock()
+	}
+	defer rl.RUnlock()
+	var dbl *vectodb.VectoDBLite
+	ctl.rwlock.RLock()
+	defer ctl.rwlock.RUnlock()
+	if dbl, err = ctl.getVectoDBLite(c, reqBatchAdd.DbID); isUnavailable(err) {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		rspBatchAdd.Err = err.Error()
+		log.Errorf("got error %+v", err)
+		c.JSON(200, rspBatchAdd)
+		return
+	} else if dbl == nil {
+		//already return a response
+		return
+	}
+	localReq := pickBatchAdd(&reqBatchAdd, localIdx)
+	if err = ctl.batchAdd(dbl, localReq); err != nil {
+		rspBatchAdd.Err = err.Error()
+		log.Errorf("got error %+v", err)
+	} else {
+		for i, idx := range localIdx {
+			rspBatchAdd.Xids[idx] = localReq.Xids[i]
+		}
+		ctl.maybeSplit(reqBatchAdd.DbID, dbl)
+	}
+	c.JSON(200, rspBatchAdd)
+}
+
+// batchAddShard partitions the positions of the vectors into the ones added to the vectodblite itself and the ones forwarded to
+// its sub-shard, the same as addShard.
+func (ctl *Controller) batchAddShard(reqBatchAdd *ReqBatchAdd) (localIdx, shardIdx []int, shard int) {
+	for i, xb := range reqBatchAdd.Xbs {
+		if s, ok := ctl.addShard(&ReqAdd{DbID: reqBatchAdd.DbID, Xb: xb, Xid: reqBatchAdd.Xids[i]}); ok {
+			shardIdx = append(shardIdx, i)
+			shard = s
+		} else {
+			localIdx = append(localIdx, i)
+		}
+	}
+	return
+}
+
+// pickBatchAdd returns a request of the vectors at the given positions of reqBatchAdd.
+func pickBatchAdd(reqBatchAdd *ReqBatchAdd, idx []int) (picked ReqBatchAdd) {
+	picked.DbID = reqBatchAdd.DbID
+	picked.Xbs = make([][]float32, len(idx))
+	picked.Xids = make([]uint64, len(idx))
+	for i, j := range idx {
+		picked.Xbs[i], picked.Xids[i] = reqBatchAdd.Xbs[j], reqBatchAdd.Xids[j]
+	}
+	return
+}
+
+// batchAdd adds the vectors of the request to dbl in one round trip of the store. Missing xids are generated with the configured
+// id strategy in place, the same as add.
+func (ctl *Controller) batchAdd(dbl *vectodb.VectoDBLite, reqBatchAdd ReqBatchAdd) (err error) {
+	if ctl.idGen != nil {
+		for i, xid := range reqBatchAdd.Xids {
+			if xid != 0 && xid != ^uint64(0) {
+				continue
+			}
+			if reqBatchAdd.Xids[i], err = ctl.idGen.nextID(reqBatchAdd.DbID, dbl); err != nil {
+				return
+			}
+		}
+	}
+	err = dbl.AddBatchWithIds(reqBatchAdd.Xbs, reqBatchAdd.Xids)
+	return
+}
+
+func (ctl *Controller) forwardBatchAdd(ctx context.Context, reqBatchAdd ReqBatchAdd) (rspBatchAdd RspBatchAdd, err error) {
+	err = ctl.postData(ctx, fmt.Sprintf("%s://%s/api/v1/batch_add", ctl.conf.scheme(), ctl.conf.ListenAddr), reqBatchAdd, &rspBatchAdd)
+	return
+}
+
+// @Description Search multiple vectors in the given vectodblite in one request. The queries are searched in one batch of the index.
+// @Accept  json
+// @Produce  json
+// @Param   batch_search	body	cluster.ReqBatchSearch	true 	"ReqBatchSearch. xqs has at most the configured max batch size queries, whose number times minResults is at most the configured max batch results. nprobe, includeVectors and minResults are the same as search, and apply to every query. If includeVectors is set, the results in total times dim shall be at most the configured max vector floats. If the vectodblite is split, the queries fan out to its sub-shard and the results are merged."
+// @Success 200 {object} cluster.RspBatchSearch "RspBatchSearch. results[i] is the search response of xqs[i], whose err is always empty."
+// @Failure 307 "redirection"
+// @Failure 400 "invalid request, or zero query vector"
+// @Failure 503 "memory pressure or draining"
+// @Router /api/v1/batch_search [post]
+func (ctl *Controller) HandleBatchSearch(c *gin.Context) {
+	var reqBatchSearch ReqBatchSearch
+	var err error
+	if err = c.ShouldBind(&reqBatchSearch); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err = ctl.conf.validateBatchSearch(&reqBatchSearch); err != nil {
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, xq := range reqBatchSearch.Xqs {
+		if err = ctl.conf.validateQuery(xq); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	var rspBatchSearch RspBatchSearch
+	shardCh, ok := ctl.batchSearchLocal(c, &reqBatchSearch, &rspBatchSearch)
+	if !ok {
+		//already return a response
+		return
+	}
+	if shardCh != nil {
+		// the sub-shard is waited after RUnlock, since it may be served by this node
+		if rspShard := <-shardCh; rspShard.Err != "" {
+			if rspBatchSearch.Err == "" {
+				rspBatchSearch.Err = rspShard.Err
+			}
+			log.Errorf("got error %v", rspShard.Err)
+		} else if rspBatchSearch.Err == "" && len(rspShard.Results) == len(rspBatchSearch.Results) {
+			reqSearch := ReqSearch{MinResults: reqBatchSearch.MinResults}
+			for i := range rspBatchSearch.Results {
+				mergeSearch(&reqSearch, &rspBatchSearch.Results[i], &rspShard.Results[i])
+			}
+		}
+	}
+	c.JSON(200, rspBatchSearch)
+}
+
+// validateBatchSearch bounds the number of queries and the results of a batch search, whose buffers are allocated up front.
+func (conf *ControllerConf) validateBatchSearch(reqBatchSearch *ReqBatchSearch) (err error) {
+	nq := len(reqBatchSearch.Xqs)
+	if nq > conf.MaxBatchSize {
+		err = errors.Errorf("invalid length of xqs %v, want <= %v", nq, conf.MaxBatchSize)
+	} else if k := vectodb.MaxInt(1, reqBatchSearch.MinResults); k > conf.MaxBatchResults || nq*k > conf.MaxBatchResults {
+		// k is checked alone first not to overflow
+		err = errors.Errorf("invalid minResults %v of %v queries, want at most %v results in total", reqBatchSearch.MinResults, nq, conf.MaxBatchResults)
+	} else if reqBatchSearch.IncludeVectors {
+		err = conf.validateIncludeVectors(nq, k)
+	}
+	return
+}
+
+// batchSearchLocal is the same as searchLocal for a batch of queries.
+func (ctl *Controller) batchSearchLocal(c *gin.Context, reqBatchSearch *ReqBatchSearch, rspBatchSearch *RspBatchSearch) (shardCh <-chan *RspBatchSearch, ok bool) {
+	var dbl *vectodb.VectoDBLite
+	var err error
+	ctl.rwlock.RLock()
+	defer ctl.rwlock.RUnlock()
+	if dbl, err = ctl.getVectoDBLite(c, reqBatchSearch.DbID); isUnavailable(err) {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		rspBatchSearch.Err = err.Error()
+		log.Errorf("got error %+v", err)
+		ok = true
+		return
+	} else if dbl == nil {
+		//already return a response
+		return
+	}
+	if split := ctl.getSplit(reqBatchSearch.DbID); split != nil {
+		shardCh = ctl.goBatchSearchShard(c.Request.Context(), *reqBatchSearch, split.Child)
+	}
+	if err = ctl.batchSearch(dbl, reqBatchSearch, rspBatchSearch); err != nil {
+		rspBatchSearch.Err = err.Error()
+		log.Errorf("got error %+v", err)
+	}
+	ok = true
+	return
+}
+
+// batchSearch searches dbl with all queries of the request in one batch, and fills rspBatchSearch except Err.
+func (ctl *Controller) batchSearch(dbl *vectodb.VectoDBLite, reqBatchSearch *ReqBatchSearch, rspBatchSearch *RspBatchSearch) (err error) {
+	nprobe := ctl.conf.effectiveNprobe(reqBatchSearch.Nprobe)
+	opts := vectodb.SearchOptions{
+		MinResults:     reqBatchSearch.MinResults,
+		IncludeVectors: reqBatchSearch.IncludeVectors,
+		PenalizeWeight: ctl.conf.PenalizeWeight,
+		Nprobe:         nprobe,
+	}
+	var rstss [][]vectodb.SearchResult
+	if rstss, err = dbl.SearchBatchWithOptions(reqBatchSearch.Xqs, opts); err != nil {
+		return
+	}
+	rspBatchSearch.Results = make([]RspSearch, len(rstss))
+	for i, rsts := range rstss {
+		rspSearch := &rspBatchSearch.Results[i]
+		rspSearch.Nprobe = nprobe
+		rspSearch.Xid = ^uint64(0)
+		if len(rsts) != 0 && !rsts[0].Relaxed {
+			rspSearch.Xid, rspSearch.Distance, rspSearch.Xb = rsts[0].Xid, rsts[0].Distance, rsts[0].Xb
+		}
+		if reqBatchSearch.MinResults > 0 {
+			rspSearch.Results = make([]SearchHit, len(rsts))
+			for j, rst := range rsts {
+				rspSearch.Results[j] = SearchHit{
+					Xid:      rst.Xid,
+					Distance: rst.Distance,
+					Xb:       rst.Xb,
+					Group:    rst.Group,
+					Relaxed:  rst.Relaxed,
+				}
+			}
+		}
+	}
+	return
+}
+
+// goBatchSearchShard is the same as goSearchShard for a batch of queries.
+func (ctl *Controller) goBatchSearchShard(ctx context.Context, reqBatchSearch ReqBatchSearch, shard int) <-chan *RspBatchSearch {
+	rspCh := make(chan *RspBatchSearch, 1)
+	reqBatchSearch.DbID = shard
+	go func() {
+		rspBatchSearch := &RspBatchSearch{}
+		if err := ctl.postData(ctx, fmt.Sprintf("%s://%s/api/v1/batch_search", ctl.conf.scheme(), ctl.conf.ListenAddr), reqBatchSearch, rspBatchSearch); err != nil {
+			rspBatchSearch.Err = err.Error()
+		} else if rspBatchSearch.Err != "" {
+			rspBatchSearch.Err = fmt.Sprintf("sub-shard %v, error %v", shard, rspBatchSearch.Err)
+		}
+		rspCh <- rspBatchSearch
+	}()
+	return rspCh
+}
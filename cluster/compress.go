@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Only gzip is supported since it's in the standard library. Clients which don't advertise it get uncompressed responses.
+
+// acceptsGzip returns true if the Accept-Encoding header accepts gzip, i.e. "gzip, deflate" or "gzip;q=0.5". q=0 refuses it.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(item, ";")
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// writeCompressed writes data, gzipped if it's at least minBytes and the client accepts gzip. Zero minBytes disables compression.
+func writeCompressed(c *gin.Context, contentType string, data []byte, minBytes int) {
+	if minBytes <= 0 || len(data) < minBytes {
+		c.Data(http.StatusOK, contentType, data)
+		return
+	}
+	c.Header("Vary", "Accept-Encoding")
+	if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Data(http.StatusOK, contentType, data)
+		return
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		err = errors.Wrap(err, "")
+		log.Errorf("failed to compress response, error %+v", err)
+		c.Data(http.StatusOK, contentType, data)
+		return
+	}
+	c.Header("Content-Encoding", "gzip")
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
@@ -0,0 +1,77 @@
+package cluster
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sources of resolved settings
+const (
+	ConfigSourceDefault  = "default"  // the node-wide setting
+	ConfigSourceOverride = "override" // the per-dbID override
+	ConfigSourceRuntime  = "runtime"  // the current state of the vectodblite owned by this node
+)
+
+type ResolvedSetting struct {
+	Name   string      `json:"name"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+type RspConfig struct {
+	DbID     int               `json:"dbID"`
+	Settings []ResolvedSetting `json:"settings"`
+	Err      string            `json:"err"`
+}
+
+// resolve returns the settings newVectoDBLite constructs the vectodblite with.
+func (conf *ControllerConf) resolve(dbID int) (settings []ResolvedSetting) {
+	indexKeySource := ConfigSourceDefault
+	if _, ok := conf.IndexKeys[dbID]; ok {
+		indexKeySource = ConfigSourceOverride
+	}
+	settings = []ResolvedSetting{
+		{Name: "dim", Value: conf.Dim, Source: ConfigSourceDefault},
+		{Name: "metric", Value: "IP", Source: ConfigSourceDefault},
+		{Name: "disThr", Value: conf.DisThr, Source: ConfigSourceDefault},
+		{Name: "sizeLimit", Value: conf.SizeLimit, Source: ConfigSourceDefault},
+		{Name: "indexKey", Value: conf.indexKey(dbID), Source: indexKeySource},
+		{Name: "maxNprobe", Value: conf.MaxNprobe, Source: ConfigSourceDefault},
+		{Name: "allowZeroQuery", Value: conf.AllowZeroQuery, Source: ConfigSourceDefault},
+		{Name: "recentSize", Value: conf.RecentSize, Source: ConfigSourceDefault},
+		{Name: "freshOnAcquire", Value: conf.FreshOnAcquire, Source: ConfigSourceDefault},
+		{Name: "publishChanges", Value: conf.WarmStandbyCount > 0, Source: ConfigSourceDefault},
+	}
+	return
+}
+
+// @Description Return the resolved configuration of the given vectodblite, along with the source of each setting.
+// @Description If it's owned by this node, its active index key and read-only flag are returned too.
+// @Produce json
+// @Param   dbID	query	int	true	"dbID"
+// @Success 200 {object} cluster.RspConfig "RspConfig"
+// @Failure 400
+// @Router /mgmt/v1/config [get]
+func (ctl *Controller) HandleConfig(c *gin.Context) {
+	var rspConfig RspConfig
+	var err error
+	if rspConfig.DbID, err = strconv.Atoi(c.Query("dbID")); err != nil {
+		err = errors.Wrap(err, "invalid dbID")
+		log.Infof("failed to parse request, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	rspConfig.Settings = ctl.conf.resolve(rspConfig.DbID)
+	ctl.rwlock.RLock()
+	if dbl, ok := ctl.dbls[rspConfig.DbID]; ok {
+		rspConfig.Settings = append(rspConfig.Settings,
+			ResolvedSetting{Name: "activeIndexKey", Value: dbl.IndexKey(), Source: ConfigSourceRuntime},
+			ResolvedSetting{Name: "readOnly", Value: dbl.ReadOnly(), Source: ConfigSourceRuntime})
+	}
+	ctl.rwlock.RUnlock()
+	c.JSON(200, rspConfig)
+}
@@ -0,0 +1,1020 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-redis/redis"
+	"github.com/hudl/fargo"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+type Status struct {
+	Status string `json:"status"`
+}
+
+type Health struct {
+	Description string  `json:"description"`
+	Status      string  `json:"status"`
+	MemPressure bool    `json:"memPressure"`
+	Mem         MemStat `json:"mem"`
+
+	Acquire AcquireStat `json:"acquire"` // acquires sent to the leader by this node
+}
+
+type ReqAcquire struct {
+	DbID     int    `json:"dbID"`
+	NodeAddr string `json:"nodeAddr"`
+}
+
+type RspAcquire struct {
+	DbID     int    `json:"dbID"`
+	NodeAddr string `json:"nodeAddr"`
+	Err      string `json:"err"`
+}
+
+type ReqRelease struct {
+	DbID int `json:"dbID"`
+}
+
+type RspRelease struct {
+	DbID int    `json:"dbID"`
+	Err  string `json:"err"`
+}
+
+type ReqTakeover struct {
+	DbID    int         `json:"dbID"`
+	Samples [][]float32 `json:"samples,omitempty"` // pretrain samples of the index if it's loaded here
+}
+
+type RspTakeover struct {
+	DbID int    `json:"dbID"`
+	Err  string `json:"err"`
+}
+
+type RspDistribution struct {
+	DbID        int       `json:"dbID"`
+	Pairs       int       `json:"pairs"`       // number of sampled pairs
+	Percentiles []float64 `json:"percentiles"` // DistributionPercentiles
+	Distances   []float32 `json:"distances"`   // distances[i] is the percentiles[i] percentile
+	Err         string    `json:"err"`
+}
+
+type ReqAdd struct {
+	DbID   int       `json:"dbID"`
+	Xb     []float32 `json:"xb"`
+	Xid    uint64    `json:"xid"`
+	Group  uint64    `json:"group"`
+	Weight float32   `json:"weight"`
+	Debug  bool      `json:"debug"`
+}
+
+type RspAdd struct {
+	Xid uint64 `json:"xid"`
+	Err string `json:"err"`
+}
+
+type ReqSearch struct {
+	DbID int       `json:"dbID"`
+	Xq   []float32 `json:"xq"`
+	// clamped to the configured max nprobe, the effective value is returned
+	Nprobe int `json:"nprobe"`
+	// return the stored vector of each neighbor as xb. The results times dim shall be at most the configured max vector floats
+	IncludeVectors bool `json:"includeVectors"`
+	// return at least this many neighbors (or all stored ones if there are fewer) in results, the ones beyond the distance threshold
+	// are flagged relaxed
+	MinResults int `json:"minResults"`
+	// return the best GroupTopK neighbors of each group in results. Only the nearest max(MinResults, GroupTopK*10) neighbors are
+	// bucketed, so that groups whose neighbors are all farther are missing. Raise MinResults to cover more groups
+	GroupBy   bool `json:"groupBy"`
+	GroupTopK int  `json:"groupTopK"`
+	// rerank neighbors by their distances scaled with weights, which are returned as distance
+	Weighted bool `json:"weighted"`
+	// log details of the request if the X-Debug-Token header matches the configured debug token
+	Debug bool `json:"debug"`
+	// return only the number of neighbors within Threshold as count
+	CountOnly bool `json:"countOnly"`
+	// distance threshold of CountOnly, 0 means the configured one
+	Threshold float32 `json:"threshold"`
+	// return results in pages of this size if > 0, out of at most MinResults (1000 by default) neighbors which are frozen at the first page
+	PageSize int `json:"pageSize"`
+	// NextPageToken of the previous page, empty for the first page
+	PageToken string `json:"pageToken"`
+}
+
+type SearchHit struct {
+	Xid      uint64    `json:"xid"`
+	Distance float32   `json:"distance"`
+	Xb       []float32 `json:"xb,omitempty"`
+	Group    uint64    `json:"group"`
+	Relaxed  bool      `json:"relaxed"`
+}
+
+type RspSearch struct {
+	Xid      uint64      `json:"xid"`
+	Distance float32     `json:"distance"`
+	Xb       []float32   `json:"xb,omitempty"`
+	Results  []SearchHit `json:"results,omitempty"`
+	Nprobe   int         `json:"nprobe"`
+	Count    int         `json:"count"`
+	Err      string      `json:"err"`
+
+	NextPageToken string `json:"nextPageToken,omitempty"` // empty if it's the last page
+}
+
+// toProto converts RspSearch to its protobuf encoding.
+func (rsp *RspSearch) toProto() (pb *vectodb.SearchResponse) {
+	pb = &vectodb.SearchResponse{
+		Xid:      rsp.Xid,
+		Distance: rsp.Distance,
+		Xb:       rsp.Xb,
+		Nprobe:   int64(rsp.Nprobe),
+		Count:    int64(rsp.Count),
+		Err:      rsp.Err,
+
+		NextPageToken: rsp.NextPageToken,
+	}
+	for _, hit := range rsp.Results {
+		pb.Results = append(pb.Results, &vectodb.SearchHit{
+			Xid:      hit.Xid,
+			Distance: hit.Distance,
+			Xb:       hit.Xb,
+			Group:    hit.Group,
+			Relaxed:  hit.Relaxed,
+		})
+	}
+	return
+}
+
+type ControllerConf struct {
+	ListenAddr      string
+	AdminAddr       string // serves mgmt and debug endpoints if not empty, otherwise they're served at ListenAddr
+	CertFile        string // serves HTTPS with the certificate and KeyFile if not empty, and requests other nodes with HTTPS then
+	KeyFile         string
+	CAFile          string // CA certificates which inter-node requests trust, the system pool if empty
+	EtcdAddr        string
+	EtcdPrefix      string // namespaces all etcd keys, so that multiple clusters can share one etcd
+	RedisAddr       string
+	RedisPassword   string // AUTH password of redis, empty means no AUTH
+	RedisDB         int    // logical db of redis
+	RedisTLS        bool   // connect redis with TLS, i.e. a managed redis
+	RedisKeyPrefix  string // prepended to every redis key, so that clusters sharing one redis are isolated
+	Dim             int
+	DisThr          float64
+	SizeLimit       int
+	IndexKey        string         // faiss index_factory key of vectodblites
+	IndexKeys       map[int]string // overrides IndexKey per dbID
+	BalanceInterval int
+	MaxNprobe       int
+	MaxRSSMB        int
+	MinAvailMemMB   int
+	AcquireTimeout  int // in milliseconds, applies to acquire and release requests to other nodes
+	DataTimeout     int // in milliseconds, applies to data requests proxied to other nodes
+	MigrateTimeout  int // in milliseconds, applies to each import of vectors migrated to a sub-shard
+	HandoffOnClose  bool
+	FreshOnAcquire  bool   // wipe vectors of a vectodblite on acquiring it, rather than loading them from redis
+	AllowZeroQuery  bool   // accept all-zero query vectors whose result order is arbitrary with inner product metric
+	DebugToken      string // per-request debug logs are emitted only if the request carries it in DebugTokenHeader, empty disables them
+	IdStrategy      string // how to generate xids of vectors added without one, IdStrategyHash by default
+	SnowflakeNode   int    // node id of IdStrategySnowflake, shall be unique in the cluster
+	RecentSize      int    // number of latest vectors per vectodblite kept for recent searches, 0 disables them
+	PenalizeWeight  bool   // weighted searches divide distances by weights rather than multiply
+
+	WarmStandbyCount int // number of warm standbys per vectodblite which take over at once if the owner dies, 0 disables them
+	CompressMinBytes int // search responses of at least this size are gzipped if the client accepts it, 0 disables compression
+
+	BackpressureHeaders bool // data responses carry load headers of this node, so that clients could throttle themselves
+	PageTTL             int  // in seconds, how long the result set of a paged search is kept for following pages
+	MaxPages            int  // max result sets of paged searches kept, the ones expiring first are evicted beyond it
+
+	MaxBatchSize    int // max vectors of a batch add, or queries of a batch search
+	MaxBatchResults int // max results of a batch search, i.e. the number of queries times minResults
+	MaxVectorFloats int // max floats of vectors returned by a search with includeVectors, i.e. its results times dim
+
+	AcquireRate      int // max acquires per second sent to the leader by this node, 0 is unlimited
+	AcquireQueueSize int // max acquires waiting for AcquireRate, the ones beyond it are responded with 503
+
+	QPSWeight   float64 // number of owned vectodblites one QPS of a node weighs as when the leader places acquires, 0 disables it
+	QPSInterval int     // in seconds, how often a node publishes its recent QPS
+
+	SplitThreshold int // number of vectors at which a vectodblite is split into two by xid range, 0 disables splitting
+
+	HashPlacement bool // place vectodblites on nodes by consistent hashing of dbIDs rather than on the first node requesting them
+
+	APIKeys  []string // keys which data requests shall carry, empty allows all
+	MgmtKeys []string // keys which mgmt requests shall carry, empty allows all. The first one authenticates inter-node requests
+
+	ClientRate  float64 // max data requests per second of each client, identified by its API key or IP, 0 is unlimited
+	ClientBurst int     // max data requests of each client in a burst above ClientRate
+
+	FaultInjection FaultInjection // for resilience tests only, changed at runtime with the debug token
+
+	EurekaAddr string
+	EurekaApp  string
+}
+
+type Controller struct {
+	conf      *ControllerConf
+	rwlock    sync.RWMutex
+	dbls      map[int]*vectodb.VectoDBLite
+	standbys  map[int]*vectodb.VectoDBLite // warm standbys of vectodblites owned by other nodes
+	hc        *http.Client
+	etcdCli   *clientv3.Client
+	isLeader  bool
+	curLeader string
+	ctx       context.Context
+	cancel    context.CancelFunc // cancels ctx on Shutdown
+	ctxL      context.Context
+	cancelL   context.CancelFunc
+	conn      fargo.EurekaConnection
+
+	readMemStat func() (MemStat, error)
+	idGen       idGenerator  // nil means IdStrategyHash
+	faultLock   sync.RWMutex // protect conf.FaultInjection
+	drainLock   sync.Mutex   // protect draining
+	draining    map[int]bool // dbIDs being drained
+
+	shuttingDown int32 // 1 once Shutdown starts, then vectodblites not owned by this node aren't acquired
+
+	inFlight    int64 // number of data requests being served
+	numRequests int64 // number of data requests served, for QPS
+
+	flatBacklog      int64 // sum of the flat backlog of owned vectodblites, refreshed every BacklogInterval, see getBacklog
+	buildQueue       int64 // number of owned vectodblites due to rebuild, refreshed along with flatBacklog
+	backlogRefreshAt int64 // in unix nanoseconds, when flatBacklog and buildQueue are refreshed next
+
+	pageLock sync.Mutex               // protect pages
+	pages    map[uint64]*pagedResults // result sets of paged searches
+
+	acquireLimiter *acquireLimiter      // nil if AcquireRate is 0
+	clientLimiter  *clientLimiter       // nil if ClientRate is 0
+	acquireLock    sync.Mutex           // protect acquiring
+	acquiring      map[int]*acquireCall // acquires in progress at the leader
+
+	peerLock sync.RWMutex    // protect peerIPs
+	peerIPs  map[string]bool // IPs of the nodes of the cluster, maintained only if ClientRate is set without MgmtKeys
+
+	leaseID clientv3.LeaseID // lease of the node key
+
+	splitLock  sync.RWMutex          // protect splits, splitting and routeLocks
+	splits     map[int]*shardSplit   // splits of vectodblites owned by this node now or before
+	splitting  map[int]bool          // dbIDs being split by this node
+	routeLocks map[int]*sync.RWMutex // see routeLock
+
+	rcli *redis.Client // pings redis for readiness
+
+	electionDone <-chan struct{} // closed once the campaign exits after ctx is done
+	registerDone chan struct{}   // closed once servRegister deregisters from Eureka after ctx is done
+
+	ringLock sync.RWMutex // protect ring
+	ring     *hashRing    // nil unless HashPlacement and the ring is in sync with the node keys
+}
+
+func NewControllerConf() (conf *ControllerConf) {
+	return &ControllerConf{
+		ListenAddr:      "127.0.0.1:8080",
+		EtcdAddr:        "127.0.0.1:2379",
+		RedisAddr:       "127.0.0.1:6379",
+		Dim:             512,
+		DisThr:          0.9,
+		SizeLimit:       10000,
+		IndexKey:        vectodb.LiteIndexKeyFlat,
+		BalanceInterval: 60,
+		MaxNprobe:       0,
+		MaxRSSMB:        0,
+		MinAvailMemMB:   0,
+		IdStrategy:      IdStrategyHash,
+		AcquireTimeout:  5000,
+		DataTimeout:     1000,
+		MigrateTimeout:  600000,
+		HandoffOnClose:  true,
+		EurekaAddr:      "http://127.0.0.1:8761/eureka",
+		EurekaApp:       "vectodblite-cluster",
+
+		CompressMinBytes: 64 * 1024,
+
+		BackpressureHeaders: false,
+		PageTTL:             60,
+		MaxPages:            1000,
+
+		MaxBatchSize:    1000,
+		MaxBatchResults: 100000,
+		MaxVectorFloats: 1 << 20,
+
+		AcquireRate:      100,
+		AcquireQueueSize: 1000,
+
+		QPSInterval: 10,
+
+		ClientBurst: 100,
+	}
+}
+
+// Validate checks the config, and shall be called before creating a controller with it.
+func (conf *ControllerConf) Validate() (err error) {
+	if (conf.CertFile == "") != (conf.KeyFile == "") {
+		err = errors.Errorf("invalid TLS config, cert file %q, key file %q, want both or neither", conf.CertFile, conf.KeyFile)
+		return
+	}
+	if err = conf.validateKeys(); err != nil {
+		return
+	}
+	if conf.MaxNprobe < 0 {
+		err = errors.Errorf("invalid max nprobe %v, want >= 0", conf.MaxNprobe)
+		return
+	}
+	if conf.MaxRSSMB < 0 || conf.MinAvailMemMB < 0 {
+		err = errors.Errorf("invalid memory watermarks, max rss %v MB, min available memory %v MB, want >= 0", conf.MaxRSSMB, conf.MinAvailMemMB)
+		return
+	}
+	if conf.AcquireTimeout <= 0 || conf.DataTimeout <= 0 || conf.MigrateTimeout <= 0 {
+		err = errors.Errorf("invalid timeouts, acquire %v ms, data %v ms, migrate %v ms, want > 0", conf.AcquireTimeout, conf.DataTimeout, conf.MigrateTimeout)
+		return
+	}
+	if conf.WarmStandbyCount < 0 {
+		err = errors.Errorf("invalid warm standby count %v, want >= 0", conf.WarmStandbyCount)
+		return
+	}
+	if conf.CompressMinBytes < 0 {
+		err = errors.Errorf("invalid compress min bytes %v, want >= 0", conf.CompressMinBytes)
+		return
+	}
+	if conf.AcquireRate < 0 || conf.AcquireQueueSize < 0 {
+		err = errors.Errorf("invalid acquire rate %v, queue size %v, want >= 0", conf.AcquireRate, conf.AcquireQueueSize)
+		return
+	}
+	if conf.QPSWeight < 0 || conf.QPSInterval <= 0 {
+		err = errors.Errorf("invalid qps weight %v, interval %v, want >= 0 and > 0", conf.QPSWeight, conf.QPSInterval)
+		return
+	}
+	if conf.ClientRate < 0 || (conf.ClientRate > 0 && conf.ClientBurst < 1) {
+		err = errors.Errorf("invalid client rate %v, burst %v, want >= 0 and >= 1", conf.ClientRate, conf.ClientBurst)
+		return
+	}
+	if conf.SplitThreshold < 0 || conf.SplitThreshold == 1 {
+		err = errors.Errorf("invalid split threshold %v, want 0 or >= 2", conf.SplitThreshold)
+		return
+	}
+	if conf.MaxBatchSize <= 0 || conf.MaxBatchResults <= 0 {
+		err = errors.Errorf("invalid max batch size %v, max batch results %v, want > 0", conf.MaxBatchSize, conf.MaxBatchResults)
+		return
+	}
+	if conf.MaxVectorFloats < conf.Dim {
+		err = errors.Errorf("invalid max vector floats %v, want >= dim %v", conf.MaxVectorFloats, conf.Dim)
+		return
+	}
+	if conf.PageTTL <= 0 || conf.MaxPages <= 0 {
+		err = errors.Errorf("invalid page ttl %v, max pages %v, want > 0", conf.PageTTL, conf.MaxPages)
+		return
+	}
+	if conf.SnowflakeNode < 0 || conf.SnowflakeNode > SnowflakeMaxNode {
+		err = errors.Errorf("invalid snowflake node %v, want [0, %v]", conf.SnowflakeNode, SnowflakeMaxNode)
+		return
+	}
+	if _, err = newIdGenerator(conf); err != nil {
+		return
+	}
+	if err = conf.FaultInjection.validate(); err != nil {
+		return
+	}
+	if err = vectodb.ValidateLiteIndexKey(conf.Dim, conf.IndexKey); err != nil {
+		return
+	}
+	for dbID, indexKey := range conf.IndexKeys {
+		if err = vectodb.ValidateLiteIndexKey(conf.Dim, indexKey); err != nil {
+			err = errors.Wrapf(err, "dbID %v", dbID)
+			return
+		}
+	}
+	return
+}
+
+// ParseIndexKeys parses per-dbID index keys in the form of "<dbID>=<indexKey>;<dbID>=<indexKey>".
+// Semicolon is the separator since index keys contain comma, for example "1=Flat;2=IVF16,Flat".
+func ParseIndexKeys(s string) (indexKeys map[int]string, err error) {
+	indexKeys = make(map[int]string)
+	for _, item := range strings.Split(s, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			err = errors.Errorf("invalid index key item %v, want <dbID>=<indexKey>", item)
+			return
+		}
+		var dbID int
+		if dbID, err = strconv.Atoi(strings.TrimSpace(kv[0])); err != nil {
+			err = errors.Wrapf(err, "invalid index key item %v", item)
+			return
+		}
+		indexKeys[dbID] = strings.TrimSpace(kv[1])
+	}
+	return
+}
+
+// scheme returns the URL scheme of this cluster, which is https if it's served with TLS.
+func (conf *ControllerConf) scheme() string {
+	if conf.CertFile != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// newHTTPClient returns the client of inter-node requests. It trusts CAFile if it's served with TLS, and carries the mgmt key if there's one.
+func (conf *ControllerConf) newHTTPClient() (hc *http.Client, err error) {
+	hc = &http.Client{}
+	defer func() {
+		if err == nil && len(conf.MgmtKeys) != 0 {
+			base := hc.Transport
+			if base == nil {
+				base = http.DefaultTransport
+			}
+			hc.Transport = &authTransport{base: base, mgmtKey: conf.MgmtKeys[0]}
+		}
+	}()
+	if conf.CertFile == "" || conf.CAFile == "" {
+		return
+	}
+	var caPEM []byte
+	if caPEM, err = ioutil.ReadFile(conf.CAFile); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caPEM) {
+		err = errors.Errorf("no certificate is found in CA file %v", conf.CAFile)
+		return
+	}
+	hc.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: rootCAs},
+	}
+	return
+}
+
+// redisOptions returns the options of redis connections, which vectodblites and the sequential id strategy share.
+func (conf *ControllerConf) redisOptions() (opts vectodb.RedisOptions) {
+	opts = vectodb.RedisOptions{
+		Addr:      conf.RedisAddr,
+		Password:  conf.RedisPassword,
+		DB:        conf.RedisDB,
+		KeyPrefix: conf.RedisKeyPrefix,
+	}
+	if conf.RedisTLS {
+		opts.TLSConfig = &tls.Config{}
+	}
+	return
+}
+
+// indexKey returns the index key of the given vectodblite.
+func (conf *ControllerConf) indexKey(dbID int) string {
+	if indexKey, ok := conf.IndexKeys[dbID]; ok {
+		return indexKey
+	}
+	return conf.IndexKey
+}
+
+// etcdPath returns the common prefix of all etcd keys of this cluster.
+func (conf *ControllerConf) etcdPath() string {
+	if conf.EtcdPrefix == "" {
+		return conf.EurekaApp
+	}
+	return fmt.Sprintf("%s/%s", conf.EtcdPrefix, conf.EurekaApp)
+}
+
+// effectiveNprobe clamps the per-request nprobe to MaxNprobe. Zero MaxNprobe means no cap.
+func (conf *ControllerConf) effectiveNprobe(nprobe int) int {
+	if conf.MaxNprobe > 0 && nprobe > conf.MaxNprobe {
+		return conf.MaxNprobe
+	}
+	return nprobe
+}
+
+// newVectoDBLite loads the given vectodblite with its index key. fresh indicates to wipe its vectors in redis.
+func (ctl *Controller) newVectoDBLite(dbID int, fresh bool) (dbl *vectodb.VectoDBLite, err error) {
+	if dbl, err = vectodb.NewVectoDBLiteWithRedisOptions(ctl.conf.redisOptions(), dbID, ctl.conf.Dim, float32(ctl.conf.DisThr), ctl.conf.SizeLimit, ctl.conf.indexKey(dbID), fresh); err != nil {
+		return
+	}
+	dbl.SetAllowZeroQuery(ctl.conf.AllowZeroQuery)
+	dbl.EnableRecent(ctl.conf.RecentSize)
+	dbl.SetPublishChanges(ctl.conf.WarmStandbyCount > 0)
+	return
+}
+
+// DebugTokenHeader carries the debug token of requests which set debug.
+const DebugTokenHeader = "X-Debug-Token"
+
+// debugEnabled returns true if the request asks for debug logs and is authorized to.
+// Debug logs are emitted at info level regardless of the global log level, so they're guarded to prevent log flooding.
+func (ctl *Controller) debugEnabled(c *gin.Context, debug bool) bool {
+	if !debug {
+		return false
+	}
+	if ctl.conf.DebugToken == "" || c.GetHeader(DebugTokenHeader) != ctl.conf.DebugToken {
+		log.Debugf("ignored debug flag of unauthorized request %v", c.Request.URL)
+		return false
+	}
+	return true
+}
+
+// validateSearch bounds the vectors returned by a search with includeVectors.
+func (conf *ControllerConf) validateSearch(reqSearch *ReqSearch) (err error) {
+	if reqSearch.IncludeVectors {
+		err = conf.validateIncludeVectors(1, maxResults(reqSearch.MinResults, reqSearch.GroupBy, reqSearch.GroupTopK))
+	}
+	return
+}
+
+// maxResults returns the max number of results a search returns, the neighbors bucketed by groupBy or at least one.
+func maxResults(minResults int, groupBy bool, groupTopK int) (k int) {
+	k = vectodb.MaxInt(1, minResults)
+	if groupBy && groupTopK > 0 {
+		// groupTopK is checked alone first not to overflow
+		if groupTopK > math.MaxInt32/vectodb.GroupOverFetch {
+			return math.MaxInt32
+		}
+		k = vectodb.MaxInt(k, groupTopK*vectodb.GroupOverFetch)
+	}
+	return
+}
+
+// validateIncludeVectors bounds the vectors returned by nq queries which include vectors, whose size is the results times dim.
+func (conf *ControllerConf) validateIncludeVectors(nq, k int) (err error) {
+	if maxK := conf.MaxVectorFloats / conf.Dim / vectodb.MaxInt(1, nq); k > maxK {
+		err = errors.Errorf("invalid minResults or groupTopK, %v queries could return %v results including vectors, want at most %v results per query", nq, k, maxK)
+	}
+	return
+}
+
+// validateQuery rejects empty query vectors, and all-zero ones unless AllowZeroQuery.
+func (conf *ControllerConf) validateQuery(xq []float32) (err error) {
+	if len(xq) == 0 || (!conf.AllowZeroQuery && vectodb.IsZeroVector(xq)) {
+		err = vectodb.ErrZeroVector
+	}
+	return
+}
+
+func NewController(conf *ControllerConf, ctx context.Context) (ctl *Controller) {
+	var err error
+	if ctl, err = newController(conf, ctx); err != nil {
+		log.Fatalf("got error %+v", err)
+	}
+	if err = ctl.initMgmt(); err != nil {
+		log.Fatalf("got error %+v", err)
+	}
+	return
+}
+
+// newController creates a controller which is not a member of any cluster yet.
+func newController(conf *ControllerConf, ctx context.Context) (ctl *Controller, err error) {
+	ctl = &Controller{
+		conf:        conf,
+		dbls:        make(map[int]*vectodb.VectoDBLite),
+		standbys:    make(map[int]*vectodb.VectoDBLite),
+		readMemStat: readMemStat,
+		splits:      make(map[int]*shardSplit),
+		splitting:   make(map[int]bool),
+	}
+	ctl.ctx, ctl.cancel = context.WithCancel(ctx)
+	if ctl.hc, err = conf.newHTTPClient(); err != nil {
+		return
+	}
+	ctl.acquireLimiter = newAcquireLimiter(conf.AcquireRate, conf.AcquireQueueSize)
+	ctl.clientLimiter = newClientLimiter(conf.ClientRate, conf.ClientBurst)
+	ctl.rcli = conf.redisOptions().NewClient()
+	if ctl.idGen, err = newIdGenerator(conf); err != nil {
+		return
+	}
+	return
+}
+
+// refers to https://github.com/swaggo/swag#api-operation
+// github.com/swaggo/swag/operation.go, (*Operation).ParseParamComment, (*Operation).ParseResponseComment
+// @Description Add a vector to the given vectodblite
+// @Accept  json
+// @Produce  json
+// @Param   add		body	cluster.ReqAdd	true 	"ReqAdd. If xid is 0 or ^uint64(0), the cluster will generate one with the configured id strategy. group is used by grouped search. weight scales the distance of the vector in weighted searches, 0 means 1. If the vectodblite is split, the add is forwarded to the sub-shard which the xid belongs to. If debug is set and the X-Debug-Token header matches the configured debug token, details of the request are logged."
+// @Success 200 {object} cluster.RspAdd "RspAdd"
+// @Failure 307 "redirection"
+// @Failure 400
+// @Failure 503 "memory pressure or draining"
+// @Router /api/v1/add [post]
+func (ctl *Controller) HandleAdd(c *gin.Context) {
+	var reqAdd ReqAdd
+	var err error
+	if err = c.ShouldBind(&reqAdd); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+	} else if pressure, _ := ctl.underMemPressure(); pressure {
+		c.String(http.StatusServiceUnavailable, errMemPressure.Error())
+	} else {
+		rl := ctl.routeLock(reqAdd.DbID)
+		rl.RLock()
+		if shard, ok := ctl.addShard(&reqAdd); ok {
+			// the sub-shard is forwarded without RLock, since it may be served by this node
+			rl.RUnlock()
+			rspAdd, err := ctl.forwardAdd(c.Request.Context(), reqAdd, shard)
+			if err != nil {
+				rspAdd.Err = err.Error()
+				log.Errorf("got error %+v", err)
+			}
+			c.JSON(200, rspAdd)
+			return
+		}
+		defer rl.RUnlock()
+		var rspAdd RspAdd
+		var dbl *vectodb.VectoDBLite
+		ctl.rwlock.RLock()
+		defer ctl.rwlock.RUnlock()
+		if dbl, err = ctl.getVectoDBLite(c, reqAdd.DbID); isUnavailable(err) {
+			c.String(http.StatusServiceUnavailable, err.Error())
+			return
+		} else if err != nil {
+			rspAdd.Err = err.Error()
+			log.Errorf("got error %+v", err)
+			c.JSON(200, rspAdd)
+			return
+		} else if dbl == nil {
+			//already return a response
+			return
+		}
+		start := time.Now()
+		if rspAdd.Xid, err = ctl.add(dbl, &reqAdd); err != nil {
+			rspAdd.Err = err.Error()
+			log.Errorf("got error %+v", err)
+		} else {
+			ctl.maybeSplit(reqAdd.DbID, dbl)
+		}
+		if ctl.debugEnabled(c, reqAdd.Debug) {
+			log.Infof("debug add: dbID %v, xb hash %016x, group %v, xid %016x, size %v, index key %v, took %v, err %v",
+				reqAdd.DbID, vectodb.HashVector(reqAdd.Xb), reqAdd.Group, rspAdd.Xid, dbl.Size(), dbl.IndexKey(), time.Since(start), err)
+		}
+		c.JSON(200, rspAdd)
+	}
+}
+
+// add adds the vector of the request to dbl. If the xid is 0 or ^uint64(0), it's generated with the configured id strategy.
+func (ctl *Controller) add(dbl *vectodb.VectoDBLite, reqAdd *ReqAdd) (xid uint64, err error) {
+	if reqAdd.Xid != 0 && reqAdd.Xid != ^uint64(0) {
+		xid = reqAdd.Xid
+		err = dbl.AddWithIdGroupWeight(reqAdd.Xb, xid, reqAdd.Group, reqAdd.Weight)
+	} else if ctl.idGen == nil {
+		xid, err = dbl.AddWithGroupWeight(reqAdd.Xb, reqAdd.Group, reqAdd.Weight)
+	} else if xid, err = ctl.idGen.nextID(reqAdd.DbID, dbl); err == nil {
+		err = dbl.AddWithIdGroupWeight(reqAdd.Xb, xid, reqAdd.Group, reqAdd.Weight)
+	}
+	return
+}
+
+// @Description Search a vector in the given vectodblite
+// @Accept  json
+// @Produce  json
+// @Produce  application/x-protobuf
+// @Param   search		body	cluster.ReqSearch	true 	"ReqSearch. If the vectodblite is split, searches fan out to its sub-shard and the results are merged, paged searches are rejected unless the page token is issued before the split."
+// @Success 200 {object} cluster.RspSearch "RspSearch. It's encoded as vectodb.SearchResponse if the request accepts application/x-protobuf."
+// @Failure 307 "redirection"
+// @Failure 400 "invalid request, zero query vector, or paged search of a split vectodblite"
+// @Failure 503 "memory pressure or draining"
+// @Router /api/v1/search [post]
+func (ctl *Controller) HandleSearch(c *gin.Context) {
+	var reqSearch ReqSearch
+	var err error
+	if err = c.ShouldBind(&reqSearch); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+	} else if err = ctl.conf.validateQuery(reqSearch.Xq); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+	} else if err = ctl.conf.validateSearch(&reqSearch); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+	} else {
+		var rspSearch RspSearch
+		shardCh, ok := ctl.searchLocal(c, &reqSearch, &rspSearch)
+		if !ok {
+			//already return a response
+			return
+		}
+		if shardCh != nil {
+			// the sub-shard is waited after RUnlock, since it may be served by this node
+			if rspShard := <-shardCh; rspShard.Err != "" {
+				if rspSearch.Err == "" {
+					rspSearch.Err = rspShard.Err
+				}
+				log.Errorf("got error %v", rspShard.Err)
+			} else {
+				mergeSearch(&reqSearch, &rspSearch, rspShard)
+			}
+		}
+		ctl.renderSearch(c, &rspSearch)
+	}
+}
+
+// searchLocal searches the vectodblite owned by this node, and starts searching its sub-shard if it's split.
+// The first pages of paged searches are rejected, since pages are frozen per node. ok is false if a response is written already.
+func (ctl *Controller) searchLocal(c *gin.Context, reqSearch *ReqSearch, rspSearch *RspSearch) (shardCh <-chan *RspSearch, ok bool) {
+	var dbl *vectodb.VectoDBLite
+	var err error
+	ctl.rwlock.RLock()
+	defer ctl.rwlock.RUnlock()
+	if dbl, err = ctl.getVectoDBLite(c, reqSearch.DbID); isUnavailable(err) {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		rspSearch.Err = err.Error()
+		log.Errorf("got error %+v", err)
+		ok = true
+		return
+	} else if dbl == nil {
+		//already return a response
+		return
+	}
+	if split := ctl.getSplit(reqSearch.DbID); split != nil && reqSearch.PageToken == "" {
+		if reqSearch.PageSize > 0 {
+			c.String(http.StatusBadRequest, errPagedSplit.Error())
+			return
+		}
+		shardCh = ctl.goSearchShard(c.Request.Context(), *reqSearch, split.Child)
+	}
+	var opts vectodb.SearchOptions
+	var rsts []vectodb.SearchResult
+	start := time.Now()
+	opts, rsts, err = ctl.search(dbl, reqSearch, rspSearch)
+	if ctl.debugEnabled(c, reqSearch.Debug) {
+		log.Infof("debug search: dbID %v, xq hash %016x, nprobe %v, options %+v, size %v, index key %v, took %v, candidates %+v, err %v",
+			reqSearch.DbID, vectodb.HashVector(reqSearch.Xq), rspSearch.Nprobe, opts, dbl.Size(), dbl.IndexKey(), time.Since(start), rsts, err)
+	}
+	if err != nil {
+		rspSearch.Err = err.Error()
+		log.Errorf("got error %+v", err)
+	}
+	ok = true
+	return
+}
+
+// search searches dbl with the request, and fills rspSearch except Err. rsts are the candidates returned by dbl.
+func (ctl *Controller) search(dbl *vectodb.VectoDBLite, reqSearch *ReqSearch, rspSearch *RspSearch) (opts vectodb.SearchOptions, rsts []vectodb.SearchResult, err error) {
+	rspSearch.Nprobe = ctl.conf.effectiveNprobe(reqSearch.Nprobe)
+	opts = vectodb.SearchOptions{
+		MinResults:     reqSearch.MinResults,
+		IncludeVectors: reqSearch.IncludeVectors,
+		GroupBy:        reqSearch.GroupBy,
+		GroupTopK:      reqSearch.GroupTopK,
+		Weighted:       reqSearch.Weighted,
+		PenalizeWeight: ctl.conf.PenalizeWeight,
+		Nprobe:         rspSearch.Nprobe,
+	}
+	rspSearch.Xid = ^uint64(0)
+	if reqSearch.PageSize > 0 || reqSearch.PageToken != "" {
+		return ctl.searchPage(dbl, reqSearch, rspSearch)
+	}
+	if reqSearch.CountOnly {
+		thr := reqSearch.Threshold
+		if thr == 0 {
+			thr = float32(ctl.conf.DisThr)
+		}
+		rspSearch.Count, err = dbl.CountWithin(reqSearch.Xq, thr)
+		return
+	}
+	if rsts, err = dbl.SearchWithOptions(reqSearch.Xq, opts); err != nil {
+		return
+	}
+	if len(rsts) != 0 && !rsts[0].Relaxed {
+		rspSearch.Xid, rspSearch.Distance, rspSearch.Xb = rsts[0].Xid, rsts[0].Distance, rsts[0].Xb
+	}
+	if reqSearch.MinResults > 0 || reqSearch.GroupBy {
+		rspSearch.Results = make([]SearchHit, len(rsts))
+		for i, rst := range rsts {
+			rspSearch.Results[i] = SearchHit{
+				Xid:      rst.Xid,
+				Distance: rst.Distance,
+				Xb:       rst.Xb,
+				Group:    rst.Group,
+				Relaxed:  rst.Relaxed,
+			}
+		}
+	}
+	return
+}
+
+// renderSearch writes the response as protobuf if the client accepts it, otherwise as json.
+// It's compressed if it's at least CompressMinBytes and the client accepts gzip.
+func (ctl *Controller) renderSearch(c *gin.Context, rspSearch *RspSearch) {
+	var data []byte
+	var err error
+	contentType := binding.MIMEPROTOBUF
+	if c.NegotiateFormat(binding.MIMEJSON, binding.MIMEPROTOBUF) != binding.MIMEPROTOBUF {
+		contentType = "application/json; charset=utf-8"
+		data, err = json.Marshal(rspSearch)
+	} else {
+		data, err = rspSearch.toProto().Marshal()
+	}
+	if err != nil {
+		err = errors.Wrap(err, "")
+		log.Errorf("got error %+v", err)
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeCompressed(c, contentType, data, ctl.conf.CompressMinBytes)
+}
+
+// postMgmt posts an acquire or release request to another node with AcquireTimeout.
+func (ctl *Controller) postMgmt(ctx context.Context, servURL string, reqObj, rspObj interface{}) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ctl.conf.AcquireTimeout)*time.Millisecond)
+	defer cancel()
+	err = PostJson(ctx, ctl.hc, servURL, reqObj, rspObj)
+	return
+}
+
+// postData posts a data request to another node with DataTimeout.
+func (ctl *Controller) postData(ctx context.Context, servURL string, reqObj, rspObj interface{}) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ctl.conf.DataTimeout)*time.Millisecond)
+	defer cancel()
+	err = PostJson(ctx, ctl.hc, servURL, reqObj, rspObj)
+	return
+}
+
+// assumes RLock is holded, which is released and re-taken while acquiring
+func (ctl *Controller) getVectoDBLite(c *gin.Context, dbID int) (dbl *vectodb.VectoDBLite, err error) {
+	var owner string
+	if dbl, owner, err = ctl.acquireVectoDBLite(c.Request.Context(), dbID); err == nil && owner != "" {
+		dstURL := *c.Request.URL
+		dstURL.Host = owner
+		c.Redirect(http.StatusTemporaryRedirect, dstURL.String())
+	}
+	return
+}
+
+// acquireVectoDBLite returns the vectodblite if this node owns it or acquires it, otherwise the node which owns it.
+// assumes RLock is holded, which is released and re-taken while acquiring
+func (ctl *Controller) acquireVectoDBLite(ctx context.Context, dbID int) (dbl *vectodb.VectoDBLite, owner string, err error) {
+	var ok bool
+	if ctl.isDraining(dbID) {
+		err = errDraining
+		return
+	}
+	if dbl, ok = ctl.dbls[dbID]; ok {
+		return
+	}
+	// Don't take back vectodblites being released by Shutdown. The client shall retry another node.
+	if atomic.LoadInt32(&ctl.shuttingDown) != 0 {
+		err = errDraining
+		return
+	}
+	// Acquiring waits for AcquireRate, the leader and etcd, so that RLock is released meanwhile not to block writers of ctl.rwlock.
+	var dstNodeAddr string
+	ctl.rwlock.RUnlock()
+	// With hash placement, a vectodblite placed on another node is redirected there without asking the leader, unless it's recorded
+	// to be owned by a node other than the ring owner, i.e. acquired before the ring changed. Otherwise the two nodes would bounce
+	// the request to each other.
+	if ring := ctl.getRing(); ring != nil {
+		if ringOwner := ring.owner(dbID); ringOwner != "" && ringOwner != ctl.conf.ListenAddr {
+			var recorded string
+			if recorded, err = ctl.recordedOwner(ctx, dbID); err != nil {
+				ctl.rwlock.RLock()
+				return
+			} else if recorded == "" || recorded == ringOwner {
+				ctl.rwlock.RLock()
+				owner = ringOwner
+				return
+			}
+		}
+	}
+	dstNodeAddr, err = ctl.requestAcquire(ctx, dbID)
+	if err == nil && ctl.conf.ListenAddr == dstNodeAddr {
+		// a vectodblite split by its previous owner keeps routing to its sub-shard
+		err = ctl.loadSplit(ctx, dbID)
+	}
+	ctl.rwlock.RLock()
+	if err != nil {
+		return
+	}
+
+	if ctl.conf.ListenAddr != dstNodeAddr {
+		owner = dstNodeAddr
+		return
+	}
+	// The leader places new vectodblites away from pressured nodes, unless all nodes are pressured or the pressure isn't published yet.
+	// Don't take them under memory pressure then, and give up the ownership so that they're placed again. The client shall retry later.
+	if dbl, ok = ctl.dbls[dbID]; ok {
+		return
+	}
+	if pressure, _ := ctl.underMemPressure(); pressure {
+		if err = ctl.disown(dbID); err == nil {
+			err = errMemPressure
+		}
+		return
+	}
+	if dbl, err = ctl.ownVectoDBLite(dbID); errors.Cause(err) == vectodb.ErrConfigMismatch {
+		// it can't be loaded with the config of this cluster, don't hold its ownership
+		log.Errorf("skipped acquiring vectodblite %d, error %+v", dbID, err)
+		if err2 := ctl.disown(dbID); err2 != nil {
+			log.Errorf("got error %+v", err2)
+		}
+	}
+	return
+}
+
+// recordedOwner returns the owner of the vectodblite recorded in etcd, empty if it isn't owned by any node.
+func (ctl *Controller) recordedOwner(ctx context.Context, dbID int) (nodeAddr string, err error) {
+	k := fmt.Sprintf("%s/vectodblite/%d", ctl.conf.etcdPath(), dbID)
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, k); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	if len(resp.Kvs) != 0 {
+		nodeAddr = string(resp.Kvs[0].Value)
+	}
+	return
+}
+
+// requestAcquire acquires the vectodblite for this node, and returns its owner. A follower sends the request to the leader at most at AcquireRate.
+func (ctl *Controller) requestAcquire(ctx context.Context, dbID int) (dstNodeAddr string, err error) {
+	if ctl.isLeader {
+		return ctl.acquireOnce(ctx, dbID, ctl.conf.ListenAddr)
+	}
+	curLeader := ctl.curLeader
+	if curLeader == "" {
+		err = errors.Errorf("Need to send acquire request to the leader. However the leader is unknown.")
+		return
+	}
+	if err = ctl.acquireLimiter.wait(ctx); err != nil {
+		return
+	}
+	var adminAddr string
+	if adminAddr, err = ctl.getAdminAddr(ctx, curLeader); err != nil {
+		return
+	}
+	servURL := fmt.Sprintf("%s://%s/mgmt/v1/acquire", ctl.conf.scheme(), adminAddr)
+	reqAcquire := ReqAcquire{
+		DbID:     dbID,
+		NodeAddr: ctl.conf.ListenAddr,
+	}
+	rspAcquire := &RspAcquire{}
+	if err = ctl.postMgmt(ctx, servURL, reqAcquire, rspAcquire); err != nil {
+		return
+	}
+	dstNodeAddr = rspAcquire.NodeAddr
+	return
+}
+
+// ownVectoDBLite adds the vectodblite to this node, after its ownership is settled. assumes RLock is holded
+func (ctl *Controller) ownVectoDBLite(dbID int) (dbl *vectodb.VectoDBLite, err error) {
+	var ok bool
+	// A vectodblite owned by this node meanwhile is reused. A warm standby is promoted at once. Otherwise it's loaded from redis unless FreshOnAcquire.
+	var dblNew *vectodb.VectoDBLite
+	if _, ok = ctl.standbys[dbID]; !ok {
+		if dblNew, err = ctl.newVectoDBLite(dbID, ctl.conf.FreshOnAcquire); err != nil {
+			return
+		}
+	}
+	ctl.rwlock.RUnlock()
+	ctl.rwlock.Lock()
+	defer func() {
+		ctl.rwlock.Unlock()
+		ctl.rwlock.RLock()
+	}()
+	if dbl, ok = ctl.dbls[dbID]; ok {
+		return
+	}
+	if standby, ok := ctl.standbys[dbID]; ok {
+		delete(ctl.standbys, dbID)
+		if err = standby.Promote(); err != nil {
+			log.Errorf("failed to promote warm standby of vectodblite %d, loading it from redis, error %+v", dbID, err)
+			standby.Destroy()
+			err = nil
+		} else {
+			log.Infof("promoted warm standby of vectodblite %d", dbID)
+			if dblNew != nil {
+				dblNew.Destroy()
+			}
+			dblNew = standby
+		}
+	}
+	if dblNew == nil {
+		// the standby is dropped meanwhile, or fails to be promoted
+		if dblNew, err = ctl.newVectoDBLite(dbID, ctl.conf.FreshOnAcquire); err != nil {
+			return
+		}
+	}
+	ctl.dbls[dbID] = dblNew
+	dbl = dblNew
+	return
+}
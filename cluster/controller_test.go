@@ -0,0 +1,2288 @@
+package cluster
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxNprobe(t *testing.T) {
+	conf := NewControllerConf()
+	conf.MaxNprobe = 64
+	require.NoError(t, conf.Validate())
+	require.Equal(t, 64, conf.effectiveNprobe(1000))
+	require.Equal(t, 16, conf.effectiveNprobe(16))
+
+	conf.MaxNprobe = 0
+	require.Equal(t, 1000, conf.effectiveNprobe(1000))
+
+	conf.MaxNprobe = -1
+	require.Error(t, conf.Validate())
+}
+
+func TestRedisOptions(t *testing.T) {
+	conf := NewControllerConf()
+	opts := conf.redisOptions()
+	require.Equal(t, conf.RedisAddr, opts.Addr)
+	require.Equal(t, "", opts.Password)
+	require.Nil(t, opts.TLSConfig)
+
+	conf.RedisPassword = "secret"
+	conf.RedisDB = 3
+	conf.RedisTLS = true
+	opts = conf.redisOptions()
+	require.Equal(t, "secret", opts.Password)
+	require.Equal(t, 3, opts.DB)
+	require.NotNil(t, opts.TLSConfig)
+	rcli := opts.NewClient()
+	defer rcli.Close()
+	require.Equal(t, 3, rcli.Options().DB)
+	require.NotNil(t, rcli.Options().TLSConfig)
+}
+
+func TestMemPressureShedsAdds(t *testing.T) {
+	conf := NewControllerConf()
+	conf.MaxRSSMB = 1024
+	ctl := &Controller{
+		conf: conf,
+		dbls: make(map[int]*vectodb.VectoDBLite),
+		readMemStat: func() (MemStat, error) {
+			return MemStat{RSS: 2048 << 20, Available: 4096 << 20}, nil
+		},
+	}
+	pressure, ms := ctl.underMemPressure()
+	require.True(t, pressure)
+	require.Equal(t, uint64(2048<<20), ms.RSS)
+
+	r := gin.New()
+	r.POST("/api/v1/add", ctl.HandleAdd)
+	r.GET("/health", ctl.HandleHealth)
+	reqBody, err := json.Marshal(ReqAdd{DbID: 1, Xb: []float32{1, 0}})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/add", bytes.NewReader(reqBody)))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var health Health
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	require.True(t, health.MemPressure)
+
+	// memory goes back to normal
+	conf.MaxRSSMB = 4096
+	pressure, _ = ctl.underMemPressure()
+	require.False(t, pressure)
+}
+
+func TestAdminRouter(t *testing.T) {
+	conf := NewControllerConf()
+	conf.AdminAddr = "127.0.0.1:18130"
+	ctl := &Controller{
+		conf: conf,
+		dbls: make(map[int]*vectodb.VectoDBLite),
+	}
+	r := gin.New()
+	admin := gin.New()
+	SetupRouters(ctl, r, admin)
+
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/add", bytes.NewReader([]byte("{}"))))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/mgmt/v1/acquire", bytes.NewReader([]byte("{}"))))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// without an admin address, profiles aren't exposed on the data port
+	conf.AdminAddr = ""
+	r = gin.New()
+	SetupRouters(ctl, r, r)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAcquireAndDataTimeouts(t *testing.T) {
+	conf := NewControllerConf()
+	conf.AcquireTimeout = 50
+	conf.DataTimeout = 2000
+	require.NoError(t, conf.Validate())
+	ctl := &Controller{
+		conf: conf,
+		dbls: make(map[int]*vectodb.VectoDBLite),
+		hc:   &http.Client{},
+	}
+	// a slow peer
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	err := ctl.postMgmt(context.Background(), srv.URL+"/mgmt/v1/acquire", ReqAcquire{DbID: 1}, &RspAcquire{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+
+	err = ctl.postData(context.Background(), srv.URL+"/api/v1/search", ReqSearch{DbID: 1}, &RspSearch{})
+	require.NoError(t, err)
+
+	conf.MigrateTimeout = 50
+	err = ctl.postImport(context.Background(), srv.URL+"/mgmt/v1/import?dbID=1", strings.NewReader(""))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+
+	conf.DataTimeout = 0
+	require.Error(t, conf.Validate())
+}
+
+// requires etcd at 127.0.0.1:2379
+func TestEtcdPrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run := time.Now().UnixNano()
+	ctls := make([]*Controller, 2)
+	for i := range ctls {
+		conf := NewControllerConf()
+		conf.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 18081+i)
+		conf.EtcdPrefix = fmt.Sprintf("test-%d-%d", run, i)
+		ctls[i] = NewController(conf, ctx)
+	}
+	// each controller is the leader of its own cluster
+	for i := 0; i < 100 && !(ctls[0].isLeader && ctls[1].isLeader); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, ctl := range ctls {
+		require.True(t, ctl.isLeader)
+		require.Equal(t, ctl.conf.ListenAddr, ctl.curLeader)
+	}
+	// each controller acquires the same db for itself
+	for _, ctl := range ctls {
+		dstNodeAddr, err := ctl.acquire(ctx, 1, ctl.conf.ListenAddr)
+		require.NoError(t, err)
+		require.Equal(t, ctl.conf.ListenAddr, dstNodeAddr)
+		load, err := ctl.getLoad()
+		require.NoError(t, err)
+		require.Equal(t, map[string][]int{ctl.conf.ListenAddr: {1}}, load)
+	}
+	for _, ctl := range ctls {
+		_, err := ctl.etcdCli.Delete(ctx, ctl.conf.EtcdPrefix, clientv3.WithPrefix())
+		require.NoError(t, err)
+	}
+}
+
+// requires redis at 127.0.0.1:6379
+func TestSearchProtobuf(t *testing.T) {
+	conf := NewControllerConf()
+	conf.Dim = 4
+	dbl, err := vectodb.NewVectoDBLite(conf.RedisAddr, 998, conf.Dim, float32(conf.DisThr), conf.SizeLimit, false)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	xb := []float32{0.5, 0.5, 0.5, 0.5}
+	_, err = dbl.AddWithGroup(xb, 3)
+	require.NoError(t, err)
+	ctl := &Controller{
+		conf: conf,
+		dbls: map[int]*vectodb.VectoDBLite{998: dbl},
+	}
+	r := gin.New()
+	r.POST("/api/v1/search", ctl.HandleSearch)
+	reqBody, err := json.Marshal(ReqSearch{DbID: 998, Xq: xb, Nprobe: 8, IncludeVectors: true, MinResults: 1})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/search", bytes.NewReader(reqBody)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var rspSearch RspSearch
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspSearch))
+	require.Equal(t, xb, rspSearch.Xb)
+	require.Len(t, rspSearch.Results, 1)
+
+	req := httptest.NewRequest("POST", "/api/v1/search", bytes.NewReader(reqBody))
+	req.Header.Set("Accept", "application/x-protobuf")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/x-protobuf", w.Header().Get("Content-Type"))
+	var pb vectodb.SearchResponse
+	require.NoError(t, pb.Unmarshal(w.Body.Bytes()))
+	require.Equal(t, rspSearch.toProto(), &pb)
+}
+
+func TestSearchCompression(t *testing.T) {
+	const dbID = 984
+	conf := NewControllerConf()
+	conf.Dim = 64
+	conf.CompressMinBytes = 1024
+	dbl, err := vectodb.NewVectoDBLite(conf.RedisAddr, dbID, conf.Dim, float32(conf.DisThr), conf.SizeLimit, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	xq := make([]float32, conf.Dim)
+	for i := 0; i < 100; i++ {
+		xb := make([]float32, conf.Dim)
+		for j := range xb {
+			xb[j] = rand.Float32()
+		}
+		_, err = dbl.Add(xb)
+		require.NoError(t, err)
+		xq = xb
+	}
+	ctl := &Controller{
+		conf: conf,
+		dbls: map[int]*vectodb.VectoDBLite{dbID: dbl},
+	}
+	r := gin.New()
+	r.POST("/api/v1/search", ctl.HandleSearch)
+	search := func(acceptEncoding string, minResults int) *httptest.ResponseRecorder {
+		reqBody, err := json.Marshal(ReqSearch{DbID: dbID, Xq: xq, IncludeVectors: true, MinResults: minResults})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/api/v1/search", bytes.NewReader(reqBody))
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	// a large response is compressed if the client accepts gzip
+	w := search("deflate, gzip;q=0.8", 50)
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	zr, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	var rspSearch RspSearch
+	require.NoError(t, json.NewDecoder(zr).Decode(&rspSearch))
+	require.Empty(t, rspSearch.Err)
+	require.Len(t, rspSearch.Results, 50)
+
+	// uncompressed otherwise
+	for _, acceptEncoding := range []string{"", "deflate", "gzip;q=0"} {
+		w = search(acceptEncoding, 50)
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+		var rspSearch2 RspSearch
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspSearch2))
+		require.Equal(t, rspSearch, rspSearch2)
+	}
+
+	// a small response is never compressed
+	w = search("gzip", 1)
+	require.True(t, w.Body.Len() < conf.CompressMinBytes)
+	require.Empty(t, w.Header().Get("Content-Encoding"))
+}
+
+// requires etcd at 127.0.0.1:2379 and redis at 127.0.0.1:6379
+func TestCloseHandoff(t *testing.T) {
+	const dbID = 997
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ctls := make([]*Controller, 2)
+	for i := range ctls {
+		conf := NewControllerConf()
+		conf.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 18091+i)
+		conf.EtcdPrefix = prefix
+		conf.Dim = 4
+		ctls[i] = NewController(conf, ctx)
+		r := gin.New()
+		SetupRouters(ctls[i], r, r)
+		srv := &http.Server{Addr: conf.ListenAddr, Handler: r}
+		go srv.ListenAndServe()
+		defer srv.Close()
+	}
+	defer ctls[0].etcdCli.Delete(ctx, prefix, clientv3.WithPrefix())
+	_, err := redis.NewClient(&redis.Options{Addr: ctls[0].conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+	require.NoError(t, err)
+	for i := 0; i < 100 && (ctls[0].curLeader == "" || ctls[0].curLeader != ctls[1].curLeader); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NotEmpty(t, ctls[0].curLeader)
+	require.Equal(t, ctls[0].curLeader, ctls[1].curLeader)
+	// the follower owns the db, and it is going to shut down
+	follower, peer := ctls[0], ctls[1]
+	if follower.isLeader {
+		follower, peer = peer, follower
+	}
+
+	xb := []float32{0.5, 0.5, 0.5, 0.5}
+	// http.Client follows 307 redirections of POST with the body
+	hc := &http.Client{}
+	var rspAdd RspAdd
+	err = PostJson(ctx, hc, fmt.Sprintf("http://%s/api/v1/add", follower.conf.ListenAddr), ReqAdd{DbID: dbID, Xb: xb}, &rspAdd)
+	require.NoError(t, err)
+	require.Empty(t, rspAdd.Err)
+	follower.rwlock.RLock()
+	require.Contains(t, follower.dbls, dbID)
+	follower.rwlock.RUnlock()
+
+	// keep searching via the peer while the follower is closing
+	stopCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			var rspSearch RspSearch
+			if err := PostJson(ctx, hc, fmt.Sprintf("http://%s/api/v1/search", peer.conf.ListenAddr), ReqSearch{DbID: dbID, Xq: xb}, &rspSearch); err != nil {
+				errCh <- err
+				return
+			} else if rspSearch.Err != "" || rspSearch.Xid != rspAdd.Xid {
+				errCh <- fmt.Errorf("unexpected search response %+v", rspSearch)
+				return
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer shutdownCancel()
+	require.NoError(t, follower.Shutdown(shutdownCtx))
+	time.Sleep(100 * time.Millisecond)
+	close(stopCh)
+	require.NoError(t, <-errCh)
+
+	peer.rwlock.RLock()
+	require.Contains(t, peer.dbls, dbID)
+	peer.rwlock.RUnlock()
+	load, err := peer.getLoad()
+	require.NoError(t, err)
+	require.Equal(t, []int{dbID}, load[peer.conf.ListenAddr])
+}
+
+// requires redis at 127.0.0.1:6379
+func TestPerDbIndexKey(t *testing.T) {
+	conf := NewControllerConf()
+	conf.Dim = 16
+	indexKeys, err := ParseIndexKeys("995=Flat; 996=IVF4,Flat")
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{995: "Flat", 996: "IVF4,Flat"}, indexKeys)
+	conf.IndexKeys = indexKeys
+	require.NoError(t, conf.Validate())
+	conf.IndexKeys = map[int]string{996: "IVF4,PQ5"}
+	require.Error(t, conf.Validate(), "PQ5 doesn't divide dim 16")
+	_, err = ParseIndexKeys("996:IVF4,Flat")
+	require.Error(t, err)
+	conf.IndexKeys = indexKeys
+	ctl := &Controller{conf: conf}
+
+	rcli := redis.NewClient(&redis.Options{Addr: conf.RedisAddr})
+	xbs := make([][]float32, 200) // IVF4 is trained with 39*4 vectors at least
+	for i := range xbs {
+		xbs[i] = make([]float32, conf.Dim)
+		var norm float32
+		for j := range xbs[i] {
+			xbs[i][j] = rand.Float32()
+			norm += xbs[i][j] * xbs[i][j]
+		}
+		norm = float32(math.Sqrt(float64(norm)))
+		for j := range xbs[i] {
+			xbs[i][j] /= norm
+		}
+	}
+	for dbID, wantKey := range map[int]string{995: "Flat", 996: "IVF4,Flat"} {
+		dbl, err := ctl.newVectoDBLite(dbID, true)
+		require.NoError(t, err)
+		require.Equal(t, "Flat", dbl.IndexKey(), "too few vectors to train")
+		xids := make([]uint64, len(xbs))
+		for i, xb := range xbs {
+			xids[i], err = dbl.Add(xb)
+			require.NoError(t, err)
+		}
+		require.NoError(t, dbl.Destroy())
+
+		// reloading trains the index
+		dbl, err = ctl.newVectoDBLite(dbID, false)
+		require.NoError(t, err)
+		require.Equal(t, wantKey, dbl.IndexKey())
+		for i, xb := range xbs {
+			xid, _, err := dbl.Search(xb)
+			require.NoError(t, err)
+			require.Equal(t, xids[i], xid)
+		}
+		require.NoError(t, dbl.Destroy())
+		_, err = rcli.Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+		require.NoError(t, err)
+	}
+}
+
+// requires redis at 127.0.0.1:6379
+func TestIngest(t *testing.T) {
+	const dbID = 994
+	const numRecords = 100000
+	conf := NewControllerConf()
+	conf.Dim = 4
+	conf.SizeLimit = numRecords
+	ctl := &Controller{conf: conf}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	ctl.dbls = map[int]*vectodb.VectoDBLite{dbID: dbl}
+	r := gin.New()
+	r.POST("/api/v1/ingest", ctl.HandleIngest)
+
+	pr, pw := io.Pipe()
+	go func() {
+		var err error
+		for i := 0; i < numRecords && err == nil; i++ {
+			err = writeIngestRecord(pw, uint64(i+1), []float32{rand.Float32(), rand.Float32(), rand.Float32(), rand.Float32()})
+		}
+		pw.CloseWithError(err)
+	}()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/api/v1/ingest?dbID=%d", dbID), pr))
+	require.Equal(t, http.StatusOK, w.Code)
+	var rspIngest RspIngest
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspIngest))
+	require.Equal(t, RspIngest{DbID: dbID, Count: numRecords}, rspIngest)
+	require.Equal(t, numRecords, dbl.Size())
+
+	// a truncated record fails the ingest after adding the complete ones
+	var buf bytes.Buffer
+	require.NoError(t, writeIngestRecord(&buf, numRecords+1, []float32{0.5, 0.5, 0.5, 0.5}))
+	require.NoError(t, writeIngestRecord(&buf, numRecords+2, []float32{0.5, 0.5, 0.5, 0.5}))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/api/v1/ingest?dbID=%d", dbID), bytes.NewReader(buf.Bytes()[:buf.Len()-1])))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspIngest))
+	require.Equal(t, 1, rspIngest.Count)
+	require.NotEmpty(t, rspIngest.Err)
+
+	_, err = redis.NewClient(&redis.Options{Addr: conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+	require.NoError(t, err)
+}
+
+// requires redis at 127.0.0.1:6379
+func TestBatchAdd(t *testing.T) {
+	const dbID = 965
+	conf := NewControllerConf()
+	conf.Dim = 4
+	ctl := &Controller{conf: conf}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	ctl.dbls = map[int]*vectodb.VectoDBLite{dbID: dbl}
+	r := gin.New()
+	r.POST("/api/v1/batch_add", ctl.HandleBatchAdd)
+
+	xbs := [][]float32{{0.5, 0.5, 0.5, 0.5}, {0.1, 0.2, 0.3, 0.4}, {0.4, 0.3, 0.2, 0.1}}
+	body, err := json.Marshal(ReqBatchAdd{DbID: dbID, Xbs: xbs, Xids: []uint64{7, 0, 9}})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/batch_add", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var rspBatchAdd RspBatchAdd
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspBatchAdd))
+	require.Empty(t, rspBatchAdd.Err)
+	require.Equal(t, []uint64{7, vectodb.HashVector(xbs[1]), 9}, rspBatchAdd.Xids)
+	require.Equal(t, len(xbs), dbl.Size())
+
+	// xids shall be as long as xbs if present
+	body, err = json.Marshal(ReqBatchAdd{DbID: dbID, Xbs: xbs, Xids: []uint64{7}})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/batch_add", bytes.NewReader(body)))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// xbs shall be at most MaxBatchSize
+	conf.MaxBatchSize = 2
+	body, err = json.Marshal(ReqBatchAdd{DbID: dbID, Xbs: xbs})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/batch_add", bytes.NewReader(body)))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	_, err = redis.NewClient(&redis.Options{Addr: conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+	require.NoError(t, err)
+}
+
+// requires redis at 127.0.0.1:6379
+func TestBatchSearch(t *testing.T) {
+	const dbID = 964
+	conf := NewControllerConf()
+	conf.Dim = 4
+	ctl := &Controller{conf: conf}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	ctl.dbls = map[int]*vectodb.VectoDBLite{dbID: dbl}
+	r := gin.New()
+	r.POST("/api/v1/batch_search", ctl.HandleBatchSearch)
+
+	xbs := [][]float32{{0.5, 0.5, 0.5, 0.5}, {0.1, 0.2, 0.3, 0.4}}
+	xids, err := dbl.AddBatch(xbs)
+	require.NoError(t, err)
+	body, err := json.Marshal(ReqBatchSearch{DbID: dbID, Xqs: xbs, MinResults: 2})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/batch_search", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var rspBatchSearch RspBatchSearch
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspBatchSearch))
+	require.Empty(t, rspBatchSearch.Err)
+	require.Equal(t, len(xbs), len(rspBatchSearch.Results))
+	for i, rspSearch := range rspBatchSearch.Results {
+		require.Equal(t, xids[i], rspSearch.Xid)
+		require.Equal(t, 2, len(rspSearch.Results))
+	}
+
+	// a zero query vector fails the whole batch
+	body, err = json.Marshal(ReqBatchSearch{DbID: dbID, Xqs: [][]float32{xbs[0], {0, 0, 0, 0}}})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/batch_search", bytes.NewReader(body)))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the results in total shall be at most MaxBatchResults
+	conf.MaxBatchResults = 3
+	body, err = json.Marshal(ReqBatchSearch{DbID: dbID, Xqs: xbs, MinResults: 2})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/batch_search", bytes.NewReader(body)))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the vectors returned shall be at most MaxVectorFloats, i.e. 2 queries * 1 result * 4 floats
+	conf.MaxBatchResults = 100
+	conf.MaxVectorFloats = 8
+	body, err = json.Marshal(ReqBatchSearch{DbID: dbID, Xqs: xbs, IncludeVectors: true})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/batch_search", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+	body, err = json.Marshal(ReqBatchSearch{DbID: dbID, Xqs: xbs, MinResults: 2, IncludeVectors: true})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/batch_search", bytes.NewReader(body)))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	r.POST("/api/v1/search", ctl.HandleSearch)
+	for _, reqSearch := range []ReqSearch{
+		{DbID: dbID, Xq: xbs[0], MinResults: 3, IncludeVectors: true},
+		{DbID: dbID, Xq: xbs[0], GroupBy: true, GroupTopK: 1, IncludeVectors: true},
+	} {
+		body, err = json.Marshal(reqSearch)
+		require.NoError(t, err)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/search", bytes.NewReader(body)))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	_, err = redis.NewClient(&redis.Options{Addr: conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+	require.NoError(t, err)
+}
+
+// requires redis at 127.0.0.1:6379
+func TestMetrics(t *testing.T) {
+	const dbID = 961
+	conf := NewControllerConf()
+	conf.Dim = 4
+	ctl := &Controller{conf: conf}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	ctl.dbls = map[int]*vectodb.VectoDBLite{dbID: dbl}
+	r := gin.New()
+	SetupRouters(ctl, r, r)
+
+	reqBody, err := json.Marshal(ReqAdd{DbID: dbID, Xb: []float32{0.5, 0.5, 0.5, 0.5}})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/add", bytes.NewReader(reqBody)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	require.Contains(t, body, `vectodblite_requests_total{code="200",endpoint="add"}`)
+	require.Contains(t, body, `vectodblite_request_duration_seconds_count{endpoint="add"}`)
+	require.Contains(t, body, "vectodblite_owned 1")
+	require.Contains(t, body, fmt.Sprintf(`vectodblite_vectors{db="%d"} 1`, dbID))
+	require.Contains(t, body, fmt.Sprintf(`vectodblite_dangling_total{db="%d"} 0`, dbID))
+
+	_, err = redis.NewClient(&redis.Options{Addr: conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+	require.NoError(t, err)
+}
+
+func TestPlanAssignment(t *testing.T) {
+	load := map[string][]int{"a": {}, "b": {}, "c": {}, "dead": {100}}
+	for dbID := 0; dbID < 30; dbID++ {
+		node := []string{"a", "b", "c"}[dbID%3]
+		load[node] = append(load[node], dbID)
+	}
+	aliveNodes := map[string]int{"a": 0, "b": 0, "c": 0}
+	target, moves := planAssignment(load, aliveNodes)
+	require.Empty(t, moves)
+	require.Equal(t, load["a"], target["a"])
+
+	// a node joining only takes its share from the others
+	aliveNodes["d"] = 0
+	target, moves = planAssignment(load, aliveNodes)
+	require.Equal(t, 7, len(moves))
+	for _, move := range moves {
+		require.Equal(t, "d", move.To)
+		require.NotContains(t, target[move.From], move.DbID)
+	}
+	sizes := make([]int, 0, len(target))
+	for _, dbList := range target {
+		sizes = append(sizes, len(dbList))
+	}
+	sort.Ints(sizes)
+	require.Equal(t, []int{7, 7, 8, 8}, sizes)
+	require.NotContains(t, target, "dead")
+
+	// the plan is deterministic
+	_, moves2 := planAssignment(load, aliveNodes)
+	require.Equal(t, moves, moves2)
+}
+
+// requires redis at 127.0.0.1:6379
+func TestOwned(t *testing.T) {
+	const dbID = 958
+	conf := NewControllerConf()
+	conf.Dim = 4
+	ctl := &Controller{conf: conf}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	_, err = dbl.Add([]float32{0.5, 0.5, 0.5, 0.5})
+	require.NoError(t, err)
+	// a deleted vector isn't counted
+	xid, err := dbl.Add([]float32{0.1, 0.2, 0.3, 0.4})
+	require.NoError(t, err)
+	require.NoError(t, dbl.Delete(xid))
+	ctl.dbls = map[int]*vectodb.VectoDBLite{dbID: dbl}
+	r := gin.New()
+	SetupRouters(ctl, r, r)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/mgmt/v1/owned", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var rspOwned RspOwned
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspOwned))
+	require.Empty(t, rspOwned.Err)
+	require.Equal(t, []OwnedDb{{DbID: dbID, Count: 1}}, rspOwned.Owned)
+
+	_, err = redis.NewClient(&redis.Options{Addr: conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+	require.NoError(t, err)
+}
+
+func TestLeader(t *testing.T) {
+	ctl := &Controller{conf: NewControllerConf(), dbls: make(map[int]*vectodb.VectoDBLite)}
+	r := gin.New()
+	SetupRouters(ctl, r, r)
+	getLeader := func() (rspLeader RspLeader) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/mgmt/v1/leader", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspLeader))
+		return
+	}
+	require.Equal(t, RspLeader{}, getLeader())
+	ctl.curLeader = "127.0.0.1:9999"
+	require.Equal(t, RspLeader{Leader: "127.0.0.1:9999"}, getLeader())
+	ctl.curLeader, ctl.isLeader = ctl.conf.ListenAddr, true
+	require.Equal(t, RspLeader{Leader: ctl.conf.ListenAddr, IsSelf: true}, getLeader())
+}
+
+func TestAuth(t *testing.T) {
+	apiKey := "client-key"
+	mgmtKey := strings.Repeat("m", MinMgmtKeyLen)
+	conf := NewControllerConf()
+	conf.APIKeys = []string{apiKey}
+	require.Error(t, conf.Validate())
+	conf.MgmtKeys = []string{"short"}
+	require.Error(t, conf.Validate())
+	conf.MgmtKeys = []string{mgmtKey}
+	require.NoError(t, conf.Validate())
+
+	ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+	r := gin.New()
+	r.Group("/api/v1", ctl.authAPI).POST("/ok", func(c *gin.Context) { c.String(http.StatusOK, "") })
+	r.Group("/mgmt/v1", ctl.authMgmt).POST("/ok", func(c *gin.Context) { c.String(http.StatusOK, "") })
+	post := func(path string, header, value string) int {
+		req := httptest.NewRequest("POST", path, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	require.Equal(t, http.StatusUnauthorized, post("/api/v1/ok", "", ""))
+	require.Equal(t, http.StatusUnauthorized, post("/api/v1/ok", APIKeyHeader, "wrong"))
+	require.Equal(t, http.StatusOK, post("/api/v1/ok", APIKeyHeader, apiKey))
+	require.Equal(t, http.StatusOK, post("/api/v1/ok", "Authorization", "Bearer "+apiKey))
+	require.Equal(t, http.StatusUnauthorized, post("/mgmt/v1/ok", APIKeyHeader, apiKey))
+	require.Equal(t, http.StatusOK, post("/mgmt/v1/ok", "Authorization", "Bearer "+mgmtKey))
+
+	// inter-node requests carry the mgmt key
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	hc, err := conf.newHTTPClient()
+	require.NoError(t, err)
+	for _, path := range []string{"/api/v1/ok", "/mgmt/v1/ok"} {
+		rsp, err := hc.Post(ts.URL+path, "application/json", nil)
+		require.NoError(t, err)
+		rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	l := newClientLimiter(2, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allow("a", now)
+		require.True(t, ok)
+	}
+	ok, retryAfter := l.allow("a", now)
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, retryAfter)
+	// buckets are per client
+	ok, _ = l.allow("b", now)
+	require.True(t, ok)
+	ok, _ = l.allow("a", now.Add(500*time.Millisecond))
+	require.True(t, ok)
+	// idle buckets are dropped
+	l.allow("c", now.Add(ClientSweepInterval))
+	require.Len(t, l.buckets, 1)
+
+	conf := NewControllerConf()
+	conf.ClientRate = 1
+	conf.ClientBurst = 1
+	require.NoError(t, conf.Validate())
+	ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite), clientLimiter: newClientLimiter(conf.ClientRate, conf.ClientBurst)}
+	r := gin.New()
+	r.Group("/api/v1", ctl.rateLimit).POST("/ok", func(c *gin.Context) { c.String(http.StatusOK, "") })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/ok", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/ok", nil))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+	// X-Forwarded-For doesn't make another client
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/ok", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	// nodes of the cluster aren't limited without mgmt keys
+	ctl.peerIPs = map[string]bool{remoteIP(req): true}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/ok", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPostJsonRedirect(t *testing.T) {
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqAdd ReqAdd
+		if err := json.NewDecoder(r.Body).Decode(&reqAdd); err != nil || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(RspAdd{Xid: uint64(reqAdd.DbID)})
+	}))
+	defer owner.Close()
+	// a stale owner bounces to another stale one with a scheme-relative location, the same as the controller
+	stale2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "//"+strings.TrimPrefix(owner.URL, "http://")+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer stale2.Close()
+	stale1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, stale2.URL+r.URL.Path, http.StatusMovedPermanently)
+	}))
+	defer stale1.Close()
+
+	var rspAdd RspAdd
+	err := PostJson(context.Background(), &http.Client{}, stale1.URL+"/api/v1/add", ReqAdd{DbID: 7}, &rspAdd)
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), rspAdd.Xid)
+
+	loop := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer loop.Close()
+	err = PostJson(context.Background(), &http.Client{}, loop.URL+"/api/v1/add", ReqAdd{DbID: 7}, &rspAdd)
+	require.Error(t, err)
+}
+
+func TestHTTPClientTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	conf := NewControllerConf()
+	require.Equal(t, "http", conf.scheme())
+	conf.CertFile = "cert.pem"
+	require.Error(t, conf.Validate())
+	conf.KeyFile = "key.pem"
+	require.NoError(t, conf.Validate())
+	require.Equal(t, "https", conf.scheme())
+
+	// the system pool doesn't trust the test server
+	hc, err := conf.newHTTPClient()
+	require.NoError(t, err)
+	_, err = hc.Get(ts.URL)
+	require.Error(t, err)
+
+	caFile, err := ioutil.TempFile("", "ca")
+	require.NoError(t, err)
+	defer os.Remove(caFile.Name())
+	err = pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	require.NoError(t, err)
+	require.NoError(t, caFile.Close())
+	conf.CAFile = caFile.Name()
+	hc, err = conf.newHTTPClient()
+	require.NoError(t, err)
+	rsp, err := hc.Get(ts.URL)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+}
+
+func TestHashRing(t *testing.T) {
+	require.Equal(t, "", newHashRing(nil).owner(1))
+	ring := newHashRing([]string{"a:1", "b:1", "c:1"})
+	ring2 := newHashRing([]string{"c:1", "a:1", "b:1"})
+	grown := newHashRing([]string{"a:1", "b:1", "c:1", "d:1"})
+	const numDbs = 1000
+	numMoved := 0
+	for dbID := 0; dbID < numDbs; dbID++ {
+		owner := ring.owner(dbID)
+		require.Equal(t, owner, ring2.owner(dbID))
+		// a node joining only takes dbIDs from the others
+		if newOwner := grown.owner(dbID); newOwner != owner {
+			require.Equal(t, "d:1", newOwner)
+			numMoved++
+		}
+	}
+	require.InDelta(t, numDbs/4, numMoved, numDbs/10)
+
+	load := map[string][]int{"a:1": {}, "b:1": {}}
+	for dbID := 0; dbID < 10; dbID++ {
+		load["a:1"] = append(load["a:1"], dbID)
+	}
+	aliveNodes := map[string]int{"a:1": 0, "b:1": 0}
+	target, moves := planRingAssignment(load, aliveNodes, newHashRing([]string{"a:1", "b:1"}))
+	for _, move := range moves {
+		require.Equal(t, "b:1", move.To)
+	}
+	require.Equal(t, len(moves), len(target["b:1"]))
+	_, moves = planRingAssignment(load, aliveNodes, nil)
+	require.Empty(t, moves)
+}
+
+// requires redis at 127.0.0.1:6379
+func TestDelete(t *testing.T) {
+	const dbID = 959
+	conf := NewControllerConf()
+	conf.Dim = 4
+	ctl := &Controller{conf: conf}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	ctl.dbls = map[int]*vectodb.VectoDBLite{dbID: dbl}
+	r := gin.New()
+	r.POST("/api/v1/delete", ctl.HandleDelete)
+	xid, err := dbl.Add([]float32{0.5, 0.5, 0.5, 0.5})
+	require.NoError(t, err)
+
+	del := func(xid uint64) (rspDelete RspDelete) {
+		reqBody, err := json.Marshal(ReqDelete{DbID: dbID, Xid: xid})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/delete", bytes.NewReader(reqBody)))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspDelete))
+		return
+	}
+	require.Equal(t, RspDelete{Found: true}, del(xid))
+	// already deleted
+	require.Equal(t, RspDelete{Found: false}, del(xid))
+	require.Equal(t, RspDelete{Found: false}, del(xid+1))
+
+	_, err = redis.NewClient(&redis.Options{Addr: conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+	require.NoError(t, err)
+}
+
+// requires redis at 127.0.0.1:6379
+func TestSearchIntersect(t *testing.T) {
+	conf := NewControllerConf()
+	conf.Dim = 4
+	ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+	for _, dbID := range []int{992, 993} {
+		dbl, err := ctl.newVectoDBLite(dbID, true)
+		require.NoError(t, err)
+		defer dbl.Destroy()
+		ctl.dbls[dbID] = dbl
+	}
+	// xids 1~4 are similar to xq, 1 and 3 are present in the other shard, 4 is deleted from the other shard.
+	xq := []float32{0.5, 0.5, 0.5, 0.5}
+	xbs := [][]float32{{0.5, 0.5, 0.5, 0.5}, {0.6, 0.5, 0.5, 0.4}, {0.7, 0.5, 0.4, 0.3}, {0.5, 0.5, 0.6, 0.4}, {1, 0, 0, 0}}
+	for i, xb := range xbs {
+		require.NoError(t, ctl.dbls[992].AddWithId(xb, uint64(i+1)))
+	}
+	for _, xid := range []uint64{1, 3, 4, 5} {
+		require.NoError(t, ctl.dbls[993].AddWithId(xbs[xid-1], xid))
+	}
+	require.NoError(t, ctl.dbls[993].Delete(4))
+
+	r := gin.New()
+	r.POST("/api/v1/search_intersect", ctl.HandleSearchIntersect)
+	search := func(topK int) (rsp RspSearchIntersect) {
+		reqBody, err := json.Marshal(ReqSearchIntersect{DbID: 992, OtherDbID: 993, Xq: xq, TopK: topK})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/search_intersect", bytes.NewReader(reqBody)))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		require.Empty(t, rsp.Err)
+		return
+	}
+	// 5 is present in both, but not similar
+	rsp := search(10)
+	require.Len(t, rsp.Results, 2)
+	require.Equal(t, uint64(1), rsp.Results[0].Xid)
+	require.Equal(t, uint64(3), rsp.Results[1].Xid)
+	rsp = search(1)
+	require.Len(t, rsp.Results, 1)
+	require.Equal(t, uint64(1), rsp.Results[0].Xid)
+}
+
+// requires redis at 127.0.0.1:6379
+func TestDistribution(t *testing.T) {
+	const dbID = 991
+	conf := NewControllerConf()
+	conf.Dim = 2
+	ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	ctl.dbls[dbID] = dbl
+	// unit vectors evenly spread on the upper half circle, so distances spread over [-1, 1]
+	for i := 0; i < 100; i++ {
+		theta := math.Pi * float64(i) / 100
+		require.NoError(t, dbl.AddWithId([]float32{float32(math.Cos(theta)), float32(math.Sin(theta))}, uint64(i+1)))
+	}
+
+	r := gin.New()
+	r.POST("/mgmt/v1/distribution", ctl.HandleDistribution)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/mgmt/v1/distribution?dbID=%d&pairs=5000", dbID), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var rspDist RspDistribution
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspDist))
+	require.Empty(t, rspDist.Err)
+	require.Equal(t, 5000, rspDist.Pairs)
+	require.Equal(t, DistributionPercentiles, rspDist.Percentiles)
+	require.Len(t, rspDist.Distances, len(DistributionPercentiles))
+	for i := 1; i < len(rspDist.Distances); i++ {
+		require.True(t, rspDist.Distances[i-1] <= rspDist.Distances[i])
+	}
+	require.True(t, rspDist.Distances[0] < 0)
+	require.True(t, rspDist.Distances[len(rspDist.Distances)-1] > 0.9)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/mgmt/v1/distribution?dbID=1", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspDist))
+	require.NotEmpty(t, rspDist.Err)
+}
+
+// requires redis at 127.0.0.1:6379
+func TestExportImport(t *testing.T) {
+	// Both clusters share the local redis, so the destination uses another dbID.
+	const srcDbID, dstDbID = 990, 989
+	conf := NewControllerConf()
+	conf.Dim = 4
+	ctls := make([]*Controller, 2)
+	for i, dbID := range []int{srcDbID, dstDbID} {
+		ctls[i] = &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+		dbl, err := ctls[i].newVectoDBLite(dbID, true)
+		require.NoError(t, err)
+		defer dbl.Destroy()
+		ctls[i].dbls[dbID] = dbl
+	}
+	for i := 0; i < 100; i++ {
+		_, err := ctls[0].dbls[srcDbID].AddWithGroup([]float32{rand.Float32(), rand.Float32(), rand.Float32(), rand.Float32()}, uint64(i%3))
+		require.NoError(t, err)
+	}
+	r := gin.New()
+	r.GET("/mgmt/v1/export", ctls[0].HandleExport)
+	r.POST("/mgmt/v1/import", ctls[1].HandleImport)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/mgmt/v1/export?dbID=%d", srcDbID), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	exported := w.Body.Bytes()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/mgmt/v1/import?dbID=%d", dstDbID), bytes.NewReader(exported)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var rspImport RspImport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspImport))
+	require.Equal(t, RspImport{DbID: dstDbID, Count: 100}, rspImport)
+
+	for i := 0; i < 10; i++ {
+		xq := []float32{rand.Float32(), rand.Float32(), rand.Float32(), rand.Float32()}
+		opts := vectodb.SearchOptions{MinResults: 5}
+		rsts0, err := ctls[0].dbls[srcDbID].SearchWithOptions(xq, opts)
+		require.NoError(t, err)
+		rsts1, err := ctls[1].dbls[dstDbID].SearchWithOptions(xq, opts)
+		require.NoError(t, err)
+		require.Equal(t, rsts0, rsts1)
+	}
+
+	// dim mismatch
+	conf2 := *conf
+	conf2.Dim = 8
+	ctls[1].conf = &conf2
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/mgmt/v1/import?dbID=%d", dstDbID), bytes.NewReader(exported)))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspImport))
+	require.Equal(t, 0, rspImport.Count)
+	require.NotEmpty(t, rspImport.Err)
+}
+
+func TestZeroQuery(t *testing.T) {
+	conf := NewControllerConf()
+	conf.Dim = 4
+	ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+	r := gin.New()
+	r.POST("/api/v1/search", ctl.HandleSearch)
+	for _, xq := range [][]float32{{}, {0, 0, 0, 0}} {
+		reqBody, err := json.Marshal(ReqSearch{DbID: 1, Xq: xq})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/search", bytes.NewReader(reqBody)))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, vectodb.ErrZeroVector.Error(), w.Body.String())
+	}
+	conf.AllowZeroQuery = true
+	require.Error(t, conf.validateQuery([]float32{}))
+	require.NoError(t, conf.validateQuery([]float32{0, 0, 0, 0}))
+}
+
+// requires redis at 127.0.0.1:6379
+func TestDebugLog(t *testing.T) {
+	const dbID = 988
+	conf := NewControllerConf()
+	conf.Dim = 4
+	conf.DebugToken = "secret"
+	ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	ctl.dbls[dbID] = dbl
+	r := gin.New()
+	r.POST("/api/v1/add", ctl.HandleAdd)
+	r.POST("/api/v1/search", ctl.HandleSearch)
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	xb := []float32{0.5, 0.5, 0.5, 0.5}
+	numDebugLogs := func(path string, reqObj interface{}, token string) (num int) {
+		reqBody, err := json.Marshal(reqObj)
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(reqBody))
+		if token != "" {
+			req.Header.Set(DebugTokenHeader, token)
+		}
+		hook.Reset()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		for _, entry := range hook.AllEntries() {
+			if strings.HasPrefix(entry.Message, "debug ") {
+				require.Equal(t, log.InfoLevel, entry.Level)
+				num++
+			}
+		}
+		return
+	}
+	require.Equal(t, 1, numDebugLogs("/api/v1/add", ReqAdd{DbID: dbID, Xb: xb, Debug: true}, "secret"))
+	require.Equal(t, 0, numDebugLogs("/api/v1/add", ReqAdd{DbID: dbID, Xb: xb}, "secret"))
+	require.Equal(t, 1, numDebugLogs("/api/v1/search", ReqSearch{DbID: dbID, Xq: xb, Debug: true}, "secret"))
+	require.Equal(t, 0, numDebugLogs("/api/v1/search", ReqSearch{DbID: dbID, Xq: xb}, "secret"))
+	// unauthorized
+	require.Equal(t, 0, numDebugLogs("/api/v1/search", ReqSearch{DbID: dbID, Xq: xb, Debug: true}, ""))
+	require.Equal(t, 0, numDebugLogs("/api/v1/search", ReqSearch{DbID: dbID, Xq: xb, Debug: true}, "wrong"))
+}
+
+// requires redis at 127.0.0.1:6379
+func TestIdStrategies(t *testing.T) {
+	const dbID = 987
+	const numAdds = 100
+	for _, strategy := range []string{IdStrategyHash, IdStrategySequential, IdStrategyRandom, IdStrategySnowflake} {
+		conf := NewControllerConf()
+		conf.Dim = 4
+		conf.IdStrategy = strategy
+		conf.SnowflakeNode = 5
+		require.NoError(t, conf.Validate())
+		ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+		var err error
+		ctl.idGen, err = newIdGenerator(conf)
+		require.NoError(t, err)
+		dbl, err := ctl.newVectoDBLite(dbID, true)
+		require.NoError(t, err)
+		ctl.dbls[dbID] = dbl
+		r := gin.New()
+		r.POST("/api/v1/add", ctl.HandleAdd)
+
+		xids := make(map[uint64]bool)
+		var prevXid uint64
+		for i := 0; i < numAdds; i++ {
+			reqBody, err := json.Marshal(ReqAdd{DbID: dbID, Xb: []float32{rand.Float32(), rand.Float32(), rand.Float32(), rand.Float32()}})
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/add", bytes.NewReader(reqBody)))
+			require.Equal(t, http.StatusOK, w.Code)
+			var rspAdd RspAdd
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspAdd))
+			require.Empty(t, rspAdd.Err, strategy)
+			require.NotEqual(t, uint64(0), rspAdd.Xid, strategy)
+			require.NotEqual(t, ^uint64(0), rspAdd.Xid, strategy)
+			require.False(t, xids[rspAdd.Xid], "%v generated duplicated xid %v", strategy, rspAdd.Xid)
+			xids[rspAdd.Xid] = true
+			switch strategy {
+			case IdStrategySequential:
+				if prevXid != 0 {
+					require.Equal(t, prevXid+1, rspAdd.Xid)
+				}
+			case IdStrategySnowflake:
+				require.True(t, rspAdd.Xid > prevXid, "snowflake xids shall increase over time")
+				require.Equal(t, uint64(conf.SnowflakeNode), rspAdd.Xid>>12&SnowflakeMaxNode)
+				ms := int64(rspAdd.Xid>>22) + SnowflakeEpoch
+				require.InDelta(t, time.Now().UnixNano()/int64(time.Millisecond), ms, 1000)
+			}
+			prevXid = rspAdd.Xid
+		}
+		require.Equal(t, numAdds, dbl.Size())
+		require.NoError(t, dbl.Destroy())
+	}
+	_, err := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"}).Del(fmt.Sprintf("vectodblite_%d", dbID), fmt.Sprintf("vectodblite_xid_seq_%d", dbID)).Result()
+	require.NoError(t, err)
+
+	conf := NewControllerConf()
+	conf.IdStrategy = "unknown"
+	require.Error(t, conf.Validate())
+}
+
+// requires redis at 127.0.0.1:6379
+func TestRandomIdCollision(t *testing.T) {
+	const dbID = 987
+	conf := NewControllerConf()
+	conf.Dim = 4
+	ctl := &Controller{conf: conf}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	require.NoError(t, dbl.AddWithId([]float32{0.5, 0.5, 0.5, 0.5}, 42))
+	// 0 and ^uint64(0) are reserved, 42 collides
+	candidates := []uint64{0, ^uint64(0), 42, 43}
+	gen := &randIdGenerator{
+		rand: func() (xid uint64) {
+			xid, candidates = candidates[0], candidates[1:]
+			return
+		},
+	}
+	xid, err := gen.nextID(dbID, dbl)
+	require.NoError(t, err)
+	require.Equal(t, uint64(43), xid)
+
+	gen.rand = func() uint64 { return 42 }
+	_, err = gen.nextID(dbID, dbl)
+	require.Error(t, err)
+}
+
+func TestFaultInjection(t *testing.T) {
+	const numReqs = 4000
+	conf := NewControllerConf()
+	conf.DebugToken = "secret"
+	ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+	r := gin.New()
+	r.Group("/api/v1", ctl.injectFault).POST("/ok", func(c *gin.Context) { c.String(http.StatusOK, "") })
+	r.PUT("/mgmt/v1/fault_injection", ctl.HandleFaultInjection)
+
+	putFaults := func(fi FaultInjection, token string) int {
+		reqBody, err := json.Marshal(fi)
+		require.NoError(t, err)
+		req := httptest.NewRequest("PUT", "/mgmt/v1/fault_injection", bytes.NewReader(reqBody))
+		req.Header.Set(DebugTokenHeader, token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	countCodes := func() (codes map[int]int) {
+		codes = make(map[int]int)
+		for i := 0; i < numReqs; i++ {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/ok", nil))
+			codes[w.Code]++
+		}
+		return
+	}
+	require.Equal(t, numReqs, countCodes()[http.StatusOK])
+
+	fi := FaultInjection{ErrorRate: 0.2, RedirectRate: 0.1, RedirectAddr: "127.0.0.1:9999"}
+	require.Equal(t, http.StatusForbidden, putFaults(fi, "wrong"))
+	require.Equal(t, http.StatusBadRequest, putFaults(FaultInjection{ErrorRate: 0.6, DelayRate: 0.6}, "secret"))
+	require.Equal(t, http.StatusOK, putFaults(fi, "secret"))
+	codes := countCodes()
+	require.InDelta(t, fi.ErrorRate, float64(codes[http.StatusInternalServerError])/numReqs, 0.03)
+	require.InDelta(t, fi.RedirectRate, float64(codes[http.StatusTemporaryRedirect])/numReqs, 0.03)
+	require.Equal(t, numReqs, codes[http.StatusOK]+codes[http.StatusInternalServerError]+codes[http.StatusTemporaryRedirect])
+
+	require.Equal(t, http.StatusOK, putFaults(FaultInjection{}, "secret"))
+	require.Equal(t, numReqs, countCodes()[http.StatusOK])
+}
+
+// requires etcd at 127.0.0.1:2379 and redis at 127.0.0.1:6379
+func TestDrain(t *testing.T) {
+	const dbID = 986
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conf := NewControllerConf()
+	conf.ListenAddr = "127.0.0.1:18101"
+	conf.EtcdPrefix = fmt.Sprintf("test-%d", time.Now().UnixNano())
+	conf.Dim = 4
+	ctl := NewController(conf, ctx)
+	defer ctl.etcdCli.Delete(ctx, conf.EtcdPrefix, clientv3.WithPrefix())
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	xb := []float32{0.5, 0.5, 0.5, 0.5}
+	_, err = dbl.Add(xb)
+	require.NoError(t, err)
+	ctl.rwlock.Lock()
+	ctl.dbls[dbID] = dbl
+	ctl.rwlock.Unlock()
+	r := gin.New()
+	r.POST("/api/v1/search", ctl.HandleSearch)
+
+	// a slow search holds the controller read lock as handlers do
+	searchDone := make(chan error, 1)
+	inFlight := make(chan struct{})
+	go func() {
+		ctl.rwlock.RLock()
+		defer ctl.rwlock.RUnlock()
+		close(inFlight)
+		time.Sleep(300 * time.Millisecond)
+		_, _, err := ctl.dbls[dbID].Search(xb)
+		searchDone <- err
+	}()
+	<-inFlight
+	drainDone := make(chan error, 1)
+	go func() {
+		drainDone <- ctl.drain(dbID)
+	}()
+	for i := 0; i < 100 && !ctl.isDraining(dbID); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	require.True(t, ctl.isDraining(dbID))
+	require.Error(t, ctl.drain(dbID))
+
+	require.NoError(t, <-drainDone)
+	select {
+	case err = <-searchDone:
+		require.NoError(t, err)
+	default:
+		t.Fatal("drain completed before the in-flight search")
+	}
+	require.False(t, ctl.isDraining(dbID))
+	ctl.rwlock.RLock()
+	require.NotContains(t, ctl.dbls, dbID)
+	ctl.rwlock.RUnlock()
+
+	// new requests are rejected while draining
+	ctl.drainLock.Lock()
+	ctl.draining[dbID] = true
+	ctl.drainLock.Unlock()
+	reqBody, err := json.Marshal(ReqSearch{DbID: dbID, Xq: xb})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/search", bytes.NewReader(reqBody)))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// requires etcd at 127.0.0.1:2379 and redis at 127.0.0.1:6379
+func TestRelease(t *testing.T) {
+	const dbID = 963
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conf := NewControllerConf()
+	conf.ListenAddr = "127.0.0.1:18121"
+	conf.EtcdPrefix = fmt.Sprintf("test-%d", time.Now().UnixNano())
+	conf.Dim = 4
+	ctl := NewController(conf, ctx)
+	defer ctl.etcdCli.Delete(ctx, conf.EtcdPrefix, clientv3.WithPrefix())
+	for i := 0; i < 100 && !ctl.isLeader; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.True(t, ctl.isLeader)
+	_, err := ctl.acquire(ctx, dbID, conf.ListenAddr)
+	require.NoError(t, err)
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	ctl.rwlock.Lock()
+	ctl.dbls[dbID] = dbl
+	ctl.rwlock.Unlock()
+	r := gin.New()
+	r.POST("/mgmt/v1/release", ctl.HandleRelease)
+
+	reqBody, err := json.Marshal(ReqRelease{DbID: dbID})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/mgmt/v1/release", bytes.NewReader(reqBody)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var rspRelease RspRelease
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspRelease))
+	require.Equal(t, RspRelease{DbID: dbID}, rspRelease)
+	ctl.rwlock.RLock()
+	require.NotContains(t, ctl.dbls, dbID)
+	ctl.rwlock.RUnlock()
+	load, err := ctl.getLoad()
+	require.NoError(t, err)
+	require.Empty(t, load[conf.ListenAddr])
+}
+
+// requires etcd at 127.0.0.1:2379 and redis at 127.0.0.1:6379
+func TestRedirectAdd(t *testing.T) {
+	const dbID = 962
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ctls := make([]*Controller, 2)
+	routers := make([]*gin.Engine, 2)
+	for i := range ctls {
+		conf := NewControllerConf()
+		conf.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 18122+i)
+		conf.EtcdPrefix = prefix
+		conf.Dim = 4
+		ctls[i] = NewController(conf, ctx)
+		routers[i] = gin.New()
+		SetupRouters(ctls[i], routers[i], routers[i])
+		srv := &http.Server{Addr: conf.ListenAddr, Handler: routers[i]}
+		go srv.ListenAndServe()
+		defer srv.Close()
+	}
+	defer ctls[0].etcdCli.Delete(ctx, prefix, clientv3.WithPrefix())
+	_, err := redis.NewClient(&redis.Options{Addr: ctls[0].conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+	require.NoError(t, err)
+	for i := 0; i < 100 && (ctls[0].curLeader == "" || ctls[0].curLeader != ctls[1].curLeader); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NotEmpty(t, ctls[0].curLeader)
+	leader, follower := ctls[0], ctls[1]
+	followerRouter := routers[1]
+	if follower.isLeader {
+		leader, follower = follower, leader
+		followerRouter = routers[0]
+	}
+	_, err = leader.acquire(ctx, dbID, leader.conf.ListenAddr)
+	require.NoError(t, err)
+
+	// the follower redirects to the owner with 307, which preserves the method and body
+	xb := []float32{0.5, 0.5, 0.5, 0.5}
+	reqBody, err := json.Marshal(ReqAdd{DbID: dbID, Xb: xb})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	followerRouter.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/add", bytes.NewReader(reqBody)))
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	require.True(t, strings.HasSuffix(w.Header().Get("Location"), leader.conf.ListenAddr+"/api/v1/add"))
+
+	var rspAdd RspAdd
+	err = PostJson(ctx, &http.Client{}, fmt.Sprintf("http://%s/api/v1/add", follower.conf.ListenAddr), ReqAdd{DbID: dbID, Xb: xb}, &rspAdd)
+	require.NoError(t, err)
+	require.Empty(t, rspAdd.Err)
+	require.Equal(t, vectodb.HashVector(xb), rspAdd.Xid)
+	leader.rwlock.RLock()
+	require.Contains(t, leader.dbls, dbID)
+	require.True(t, leader.dbls[dbID].Contains(rspAdd.Xid))
+	leader.rwlock.RUnlock()
+	follower.rwlock.RLock()
+	require.NotContains(t, follower.dbls, dbID)
+	follower.rwlock.RUnlock()
+}
+
+// requires etcd at 127.0.0.1:2379 and redis at 127.0.0.1:6379
+func TestReadyz(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	probe := func(ctl *Controller, path string) int {
+		r := gin.New()
+		SetupRouters(ctl, r, r)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	// a controller without etcd is alive but not ready
+	conf := NewControllerConf()
+	ctl := &Controller{conf: conf}
+	require.Equal(t, http.StatusOK, probe(ctl, "/healthz"))
+	require.Equal(t, http.StatusServiceUnavailable, probe(ctl, "/readyz"))
+
+	conf = NewControllerConf()
+	conf.ListenAddr = "127.0.0.1:18124"
+	conf.EtcdPrefix = fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ctl = NewController(conf, ctx)
+	defer ctl.etcdCli.Delete(ctx, conf.EtcdPrefix, clientv3.WithPrefix())
+	for i := 0; i < 100 && !ctl.isLeader; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Equal(t, http.StatusOK, probe(ctl, "/readyz"))
+}
+
+// requires etcd at 127.0.0.1:2379 and redis at 127.0.0.1:6379
+func TestShutdown(t *testing.T) {
+	const dbID = 960
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ctls := make([]*Controller, 2)
+	for i := range ctls {
+		conf := NewControllerConf()
+		conf.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 18125+i)
+		conf.EtcdPrefix = prefix
+		conf.Dim = 4
+		ctls[i] = NewController(conf, ctx)
+	}
+	for i := 0; i < 100 && (ctls[0].curLeader == "" || ctls[0].curLeader != ctls[1].curLeader); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NotEmpty(t, ctls[0].curLeader)
+	leader, peer := ctls[0], ctls[1]
+	if peer.isLeader {
+		leader, peer = peer, leader
+	}
+	defer peer.etcdCli.Delete(ctx, prefix, clientv3.WithPrefix())
+	_, err := leader.acquire(ctx, dbID, leader.conf.ListenAddr)
+	require.NoError(t, err)
+	dbl, err := leader.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	leader.rwlock.Lock()
+	leader.dbls[dbID] = dbl
+	leader.rwlock.Unlock()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer shutdownCancel()
+	require.NoError(t, leader.Shutdown(shutdownCtx))
+
+	// the ownership and the node key are cleared, and the peer takes over the leadership before the session TTL
+	load, err := peer.getLoad()
+	require.NoError(t, err)
+	require.Empty(t, load[leader.conf.ListenAddr])
+	resp, err := peer.etcdCli.Get(ctx, fmt.Sprintf("%s/node/%s", peer.conf.etcdPath(), leader.conf.ListenAddr))
+	require.NoError(t, err)
+	require.Empty(t, resp.Kvs)
+	for i := 0; i < 50 && !peer.isLeader; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.True(t, peer.isLeader)
+}
+
+// requires etcd at 127.0.0.1:2379 and redis at 127.0.0.1:6379
+func TestWarmStandby(t *testing.T) {
+	const dbID = 985
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ctls := make([]*Controller, 2)
+	srvs := make([]*http.Server, 2)
+	for i := range ctls {
+		conf := NewControllerConf()
+		conf.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 18111+i)
+		conf.EtcdPrefix = prefix
+		conf.Dim = 4
+		conf.WarmStandbyCount = 1
+		ctls[i] = NewController(conf, ctx)
+		r := gin.New()
+		SetupRouters(ctls[i], r, r)
+		srvs[i] = &http.Server{Addr: conf.ListenAddr, Handler: r}
+		go srvs[i].ListenAndServe()
+		defer srvs[i].Close()
+	}
+	defer ctls[0].etcdCli.Delete(ctx, prefix, clientv3.WithPrefix())
+	_, err := redis.NewClient(&redis.Options{Addr: ctls[0].conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+	require.NoError(t, err)
+	for i := 0; i < 100 && (ctls[0].curLeader == "" || ctls[0].curLeader != ctls[1].curLeader); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NotEmpty(t, ctls[0].curLeader)
+	require.Equal(t, ctls[0].curLeader, ctls[1].curLeader)
+	// the follower owns the db, and the leader becomes its warm standby
+	owner, leader := ctls[0], ctls[1]
+	if owner.isLeader {
+		owner, leader = leader, owner
+	}
+	ownerSrv := srvs[0]
+	if owner == ctls[1] {
+		ownerSrv = srvs[1]
+	}
+
+	hc := &http.Client{}
+	xbs := [][]float32{{0.5, 0.5, 0.5, 0.5}, {0.5, -0.5, 0.5, -0.5}, {-0.5, 0.5, -0.5, 0.5}}
+	var rspAdd RspAdd
+	err = PostJson(ctx, hc, fmt.Sprintf("http://%s/api/v1/add", owner.conf.ListenAddr), ReqAdd{DbID: dbID, Xb: xbs[0]}, &rspAdd)
+	require.NoError(t, err)
+	require.Empty(t, rspAdd.Err)
+	var standby *vectodb.VectoDBLite
+	for i := 0; i < 100 && standby == nil; i++ {
+		time.Sleep(50 * time.Millisecond)
+		leader.rwlock.RLock()
+		standby = leader.standbys[dbID]
+		leader.rwlock.RUnlock()
+	}
+	require.NotNil(t, standby)
+	// vectors added after the standby started are tailed
+	xids := []uint64{rspAdd.Xid}
+	for _, xb := range xbs[1:] {
+		err = PostJson(ctx, hc, fmt.Sprintf("http://%s/api/v1/add", owner.conf.ListenAddr), ReqAdd{DbID: dbID, Xb: xb}, &rspAdd)
+		require.NoError(t, err)
+		require.Empty(t, rspAdd.Err)
+		xids = append(xids, rspAdd.Xid)
+	}
+	for i := 0; i < 100 && !standby.Contains(xids[len(xids)-1]); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, xid := range xids {
+		require.True(t, standby.Contains(xid))
+	}
+
+	// the owner dies
+	ownerSrv.Close()
+	_, err = owner.etcdCli.Delete(ctx, fmt.Sprintf("%s/node/%s", owner.conf.etcdPath(), owner.conf.ListenAddr))
+	require.NoError(t, err)
+	var load map[string][]int
+	for i := 0; i < 100; i++ {
+		if load, err = leader.getLoad(); err == nil && len(load[leader.conf.ListenAddr]) != 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Equal(t, []int{dbID}, load[leader.conf.ListenAddr])
+
+	for i, xb := range xbs {
+		var rspSearch RspSearch
+		err = PostJson(ctx, hc, fmt.Sprintf("http://%s/api/v1/search", leader.conf.ListenAddr), ReqSearch{DbID: dbID, Xq: xb}, &rspSearch)
+		require.NoError(t, err)
+		require.Empty(t, rspSearch.Err)
+		require.Equal(t, xids[i], rspSearch.Xid)
+	}
+	leader.rwlock.RLock()
+	require.True(t, standby == leader.dbls[dbID], "the standby shall be promoted rather than loaded again")
+	require.NotContains(t, leader.standbys, dbID)
+	leader.rwlock.RUnlock()
+}
+
+// requires redis at 127.0.0.1:6379
+func TestEmbeddedController(t *testing.T) {
+	const dbID = 983
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conf := NewControllerConf()
+	conf.ListenAddr = "127.0.0.1:18129"
+	conf.EtcdPrefix = fmt.Sprintf("test-%d", time.Now().UnixNano())
+	conf.Dim = 4
+	conf.FreshOnAcquire = true
+	ec, err := NewEmbeddedController(conf, ctx)
+	require.NoError(t, err)
+	defer ec.ctl.etcdCli.Delete(ctx, conf.EtcdPrefix, clientv3.WithPrefix())
+	for i := 0; i < 100 && !ec.ctl.isLeader; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.True(t, ec.ctl.isLeader)
+	defer func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(ctx, ShutdownTimeout)
+		defer shutdownCancel()
+		require.NoError(t, ec.Shutdown(shutdownCtx))
+	}()
+
+	xb1 := []float32{0.5, 0.5, 0.5, 0.5}
+	xb2 := []float32{0.5, -0.5, 0.5, -0.5}
+	xid1, err := ec.Add(ReqAdd{DbID: dbID, Xb: xb1})
+	require.NoError(t, err)
+	require.Equal(t, vectodb.HashVector(xb1), xid1)
+	xid2, err := ec.Add(ReqAdd{DbID: dbID, Xb: xb2, Xid: 42})
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), xid2)
+	ec.ctl.rwlock.RLock()
+	require.Contains(t, ec.ctl.dbls, dbID)
+	ec.ctl.rwlock.RUnlock()
+
+	rspSearch, err := ec.Search(ReqSearch{DbID: dbID, Xq: xb2, MinResults: 2})
+	require.NoError(t, err)
+	require.Equal(t, xid2, rspSearch.Xid)
+	require.Len(t, rspSearch.Results, 2)
+	require.Equal(t, xid1, rspSearch.Results[1].Xid)
+	require.True(t, rspSearch.Results[1].Relaxed)
+
+	require.NoError(t, ec.Delete(dbID, xid2))
+	rspSearch, err = ec.Search(ReqSearch{DbID: dbID, Xq: xb2})
+	require.NoError(t, err)
+	require.Equal(t, ^uint64(0), rspSearch.Xid)
+
+	_, err = ec.Search(ReqSearch{DbID: dbID, Xq: []float32{0, 0, 0, 0}})
+	require.Equal(t, vectodb.ErrZeroVector, err)
+
+	// a vectodblite owned by another node isn't taken over
+	const otherID = 955
+	_, err = ec.ctl.acquire(ctx, otherID, "127.0.0.1:18130")
+	require.NoError(t, err)
+	_, err = ec.Add(ReqAdd{DbID: otherID, Xb: xb1})
+	require.Equal(t, ErrNotOwner, errors.Cause(err))
+	ec.ctl.rwlock.RLock()
+	require.NotContains(t, ec.ctl.dbls, otherID)
+	ec.ctl.rwlock.RUnlock()
+}
+
+// requires redis at 127.0.0.1:6379
+func TestReadOnly(t *testing.T) {
+	const dbID = 982
+	conf := NewControllerConf()
+	conf.Dim = 4
+	dbl, err := vectodb.NewVectoDBLite(conf.RedisAddr, dbID, conf.Dim, float32(conf.DisThr), conf.SizeLimit, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	require.NoError(t, dbl.SetReadOnly(false))
+	ctl := &Controller{
+		conf: conf,
+		dbls: map[int]*vectodb.VectoDBLite{dbID: dbl},
+	}
+	r := gin.New()
+	r.POST("/api/v1/add", ctl.HandleAdd)
+	r.POST("/api/v1/search", ctl.HandleSearch)
+	r.POST("/mgmt/v1/readonly", ctl.HandleReadOnly)
+	add := func(xb []float32) (rspAdd RspAdd) {
+		reqBody, err := json.Marshal(ReqAdd{DbID: dbID, Xb: xb})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/add", bytes.NewReader(reqBody)))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspAdd))
+		return
+	}
+	setReadOnly := func(dbID int, enabled bool) (rspReadOnly RspReadOnly) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/mgmt/v1/readonly?dbID=%d&enabled=%v", dbID, enabled), nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspReadOnly))
+		return
+	}
+
+	xb1 := []float32{0.5, 0.5, 0.5, 0.5}
+	xb2 := []float32{0.5, -0.5, 0.5, -0.5}
+	rspAdd := add(xb1)
+	require.Empty(t, rspAdd.Err)
+	xid1 := rspAdd.Xid
+
+	rspReadOnly := setReadOnly(dbID, true)
+	require.Empty(t, rspReadOnly.Err)
+	require.True(t, rspReadOnly.Enabled)
+	rspAdd = add(xb2)
+	require.Contains(t, rspAdd.Err, vectodb.ErrReadOnly.Error())
+	require.Equal(t, vectodb.ErrReadOnly, errors.Cause(dbl.Delete(xid1)))
+	reqBody, err := json.Marshal(ReqSearch{DbID: dbID, Xq: xb1})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/search", bytes.NewReader(reqBody)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var rspSearch RspSearch
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspSearch))
+	require.Empty(t, rspSearch.Err)
+	require.Equal(t, xid1, rspSearch.Xid)
+
+	// the flag survives reloading
+	dbl2, err := vectodb.NewVectoDBLite(conf.RedisAddr, dbID, conf.Dim, float32(conf.DisThr), conf.SizeLimit, false)
+	require.NoError(t, err)
+	require.True(t, dbl2.ReadOnly())
+	require.NoError(t, dbl2.Destroy())
+
+	rspReadOnly = setReadOnly(dbID, false)
+	require.Empty(t, rspReadOnly.Err)
+	rspAdd = add(xb2)
+	require.Empty(t, rspAdd.Err)
+
+	rspReadOnly = setReadOnly(dbID+1, true)
+	require.NotEmpty(t, rspReadOnly.Err)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/mgmt/v1/readonly?dbID=%d&enabled=maybe", dbID), nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// requires redis at 127.0.0.1:6379
+func TestBackpressureHeaders(t *testing.T) {
+	const dbID = 981
+	conf := NewControllerConf()
+	conf.Dim = 4
+	conf.BackpressureHeaders = true
+	ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	ctl.dbls[dbID] = dbl
+	r := gin.New()
+	api := r.Group("/api/v1", ctl.backpressure)
+	api.POST("/add", ctl.HandleAdd)
+	api.POST("/search", ctl.HandleSearch)
+	post := func(path string, reqObj interface{}) *httptest.ResponseRecorder {
+		reqBody, err := json.Marshal(reqObj)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", path, bytes.NewReader(reqBody)))
+		require.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	// the counters are refreshed by the test only, rather than in the background every BacklogInterval
+	atomic.StoreInt64(&ctl.backlogRefreshAt, math.MaxInt64)
+	ctl.refreshBacklog()
+	w := post("/api/v1/search", ReqSearch{DbID: dbID, Xq: []float32{1, 0, 0, 0}})
+	require.Equal(t, "0", w.Header().Get(FlatBacklogHeader))
+	require.Equal(t, "0", w.Header().Get(BuildQueueHeader))
+	require.Equal(t, "1", w.Header().Get(InFlightHeader))
+
+	const numAdds = 10
+	var xid uint64
+	for i := 0; i < numAdds; i++ {
+		w = post("/api/v1/add", ReqAdd{DbID: dbID, Xb: []float32{rand.Float32(), rand.Float32(), rand.Float32(), rand.Float32()}})
+		var rspAdd RspAdd
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspAdd))
+		require.Empty(t, rspAdd.Err)
+		xid = rspAdd.Xid
+	}
+	ctl.refreshBacklog()
+	w = post("/api/v1/search", ReqSearch{DbID: dbID, Xq: []float32{1, 0, 0, 0}})
+	require.Equal(t, strconv.Itoa(numAdds), w.Header().Get(FlatBacklogHeader))
+	require.Equal(t, "0", w.Header().Get(BuildQueueHeader))
+	require.Equal(t, "1", w.Header().Get(InFlightHeader))
+	require.Equal(t, int64(0), atomic.LoadInt64(&ctl.inFlight))
+
+	// the tombstone is purged at the next rebuild
+	require.NoError(t, dbl.Delete(xid))
+	ctl.refreshBacklog()
+	w = post("/api/v1/search", ReqSearch{DbID: dbID, Xq: []float32{1, 0, 0, 0}})
+	require.Equal(t, "1", w.Header().Get(BuildQueueHeader))
+
+	conf.BackpressureHeaders = false
+	w = post("/api/v1/search", ReqSearch{DbID: dbID, Xq: []float32{1, 0, 0, 0}})
+	require.Empty(t, w.Header().Get(FlatBacklogHeader))
+	require.Empty(t, w.Header().Get(InFlightHeader))
+}
+
+// requires etcd at 127.0.0.1:2379 and redis at 127.0.0.1:6379
+func TestPrecreate(t *testing.T) {
+	dbIDs := []int{980, 979}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ctls := make([]*Controller, 2)
+	for i := range ctls {
+		conf := NewControllerConf()
+		conf.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 18113+i)
+		conf.EtcdPrefix = prefix
+		conf.Dim = 4
+		// requires 39 vectors to train
+		conf.IndexKey = "IVF1,Flat"
+		ctls[i] = NewController(conf, ctx)
+		r := gin.New()
+		SetupRouters(ctls[i], r, r)
+		srv := &http.Server{Addr: conf.ListenAddr, Handler: r}
+		go srv.ListenAndServe()
+		defer srv.Close()
+	}
+	defer ctls[0].etcdCli.Delete(ctx, prefix, clientv3.WithPrefix())
+	for _, dbID := range dbIDs {
+		_, err := redis.NewClient(&redis.Options{Addr: ctls[0].conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID)).Result()
+		require.NoError(t, err)
+	}
+	for i := 0; i < 100 && (ctls[0].curLeader == "" || ctls[0].curLeader != ctls[1].curLeader); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NotEmpty(t, ctls[0].curLeader)
+	require.Equal(t, ctls[0].curLeader, ctls[1].curLeader)
+	leader := ctls[0]
+	if !leader.isLeader {
+		leader = ctls[1]
+	}
+
+	samples := make([][]float32, 50)
+	for i := range samples {
+		samples[i] = []float32{rand.Float32(), rand.Float32(), rand.Float32(), rand.Float32()}
+	}
+	hc := &http.Client{}
+	var rspPrecreate RspPrecreate
+	err := PostJson(ctx, hc, fmt.Sprintf("http://%s/mgmt/v1/precreate", leader.conf.ListenAddr), ReqPrecreate{DbIDs: dbIDs, Samples: samples}, &rspPrecreate)
+	require.NoError(t, err)
+	require.Empty(t, rspPrecreate.Err)
+	require.Len(t, rspPrecreate.NodeAddrs, len(dbIDs))
+	require.NotEqual(t, rspPrecreate.NodeAddrs[0], rspPrecreate.NodeAddrs[1], "vectodblites shall be placed on the least loaded nodes")
+
+	for i, dbID := range dbIDs {
+		owner := ctls[0]
+		if owner.conf.ListenAddr != rspPrecreate.NodeAddrs[i] {
+			owner = ctls[1]
+		}
+		owner.rwlock.RLock()
+		dbl := owner.dbls[dbID]
+		owner.rwlock.RUnlock()
+		require.NotNil(t, dbl, "vectodblite %d shall be loaded by %s", dbID, owner.conf.ListenAddr)
+		require.Equal(t, "IVF1,Flat", dbl.IndexKey())
+
+		var rspSearch RspSearch
+		err = PostJson(ctx, hc, fmt.Sprintf("http://%s/api/v1/search", owner.conf.ListenAddr), ReqSearch{DbID: dbID, Xq: samples[0]}, &rspSearch)
+		require.NoError(t, err)
+		require.Empty(t, rspSearch.Err)
+		owner.rwlock.RLock()
+		require.True(t, dbl == owner.dbls[dbID], "the precreated vectodblite shall serve the first request")
+		owner.rwlock.RUnlock()
+	}
+}
+
+// requires redis at 127.0.0.1:6379
+func TestSearchPages(t *testing.T) {
+	const dbID = 978
+	const numVecs = 25
+	const pageSize = 10
+	conf := NewControllerConf()
+	conf.Dim = 4
+	ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	ctl.dbls[dbID] = dbl
+	for i := 0; i < numVecs; i++ {
+		_, err = dbl.Add([]float32{rand.Float32(), rand.Float32(), rand.Float32(), rand.Float32()})
+		require.NoError(t, err)
+	}
+	r := gin.New()
+	r.POST("/api/v1/search", ctl.HandleSearch)
+	search := func(reqSearch ReqSearch) (rspSearch RspSearch) {
+		reqBody, err := json.Marshal(reqSearch)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/search", bytes.NewReader(reqBody)))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspSearch))
+		return
+	}
+
+	xq := []float32{0.5, 0.5, 0.5, 0.5}
+	var hits []SearchHit
+	rspSearch := search(ReqSearch{DbID: dbID, Xq: xq, PageSize: pageSize})
+	require.Empty(t, rspSearch.Err)
+	require.Len(t, rspSearch.Results, pageSize)
+	hits = append(hits, rspSearch.Results...)
+	// the intervening add is invisible to following pages
+	xid, err := dbl.Add(xq)
+	require.NoError(t, err)
+	for rspSearch.NextPageToken != "" {
+		rspSearch = search(ReqSearch{DbID: dbID, Xq: xq, PageSize: pageSize, PageToken: rspSearch.NextPageToken})
+		require.Empty(t, rspSearch.Err)
+		require.NotEmpty(t, rspSearch.Results)
+		hits = append(hits, rspSearch.Results...)
+	}
+	require.Len(t, hits, numVecs)
+	seen := make(map[uint64]bool)
+	for i, hit := range hits {
+		require.False(t, seen[hit.Xid], "xid %v is returned twice", hit.Xid)
+		require.NotEqual(t, xid, hit.Xid)
+		seen[hit.Xid] = true
+		if i > 0 {
+			require.True(t, hits[i-1].Distance >= hit.Distance, "pages shall be in descending order of distance")
+		}
+	}
+
+	// a new paged search sees the add
+	rspSearch = search(ReqSearch{DbID: dbID, Xq: xq, PageSize: pageSize})
+	require.Equal(t, xid, rspSearch.Results[0].Xid)
+
+	rspSearch = search(ReqSearch{DbID: dbID, Xq: xq, PageSize: pageSize, PageToken: "garbage"})
+	require.NotEmpty(t, rspSearch.Err)
+	rspSearch = search(ReqSearch{DbID: dbID, Xq: xq, PageSize: pageSize, PageToken: encodePageToken(dbID, 1, 0)})
+	require.NotEmpty(t, rspSearch.Err)
+
+	// the result set expiring first is evicted beyond MaxPages
+	conf.MaxPages = 2
+	first := search(ReqSearch{DbID: dbID, Xq: xq, PageSize: pageSize})
+	for i := 0; i < conf.MaxPages; i++ {
+		search(ReqSearch{DbID: dbID, Xq: xq, PageSize: pageSize})
+	}
+	require.Len(t, ctl.pages, conf.MaxPages)
+	rspSearch = search(ReqSearch{DbID: dbID, Xq: xq, PageSize: pageSize, PageToken: first.NextPageToken})
+	require.Equal(t, errInvalidPageToken.Error(), rspSearch.Err)
+}
+
+// requires etcd at 127.0.0.1:2379
+func TestAcquireRateLimit(t *testing.T) {
+	const rate = 20
+	const numAcquires = 30
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	var hitsLock sync.Mutex
+	var hits []time.Time
+	ctls := make([]*Controller, 2)
+	for i := range ctls {
+		conf := NewControllerConf()
+		conf.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 18115+i)
+		conf.EtcdPrefix = prefix
+		conf.AcquireRate = rate
+		ctls[i] = NewController(conf, ctx)
+		r := gin.New()
+		r.Use(func(c *gin.Context) {
+			if c.Request.URL.Path == "/mgmt/v1/acquire" {
+				hitsLock.Lock()
+				hits = append(hits, time.Now())
+				hitsLock.Unlock()
+			}
+		})
+		SetupRouters(ctls[i], r, r)
+		srv := &http.Server{Addr: conf.ListenAddr, Handler: r}
+		go srv.ListenAndServe()
+		defer srv.Close()
+	}
+	defer ctls[0].etcdCli.Delete(ctx, prefix, clientv3.WithPrefix())
+	for i := 0; i < 100 && (ctls[0].curLeader == "" || ctls[0].curLeader != ctls[1].curLeader); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NotEmpty(t, ctls[0].curLeader)
+	require.Equal(t, ctls[0].curLeader, ctls[1].curLeader)
+	follower := ctls[0]
+	if follower.isLeader {
+		follower = ctls[1]
+	}
+
+	// a storm of cold dbIDs
+	var wg sync.WaitGroup
+	errCh := make(chan error, numAcquires)
+	for i := 0; i < numAcquires; i++ {
+		wg.Add(1)
+		go func(dbID int) {
+			defer wg.Done()
+			dstNodeAddr, err := follower.requestAcquire(ctx, dbID)
+			if err == nil && dstNodeAddr != follower.conf.ListenAddr {
+				err = errors.Errorf("vectodblite %d is acquired by %v, want %v", dbID, dstNodeAddr, follower.conf.ListenAddr)
+			}
+			errCh <- err
+		}(10000 + i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+	require.Len(t, hits, numAcquires)
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Before(hits[j]) })
+	// at most rate+1 requests in any second
+	for i := 0; i+rate+1 < len(hits); i++ {
+		require.True(t, hits[i+rate+1].Sub(hits[i]) >= 900*time.Millisecond, "the leader sees %v acquires within %v", rate+2, hits[i+rate+1].Sub(hits[i]))
+	}
+	st := follower.acquireLimiter.stat()
+	require.Equal(t, 0, st.Queued)
+	require.True(t, st.Throttled > 0)
+	require.True(t, st.WaitMs > 0)
+
+	// the queue is full
+	follower.acquireLimiter = newAcquireLimiter(1, 1)
+	errCh = make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(dbID int) {
+			defer wg.Done()
+			_, err := follower.requestAcquire(ctx, dbID)
+			errCh <- err
+		}(20000 + i)
+	}
+	wg.Wait()
+	close(errCh)
+	var numBusy int
+	for err := range errCh {
+		if err == errAcquireBusy {
+			numBusy++
+		} else {
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, 1, numBusy)
+	require.True(t, isUnavailable(errAcquireBusy))
+}
+
+// requires redis at 127.0.0.1:6379
+func TestResolvedConfig(t *testing.T) {
+	const dbID = 977
+	conf := NewControllerConf()
+	conf.Dim = 4
+	conf.IndexKeys = map[int]string{dbID: "IVF1,Flat"}
+	ctl := &Controller{conf: conf, dbls: make(map[int]*vectodb.VectoDBLite)}
+	r := gin.New()
+	r.GET("/mgmt/v1/config", ctl.HandleConfig)
+	getConfig := func(dbID int) (settings map[string]ResolvedSetting) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/mgmt/v1/config?dbID=%d", dbID), nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var rspConfig RspConfig
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rspConfig))
+		require.Empty(t, rspConfig.Err)
+		settings = make(map[string]ResolvedSetting)
+		for _, setting := range rspConfig.Settings {
+			settings[setting.Name] = setting
+		}
+		return
+	}
+
+	settings := getConfig(dbID)
+	require.Equal(t, ResolvedSetting{Name: "indexKey", Value: "IVF1,Flat", Source: ConfigSourceOverride}, settings["indexKey"])
+	require.Equal(t, ResolvedSetting{Name: "dim", Value: float64(4), Source: ConfigSourceDefault}, settings["dim"])
+	require.NotContains(t, settings, "activeIndexKey")
+	settings = getConfig(dbID + 1)
+	require.Equal(t, ResolvedSetting{Name: "indexKey", Value: vectodb.LiteIndexKeyFlat, Source: ConfigSourceDefault}, settings["indexKey"])
+
+	// the index isn't trained yet
+	dbl, err := ctl.newVectoDBLite(dbID, true)
+	require.NoError(t, err)
+	defer dbl.Destroy()
+	ctl.dbls[dbID] = dbl
+	settings = getConfig(dbID)
+	require.Equal(t, ResolvedSetting{Name: "activeIndexKey", Value: vectodb.LiteIndexKeyFlat, Source: ConfigSourceRuntime}, settings["activeIndexKey"])
+}
+
+// requires etcd at 127.0.0.1:2379
+func TestQPSPlacement(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ctls := make([]*Controller, 2)
+	for i := range ctls {
+		conf := NewControllerConf()
+		conf.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 18117+i)
+		conf.EtcdPrefix = prefix
+		conf.QPSWeight = 0.1
+		// the test publishes qps by itself
+		conf.QPSInterval = 3600
+		ctls[i] = NewController(conf, ctx)
+	}
+	defer ctls[0].etcdCli.Delete(ctx, prefix, clientv3.WithPrefix())
+	for i := 0; i < 100 && (ctls[0].curLeader == "" || ctls[0].curLeader != ctls[1].curLeader); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NotEmpty(t, ctls[0].curLeader)
+	require.Equal(t, ctls[0].curLeader, ctls[1].curLeader)
+	leader := ctls[0]
+	if !leader.isLeader {
+		leader = ctls[1]
+	}
+	busy, idle := ctls[0].conf.ListenAddr, ctls[1].conf.ListenAddr
+
+	// the requester is kept on ties
+	_, err := leader.etcdCli.Put(ctx, leader.qpsKey(busy), "0")
+	require.NoError(t, err)
+	_, err = leader.etcdCli.Put(ctx, leader.qpsKey(idle), "0")
+	require.NoError(t, err)
+	owner, err := leader.acquireOnce(ctx, 976, busy)
+	require.NoError(t, err)
+	require.Equal(t, busy, owner)
+
+	_, err = leader.etcdCli.Put(ctx, leader.qpsKey(busy), "1000")
+	require.NoError(t, err)
+	owner, err = leader.acquireOnce(ctx, 975, busy)
+	require.NoError(t, err)
+	require.Equal(t, idle, owner, "the high-QPS node shall be skipped")
+
+	qps, err := leader.getQPS(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{busy: 1000, idle: 0}, qps)
+}
+
+// requires etcd at 127.0.0.1:2379
+func TestPressurePlacement(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ctls := make([]*Controller, 2)
+	for i := range ctls {
+		conf := NewControllerConf()
+		conf.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 18127+i)
+		conf.EtcdPrefix = prefix
+		ctls[i] = NewController(conf, ctx)
+	}
+	defer ctls[0].etcdCli.Delete(ctx, prefix, clientv3.WithPrefix())
+	for i := 0; i < 100 && (ctls[0].curLeader == "" || ctls[0].curLeader != ctls[1].curLeader); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NotEmpty(t, ctls[0].curLeader)
+	require.Equal(t, ctls[0].curLeader, ctls[1].curLeader)
+	leader := ctls[0]
+	if !leader.isLeader {
+		leader = ctls[1]
+	}
+	pressured, free := ctls[0].conf.ListenAddr, ctls[1].conf.ListenAddr
+
+	_, err := leader.etcdCli.Put(ctx, leader.pressureKey(pressured), "0")
+	require.NoError(t, err)
+	owner, err := leader.acquireOnce(ctx, 957, pressured)
+	require.NoError(t, err)
+	require.Equal(t, free, owner, "the pressured node shall be skipped")
+
+	// the requester is kept if all nodes are pressured
+	_, err = leader.etcdCli.Put(ctx, leader.pressureKey(free), "0")
+	require.NoError(t, err)
+	owner, err = leader.acquireOnce(ctx, 956, pressured)
+	require.NoError(t, err)
+	require.Equal(t, pressured, owner)
+}
+
+func TestShardSplit(t *testing.T) {
+	const dbID, numVecs = 974, 100
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ctls := make([]*Controller, 2)
+	for i := range ctls {
+		conf := NewControllerConf()
+		conf.ListenAddr = fmt.Sprintf("127.0.0.1:%d", 18119+i)
+		conf.EtcdPrefix = prefix
+		conf.Dim = 4
+		conf.SplitThreshold = 40
+		ctls[i] = NewController(conf, ctx)
+		r := gin.New()
+		SetupRouters(ctls[i], r, r)
+		srv := &http.Server{Addr: conf.ListenAddr, Handler: r}
+		go srv.ListenAndServe()
+		defer srv.Close()
+	}
+	defer ctls[0].etcdCli.Delete(ctx, prefix, clientv3.WithPrefix())
+	// the first sub-shard of a new cluster is -1
+	_, err := redis.NewClient(&redis.Options{Addr: ctls[0].conf.RedisAddr}).Del(fmt.Sprintf("vectodblite_%d", dbID), "vectodblite_-1").Result()
+	require.NoError(t, err)
+	for i := 0; i < 100 && (ctls[0].curLeader == "" || ctls[0].curLeader != ctls[1].curLeader); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NotEmpty(t, ctls[0].curLeader)
+	require.Equal(t, ctls[0].curLeader, ctls[1].curLeader)
+
+	// ctls[0] owns the vectodblite since it requests it first
+	hc := &http.Client{}
+	xbs := make([][]float32, numVecs+1)
+	for xid := 1; xid <= numVecs; xid++ {
+		xbs[xid] = []float32{rand.Float32(), rand.Float32(), rand.Float32(), rand.Float32()}
+		var rspAdd RspAdd
+		err = PostJson(ctx, hc, fmt.Sprintf("http://%s/api/v1/add", ctls[0].conf.ListenAddr), ReqAdd{DbID: dbID, Xid: uint64(xid), Xb: xbs[xid]}, &rspAdd)
+		require.NoError(t, err)
+		require.Empty(t, rspAdd.Err)
+	}
+	var split *shardSplit
+	for i := 0; i < 100; i++ {
+		ctls[0].splitLock.RLock()
+		split = ctls[0].splits[dbID]
+		splitting := ctls[0].splitting[dbID]
+		ctls[0].splitLock.RUnlock()
+		if split != nil && !splitting {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NotNil(t, split)
+	require.Equal(t, -1, split.Child)
+	require.Equal(t, ctls[1].conf.ListenAddr, split.NodeAddr, "the sub-shard shall be placed at another node")
+
+	// the migrated vectors are tombstones in the parent, which aren't exported
+	ctls[0].rwlock.RLock()
+	parentSize, err := ctls[0].dbls[dbID].Export(ioutil.Discard)
+	ctls[0].rwlock.RUnlock()
+	require.NoError(t, err)
+	ctls[1].rwlock.RLock()
+	require.Contains(t, ctls[1].dbls, split.Child)
+	childSize, err := ctls[1].dbls[split.Child].Export(ioutil.Discard)
+	ctls[1].rwlock.RUnlock()
+	require.NoError(t, err)
+	require.Equal(t, numVecs, parentSize+childSize)
+	require.Equal(t, numVecs-int(split.Pivot)+1, childSize)
+
+	// searches via the other node fan out to both shards and return the global top-k
+	const k = 10
+	for i := 0; i < 10; i++ {
+		xq := []float32{rand.Float32(), rand.Float32(), rand.Float32(), rand.Float32()}
+		xids := make([]uint64, numVecs)
+		for xid := 1; xid <= numVecs; xid++ {
+			xids[xid-1] = uint64(xid)
+		}
+		ip := func(xid uint64) (dis float32) {
+			for j := range xq {
+				dis += xq[j] * xbs[xid][j]
+			}
+			return
+		}
+		sort.Slice(xids, func(a, b int) bool { return ip(xids[a]) > ip(xids[b]) })
+		var rspSearch RspSearch
+		err = PostJson(ctx, hc, fmt.Sprintf("http://%s/api/v1/search", ctls[1].conf.ListenAddr), ReqSearch{DbID: dbID, Xq: xq, MinResults: k}, &rspSearch)
+		require.NoError(t, err)
+		require.Empty(t, rspSearch.Err)
+		require.Len(t, rspSearch.Results, k)
+		for j, hit := range rspSearch.Results {
+			require.Equal(t, xids[j], hit.Xid)
+		}
+	}
+
+	// the first pages of paged searches are rejected, since pages aren't merged across shards
+	body, err := json.Marshal(ReqSearch{DbID: dbID, Xq: xbs[1], PageSize: k})
+	require.NoError(t, err)
+	rsp, err := hc.Post(fmt.Sprintf("http://%s/api/v1/search", ctls[0].conf.ListenAddr), "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+	// deletes of xids at least the pivot reach the sub-shard
+	var rspDelete RspDelete
+	err = PostJson(ctx, hc, fmt.Sprintf("http://%s/api/v1/delete", ctls[1].conf.ListenAddr), ReqDelete{DbID: dbID, Xid: numVecs}, &rspDelete)
+	require.NoError(t, err)
+	require.Empty(t, rspDelete.Err)
+	require.True(t, rspDelete.Found)
+	var rspDeleteIds RspDeleteIds
+	err = PostJson(ctx, hc, fmt.Sprintf("http://%s/api/v1/delete_ids", ctls[0].conf.ListenAddr), ReqDeleteIds{DbID: dbID, Xids: []uint64{1, numVecs - 1, numVecs}}, &rspDeleteIds)
+	require.NoError(t, err)
+	require.Empty(t, rspDeleteIds.Err)
+	require.Equal(t, 2, rspDeleteIds.Deleted)
+	require.Equal(t, []uint64{numVecs}, rspDeleteIds.NotFound)
+	ctls[1].rwlock.RLock()
+	require.False(t, ctls[1].dbls[split.Child].Contains(numVecs-1))
+	ctls[1].rwlock.RUnlock()
+}
@@ -0,0 +1,159 @@
+package cluster
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type ReqDelete struct {
+	DbID int    `json:"dbID"`
+	Xid  uint64 `json:"xid"`
+}
+
+type RspDelete struct {
+	Found bool   `json:"found"` // false if the vector is absent or already deleted
+	Err   string `json:"err"`
+}
+
+type ReqDeleteIds struct {
+	DbID int      `json:"dbID"`
+	Xids []uint64 `json:"xids"`
+}
+
+type RspDeleteIds struct {
+	Deleted  int      `json:"deleted"`
+	NotFound []uint64 `json:"notFound"`
+	Err      string   `json:"err"`
+}
+
+// @Description Delete vectors from the given vectodblite
+// @Accept  json
+// @Produce  json
+// @Param   delete_ids	body	cluster.ReqDeleteIds	true 	"ReqDeleteIds. If the vectodblite is split, the xids which belong to the sub-shard are deleted from it as well."
+// @Success 200 {object} cluster.RspDeleteIds "RspDeleteIds. notFound contains the xids which are absent or already deleted."
+// @Failure 307 "redirection"
+// @Failure 400
+// @Failure 503 "memory pressure or draining"
+// @Router /api/v1/delete_ids [post]
+func (ctl *Controller) HandleDeleteIds(c *gin.Context) {
+	var reqDeleteIds ReqDeleteIds
+	var err error
+	if err = c.ShouldBind(&reqDeleteIds); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+	} else {
+		var rspDeleteIds RspDeleteIds
+		rl := ctl.routeLock(reqDeleteIds.DbID)
+		rl.RLock()
+		defer rl.RUnlock()
+		var done bool
+		if rspDeleteIds.Deleted, rspDeleteIds.NotFound, done, err = ctl.deleteLocal(c, reqDeleteIds.DbID, reqDeleteIds.Xids); done {
+			return
+		}
+		// the split is loaded along with the vectodblite
+		var shard int
+		var shardXids []uint64
+		for _, xid := range reqDeleteIds.Xids {
+			if s, ok := ctl.xidShard(reqDeleteIds.DbID, xid); ok {
+				shard = s
+				shardXids = append(shardXids, xid)
+			}
+		}
+		if err == nil && len(shardXids) != 0 {
+			var rspShard RspDeleteIds
+			if rspShard, err = ctl.forwardDeleteIds(c.Request.Context(), ReqDeleteIds{Xids: shardXids}, shard); err == nil {
+				rspDeleteIds.Deleted += rspShard.Deleted
+				rspDeleteIds.NotFound = mergeNotFound(rspDeleteIds.NotFound, shardXids, rspShard.NotFound)
+			}
+		}
+		if err != nil {
+			rspDeleteIds.Err = err.Error()
+			log.Errorf("got error %+v", err)
+		}
+		c.JSON(200, rspDeleteIds)
+	}
+}
+
+// mergeNotFound returns the xids absent from the vectodblite which are absent from the sub-shard too if they belong to it.
+func mergeNotFound(notFound, shardXids, shardNotFound []uint64) (merged []uint64) {
+	inShard := make(map[uint64]bool, len(shardXids))
+	for _, xid := range shardXids {
+		inShard[xid] = true
+	}
+	for _, xid := range shardNotFound {
+		inShard[xid] = false
+	}
+	for _, xid := range notFound {
+		if !inShard[xid] {
+			merged = append(merged, xid)
+		}
+	}
+	return
+}
+
+// deleteLocal deletes the xids from the vectodblite itself. done is true if a response has been written already.
+// Vectors whose xids belong to a sub-shard are deleted as well, since the migration of them could have not completed.
+func (ctl *Controller) deleteLocal(c *gin.Context, dbID int, xids []uint64) (numDeleted int, notFound []uint64, done bool, err error) {
+	var dbl *vectodb.VectoDBLite
+	ctl.rwlock.RLock()
+	defer ctl.rwlock.RUnlock()
+	if dbl, err = ctl.getVectoDBLite(c, dbID); isUnavailable(err) {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		done = true
+		return
+	} else if err != nil {
+		return
+	} else if dbl == nil {
+		//already return a response
+		done = true
+		return
+	}
+	numDeleted, notFound, err = dbl.DeleteIds(xids)
+	return
+}
+
+// @Description Delete a vector from the given vectodblite
+// @Accept  json
+// @Produce  json
+// @Param   delete	body	cluster.ReqDelete	true 	"ReqDelete. If the vectodblite is split and the xid belongs to the sub-shard, it's deleted from the sub-shard as well."
+// @Success 200 {object} cluster.RspDelete "RspDelete. found is false if the vector is absent or already deleted."
+// @Failure 307 "redirection"
+// @Failure 400
+// @Failure 503 "memory pressure or draining"
+// @Router /api/v1/delete [post]
+func (ctl *Controller) HandleDelete(c *gin.Context) {
+	var reqDelete ReqDelete
+	var err error
+	if err = c.ShouldBind(&reqDelete); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+	} else {
+		var rspDelete RspDelete
+		rl := ctl.routeLock(reqDelete.DbID)
+		rl.RLock()
+		defer rl.RUnlock()
+		var numDeleted int
+		var done bool
+		if numDeleted, _, done, err = ctl.deleteLocal(c, reqDelete.DbID, []uint64{reqDelete.Xid}); done {
+			return
+		}
+		rspDelete.Found = numDeleted != 0
+		if shard, ok := ctl.xidShard(reqDelete.DbID, reqDelete.Xid); err == nil && ok {
+			var rspShard RspDelete
+			if rspShard, err = ctl.forwardDelete(c.Request.Context(), reqDelete, shard); err == nil {
+				rspDelete.Found = rspDelete.Found || rspShard.Found
+			}
+		}
+		if err != nil {
+			rspDelete.Err = err.Error()
+			log.Errorf("got error %+v", err)
+		}
+		c.JSON(200, rspDelete)
+	}
+}
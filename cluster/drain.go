@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var errDraining = errors.New("vectodblite is draining, retry later")
+
+type RspDrain struct {
+	DbID int    `json:"dbID"`
+	Err  string `json:"err"`
+}
+
+// isUnavailable returns true if the request shall be retried later or at another node, which is responded with 503.
+func isUnavailable(err error) bool {
+	return err == errMemPressure || err == errDraining || err == errAcquireBusy
+}
+
+func (ctl *Controller) isDraining(dbID int) bool {
+	ctl.drainLock.Lock()
+	defer ctl.drainLock.Unlock()
+	return ctl.draining[dbID]
+}
+
+// @Description Drain the given vectodblite before deleting it. New requests to it are rejected with 503 while in-flight ones are waited for.
+// @Description Then it's released and its ownership is dropped. A later request to it loads it from redis again.
+// @Produce json
+// @Param   dbID	query	int	true	"dbID"
+// @Success 200 {object} cluster.RspDrain "RspDrain"
+// @Failure 400
+// @Router /mgmt/v1/drain [post]
+func (ctl *Controller) HandleDrain(c *gin.Context) {
+	var rspDrain RspDrain
+	var err error
+	if rspDrain.DbID, err = strconv.Atoi(c.Query("dbID")); err != nil {
+		err = errors.Wrap(err, "invalid dbID")
+		log.Infof("failed to parse request, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err = ctl.drain(rspDrain.DbID); err != nil {
+		log.Errorf("got error %+v", err)
+		rspDrain.Err = err.Error()
+	}
+	c.JSON(200, rspDrain)
+}
+
+// drain marks the vectodblite as draining, waits for in-flight requests, and then releases and disowns it.
+// In-flight requests hold the controller read lock, so the write lock in release is acquired after all of them finish.
+func (ctl *Controller) drain(dbID int) (err error) {
+	ctl.drainLock.Lock()
+	if ctl.draining[dbID] {
+		ctl.drainLock.Unlock()
+		err = errors.Errorf("vectodblite %d is already draining", dbID)
+		return
+	}
+	if ctl.draining == nil {
+		ctl.draining = make(map[int]bool)
+	}
+	ctl.draining[dbID] = true
+	ctl.drainLock.Unlock()
+	defer func() {
+		ctl.drainLock.Lock()
+		delete(ctl.draining, dbID)
+		ctl.drainLock.Unlock()
+	}()
+	log.Infof("draining vectodblite %d", dbID)
+	if err = ctl.release(dbID); err != nil {
+		return
+	}
+	log.Infof("drained vectodblite %d", dbID)
+	return
+}
@@ -1,4 +1,4 @@
-package main
+package cluster
 
 /*
 based on "ectdctl elect" impl code github.com/coreos/etcd/etcdctl/ctlv3/command/elect_command.go
@@ -77,6 +77,8 @@ func campaign(ctx context.Context, c *clientv3.Client, pfx string, prop string)
 		err = errors.Wrap(err, "")
 		log.Fatalf("got error %+v", err)
 	}
+	// closing the session revokes its lease, which resigns at once rather than after the TTL
+	defer s.Close()
 	e := concurrency.NewElection(s, pfx)
 
 	log.Infof("my proposal: %v", prop)
@@ -99,6 +101,8 @@ func campaign(ctx context.Context, c *clientv3.Client, pfx string, prop string)
 		err = errors.Errorf("Campaign got empty response")
 		return
 	}
+	<-ctx.Done()
+	log.Info("campaign goroutine resigned due to context done")
 	return
 }
 
@@ -122,9 +126,15 @@ func NewEtcdClient(etcdAddr string) (*clientv3.Client, error) {
 
 //https://blog.golang.org/context, Go Concurrency Patterns: Context
 //https://golang.org/pkg/context/
-func StartElection(ctx context.Context, client *clientv3.Client, path string, proposal string, cb LeaderChangedHandler) {
+// done is closed once the campaign exits after ctx is done, and the leadership is resigned if it was elected.
+func StartElection(ctx context.Context, client *clientv3.Client, path string, proposal string, cb LeaderChangedHandler) (done <-chan struct{}) {
 	//Note: puting election and jobs at the same path level doesn't work!
 	pfx := fmt.Sprintf("%s/election", path)
+	campaignDone := make(chan struct{})
 	go observe(ctx, client, pfx, cb)
-	go campaign(ctx, client, pfx, proposal)
+	go func() {
+		defer close(campaignDone)
+		campaign(ctx, client, pfx, proposal)
+	}()
+	return campaignDone
 }
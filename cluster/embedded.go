@@ -0,0 +1,103 @@
+package cluster
+
+import (
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ErrNotOwner is returned by EmbeddedController for vectodblites owned by another node of the cluster.
+var ErrNotOwner = errors.New("vectodblite is owned by another node")
+
+// EmbeddedController serves vectodblites in-process via method calls, for tests and single-process usage.
+// It joins the cluster via etcd like a Controller, and acquires vectodblites through the leader, so that a vectodblite is
+// never owned by two nodes. Vectodblites owned by other nodes are rejected with ErrNotOwner. Other nodes redirect requests
+// and acquires to ListenAddr, which shall be served with SetupRouters if the cluster has other nodes.
+// Adds and searches go through the same code as the HTTP handlers.
+type EmbeddedController struct {
+	ctl *Controller
+}
+
+func NewEmbeddedController(conf *ControllerConf, ctx context.Context) (ec *EmbeddedController, err error) {
+	if err = conf.Validate(); err != nil {
+		return
+	}
+	var ctl *Controller
+	if ctl, err = newController(conf, ctx); err != nil {
+		return
+	}
+	if err = ctl.initMgmt(); err != nil {
+		return
+	}
+	ec = &EmbeddedController{ctl: ctl}
+	return
+}
+
+// Controller returns the underlying controller, whose endpoints could be served with SetupRouters.
+func (ec *EmbeddedController) Controller() *Controller {
+	return ec.ctl
+}
+
+// getVectoDBLite is the same as Controller.getVectoDBLite, except that a vectodblite owned by another node is an error.
+// assumes RLock is holded, which is released and re-taken while acquiring
+func (ec *EmbeddedController) getVectoDBLite(dbID int) (dbl *vectodb.VectoDBLite, err error) {
+	var owner string
+	if dbl, owner, err = ec.ctl.acquireVectoDBLite(ec.ctl.ctx, dbID); err == nil && owner != "" {
+		err = errors.Wrapf(ErrNotOwner, "vectodblite %d is owned by %s", dbID, owner)
+	}
+	return
+}
+
+// Add adds a vector and returns its xid. If the xid is 0 or ^uint64(0), it's generated with the configured id strategy.
+func (ec *EmbeddedController) Add(reqAdd ReqAdd) (xid uint64, err error) {
+	ctl := ec.ctl
+	if pressure, _ := ctl.underMemPressure(); pressure {
+		err = errMemPressure
+		return
+	}
+	ctl.rwlock.RLock()
+	defer ctl.rwlock.RUnlock()
+	var dbl *vectodb.VectoDBLite
+	if dbl, err = ec.getVectoDBLite(reqAdd.DbID); err != nil {
+		return
+	}
+	xid, err = ctl.add(dbl, &reqAdd)
+	return
+}
+
+// Search searches a vector. rspSearch.Err is never set, the error is returned instead.
+func (ec *EmbeddedController) Search(reqSearch ReqSearch) (rspSearch RspSearch, err error) {
+	ctl := ec.ctl
+	if err = ctl.conf.validateQuery(reqSearch.Xq); err != nil {
+		return
+	}
+	if err = ctl.conf.validateSearch(&reqSearch); err != nil {
+		return
+	}
+	ctl.rwlock.RLock()
+	defer ctl.rwlock.RUnlock()
+	var dbl *vectodb.VectoDBLite
+	if dbl, err = ec.getVectoDBLite(reqSearch.DbID); err != nil {
+		return
+	}
+	_, _, err = ctl.search(dbl, &reqSearch, &rspSearch)
+	return
+}
+
+// Delete marks the vector as deleted. It's a no-op if the vector is absent.
+func (ec *EmbeddedController) Delete(dbID int, xid uint64) (err error) {
+	ctl := ec.ctl
+	ctl.rwlock.RLock()
+	defer ctl.rwlock.RUnlock()
+	var dbl *vectodb.VectoDBLite
+	if dbl, err = ec.getVectoDBLite(dbID); err != nil {
+		return
+	}
+	err = dbl.Delete(xid)
+	return
+}
+
+// Shutdown releases all vectodblites and leaves the cluster. Their vectors are kept in redis.
+func (ec *EmbeddedController) Shutdown(ctx context.Context) (err error) {
+	return ec.ctl.Shutdown(ctx)
+}
@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type RspImport struct {
+	DbID  int    `json:"dbID"`
+	Count int    `json:"count"` // number of vectors imported, including the ones before an error
+	Err   string `json:"err"`
+}
+
+// @Description Export vectors of the given vectodblite for migrating it to another cluster. The body is a stream in vectodb export format, whose header includes dim and metric.
+// @Produce application/octet-stream
+// @Param   dbID	query	int	true	"dbID"
+// @Success 200 "vectodb export stream"
+// @Failure 307 "redirection"
+// @Failure 400
+// @Failure 500
+// @Failure 503 "memory pressure or draining"
+// @Router /mgmt/v1/export [get]
+func (ctl *Controller) HandleExport(c *gin.Context) {
+	dbID, err := strconv.Atoi(c.Query("dbID"))
+	if err != nil {
+		err = errors.Wrap(err, "invalid dbID")
+		log.Infof("failed to parse request, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	var dbl *vectodb.VectoDBLite
+	ctl.rwlock.RLock()
+	dbl, err = ctl.getVectoDBLite(c, dbID)
+	ctl.rwlock.RUnlock()
+	if isUnavailable(err) {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		log.Errorf("got error %+v", err)
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	} else if dbl == nil {
+		//already return a response
+		return
+	}
+	// Export only reads lru, so it's safe without holding the controller lock even if the vectodblite is released meanwhile.
+	c.Header("Content-Type", "application/octet-stream")
+	c.Status(http.StatusOK)
+	w := bufio.NewWriter(c.Writer)
+	var count int
+	if count, err = dbl.Export(w); err == nil {
+		err = errors.Wrap(w.Flush(), "")
+	}
+	if err != nil {
+		// the status is already sent, the client detects the truncated stream
+		log.Errorf("vectodblite %v export got error %+v", dbID, err)
+		return
+	}
+	log.Infof("vectodblite %v exported %v vectors", dbID, count)
+}
+
+// @Description Import vectors exported by /mgmt/v1/export into the given vectodblite. The dim and metric of the stream shall match this cluster.
+// @Accept  application/octet-stream
+// @Produce  json
+// @Param   dbID	query	int	true	"dbID"
+// @Success 200 {object} cluster.RspImport "RspImport"
+// @Failure 307 "redirection"
+// @Failure 400
+// @Failure 503 "memory pressure or draining"
+// @Router /mgmt/v1/import [post]
+func (ctl *Controller) HandleImport(c *gin.Context) {
+	var rspImport RspImport
+	var err error
+	if rspImport.DbID, err = strconv.Atoi(c.Query("dbID")); err != nil {
+		err = errors.Wrap(err, "invalid dbID")
+		log.Infof("failed to parse request, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if pressure, _ := ctl.underMemPressure(); pressure {
+		c.String(http.StatusServiceUnavailable, errMemPressure.Error())
+		return
+	}
+	var dbl *vectodb.VectoDBLite
+	ctl.rwlock.RLock()
+	dbl, err = ctl.getVectoDBLite(c, rspImport.DbID)
+	ctl.rwlock.RUnlock()
+	if isUnavailable(err) {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		rspImport.Err = err.Error()
+		log.Errorf("got error %+v", err)
+		c.JSON(200, rspImport)
+		return
+	} else if dbl == nil {
+		//already return a response
+		return
+	}
+	if rspImport.Count, err = ctl.importStream(rspImport.DbID, c.Request.Body); err != nil {
+		rspImport.Err = err.Error()
+		log.Errorf("got error %+v", err)
+	}
+	c.JSON(200, rspImport)
+}
+
+// importStream validates the header, and adds records in batches the same as ingest.
+func (ctl *Controller) importStream(dbID int, r io.Reader) (count int, err error) {
+	br := bufio.NewReader(r)
+	var hdr vectodb.ExportHeader
+	if hdr, err = vectodb.ReadExportHeader(br); err != nil {
+		return
+	}
+	if hdr.Dim != ctl.conf.Dim || hdr.Metric != vectodb.MetricInnerProduct {
+		err = errors.Errorf("incompatible export, want dim %v metric %v, have dim %v metric %v", ctl.conf.Dim, vectodb.MetricInnerProduct, hdr.Dim, hdr.Metric)
+		return
+	}
+	xids := make([]uint64, 0, IngestBatchSize)
+	vts := make([]*vectodb.VecTimestamp, 0, IngestBatchSize)
+	var errRead error
+	for errRead == nil {
+		var xid uint64
+		var vt *vectodb.VecTimestamp
+		if xid, vt, errRead = vectodb.ReadExportRecord(br); errRead == nil {
+			xids = append(xids, xid)
+			vts = append(vts, vt)
+		}
+		if len(xids) == IngestBatchSize || (errRead != nil && len(xids) != 0) {
+			if err = ctl.withVectoDBLite(dbID, func(dbl *vectodb.VectoDBLite) error { return dbl.ImportBatch(xids, vts) }); err != nil {
+				return
+			}
+			count += len(xids)
+			xids = xids[:0]
+			vts = vts[:0]
+		}
+	}
+	if errRead != io.EOF {
+		err = errRead
+	}
+	return
+}
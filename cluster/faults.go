@@ -0,0 +1,100 @@
+package cluster
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var errInjectedFault = errors.New("injected fault")
+
+// FaultInjection makes data requests fail on purpose, so that clients can validate their retry and failover logic in staging.
+// Each rate is the fraction of requests affected, and they're exclusive of each other. All zero disables it.
+type FaultInjection struct {
+	ErrorRate    float64 `json:"errorRate"`    // fail with 500
+	DelayRate    float64 `json:"delayRate"`    // delay by DelayMs before handling
+	DelayMs      int     `json:"delayMs"`      // in milliseconds
+	RedirectRate float64 `json:"redirectRate"` // redirect to RedirectAddr, or to this node if it's empty
+	RedirectAddr string  `json:"redirectAddr"`
+}
+
+func (fi *FaultInjection) validate() (err error) {
+	for _, rate := range []float64{fi.ErrorRate, fi.DelayRate, fi.RedirectRate} {
+		if rate < 0 || rate > 1 {
+			err = errors.Errorf("invalid fault injection rate %v, want [0, 1]", rate)
+			return
+		}
+	}
+	if sum := fi.ErrorRate + fi.DelayRate + fi.RedirectRate; sum > 1 {
+		err = errors.Errorf("invalid fault injection rates, sum %v, want <= 1", sum)
+		return
+	}
+	if fi.DelayMs < 0 {
+		err = errors.Errorf("invalid fault injection delay %v ms, want >= 0", fi.DelayMs)
+		return
+	}
+	return
+}
+
+// injectFault is a middleware of data endpoints which injects faults per FaultInjection.
+func (ctl *Controller) injectFault(c *gin.Context) {
+	ctl.faultLock.RLock()
+	fi := ctl.conf.FaultInjection
+	ctl.faultLock.RUnlock()
+	if fi.ErrorRate == 0 && fi.DelayRate == 0 && fi.RedirectRate == 0 {
+		return
+	}
+	r := rand.Float64()
+	switch {
+	case r < fi.ErrorRate:
+		c.String(http.StatusInternalServerError, errInjectedFault.Error())
+		c.Abort()
+	case r < fi.ErrorRate+fi.RedirectRate:
+		dstURL := *c.Request.URL
+		dstURL.Host = fi.RedirectAddr
+		if dstURL.Host == "" {
+			dstURL.Host = ctl.conf.ListenAddr
+		}
+		c.Redirect(http.StatusTemporaryRedirect, dstURL.String())
+		c.Abort()
+	case r < fi.ErrorRate+fi.RedirectRate+fi.DelayRate:
+		time.Sleep(time.Duration(fi.DelayMs) * time.Millisecond)
+	}
+}
+
+// HandleFaultInjection gets the current fault injection with GET, and replaces it with PUT.
+// It's deliberately left out of the API docs, and requires the debug token in DebugTokenHeader.
+func (ctl *Controller) HandleFaultInjection(c *gin.Context) {
+	if ctl.conf.DebugToken == "" || c.GetHeader(DebugTokenHeader) != ctl.conf.DebugToken {
+		c.String(http.StatusForbidden, "")
+		return
+	}
+	if c.Request.Method == http.MethodGet {
+		ctl.faultLock.RLock()
+		fi := ctl.conf.FaultInjection
+		ctl.faultLock.RUnlock()
+		c.JSON(200, fi)
+		return
+	}
+	var fi FaultInjection
+	var err error
+	if err = c.ShouldBindJSON(&fi); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err = fi.validate(); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctl.faultLock.Lock()
+	ctl.conf.FaultInjection = fi
+	ctl.faultLock.Unlock()
+	log.Warnf("fault injection is set to %+v", fi)
+	c.JSON(200, fi)
+}
@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// MaxRedirects is the max number of redirects PostJson follows, i.e. a vectodblite moving while the request is being redirected.
+const MaxRedirects = 3
+
+// PostJson posts reqObj to servURL and decodes the response body into rspObj. The request is canceled once ctx is done.
+// Redirects of nodes which don't own the vectodblite are followed up to MaxRedirects hops, and reqObj is posted again to each of them.
+func PostJson(ctx context.Context, hc *http.Client, servURL string, reqObj, rspObj interface{}) (err error) {
+	var reqBody, rspBody []byte
+	if reqBody, err = json.Marshal(reqObj); err != nil {
+		err = errors.Wrapf(err, "servURL %+v, failed to encode reqObj: %+v", servURL, reqObj)
+		return
+	}
+	// follow redirects here rather than in hc, which turns POST into GET on 301, 302 and 303
+	noRedirect := *hc
+	noRedirect.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	var rsp *http.Response
+	for hops := 0; ; hops++ {
+		var req *http.Request
+		if req, err = http.NewRequest(http.MethodPost, servURL, bytes.NewReader(reqBody)); err != nil {
+			err = errors.Wrapf(err, "servURL %+v", servURL)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if rsp, err = noRedirect.Do(req.WithContext(ctx)); err != nil {
+			err = errors.Wrapf(err, "servURL %+v", servURL)
+			return
+		}
+		if !isRedirect(rsp.StatusCode) {
+			break
+		}
+		io.Copy(ioutil.Discard, rsp.Body)
+		rsp.Body.Close()
+		if hops >= MaxRedirects {
+			err = errors.Errorf("servURL %+v, stopped after %d redirects", servURL, MaxRedirects)
+			return
+		}
+		var loc *url.URL
+		if loc, err = rsp.Location(); err != nil {
+			err = errors.Wrapf(err, "servURL %+v, invalid redirect", servURL)
+			return
+		}
+		servURL = loc.String()
+	}
+	rspBody, err = ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if err != nil {
+		err = errors.Wrapf(err, "servURL %+v", servURL)
+		return
+	}
+	if err = json.Unmarshal(rspBody, rspObj); err != nil {
+		err = errors.Wrapf(err, "servURL %+v, failed to decode rspBody: %+v", servURL, string(rspBody))
+		return
+	}
+	return
+}
+
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
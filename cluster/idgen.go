@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+)
+
+// Strategies to generate xids of vectors added without one.
+const (
+	IdStrategyHash       = "hash"       // hash of the vector, the same vector always gets the same xid
+	IdStrategySequential = "sequential" // per-dbID counter in redis
+	IdStrategyRandom     = "random"     // random uint64, retried on collision
+	IdStrategySnowflake  = "snowflake"  // <41 bits milliseconds since SnowflakeEpoch><10 bits node id><12 bits sequence>
+
+	SnowflakeEpoch   int64 = 1546300800000 // 2019-01-01T00:00:00Z in milliseconds
+	SnowflakeMaxNode       = 1<<10 - 1
+	maxRandomRetries       = 16
+)
+
+// idGenerator generates xids of vectors added without one. Generated xids are never 0 or ^uint64(0) which mean "absent".
+type idGenerator interface {
+	nextID(dbID int, dbl *vectodb.VectoDBLite) (xid uint64, err error)
+}
+
+// newIdGenerator returns nil for IdStrategyHash which is implemented by VectoDBLite itself.
+func newIdGenerator(conf *ControllerConf) (gen idGenerator, err error) {
+	switch conf.IdStrategy {
+	case IdStrategyHash:
+	case IdStrategySequential:
+		gen = &seqIdGenerator{
+			rcli:      conf.redisOptions().NewClient(),
+			keyPrefix: conf.RedisKeyPrefix,
+		}
+	case IdStrategyRandom:
+		gen = &randIdGenerator{
+			rand: func() uint64 { return rand.Uint64() },
+		}
+	case IdStrategySnowflake:
+		gen = &snowflakeIdGenerator{
+			node: uint64(conf.SnowflakeNode),
+			now:  func() int64 { return time.Now().UnixNano() / int64(time.Millisecond) },
+		}
+	default:
+		err = errors.Errorf("invalid id strategy %v", conf.IdStrategy)
+	}
+	return
+}
+
+type seqIdGenerator struct {
+	rcli      *redis.Client
+	keyPrefix string
+}
+
+func (gen *seqIdGenerator) nextID(dbID int, dbl *vectodb.VectoDBLite) (xid uint64, err error) {
+	var seq int64
+	if seq, err = gen.rcli.Incr(fmt.Sprintf("%svectodblite_xid_seq_%d", gen.keyPrefix, dbID)).Result(); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	// INCR starts from 1
+	xid = uint64(seq)
+	return
+}
+
+type randIdGenerator struct {
+	rand func() uint64
+}
+
+func (gen *randIdGenerator) nextID(dbID int, dbl *vectodb.VectoDBLite) (xid uint64, err error) {
+	for i := 0; i < maxRandomRetries; i++ {
+		xid = gen.rand()
+		if xid != 0 && xid != ^uint64(0) && !dbl.Contains(xid) {
+			return
+		}
+	}
+	err = errors.Errorf("vectodblite %v failed to generate a random xid after %v retries", dbID, maxRandomRetries)
+	return
+}
+
+type snowflakeIdGenerator struct {
+	node   uint64
+	now    func() int64 // in milliseconds
+	mu     sync.Mutex
+	lastMs int64
+	seq    uint64
+}
+
+func (gen *snowflakeIdGenerator) nextID(dbID int, dbl *vectodb.VectoDBLite) (xid uint64, err error) {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+	ms := gen.now()
+	if ms < gen.lastMs {
+		// clock goes backwards, stick to the last millisecond
+		ms = gen.lastMs
+	}
+	if ms == gen.lastMs {
+		gen.seq = (gen.seq + 1) & (1<<12 - 1)
+		if gen.seq == 0 {
+			// sequence exhausted, wait for the next millisecond
+			for ms <= gen.lastMs {
+				time.Sleep(100 * time.Microsecond)
+				ms = gen.now()
+			}
+		}
+	} else {
+		gen.seq = 0
+	}
+	gen.lastMs = ms
+	xid = uint64(ms-SnowflakeEpoch)<<22 | gen.node<<12 | gen.seq
+	return
+}
@@ -0,0 +1,153 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// IngestBatchSize is the number of records HandleIngest adds at a time.
+const IngestBatchSize = 1000
+
+type RspIngest struct {
+	DbID  int    `json:"dbID"`
+	Count int    `json:"count"` // number of vectors added, including the ones before an error
+	Err   string `json:"err"`
+}
+
+// writeIngestRecord encodes a record of the ingest stream: <length uint32><xid uint64>{<dim><float32>}, little endian.
+// length is the number of bytes following it.
+func writeIngestRecord(w io.Writer, xid uint64, xb []float32) (err error) {
+	buf := make([]byte, 4+8+4*len(xb))
+	binary.LittleEndian.PutUint32(buf, uint32(8+4*len(xb)))
+	binary.LittleEndian.PutUint64(buf[4:], xid)
+	for i, x := range xb {
+		binary.LittleEndian.PutUint32(buf[12+4*i:], math.Float32bits(x))
+	}
+	if _, err = w.Write(buf); err != nil {
+		err = errors.Wrap(err, "")
+	}
+	return
+}
+
+// readIngestRecord decodes a record written by writeIngestRecord. It returns io.EOF at a clean end of stream.
+func readIngestRecord(r io.Reader, dim int, buf []byte) (xid uint64, xb []float32, err error) {
+	if _, err = io.ReadFull(r, buf[:4]); err != nil {
+		if err != io.EOF {
+			err = errors.Wrap(err, "failed to read record length")
+		}
+		return
+	}
+	length := int(binary.LittleEndian.Uint32(buf))
+	if length != 8+4*dim {
+		err = errors.Errorf("invalid record length, want %v, have %v", 8+4*dim, length)
+		return
+	}
+	if _, err = io.ReadFull(r, buf[:length]); err != nil {
+		err = errors.Wrap(err, "failed to read record")
+		return
+	}
+	xid = binary.LittleEndian.Uint64(buf)
+	xb = make([]float32, dim)
+	for i := range xb {
+		xb[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[8+4*i:]))
+	}
+	return
+}
+
+// @Description Stream vectors into the given vectodblite. The body is a sequence of records <length uint32><xid uint64>{<dim><float32>} in little endian,
+// @Description where length is the number of bytes following it. If xid is 0 or ^uint64(0), the cluster will generate one. Records are added in batches as they're read.
+// @Accept  application/octet-stream
+// @Produce  json
+// @Param   dbID	query	int	true	"dbID"
+// @Success 200 {object} cluster.RspIngest "RspIngest"
+// @Failure 307 "redirection"
+// @Failure 400
+// @Failure 503 "memory pressure or draining"
+// @Router /api/v1/ingest [post]
+func (ctl *Controller) HandleIngest(c *gin.Context) {
+	var rspIngest RspIngest
+	var err error
+	if rspIngest.DbID, err = strconv.Atoi(c.Query("dbID")); err != nil {
+		err = errors.Wrap(err, "invalid dbID")
+		log.Infof("failed to parse request, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if pressure, _ := ctl.underMemPressure(); pressure {
+		c.String(http.StatusServiceUnavailable, errMemPressure.Error())
+		return
+	}
+	var dbl *vectodb.VectoDBLite
+	ctl.rwlock.RLock()
+	dbl, err = ctl.getVectoDBLite(c, rspIngest.DbID)
+	ctl.rwlock.RUnlock()
+	if isUnavailable(err) {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		rspIngest.Err = err.Error()
+		log.Errorf("got error %+v", err)
+		c.JSON(200, rspIngest)
+		return
+	} else if dbl == nil {
+		//already return a response
+		return
+	}
+	if rspIngest.Count, err = ctl.ingest(rspIngest.DbID, c.Request.Body); err != nil {
+		rspIngest.Err = err.Error()
+		log.Errorf("got error %+v", err)
+	}
+	c.JSON(200, rspIngest)
+}
+
+// ingest reads records from r and adds them in batches. The complete records before a malformed one are added.
+// The controller lock is held per batch rather than the whole stream, so that a long ingest doesn't block acquiring and releasing vectodblites.
+func (ctl *Controller) ingest(dbID int, r io.Reader) (count int, err error) {
+	br := bufio.NewReader(r)
+	buf := make([]byte, 8+4*ctl.conf.Dim)
+	xbs := make([][]float32, 0, IngestBatchSize)
+	xids := make([]uint64, 0, IngestBatchSize)
+	var errRead error
+	for errRead == nil {
+		var xid uint64
+		var xb []float32
+		if xid, xb, errRead = readIngestRecord(br, ctl.conf.Dim, buf); errRead == nil {
+			xbs = append(xbs, xb)
+			xids = append(xids, xid)
+		}
+		if len(xids) == IngestBatchSize || (errRead != nil && len(xids) != 0) {
+			if err = ctl.withVectoDBLite(dbID, func(dbl *vectodb.VectoDBLite) error { return dbl.AddBatchWithIds(xbs, xids) }); err != nil {
+				return
+			}
+			count += len(xids)
+			xbs = xbs[:0]
+			xids = xids[:0]
+		}
+	}
+	if errRead != io.EOF {
+		err = errRead
+	}
+	return
+}
+
+// withVectoDBLite calls fn with the given vectodblite under the controller lock. It fails if the vectodblite is released meanwhile.
+func (ctl *Controller) withVectoDBLite(dbID int, fn func(dbl *vectodb.VectoDBLite) error) (err error) {
+	ctl.rwlock.RLock()
+	defer ctl.rwlock.RUnlock()
+	dbl, ok := ctl.dbls[dbID]
+	if !ok {
+		err = errors.Errorf("vectodblite %v is released meanwhile", dbID)
+		return
+	}
+	err = fn(dbl)
+	return
+}
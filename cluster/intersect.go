@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	IntersectOverFetch     = 10   // intersection searches TopK*IntersectOverFetch candidates
+	IntersectMaxCandidates = 1000 // upper bound of intersection candidates
+)
+
+type ReqContains struct {
+	DbID int      `json:"dbID"`
+	Xids []uint64 `json:"xids"`
+}
+
+type RspContains struct {
+	Exists []bool `json:"exists"`
+	Err    string `json:"err"`
+}
+
+type ReqSearchIntersect struct {
+	DbID      int       `json:"dbID"`
+	OtherDbID int       `json:"otherDbID"`
+	Xq        []float32 `json:"xq"`
+	TopK      int       `json:"topK"`
+}
+
+type RspSearchIntersect struct {
+	Results []SearchHit `json:"results"`
+	Err     string      `json:"err"`
+}
+
+// @Description Check if vectors are present in the given vectodblite
+// @Accept  json
+// @Produce  json
+// @Param   contains	body	cluster.ReqContains	true 	"ReqContains"
+// @Success 200 {object} cluster.RspContains "RspContains. exists[i] indicates if xids[i] is present."
+// @Failure 307 "redirection"
+// @Failure 400
+// @Failure 503 "memory pressure or draining"
+// @Router /api/v1/contains [post]
+func (ctl *Controller) HandleContains(c *gin.Context) {
+	var reqContains ReqContains
+	var err error
+	if err = c.ShouldBind(&reqContains); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+	} else {
+		var rspContains RspContains
+		var dbl *vectodb.VectoDBLite
+		ctl.rwlock.RLock()
+		defer ctl.rwlock.RUnlock()
+		if dbl, err = ctl.getVectoDBLite(c, reqContains.DbID); isUnavailable(err) {
+			c.String(http.StatusServiceUnavailable, err.Error())
+			return
+		} else if err != nil {
+			rspContains.Err = err.Error()
+			log.Errorf("got error %+v", err)
+			c.JSON(200, rspContains)
+			return
+		} else if dbl == nil {
+			//already return a response
+			return
+		}
+		rspContains.Exists = make([]bool, len(reqContains.Xids))
+		for i, xid := range reqContains.Xids {
+			rspContains.Exists[i] = dbl.Contains(xid)
+		}
+		c.JSON(200, rspContains)
+	}
+}
+
+// @Description Search a vector in the given vectodblite, and return only the neighbors which are present in the other vectodblite.
+// @Accept  json
+// @Produce  json
+// @Param   search		body	cluster.ReqSearchIntersect	true 	"ReqSearchIntersect. At most topK*10 (no more than 1000) neighbors within the distance threshold are candidates of the intersection."
+// @Success 200 {object} cluster.RspSearchIntersect "RspSearchIntersect. At most topK neighbors in descending order of distance."
+// @Failure 307 "redirection"
+// @Failure 400 "invalid request, or zero query vector"
+// @Failure 503 "memory pressure or draining"
+// @Router /api/v1/search_intersect [post]
+func (ctl *Controller) HandleSearchIntersect(c *gin.Context) {
+	var reqSearch ReqSearchIntersect
+	var err error
+	if err = c.ShouldBind(&reqSearch); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err = ctl.conf.validateQuery(reqSearch.Xq); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if reqSearch.TopK <= 0 {
+		err = errors.Errorf("invalid topK %v, want > 0", reqSearch.TopK)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	var rspSearch RspSearchIntersect
+	var dbl *vectodb.VectoDBLite
+	var rsts []vectodb.SearchResult
+	// The lock is released before checking the other vectodblite, which could go through this node's own endpoint.
+	ctl.rwlock.RLock()
+	if dbl, err = ctl.getVectoDBLite(c, reqSearch.DbID); err == nil && dbl != nil {
+		opts := vectodb.SearchOptions{
+			MinResults: vectodb.MinInt(reqSearch.TopK*IntersectOverFetch, IntersectMaxCandidates),
+		}
+		rsts, err = dbl.SearchWithOptions(reqSearch.Xq, opts)
+	}
+	ctl.rwlock.RUnlock()
+	if isUnavailable(err) {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		rspSearch.Err = err.Error()
+		log.Errorf("got error %+v", err)
+		c.JSON(200, rspSearch)
+		return
+	} else if dbl == nil {
+		//already return a response
+		return
+	}
+	xids := make([]uint64, 0, len(rsts))
+	for _, rst := range rsts {
+		if rst.Relaxed {
+			break
+		}
+		xids = append(xids, rst.Xid)
+	}
+	var exists []bool
+	if exists, err = ctl.contains(c.Request.Context(), reqSearch.OtherDbID, xids); err != nil {
+		rspSearch.Err = err.Error()
+		log.Errorf("got error %+v", err)
+		c.JSON(200, rspSearch)
+		return
+	}
+	rspSearch.Results = make([]SearchHit, 0, reqSearch.TopK)
+	for i := range xids {
+		if !exists[i] {
+			continue
+		}
+		rspSearch.Results = append(rspSearch.Results, SearchHit{
+			Xid:      rsts[i].Xid,
+			Distance: rsts[i].Distance,
+			Group:    rsts[i].Group,
+		})
+		if len(rspSearch.Results) >= reqSearch.TopK {
+			break
+		}
+	}
+	c.JSON(200, rspSearch)
+}
+
+// contains checks membership of xids in the given vectodblite. It's checked locally if this node owns the vectodblite,
+// otherwise via the contains endpoint of this node which redirects to the owner.
+func (ctl *Controller) contains(ctx context.Context, dbID int, xids []uint64) (exists []bool, err error) {
+	ctl.rwlock.RLock()
+	dbl, ok := ctl.dbls[dbID]
+	if ok {
+		exists = make([]bool, len(xids))
+		for i, xid := range xids {
+			exists[i] = dbl.Contains(xid)
+		}
+	}
+	ctl.rwlock.RUnlock()
+	if ok || len(xids) == 0 {
+		return
+	}
+	servURL := fmt.Sprintf("%s://%s/api/v1/contains", ctl.conf.scheme(), ctl.conf.ListenAddr)
+	rspContains := &RspContains{}
+	if err = ctl.postData(ctx, servURL, ReqContains{DbID: dbID, Xids: xids}, rspContains); err != nil {
+		return
+	}
+	if rspContains.Err != "" {
+		err = errors.Errorf("vectodblite %v contains: %v", dbID, rspContains.Err)
+		return
+	}
+	if len(rspContains.Exists) != len(xids) {
+		err = errors.Errorf("vectodblite %v contains: invalid length of exists, want %v, have %v", dbID, len(xids), len(rspContains.Exists))
+		return
+	}
+	exists = rspContains.Exists
+	return
+}
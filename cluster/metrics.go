@@ -0,0 +1,96 @@
+package cluster
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The request metrics are registered to the prometheus default registry, along with the cgo metrics of vectodb.
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vectodblite",
+		Name:      "requests_total",
+		Help:      "Number of data requests by endpoint and status code.",
+	}, []string{"endpoint", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vectodblite",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of data requests by endpoint.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10), // 100us ~ 26s
+	}, []string{"endpoint"})
+	redirectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vectodblite",
+		Name:      "redirects_total",
+		Help:      "Number of data requests redirected to the owner of the vectodblite, by endpoint.",
+	}, []string{"endpoint"})
+	acquireWaitDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "vectodblite",
+		Name:      "acquire_wait_seconds",
+		Help:      "Duration of acquires waiting for the acquire rate before they're sent to the leader.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8), // 1ms ~ 16s
+	})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration, redirectsTotal, acquireWaitDuration)
+}
+
+// instrument is a middleware of data endpoints which records the request metrics. The endpoint label is the path without /api/v1/.
+func (ctl *Controller) instrument(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	endpoint := strings.TrimPrefix(c.Request.URL.Path, "/api/v1/")
+	code := c.Writer.Status()
+	requestsTotal.WithLabelValues(endpoint, strconv.Itoa(code)).Inc()
+	requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if code == http.StatusTemporaryRedirect {
+		redirectsTotal.WithLabelValues(endpoint).Inc()
+	}
+}
+
+// dblCollector reports the vectodblites owned by this node when it's scraped, so that released ones don't linger as stale series.
+type dblCollector struct {
+	ctl          *Controller
+	ownedDesc    *prometheus.Desc
+	sizeDesc     *prometheus.Desc
+	danglingDesc *prometheus.Desc
+}
+
+func newDblCollector(ctl *Controller) *dblCollector {
+	return &dblCollector{
+		ctl:       ctl,
+		ownedDesc: prometheus.NewDesc("vectodblite_owned", "Number of vectodblites owned by this node.", nil, nil),
+		sizeDesc:  prometheus.NewDesc("vectodblite_vectors", "Number of vectors of an owned vectodblite, tombstones included.", []string{"db"}, nil),
+		danglingDesc: prometheus.NewDesc("vectodblite_dangling_total", "Number of search candidates of an owned vectodblite skipped since they're in the index but absent in redis.",
+			[]string{"db"}, nil),
+	}
+}
+
+func (dc *dblCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dc.ownedDesc
+	ch <- dc.sizeDesc
+	ch <- dc.danglingDesc
+}
+
+func (dc *dblCollector) Collect(ch chan<- prometheus.Metric) {
+	dc.ctl.rwlock.RLock()
+	defer dc.ctl.rwlock.RUnlock()
+	ch <- prometheus.MustNewConstMetric(dc.ownedDesc, prometheus.GaugeValue, float64(len(dc.ctl.dbls)))
+	for dbID, dbl := range dc.ctl.dbls {
+		ch <- prometheus.MustNewConstMetric(dc.sizeDesc, prometheus.GaugeValue, float64(dbl.Size()), strconv.Itoa(dbID))
+		ch <- prometheus.MustNewConstMetric(dc.danglingDesc, prometheus.CounterValue, float64(dbl.NumDangling()), strconv.Itoa(dbID))
+	}
+}
+
+// metricsHandler serves the metrics of the default registry and the vectodblites of this controller in the prometheus text format.
+func (ctl *Controller) metricsHandler() gin.HandlerFunc {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newDblCollector(ctl))
+	return gin.WrapH(promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, reg}, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,845 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gin-gonic/gin"
+	"github.com/hudl/fargo"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	MaxLoadDelta = 2
+	// NodeAliveVal is the value of a node key if the node serves mgmt endpoints at its listen address.
+	// Otherwise the value is the admin address.
+	NodeAliveVal = "alive"
+	// https://github.com/Netflix/eureka/wiki/Understanding-eureka-client-server-communication
+	EurekaHeartbeatInterval = 30
+	// distance distribution sampling
+	DistributionPairs    = 1000
+	DistributionMaxPairs = 100000
+)
+
+// ShutdownTimeout bounds Shutdown on SIGINT and SIGTERM, including handing off or disowning vectodblites.
+const ShutdownTimeout = 60 * time.Second
+
+var DistributionPercentiles = []float64{1, 5, 10, 25, 50, 75, 90, 95, 99}
+
+func (ctl *Controller) initMgmt() (err error) {
+	if ctl.etcdCli, err = NewEtcdClient(ctl.conf.EtcdAddr); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	if err = ctl.nodeKeepalive(); err != nil {
+		return
+	}
+	ctl.electionDone = StartElection(ctl.ctx, ctl.etcdCli, ctl.conf.etcdPath(), ctl.conf.ListenAddr, ctl.leaderChangedCb)
+	ctl.registerDone = make(chan struct{})
+	go ctl.servRegister()
+	go ctl.servQPS()
+	if ctl.conf.MaxRSSMB != 0 || ctl.conf.MinAvailMemMB != 0 {
+		go ctl.servPressure()
+	}
+	if ctl.conf.HashPlacement {
+		go ctl.servRing(ctl.ctx)
+	}
+	if ctl.clientLimiter != nil && len(ctl.conf.MgmtKeys) == 0 {
+		go ctl.servPeers(ctl.ctx)
+	}
+	return
+}
+
+func (ctl *Controller) nodeKeepalive() (err error) {
+	resp, err := ctl.etcdCli.Grant(ctl.ctx, int64(60))
+	if err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	// the key will be kept forever
+	_, kaerr := ctl.etcdCli.KeepAlive(ctl.ctx, resp.ID)
+	if kaerr != nil {
+		err = errors.Wrap(kaerr, "")
+		return
+	}
+	leaseID := resp.ID
+	atomic.StoreInt64((*int64)(&ctl.leaseID), int64(leaseID))
+
+	k := fmt.Sprintf("%s/node/%s", ctl.conf.etcdPath(), ctl.conf.ListenAddr)
+	val := NodeAliveVal
+	if ctl.conf.AdminAddr != "" {
+		val = ctl.conf.AdminAddr
+	}
+	txn := ctl.etcdCli.Txn(ctl.ctx).If(clientv3.Compare(clientv3.CreateRevision(k), "=", 0))
+	txn = txn.Then(clientv3.OpPut(k, val, clientv3.WithLease(leaseID)))
+	if _, err = txn.Commit(); err != nil {
+		err = errors.Wrap(err, "")
+		return err
+	}
+	return
+}
+
+// getLeaseID returns the lease of the node key, clientv3.NoLease if it isn't granted yet.
+func (ctl *Controller) getLeaseID() clientv3.LeaseID {
+	return clientv3.LeaseID(atomic.LoadInt64((*int64)(&ctl.leaseID)))
+}
+
+// getAdminAddr returns the address which serves mgmt endpoints of the given node.
+func (ctl *Controller) getAdminAddr(ctx context.Context, nodeAddr string) (adminAddr string, err error) {
+	if nodeAddr == ctl.conf.ListenAddr {
+		adminAddr = ctl.conf.AdminAddr
+		if adminAddr == "" {
+			adminAddr = nodeAddr
+		}
+		return
+	}
+	adminAddr = nodeAddr
+	k := fmt.Sprintf("%s/node/%s", ctl.conf.etcdPath(), nodeAddr)
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, k); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	if len(resp.Kvs) != 0 {
+		if val := string(resp.Kvs[0].Value); val != NodeAliveVal {
+			adminAddr = val
+		}
+	}
+	return
+}
+
+func (ctl *Controller) leaderChangedCb(prevLeader, curLeader string) {
+	ctl.curLeader = curLeader
+	if ctl.conf.ListenAddr == curLeader && !ctl.isLeader {
+		log.Infof("I've been promoted as leader")
+		ctl.isLeader = true
+		ctl.ctxL, ctl.cancelL = context.WithCancel(ctl.ctx)
+		go ctl.servLeaderWork(ctl.ctxL)
+	} else if ctl.conf.ListenAddr != curLeader && ctl.isLeader {
+		log.Infof("I've resigned as follower")
+		ctl.isLeader = false
+		ctl.cancelL()
+	}
+}
+
+type RspLeader struct {
+	Leader string `json:"leader"` // listen address of the leader, empty if it's unknown
+	IsSelf bool   `json:"isSelf"` // whether this node is the leader
+}
+
+// @Description Return the current leader, so that clients could send requests which acquire vectodblites to it directly.
+// @Produce json
+// @Success 200 {object} cluster.RspLeader "RspLeader"
+// @Router /mgmt/v1/leader [get]
+func (ctl *Controller) HandleLeader(c *gin.Context) {
+	c.JSON(200, RspLeader{
+		Leader: ctl.curLeader,
+		IsSelf: ctl.isLeader,
+	})
+}
+
+type OwnedDb struct {
+	DbID  int `json:"dbID"`
+	Count int `json:"count"` // number of vectors except deleted ones
+}
+
+type RspOwned struct {
+	Owned []OwnedDb `json:"owned"` // in ascending order of dbID
+	Err   string    `json:"err"`
+}
+
+// @Description Return the vectodblites owned by this node along with their vector counts. It helps to debug placement.
+// @Produce json
+// @Success 200 {object} cluster.RspOwned "RspOwned"
+// @Router /mgmt/v1/owned [get]
+func (ctl *Controller) HandleOwned(c *gin.Context) {
+	// snapshot under RLock, and count vectors without it so that requests aren't blocked by the scan
+	ctl.rwlock.RLock()
+	dbls := make(map[int]*vectodb.VectoDBLite, len(ctl.dbls))
+	for dbID, dbl := range ctl.dbls {
+		dbls[dbID] = dbl
+	}
+	ctl.rwlock.RUnlock()
+	rspOwned := RspOwned{Owned: make([]OwnedDb, 0, len(dbls))}
+	for dbID, dbl := range dbls {
+		count, err := dbl.Count()
+		if err != nil {
+			rspOwned.Err = err.Error()
+			log.Errorf("got error %+v", err)
+			c.JSON(200, rspOwned)
+			return
+		}
+		rspOwned.Owned = append(rspOwned.Owned, OwnedDb{DbID: dbID, Count: count})
+	}
+	sort.Slice(rspOwned.Owned, func(i, j int) bool { return rspOwned.Owned[i].DbID < rspOwned.Owned[j].DbID })
+	c.JSON(200, rspOwned)
+}
+
+func (ctl *Controller) servLeaderWork(ctx context.Context) {
+	var err error
+	aliveNodes := make(map[string]int, 0)
+	pfx := fmt.Sprintf("%s/node", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = clientv3.NewKV(ctl.etcdCli).Get(ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		log.Fatalf("got error %+x", err)
+	}
+	for _, item := range resp.Kvs {
+		nodeAddr := filepath.Base(string(item.Key))
+		aliveNodes[nodeAddr] = 0
+	}
+	revision := resp.Header.Revision
+
+	var load map[string][]int
+	if load, err = ctl.getLoad(); err != nil {
+		log.Fatalf("got error %+x", err)
+	}
+	if err = ctl.purgeDeadNodes(load, aliveNodes); err != nil {
+		log.Fatalf("got error %+x", err)
+	}
+
+	watcher := clientv3.NewWatcher(ctl.etcdCli)
+	nodeChangeCh := watcher.Watch(ctx, pfx, clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+
+	balanceInterval := time.Duration(ctl.conf.BalanceInterval) * time.Second
+	balanceTick := time.After(balanceInterval)
+	log.Debugf("balance interval is %v", balanceInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("balance goroutine exited due to context done")
+			return
+		case nc := <-nodeChangeCh:
+			log.Debugf("node change: %+v", nc)
+			if err = nc.Err(); err != nil {
+				err = errors.Wrap(err, "")
+				log.Errorf("got error %+x", err)
+			}
+			for _, e := range nc.Events {
+				nodeAddr := filepath.Base(string(e.Kv.Key))
+				if e.Type == clientv3.EventTypeDelete {
+					delete(aliveNodes, nodeAddr)
+				} else if e.IsCreate() || e.IsModify() {
+					aliveNodes[nodeAddr] = 0
+				}
+			}
+			if err = ctl.purgeDeadNodes(load, aliveNodes); err != nil {
+				log.Fatalf("got error %+x", err)
+			}
+			// a node joined or left, rebalance at once rather than at the next tick
+			if err = ctl.rebalance(ctx, load, aliveNodes); err != nil {
+				log.Errorf("got error %+v", err)
+			}
+		case <-balanceTick:
+			if load, err = ctl.getLoad(); err != nil {
+				log.Errorf("got error %+x", err)
+			} else if err = ctl.rebalance(ctx, load, aliveNodes); err != nil {
+				log.Errorf("got error %+v", err)
+			}
+			balanceTick = time.After(balanceInterval)
+		}
+	}
+}
+
+func (ctl *Controller) getLoad() (load map[string][]int, err error) {
+	load = make(map[string][]int, 0)
+	pfx := fmt.Sprintf("%s/vectodblite", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = clientv3.NewKV(ctl.etcdCli).Get(ctl.ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	for _, item := range resp.Kvs {
+		strDbID := filepath.Base(string(item.Key))
+		var dbID int
+		if dbID, err = strconv.Atoi(strDbID); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+		nodeAddr := string(item.Value)
+		var dbList []int
+		var ok bool
+		if dbList, ok = load[nodeAddr]; !ok {
+			dbList = []int{}
+		}
+		dbList = append(dbList, dbID)
+		load[nodeAddr] = dbList
+	}
+	log.Infof("cluster load %+v", load)
+	return
+}
+
+func (ctl *Controller) purgeDeadNodes(load map[string][]int, aliveNodes map[string]int) (err error) {
+	for nodeAddr, dbList := range load {
+		if _, ok := aliveNodes[nodeAddr]; !ok {
+			for _, dbID := range dbList {
+				key := fmt.Sprintf("%s/vectodblite/%d", ctl.conf.etcdPath(), dbID)
+				var standby string
+				if ctl.conf.WarmStandbyCount > 0 {
+					if standby, err = ctl.pickLiveStandby(ctl.ctxL, dbID, aliveNodes); err != nil {
+						return
+					}
+				}
+				if standby == "" {
+					if _, err = clientv3.NewKV(ctl.etcdCli).Delete(ctl.ctxL, key); err != nil {
+						err = errors.Wrap(err, "")
+						return
+					}
+					continue
+				}
+				// the standby promotes itself on the next request to the vectodblite
+				if _, err = clientv3.NewKV(ctl.etcdCli).Put(ctl.ctxL, key, standby); err != nil {
+					err = errors.Wrap(err, "")
+					return
+				}
+				load[standby] = append(load[standby], dbID)
+				log.Infof("reassigned vectodblite %d of dead node %v to its warm standby %v", dbID, nodeAddr, standby)
+				go ctl.replenishStandbys(dbID, standby)
+			}
+			delete(load, nodeAddr)
+			log.Infof("purged dead node %v", nodeAddr)
+		}
+	}
+	return
+}
+
+// @Description Assocaite a vectodblite with the given node. Only the leader node supports this API.
+// @Accept  json
+// @Produce json
+// @Param   add		body	cluster.ReqAcquire	true 	"ReqAcquire"
+// @Success 200 {object} cluster.RspAcquire "RspAcquire"
+// @Failure 307 "redirection"
+// @Failure 400
+// @Router /mgmt/v1/acquire [post]
+func (ctl *Controller) HandleAcquire(c *gin.Context) {
+	var reqAcquire ReqAcquire
+	var err error
+	if err = c.ShouldBind(&reqAcquire); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+	} else if !ctl.isLeader && ctl.curLeader != "" {
+		var adminAddr string
+		if adminAddr, err = ctl.getAdminAddr(c.Request.Context(), ctl.curLeader); err != nil {
+			log.Errorf("got error %+v", err)
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		dstURL := *c.Request.URL
+		dstURL.Host = adminAddr
+		c.Redirect(http.StatusTemporaryRedirect, dstURL.String())
+	} else {
+		rspAcquire := RspAcquire{
+			DbID: reqAcquire.DbID,
+		}
+		ctx := c.Request.Context()
+		rspAcquire.NodeAddr, err = ctl.acquireOnce(ctx, reqAcquire.DbID, reqAcquire.NodeAddr)
+		if err != nil {
+			rspAcquire.Err = err.Error()
+			log.Errorf("got error %+v", err)
+		}
+		c.JSON(200, rspAcquire)
+	}
+}
+
+func (ctl *Controller) acquire(ctx context.Context, dbID int, nodeAddr string) (dstNodeAddr string, err error) {
+	if !ctl.isLeader {
+		err = errors.Errorf("not capable to acquire since I'm not the leader")
+		return
+	}
+	if ring := ctl.getRing(); ring != nil {
+		if owner := ring.owner(dbID); owner != "" {
+			nodeAddr = owner
+		}
+	} else if ctl.conf.QPSWeight > 0 {
+		if nodeAddr, err = ctl.placeByQPS(ctx, nodeAddr); err != nil {
+			return
+		}
+	}
+	if nodeAddr, err = ctl.avoidPressure(ctx, nodeAddr); err != nil {
+		return
+	}
+	k := fmt.Sprintf("%s/vectodblite/%d", ctl.conf.etcdPath(), dbID)
+	// https://coreos.com/etcd/docs/latest/learning/api.html
+	val := nodeAddr
+	txn := ctl.etcdCli.Txn(ctx).If(clientv3.Compare(clientv3.CreateRevision(k), "=", 0))
+	txn = txn.Then(clientv3.OpPut(k, val))
+	txn = txn.Else(clientv3.OpGet(k))
+	resp, err := txn.Commit()
+	if err != nil {
+		err = errors.Wrap(err, "")
+		return "", err
+	}
+	if resp.Succeeded {
+		dstNodeAddr = nodeAddr
+		log.Infof("acquired vectodblite %d for %s", dbID, nodeAddr)
+		if ctl.conf.WarmStandbyCount > 0 {
+			go ctl.replenishStandbys(dbID, nodeAddr)
+		}
+	} else {
+		kv := resp.Responses[0].GetResponseRange().Kvs[0]
+		dstNodeAddr = string(kv.Value)
+		log.Infof("failed to acquire vectodblite %d for %s, it's already acquired by %s", dbID, nodeAddr, dstNodeAddr)
+	}
+	return
+}
+
+// @Description De-associate a vectodblite with this node. It's closed and its ownership in etcd is cleared if it's owned by this node,
+// @Description so that the next request to it acquires it again, i.e. on another node.
+// @Accept  json
+// @Produce json
+// @Param   add		body	cluster.ReqRelease	true 	"ReqRelease"
+// @Success 200 {object} cluster.RspRelease "RspRelease"
+// @Failure 307 "redirection"
+// @Failure 400
+// @Router /mgmt/v1/release [post]
+func (ctl *Controller) HandleRelease(c *gin.Context) {
+	var reqRelease ReqRelease
+	var err error
+	if err = c.ShouldBind(&reqRelease); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+	} else {
+		rspRelease := RspRelease{
+			DbID: reqRelease.DbID,
+		}
+		dbID := reqRelease.DbID
+		if err = ctl.release(dbID); err != nil {
+			log.Errorf("got error %+v", err)
+			rspRelease.Err = err.Error()
+		}
+		c.JSON(200, rspRelease)
+	}
+}
+
+// release closes the vectodblite and forgets it, and then clears its ownership in etcd if it's still owned by this node, see disown.
+// Both are done under the write lock, so that a request meanwhile can't load it again before the ownership is cleared.
+func (ctl *Controller) release(dbID int) (err error) {
+	ctl.rwlock.Lock()
+	defer ctl.rwlock.Unlock()
+	if dbl, ok := ctl.dbls[dbID]; ok {
+		delete(ctl.dbls, dbID)
+		if err = dbl.Destroy(); err != nil {
+			return
+		} else {
+			log.Infof("released vectodblite %d", dbID)
+		}
+	} else {
+		log.Infof("vectodblite %d is already released", dbID)
+	}
+	err = ctl.disown(dbID)
+	return
+}
+
+// @Description Load a vectodblite which is being handed off from a node shutting down, or precreated by the leader. A handed off one is reassigned to this node by the caller afterwards.
+// @Accept  json
+// @Produce json
+// @Param   takeover		body	cluster.ReqTakeover	true 	"ReqTakeover"
+// @Success 200 {object} cluster.RspTakeover "RspTakeover"
+// @Failure 400
+// @Router /mgmt/v1/takeover [post]
+func (ctl *Controller) HandleTakeover(c *gin.Context) {
+	var reqTakeover ReqTakeover
+	var err error
+	if err = c.ShouldBind(&reqTakeover); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+	} else {
+		rspTakeover := RspTakeover{
+			DbID: reqTakeover.DbID,
+		}
+		if err = ctl.takeover(reqTakeover.DbID, reqTakeover.Samples); err != nil {
+			log.Errorf("got error %+v", err)
+			rspTakeover.Err = err.Error()
+		}
+		c.JSON(200, rspTakeover)
+	}
+}
+
+// takeover loads the vectodblite unless it's owned or has a warm standby here. The loaded one is pretrained with samples if there're any.
+func (ctl *Controller) takeover(dbID int, samples [][]float32) (err error) {
+	ctl.rwlock.RLock()
+	_, ok := ctl.dbls[dbID]
+	_, isStandby := ctl.standbys[dbID]
+	ctl.rwlock.RUnlock()
+	if ok {
+		return
+	}
+	var dblNew *vectodb.VectoDBLite
+	if !isStandby {
+		if pressure, _ := ctl.underMemPressure(); pressure {
+			err = errMemPressure
+			return
+		}
+		// vectors are in redis, loading them is enough to sync state.
+		if dblNew, err = ctl.newVectoDBLite(dbID, false); err != nil {
+			return
+		}
+		if len(samples) != 0 {
+			if err = dblNew.Pretrain(samples); err != nil {
+				dblNew.Destroy()
+				return
+			}
+		}
+	}
+	ctl.rwlock.Lock()
+	defer ctl.rwlock.Unlock()
+	if _, ok = ctl.dbls[dbID]; ok {
+		if dblNew != nil {
+			err = dblNew.Destroy()
+		}
+		return
+	}
+	if standby, ok := ctl.standbys[dbID]; ok {
+		delete(ctl.standbys, dbID)
+		if err = standby.Promote(); err != nil {
+			log.Errorf("failed to promote warm standby of vectodblite %d, loading it from redis, error %+v", dbID, err)
+			standby.Destroy()
+			err = nil
+		} else {
+			if dblNew != nil {
+				dblNew.Destroy()
+			}
+			dblNew = standby
+		}
+	}
+	if dblNew == nil {
+		// the standby is dropped meanwhile, or fails to be promoted
+		if dblNew, err = ctl.newVectoDBLite(dbID, false); err != nil {
+			return
+		}
+	}
+	ctl.dbls[dbID] = dblNew
+	log.Infof("took over vectodblite %d", dbID)
+	return
+}
+
+// @Description Sample random pairs of vectors of the given vectodblite, and return percentiles of their distances. It helps to pick the distance threshold.
+// @Produce json
+// @Param   dbID	query	int	true	"dbID. The vectodblite shall be owned by this node."
+// @Param   pairs	query	int	false	"number of pairs to sample, 1000 by default, at most 100000"
+// @Success 200 {object} cluster.RspDistribution "RspDistribution"
+// @Failure 400
+// @Router /mgmt/v1/distribution [post]
+func (ctl *Controller) HandleDistribution(c *gin.Context) {
+	var rspDist RspDistribution
+	var err error
+	if rspDist.DbID, err = strconv.Atoi(c.Query("dbID")); err != nil {
+		err = errors.Wrap(err, "invalid dbID")
+		log.Infof("failed to parse request, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	pairs := DistributionPairs
+	if pairsS := c.Query("pairs"); pairsS != "" {
+		if pairs, err = strconv.Atoi(pairsS); err != nil || pairs <= 0 {
+			err = errors.Errorf("invalid pairs %v, want > 0", pairsS)
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		pairs = vectodb.MinInt(pairs, DistributionMaxPairs)
+	}
+	ctl.rwlock.RLock()
+	dbl, ok := ctl.dbls[rspDist.DbID]
+	var distances []float32
+	if ok {
+		distances = dbl.SampleDistances(pairs)
+	}
+	ctl.rwlock.RUnlock()
+	if !ok {
+		rspDist.Err = fmt.Sprintf("vectodblite %v is not owned by this node", rspDist.DbID)
+		c.JSON(200, rspDist)
+		return
+	}
+	rspDist.Pairs = len(distances)
+	if len(distances) != 0 {
+		rspDist.Percentiles = DistributionPercentiles
+		rspDist.Distances = make([]float32, len(DistributionPercentiles))
+		for i, p := range DistributionPercentiles {
+			rspDist.Distances[i] = distances[int(p/100*float64(len(distances)-1))]
+		}
+	}
+	c.JSON(200, rspDist)
+}
+
+// Shutdown releases all vectodblites and warm standbys of this node. If HandoffOnClose is set, each one is handed off to the least loaded
+// peer before being released, so that there's no query gap during rolling restart. Otherwise its ownership is dropped. Afterwards it resigns
+// the leadership, revokes the node lease and deregisters from Eureka, so that other nodes take over at once rather than after the leases
+// expire. Data requests to vectodblites not owned by this node are rejected with 503 from the start, so that released ones aren't acquired
+// again. A step which fails doesn't skip the following ones, and the first error is returned. It gives up waiting once ctx is done.
+func (ctl *Controller) Shutdown(ctx context.Context) (err error) {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ctl.shutdown(ctx)
+	}()
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "shutdown is not completed")
+	}
+	return
+}
+
+func (ctl *Controller) shutdown(ctx context.Context) (err error) {
+	log.Infof("shutting down")
+	atomic.StoreInt32(&ctl.shuttingDown, 1)
+	keepFirst := func(err2 error) {
+		if err2 == nil {
+			return
+		}
+		log.Errorf("got error %+v", err2)
+		if err == nil {
+			err = err2
+		}
+	}
+	keepFirst(ctl.releaseAll())
+	if leaseID := ctl.getLeaseID(); leaseID != clientv3.NoLease {
+		if _, err2 := ctl.etcdCli.Revoke(ctx, leaseID); err2 != nil {
+			keepFirst(errors.Wrap(err2, ""))
+		}
+	}
+	ctl.cancel()
+	// the campaign resigns and Eureka is deregistered in background, etcd shall stay connected until then
+	for _, done := range []<-chan struct{}{ctl.electionDone, ctl.registerDone} {
+		if done != nil {
+			<-done
+		}
+	}
+	if err2 := ctl.etcdCli.Close(); err2 != nil {
+		keepFirst(errors.Wrap(err2, ""))
+	}
+	log.Infof("shut down")
+	return
+}
+
+// releaseAll releases all vectodblites and warm standbys of this node, see Shutdown. A vectodblite which fails to be released doesn't
+// stop the rest, and the first error is returned.
+func (ctl *Controller) releaseAll() (err error) {
+	ctl.rwlock.RLock()
+	dbIDs := make([]int, 0, len(ctl.dbls))
+	for dbID := range ctl.dbls {
+		dbIDs = append(dbIDs, dbID)
+	}
+	ctl.rwlock.RUnlock()
+	for _, dbID := range dbIDs {
+		if err2 := ctl.releaseOne(dbID); err2 != nil {
+			log.Errorf("failed to release vectodblite %d, error %+v", dbID, err2)
+			if err == nil {
+				err = err2
+			}
+		}
+	}
+	ctl.rwlock.Lock()
+	for dbID, standby := range ctl.standbys {
+		delete(ctl.standbys, dbID)
+		standby.Destroy()
+	}
+	ctl.rwlock.Unlock()
+	return
+}
+
+// releaseOne hands off the vectodblite if HandoffOnClose, and then releases it. It's disowned unless it's handed off.
+func (ctl *Controller) releaseOne(dbID int) (err error) {
+	if ctl.conf.HandoffOnClose {
+		if err = ctl.handoff(dbID); err != nil {
+			log.Errorf("failed to hand off vectodblite %d, error %+v", dbID, err)
+		}
+	}
+	err = ctl.release(dbID)
+	return
+}
+
+// handoff lets the least loaded peer load the vectodblite, and then reassigns the ownership to it.
+func (ctl *Controller) handoff(dbID int) (err error) {
+	var target string
+	if target, err = ctl.pickHandoffTarget(); err != nil {
+		return
+	}
+	if target == "" {
+		err = errors.Errorf("there's no peer to hand off vectodblite %d", dbID)
+		return
+	}
+	if err = ctl.requestTakeover(ctl.ctx, target, dbID, nil); err != nil {
+		return
+	}
+	k := fmt.Sprintf("%s/vectodblite/%d", ctl.conf.etcdPath(), dbID)
+	txn := ctl.etcdCli.Txn(ctl.ctx).If(clientv3.Compare(clientv3.Value(k), "=", ctl.conf.ListenAddr))
+	txn = txn.Then(clientv3.OpPut(k, target))
+	var resp *clientv3.TxnResponse
+	if resp, err = txn.Commit(); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	if !resp.Succeeded {
+		err = errors.Errorf("vectodblite %d is not owned by me", dbID)
+		return
+	}
+	log.Infof("handed off vectodblite %d to %s", dbID, target)
+	return
+}
+
+// requestTakeover asks the given node to load the vectodblite.
+func (ctl *Controller) requestTakeover(ctx context.Context, nodeAddr string, dbID int, samples [][]float32) (err error) {
+	if nodeAddr == ctl.conf.ListenAddr {
+		return ctl.takeover(dbID, samples)
+	}
+	var adminAddr string
+	if adminAddr, err = ctl.getAdminAddr(ctx, nodeAddr); err != nil {
+		return
+	}
+	reqTakeover := ReqTakeover{
+		DbID:    dbID,
+		Samples: samples,
+	}
+	rspTakeover := &RspTakeover{}
+	if err = ctl.postMgmt(ctx, fmt.Sprintf("%s://%s/mgmt/v1/takeover", ctl.conf.scheme(), adminAddr), reqTakeover, rspTakeover); err != nil {
+		return
+	} else if rspTakeover.Err != "" {
+		err = errors.New(rspTakeover.Err)
+		return
+	}
+	return
+}
+
+// pickHandoffTarget returns the least loaded alive peer, or empty if there's none.
+func (ctl *Controller) pickHandoffTarget() (target string, err error) {
+	pfx := fmt.Sprintf("%s/node", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctl.ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	var load map[string][]int
+	if load, err = ctl.getLoad(); err != nil {
+		return
+	}
+	minLoad := -1
+	for _, item := range resp.Kvs {
+		nodeAddr := filepath.Base(string(item.Key))
+		if nodeAddr == ctl.conf.ListenAddr {
+			continue
+		}
+		if minLoad < 0 || len(load[nodeAddr]) < minLoad {
+			target = nodeAddr
+			minLoad = len(load[nodeAddr])
+		}
+	}
+	return
+}
+
+// disown deletes the ownership of the vectodblite if it's owned by me, so that others could acquire it at once.
+func (ctl *Controller) disown(dbID int) (err error) {
+	k := fmt.Sprintf("%s/vectodblite/%d", ctl.conf.etcdPath(), dbID)
+	txn := ctl.etcdCli.Txn(ctl.ctx).If(clientv3.Compare(clientv3.Value(k), "=", ctl.conf.ListenAddr))
+	txn = txn.Then(clientv3.OpDelete(k))
+	if _, err = txn.Commit(); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	return
+}
+
+// @Description Eureka statusPageUrl.
+// @Produce json
+// @Success 200 {object} cluster.Status "Status"
+// @Router /status [get]
+func (ctl *Controller) HandleStatus(c *gin.Context) {
+	status := Status{
+		Status: "UP",
+	}
+	c.JSON(200, status)
+}
+
+// @Description Eureka healthCheckUrl.
+// @Produce json
+// @Success 200 {object} cluster.Health "Health"
+// @Router /health [get]
+func (ctl *Controller) HandleHealth(c *gin.Context) {
+	health := Health{
+		Description: "VectoDBLite cluster",
+		Status:      "UP",
+	}
+	health.MemPressure, health.Mem = ctl.underMemPressure()
+	health.Acquire = ctl.acquireLimiter.stat()
+	c.JSON(200, health)
+}
+
+func (ctl *Controller) servRegister() {
+	defer close(ctl.registerDone)
+	var err error
+	addrs := strings.Split(ctl.conf.EurekaAddr, ",")
+	ctl.conn = fargo.NewConn(addrs...)
+	ipPort := strings.Split(ctl.conf.ListenAddr, ":")
+	var port int
+	if port, err = strconv.Atoi(ipPort[1]); err != nil {
+		log.Fatalf("invalid listen address %v", ctl.conf.ListenAddr)
+	}
+	inst := fargo.Instance{
+		InstanceId:       ctl.conf.ListenAddr,
+		HostName:         ipPort[0], // shall not contain port
+		App:              ctl.conf.EurekaApp,
+		IPAddr:           ipPort[0],
+		VipAddress:       ctl.conf.EurekaApp,
+		SecureVipAddress: ctl.conf.EurekaApp,
+		Port:             port,
+		PortEnabled:      true,
+		Status:           "UP",
+		HomePageUrl:      fmt.Sprintf("%s://%s", ctl.conf.scheme(), ctl.conf.ListenAddr),
+		StatusPageUrl:    fmt.Sprintf("%s://%s/status", ctl.conf.scheme(), ctl.conf.ListenAddr),
+		HealthCheckUrl:   fmt.Sprintf("%s://%s/health", ctl.conf.scheme(), ctl.conf.ListenAddr),
+		DataCenterInfo: fargo.DataCenterInfo{ //required for registration
+			Name:  "MyOwn",
+			Class: "ignored",
+		},
+	}
+	defer func() {
+		if err = ctl.conn.DeregisterInstance(&inst); err != nil {
+			log.Warnf("failed to deregister with Eureka, error %+v", err)
+		}
+	}()
+
+	ticker := time.NewTicker(time.Duration(EurekaHeartbeatInterval) * time.Second)
+	for {
+		select {
+		case <-ctl.ctx.Done():
+			log.Info("servRegister goroutine exited due to context done")
+			return
+		default:
+		}
+		log.Infof("registering with Eureka %v, instance %v", ctl.conf.EurekaAddr, inst)
+		if err = ctl.conn.RegisterInstance(&inst); err != nil {
+			log.Warnf("failed to register with Eureka, error %+v", err)
+			select {
+			case <-ctl.ctx.Done():
+			case <-time.After(10 * time.Second):
+			}
+			continue
+		}
+		log.Infof("registered with Eureka %v, instance %v", ctl.conf.EurekaAddr, inst)
+	HEARTBEAT_LOOP:
+		for {
+			select {
+			case <-ctl.ctx.Done():
+				log.Info("servRegister goroutine exited due to context done")
+				return
+			case <-ticker.C:
+				if err = ctl.conn.HeartBeatInstance(&inst); err != nil {
+					log.Warnf("failed to heartbeat with Eureka, error %+v", err)
+					break HEARTBEAT_LOOP
+				}
+			}
+		}
+	}
+}
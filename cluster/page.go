@@ -0,0 +1,140 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+)
+
+// PageMaxResults is the default depth of a paged search, which is frozen at its first page.
+const PageMaxResults = 1000
+
+var errInvalidPageToken = errors.New("page token is invalid or expired")
+
+// pagedResults is the frozen result set of a paged search. Later pages are served from it even if the vectodblite changes meanwhile.
+type pagedResults struct {
+	dbID     int
+	hits     []SearchHit
+	expireAt time.Time
+}
+
+// encodePageToken encodes the position of the next page into an opaque token.
+func encodePageToken(dbID int, id uint64, offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%x:%d", dbID, id, offset)))
+}
+
+func decodePageToken(token string) (dbID int, id uint64, offset int, err error) {
+	var b []byte
+	if b, err = base64.RawURLEncoding.DecodeString(token); err != nil {
+		err = errors.Wrap(errInvalidPageToken, err.Error())
+		return
+	}
+	if _, err = fmt.Sscanf(string(b), "%d:%x:%d", &dbID, &id, &offset); err != nil || offset < 0 {
+		err = errInvalidPageToken
+		return
+	}
+	return
+}
+
+// searchPage serves a page of a paged search. The first page searches at most MinResults (PageMaxResults by default) neighbors,
+// and caches them for PageTTL seconds. Following pages are sliced from the cache with NextPageToken of the previous page.
+func (ctl *Controller) searchPage(dbl *vectodb.VectoDBLite, reqSearch *ReqSearch, rspSearch *RspSearch) (opts vectodb.SearchOptions, rsts []vectodb.SearchResult, err error) {
+	if reqSearch.PageSize <= 0 {
+		err = errors.Errorf("invalid page size %v, want > 0", reqSearch.PageSize)
+		return
+	}
+	var pr *pagedResults
+	var id uint64
+	var offset int
+	if reqSearch.PageToken != "" {
+		var dbID int
+		if dbID, id, offset, err = decodePageToken(reqSearch.PageToken); err != nil {
+			return
+		}
+		ctl.pageLock.Lock()
+		pr = ctl.pages[id]
+		ctl.pageLock.Unlock()
+		if pr == nil || pr.dbID != dbID || dbID != reqSearch.DbID || time.Now().After(pr.expireAt) {
+			err = errInvalidPageToken
+			return
+		}
+	} else {
+		opts = vectodb.SearchOptions{
+			MinResults:     reqSearch.MinResults,
+			IncludeVectors: reqSearch.IncludeVectors,
+			Weighted:       reqSearch.Weighted,
+			PenalizeWeight: ctl.conf.PenalizeWeight,
+			Nprobe:         rspSearch.Nprobe,
+		}
+		if opts.MinResults <= 0 {
+			opts.MinResults = PageMaxResults
+		}
+		if rsts, err = dbl.SearchWithOptions(reqSearch.Xq, opts); err != nil {
+			return
+		}
+		pr = &pagedResults{
+			dbID:     reqSearch.DbID,
+			hits:     make([]SearchHit, len(rsts)),
+			expireAt: time.Now().Add(time.Duration(ctl.conf.PageTTL) * time.Second),
+		}
+		for i, rst := range rsts {
+			pr.hits[i] = SearchHit{
+				Xid:      rst.Xid,
+				Distance: rst.Distance,
+				Xb:       rst.Xb,
+				Group:    rst.Group,
+				Relaxed:  rst.Relaxed,
+			}
+		}
+		id = ctl.putPages(pr)
+	}
+	end := vectodb.MinInt(offset+reqSearch.PageSize, len(pr.hits))
+	if offset < end {
+		rspSearch.Results = pr.hits[offset:end]
+		if hit := rspSearch.Results[0]; !hit.Relaxed {
+			rspSearch.Xid, rspSearch.Distance, rspSearch.Xb = hit.Xid, hit.Distance, hit.Xb
+		}
+	}
+	if end < len(pr.hits) {
+		rspSearch.NextPageToken = encodePageToken(pr.dbID, id, end)
+	}
+	return
+}
+
+// putPages caches the result set, and purges the expired ones. If there're MaxPages result sets still, the one expiring first is evicted,
+// whose following pages are rejected as expired.
+func (ctl *Controller) putPages(pr *pagedResults) (id uint64) {
+	ctl.pageLock.Lock()
+	defer ctl.pageLock.Unlock()
+	now := time.Now()
+	for id, pr := range ctl.pages {
+		if now.After(pr.expireAt) {
+			delete(ctl.pages, id)
+		}
+	}
+	for len(ctl.pages) >= ctl.conf.MaxPages {
+		var oldest *pagedResults
+		var oldestID uint64
+		for id, pr := range ctl.pages {
+			if oldest == nil || pr.expireAt.Before(oldest.expireAt) {
+				oldest, oldestID = pr, id
+			}
+		}
+		delete(ctl.pages, oldestID)
+	}
+	if ctl.pages == nil {
+		ctl.pages = make(map[uint64]*pagedResults)
+	}
+	for {
+		id = rand.Uint64()
+		if _, ok := ctl.pages[id]; !ok {
+			break
+		}
+	}
+	ctl.pages[id] = pr
+	return
+}
@@ -0,0 +1,119 @@
+package cluster
+
+import (
+	"net/http"
+	"path/filepath"
+	"sort"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+type ReqPrecreate struct {
+	DbIDs   []int       `json:"dbIDs"`
+	Samples [][]float32 `json:"samples"` // pretrain samples of indexes which require training, optional
+}
+
+type RspPrecreate struct {
+	DbIDs     []int    `json:"dbIDs"`
+	NodeAddrs []string `json:"nodeAddrs"` // owner of each vectodblite, in the order of dbIDs
+	Err       string   `json:"err"`
+}
+
+// @Description Place the given vectodblites on the least loaded nodes and load them ahead of traffic, so that the first requests don't pay for acquiring and loading.
+// @Description Indexes which require training are pretrained with samples if there're not enough vectors yet. Only the leader node supports this API.
+// @Accept  json
+// @Produce json
+// @Param   precreate		body	cluster.ReqPrecreate	true 	"ReqPrecreate"
+// @Success 200 {object} cluster.RspPrecreate "RspPrecreate. Already owned vectodblites are kept at their owners."
+// @Failure 307 "redirection"
+// @Failure 400
+// @Router /mgmt/v1/precreate [post]
+func (ctl *Controller) HandlePrecreate(c *gin.Context) {
+	var reqPrecreate ReqPrecreate
+	var err error
+	if err = c.ShouldBind(&reqPrecreate); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, xb := range reqPrecreate.Samples {
+		if len(xb) != ctl.conf.Dim {
+			err = errors.Errorf("invalid length of sample, want %v, have %v", ctl.conf.Dim, len(xb))
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if !ctl.isLeader && ctl.curLeader != "" {
+		var adminAddr string
+		if adminAddr, err = ctl.getAdminAddr(c.Request.Context(), ctl.curLeader); err != nil {
+			log.Errorf("got error %+v", err)
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		dstURL := *c.Request.URL
+		dstURL.Host = adminAddr
+		c.Redirect(http.StatusTemporaryRedirect, dstURL.String())
+		return
+	}
+	rspPrecreate := RspPrecreate{
+		DbIDs: reqPrecreate.DbIDs,
+	}
+	if rspPrecreate.NodeAddrs, err = ctl.precreate(c.Request.Context(), reqPrecreate.DbIDs, reqPrecreate.Samples); err != nil {
+		rspPrecreate.Err = err.Error()
+		log.Errorf("got error %+v", err)
+	}
+	c.JSON(200, rspPrecreate)
+}
+
+// precreate acquires each vectodblite for the least loaded alive node, and lets the owner load it.
+func (ctl *Controller) precreate(ctx context.Context, dbIDs []int, samples [][]float32) (nodeAddrs []string, err error) {
+	if !ctl.isLeader {
+		err = errors.Errorf("not capable to precreate since I'm not the leader")
+		return
+	}
+	pfx := ctl.conf.etcdPath() + "/node"
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	alive := make([]string, 0, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		alive = append(alive, filepath.Base(string(item.Key)))
+	}
+	if len(alive) == 0 {
+		err = errors.Errorf("there's no alive node")
+		return
+	}
+	sort.Strings(alive)
+	var load map[string][]int
+	if load, err = ctl.getLoad(); err != nil {
+		return
+	}
+	for _, dbID := range dbIDs {
+		target := alive[0]
+		for _, nodeAddr := range alive[1:] {
+			if len(load[nodeAddr]) < len(load[target]) {
+				target = nodeAddr
+			}
+		}
+		var owner string
+		if owner, err = ctl.acquireOnce(ctx, dbID, target); err != nil {
+			return
+		}
+		if owner == target {
+			load[target] = append(load[target], dbID)
+		}
+		if err = ctl.requestTakeover(ctx, owner, dbID, samples); err != nil {
+			return
+		}
+		nodeAddrs = append(nodeAddrs, owner)
+		log.Infof("precreated vectodblite %d at %s", dbID, owner)
+	}
+	return
+}
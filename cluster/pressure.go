@@ -0,0 +1,106 @@
+package cluster
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// A node under memory pressure publishes etcd key <etcdPath>/pressure/<nodeAddr>, which is bound to the node lease,
+// and deletes it once the pressure is gone. The leader doesn't place acquired vectodblites onto pressured nodes,
+// so that a pressured node redirects requests of vectodblites it doesn't own rather than rejecting them.
+
+// PressureInterval is how often a node checks its memory pressure and publishes the change.
+const PressureInterval = 5 * time.Second
+
+func (ctl *Controller) pressureKey(nodeAddr string) string {
+	return fmt.Sprintf("%s/pressure/%s", ctl.conf.etcdPath(), nodeAddr)
+}
+
+// servPressure publishes the memory pressure of this node whenever it changes. A failed publish is retried at the next check.
+func (ctl *Controller) servPressure() {
+	ticker := time.NewTicker(PressureInterval)
+	defer ticker.Stop()
+	var published bool
+	for {
+		select {
+		case <-ctl.ctx.Done():
+			return
+		case <-ticker.C:
+			pressure, ms := ctl.underMemPressure()
+			if pressure == published {
+				continue
+			}
+			// a key put without lease would outlive this node, retry at the next check
+			leaseID := ctl.getLeaseID()
+			if leaseID == clientv3.NoLease {
+				continue
+			}
+			var err error
+			k := ctl.pressureKey(ctl.conf.ListenAddr)
+			if pressure {
+				_, err = ctl.etcdCli.Put(ctl.ctx, k, fmt.Sprintf("%d", ms.RSS), clientv3.WithLease(leaseID))
+			} else {
+				_, err = ctl.etcdCli.Delete(ctl.ctx, k)
+			}
+			if err != nil {
+				log.Errorf("failed to publish memory pressure %v, error %+v", pressure, errors.Wrap(err, ""))
+				continue
+			}
+			published = pressure
+			log.Infof("published memory pressure %v, %+v", pressure, ms)
+		}
+	}
+}
+
+// getPressured returns the nodes which published memory pressure.
+func (ctl *Controller) getPressured(ctx context.Context) (pressured map[string]bool, err error) {
+	pfx := fmt.Sprintf("%s/pressure/", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, pfx, clientv3.WithPrefix(), clientv3.WithKeysOnly()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	pressured = make(map[string]bool, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		pressured[filepath.Base(string(item.Key))] = true
+	}
+	return
+}
+
+// avoidPressure returns nodeAddr if it isn't pressured, otherwise the least loaded alive node which isn't pressured.
+// nodeAddr is kept if all nodes are pressured.
+func (ctl *Controller) avoidPressure(ctx context.Context, nodeAddr string) (target string, err error) {
+	target = nodeAddr
+	var pressured map[string]bool
+	if pressured, err = ctl.getPressured(ctx); err != nil || !pressured[nodeAddr] {
+		return
+	}
+	var aliveNodes map[string]int
+	if aliveNodes, err = ctl.getAliveNodes(ctx); err != nil {
+		return
+	}
+	var load map[string][]int
+	if load, err = ctl.getLoad(); err != nil {
+		return
+	}
+	minLoad := -1
+	for addr := range aliveNodes {
+		if pressured[addr] {
+			continue
+		}
+		if minLoad < 0 || len(load[addr]) < minLoad || (len(load[addr]) == minLoad && addr < target) {
+			target = addr
+			minLoad = len(load[addr])
+		}
+	}
+	if target != nodeAddr {
+		log.Infof("placed vectodblite requested by %s at %s since the former is under memory pressure", nodeAddr, target)
+	}
+	return
+}
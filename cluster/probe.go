@@ -0,0 +1,60 @@
+package cluster
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// ReadyTimeout bounds each dependency check of /readyz.
+const ReadyTimeout = 2 * time.Second
+
+// @Description Liveness probe. It succeeds as long as the process serves HTTP.
+// @Produce plain
+// @Success 200 {string} string "ok"
+// @Router /healthz [get]
+func (ctl *Controller) HandleHealthz(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+// @Description Readiness probe. It fails until etcd is connected, the leader is known or this node is the leader, and redis is reachable.
+// @Produce plain
+// @Success 200 {string} string "ok"
+// @Failure 503 "the reason of being not ready"
+// @Router /readyz [get]
+func (ctl *Controller) HandleReadyz(c *gin.Context) {
+	if err := ctl.checkReady(c.Request.Context()); err != nil {
+		log.Debugf("not ready, error %+v", err)
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "ok")
+}
+
+// checkReady returns the first dependency which is not ready.
+func (ctl *Controller) checkReady(ctx context.Context) (err error) {
+	if ctl.etcdCli == nil {
+		err = errors.New("etcd client is not initialized")
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, ReadyTimeout)
+	defer cancel()
+	if _, err = ctl.etcdCli.Get(ctx, ctl.conf.etcdPath(), clientv3.WithCountOnly()); err != nil {
+		err = errors.Wrap(err, "etcd is unreachable")
+		return
+	}
+	if !ctl.isLeader && ctl.curLeader == "" {
+		err = errors.New("the leader is unknown")
+		return
+	}
+	if err = ctl.rcli.Ping().Err(); err != nil {
+		err = errors.Wrap(err, "redis is unreachable")
+		return
+	}
+	return
+}
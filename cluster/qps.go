@@ -0,0 +1,115 @@
+package cluster
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// Each node publishes its recent QPS of data requests as etcd key <etcdPath>/qps/<nodeAddr>, which is bound to the node lease.
+// If QPSWeight is set, the leader places acquired vectodblites by the score len(load)+QPSWeight*QPS of nodes,
+// so that new vectodblites don't pile up onto busy nodes.
+
+func (ctl *Controller) qpsKey(nodeAddr string) string {
+	return fmt.Sprintf("%s/qps/%s", ctl.conf.etcdPath(), nodeAddr)
+}
+
+// servQPS publishes the QPS of this node every QPSInterval.
+func (ctl *Controller) servQPS() {
+	interval := time.Duration(ctl.conf.QPSInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prev := atomic.LoadInt64(&ctl.numRequests)
+	for {
+		select {
+		case <-ctl.ctx.Done():
+			return
+		case <-ticker.C:
+			cur := atomic.LoadInt64(&ctl.numRequests)
+			qps := float64(cur-prev) / interval.Seconds()
+			prev = cur
+			// a key put without lease would outlive this node
+			leaseID := ctl.getLeaseID()
+			if leaseID == clientv3.NoLease {
+				continue
+			}
+			val := strconv.FormatFloat(qps, 'f', 2, 64)
+			if _, err := ctl.etcdCli.Put(ctl.ctx, ctl.qpsKey(ctl.conf.ListenAddr), val, clientv3.WithLease(leaseID)); err != nil {
+				log.Errorf("failed to publish qps, error %+v", errors.Wrap(err, ""))
+			}
+		}
+	}
+}
+
+// getQPS returns the QPS published by nodes. A node which hasn't published yet is absent.
+func (ctl *Controller) getQPS(ctx context.Context) (qps map[string]float64, err error) {
+	pfx := fmt.Sprintf("%s/qps/", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	qps = make(map[string]float64, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		var val float64
+		if val, err = strconv.ParseFloat(string(item.Value), 64); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+		qps[filepath.Base(string(item.Key))] = val
+	}
+	return
+}
+
+// placeByQPS returns the node which shall own a vectodblite requested by nodeAddr. Nodes within MaxLoadDelta of the least loaded one
+// have free capacity, and the one of the lowest score among them is picked. nodeAddr is kept on ties.
+func (ctl *Controller) placeByQPS(ctx context.Context, nodeAddr string) (target string, err error) {
+	target = nodeAddr
+	pfx := fmt.Sprintf("%s/node", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	var load map[string][]int
+	if load, err = ctl.getLoad(); err != nil {
+		return
+	}
+	var qps map[string]float64
+	if qps, err = ctl.getQPS(ctx); err != nil {
+		return
+	}
+	alive := make([]string, 0, len(resp.Kvs))
+	minLoad := -1
+	for _, item := range resp.Kvs {
+		addr := filepath.Base(string(item.Key))
+		alive = append(alive, addr)
+		if minLoad < 0 || len(load[addr]) < minLoad {
+			minLoad = len(load[addr])
+		}
+	}
+	score := func(addr string) float64 {
+		return float64(len(load[addr])) + ctl.conf.QPSWeight*qps[addr]
+	}
+	minScore := score(nodeAddr)
+	for _, addr := range alive {
+		if len(load[addr]) > minLoad+MaxLoadDelta {
+			continue
+		}
+		if s := score(addr); s < minScore {
+			target = addr
+			minScore = s
+		}
+	}
+	if target != nodeAddr {
+		log.Infof("placed vectodblite requested by %s (qps %v) at %s (qps %v)", nodeAddr, qps[nodeAddr], target, qps[target])
+	}
+	return
+}
@@ -0,0 +1,175 @@
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// ClientSweepInterval is how often idle buckets of clientLimiter are dropped.
+const ClientSweepInterval = time.Minute
+
+// PeerRefreshInterval is how often the IPs of the nodes of the cluster are refreshed, see rateLimit.
+const PeerRefreshInterval = 10 * time.Second
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// clientLimiter is a token bucket per client, so that a misbehaving client can't saturate the search threads of a node.
+// Each bucket is refilled at rate tokens per second up to burst.
+type clientLimiter struct {
+	rate      float64
+	burst     float64
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newClientLimiter returns nil if rate is 0, which is unlimited.
+func newClientLimiter(rate float64, burst int) *clientLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &clientLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token of the client. If the bucket is empty, it returns how long until the next token.
+func (l *clientLimiter) allow(client string, now time.Time) (ok bool, retryAfter time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if now.Sub(l.lastSweep) >= ClientSweepInterval {
+		l.sweep(now)
+	}
+	b, found := l.buckets[client]
+	if !found {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	retryAfter = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return
+}
+
+// sweep drops the buckets which have been refilled to burst, since they're the same as new ones. Assumes lock is held.
+func (l *clientLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimit is a middleware of data endpoints which limits requests per client at ClientRate.
+// A client is identified by its API key, or by the IP of the connection if it carries none. X-Forwarded-For isn't trusted
+// since any client could set it. Requests of nodes, i.e. forwarded to sub-shards, aren't limited since the client has been
+// limited by the node forwarding them. They're recognized by a mgmt key if MgmtKeys is configured, otherwise by the IP.
+func (ctl *Controller) rateLimit(c *gin.Context) {
+	client := requestKey(c)
+	if len(ctl.conf.MgmtKeys) != 0 {
+		if client != "" && matchKey(ctl.conf.MgmtKeys, client) {
+			c.Next()
+			return
+		}
+	} else if ctl.isPeer(remoteIP(c.Request)) {
+		c.Next()
+		return
+	}
+	if client == "" || !matchKey(ctl.conf.APIKeys, client) {
+		client = remoteIP(c.Request)
+	}
+	ok, retryAfter := ctl.clientLimiter.allow(client, time.Now())
+	if !ok {
+		secs := int(math.Ceil(retryAfter.Seconds()))
+		log.Debugf("rate limited %s from %s, retry after %ds", c.Request.URL.Path, remoteIP(c.Request), secs)
+		c.Header("Retry-After", strconv.Itoa(secs))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"err": "too many requests, retry later"})
+		return
+	}
+	c.Next()
+}
+
+// remoteIP returns the IP of the connection of the request.
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// isPeer returns true if the IP is a loopback one, or one of the nodes of the cluster.
+func (ctl *Controller) isPeer(ip string) bool {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.IsLoopback() {
+		return true
+	}
+	ctl.peerLock.RLock()
+	defer ctl.peerLock.RUnlock()
+	return ctl.peerIPs[ip]
+}
+
+// servPeers keeps the IPs of the nodes of the cluster in sync with the node keys in etcd, for rateLimit without MgmtKeys.
+func (ctl *Controller) servPeers(ctx context.Context) {
+	ticker := time.NewTicker(PeerRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := ctl.refreshPeers(ctx); err != nil {
+			log.Errorf("failed to refresh peers, error %+v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ctl *Controller) refreshPeers(ctx context.Context) (err error) {
+	pfx := fmt.Sprintf("%s/node", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	peerIPs := make(map[string]bool, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		host, _, err2 := net.SplitHostPort(filepath.Base(string(item.Key)))
+		if err2 != nil {
+			continue
+		}
+		if ips, err2 := net.LookupHost(host); err2 == nil {
+			for _, ip := range ips {
+				peerIPs[ip] = true
+			}
+		}
+	}
+	ctl.peerLock.Lock()
+	ctl.peerIPs = peerIPs
+	ctl.peerLock.Unlock()
+	return
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type RspReadOnly struct {
+	DbID    int    `json:"dbID"`
+	Enabled bool   `json:"enabled"`
+	Err     string `json:"err"`
+}
+
+// @Description Set whether the given vectodblite is read-only, i.e. during maintenance. Adds and deletes fail with "vectodblite is read-only" while searches are served.
+// @Description The flag is persisted in redis, so that it survives reloading the vectodblite.
+// @Produce json
+// @Param   dbID	query	int	true	"dbID. The vectodblite shall be owned by this node."
+// @Param   enabled	query	bool	true	"whether it's read-only"
+// @Success 200 {object} cluster.RspReadOnly "RspReadOnly"
+// @Failure 400
+// @Router /mgmt/v1/readonly [post]
+func (ctl *Controller) HandleReadOnly(c *gin.Context) {
+	var rspReadOnly RspReadOnly
+	var err error
+	if rspReadOnly.DbID, err = strconv.Atoi(c.Query("dbID")); err != nil {
+		err = errors.Wrap(err, "invalid dbID")
+		log.Infof("failed to parse request, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if rspReadOnly.Enabled, err = strconv.ParseBool(c.Query("enabled")); err != nil {
+		err = errors.Wrap(err, "invalid enabled")
+		log.Infof("failed to parse request, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctl.rwlock.RLock()
+	dbl, ok := ctl.dbls[rspReadOnly.DbID]
+	if ok {
+		err = dbl.SetReadOnly(rspReadOnly.Enabled)
+	}
+	ctl.rwlock.RUnlock()
+	if !ok {
+		rspReadOnly.Err = fmt.Sprintf("vectodblite %v is not owned by this node", rspReadOnly.DbID)
+	} else if err != nil {
+		rspReadOnly.Err = err.Error()
+		log.Errorf("got error %+v", err)
+	}
+	c.JSON(200, rspReadOnly)
+}
@@ -0,0 +1,244 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// DbMove moves the ownership of a vectodblite from a node to another.
+type DbMove struct {
+	DbID int    `json:"dbID"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type RspAssignment struct {
+	Target map[string][]int `json:"target"` // dbIDs which each alive node shall own
+	Moves  []DbMove         `json:"moves"`  // moves from the current ownership to the target
+	Err    string           `json:"err"`
+}
+
+// planAssignment returns the target ownership of the alive nodes, and the moves to reach it. Vectodblites of dead nodes are left to purgeDeadNodes.
+// Each node owns either floor or ceil of the average number of vectodblites, and the nodes which own the most get the ceil ones,
+// so the moves are minimal: a node joining takes about average vectodblites from the others, and the rest stay where they are.
+// Nothing moves if the loads are within MaxLoadDelta of each other.
+func planAssignment(load map[string][]int, aliveNodes map[string]int) (target map[string][]int, moves []DbMove) {
+	target = make(map[string][]int, len(aliveNodes))
+	nodes := make([]string, 0, len(aliveNodes))
+	total := 0
+	for nodeAddr := range aliveNodes {
+		nodes = append(nodes, nodeAddr)
+		dbList := append([]int(nil), load[nodeAddr]...)
+		sort.Ints(dbList)
+		target[nodeAddr] = dbList
+		total += len(dbList)
+	}
+	if len(nodes) < 2 {
+		return
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if len(target[nodes[i]]) != len(target[nodes[j]]) {
+			return len(target[nodes[i]]) > len(target[nodes[j]])
+		}
+		return nodes[i] < nodes[j]
+	})
+	if len(target[nodes[0]])-len(target[nodes[len(nodes)-1]]) <= MaxLoadDelta {
+		return
+	}
+	quota := make(map[string]int, len(nodes))
+	var surplus []DbMove
+	for i, nodeAddr := range nodes {
+		quota[nodeAddr] = total / len(nodes)
+		if i < total%len(nodes) {
+			quota[nodeAddr]++
+		}
+		// keep the smallest dbIDs, so that the plan is deterministic
+		if dbList := target[nodeAddr]; len(dbList) > quota[nodeAddr] {
+			for _, dbID := range dbList[quota[nodeAddr]:] {
+				surplus = append(surplus, DbMove{DbID: dbID, From: nodeAddr})
+			}
+			target[nodeAddr] = dbList[:quota[nodeAddr]]
+		}
+	}
+	for _, nodeAddr := range nodes {
+		for len(target[nodeAddr]) < quota[nodeAddr] && len(surplus) != 0 {
+			move := surplus[0]
+			surplus = surplus[1:]
+			move.To = nodeAddr
+			target[nodeAddr] = append(target[nodeAddr], move.DbID)
+			moves = append(moves, move)
+		}
+	}
+	return
+}
+
+// planRingAssignment is the same as planAssignment except that the target owner of each vectodblite is given by the hash ring.
+// Nothing moves if the ring is nil, i.e. stale.
+func planRingAssignment(load map[string][]int, aliveNodes map[string]int, ring *hashRing) (target map[string][]int, moves []DbMove) {
+	target = make(map[string][]int, len(aliveNodes))
+	nodes := make([]string, 0, len(aliveNodes))
+	for nodeAddr := range aliveNodes {
+		nodes = append(nodes, nodeAddr)
+		target[nodeAddr] = []int{}
+	}
+	sort.Strings(nodes)
+	for _, nodeAddr := range nodes {
+		dbList := append([]int(nil), load[nodeAddr]...)
+		sort.Ints(dbList)
+		for _, dbID := range dbList {
+			owner := nodeAddr
+			if ring != nil {
+				if ringOwner := ring.owner(dbID); ringOwner != nodeAddr {
+					if _, ok := aliveNodes[ringOwner]; ok {
+						owner = ringOwner
+						moves = append(moves, DbMove{DbID: dbID, From: nodeAddr, To: owner})
+					}
+				}
+			}
+			target[owner] = append(target[owner], dbID)
+		}
+	}
+	return
+}
+
+// plan returns the target ownership and the moves to reach it, by the hash ring if HashPlacement, otherwise by even counts.
+func (ctl *Controller) plan(load map[string][]int, aliveNodes map[string]int) (target map[string][]int, moves []DbMove) {
+	if ctl.conf.HashPlacement {
+		return planRingAssignment(load, aliveNodes, ctl.getRing())
+	}
+	return planAssignment(load, aliveNodes)
+}
+
+// getAliveNodes returns the nodes whose node keys are present.
+func (ctl *Controller) getAliveNodes(ctx context.Context) (aliveNodes map[string]int, err error) {
+	pfx := fmt.Sprintf("%s/node", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	aliveNodes = make(map[string]int, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		aliveNodes[filepath.Base(string(item.Key))] = 0
+	}
+	return
+}
+
+// rebalance moves vectodblites toward the target of plan. load is updated with the moves done.
+func (ctl *Controller) rebalance(ctx context.Context, load map[string][]int, aliveNodes map[string]int) (err error) {
+	_, moves := ctl.plan(load, aliveNodes)
+	if len(moves) == 0 {
+		log.Debugf("skipped rebalancing since the load is already at the target")
+		return
+	}
+	log.Infof("rebalancing %d vectodblites", len(moves))
+	for _, move := range moves {
+		if err = ctl.moveDb(ctx, move); err != nil {
+			return
+		}
+		load[move.From] = removeDbID(load[move.From], move.DbID)
+		load[move.To] = append(load[move.To], move.DbID)
+	}
+	return
+}
+
+func removeDbID(dbList []int, dbID int) []int {
+	for i, id := range dbList {
+		if id == dbID {
+			return append(dbList[:i], dbList[i+1:]...)
+		}
+	}
+	return dbList
+}
+
+// moveDb lets the destination load the vectodblite, reassigns the ownership to it, and then tells the source to release it,
+// so that there's no query gap, the same as handoff.
+func (ctl *Controller) moveDb(ctx context.Context, move DbMove) (err error) {
+	if err = ctl.requestTakeover(ctx, move.To, move.DbID, nil); err != nil {
+		return
+	}
+	k := fmt.Sprintf("%s/vectodblite/%d", ctl.conf.etcdPath(), move.DbID)
+	txn := ctl.etcdCli.Txn(ctx).If(clientv3.Compare(clientv3.Value(k), "=", move.From))
+	txn = txn.Then(clientv3.OpPut(k, move.To))
+	var resp *clientv3.TxnResponse
+	if resp, err = txn.Commit(); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	if !resp.Succeeded {
+		// the ownership changed meanwhile, don't leave a copy at the destination
+		log.Infof("skipped moving vectodblite %d from %s to %s since it's not owned by the former anymore", move.DbID, move.From, move.To)
+		err = ctl.requestRelease(ctx, move.To, move.DbID)
+		return
+	}
+	// the source doesn't disown it since it's owned by the destination now
+	if err = ctl.requestRelease(ctx, move.From, move.DbID); err != nil {
+		return
+	}
+	log.Infof("moved vectodblite %d from %s to %s", move.DbID, move.From, move.To)
+	return
+}
+
+// requestRelease asks the given node to release the vectodblite.
+func (ctl *Controller) requestRelease(ctx context.Context, nodeAddr string, dbID int) (err error) {
+	if nodeAddr == ctl.conf.ListenAddr {
+		return ctl.release(dbID)
+	}
+	var adminAddr string
+	if adminAddr, err = ctl.getAdminAddr(ctx, nodeAddr); err != nil {
+		return
+	}
+	reqRelease := ReqRelease{
+		DbID: dbID,
+	}
+	rspRelease := &RspRelease{}
+	if err = ctl.postMgmt(ctx, fmt.Sprintf("%s://%s/mgmt/v1/release", ctl.conf.scheme(), adminAddr), reqRelease, rspRelease); err != nil {
+		return
+	} else if rspRelease.Err != "" {
+		err = errors.New(rspRelease.Err)
+		return
+	}
+	return
+}
+
+// @Description Get the target ownership of the rebalancer, and the moves to reach it. Only the leader node supports this API.
+// @Produce json
+// @Success 200 {object} cluster.RspAssignment "RspAssignment"
+// @Failure 307 "redirection"
+// @Router /mgmt/v1/assignment [get]
+func (ctl *Controller) HandleAssignment(c *gin.Context) {
+	if !ctl.isLeader && ctl.curLeader != "" {
+		adminAddr, err := ctl.getAdminAddr(c.Request.Context(), ctl.curLeader)
+		if err != nil {
+			log.Errorf("got error %+v", err)
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		dstURL := *c.Request.URL
+		dstURL.Host = adminAddr
+		c.Redirect(http.StatusTemporaryRedirect, dstURL.String())
+		return
+	}
+	var rspAssignment RspAssignment
+	var err error
+	var load map[string][]int
+	var aliveNodes map[string]int
+	if aliveNodes, err = ctl.getAliveNodes(c.Request.Context()); err == nil {
+		if load, err = ctl.getLoad(); err == nil {
+			rspAssignment.Target, rspAssignment.Moves = ctl.plan(load, aliveNodes)
+		}
+	}
+	if err != nil {
+		rspAssignment.Err = err.Error()
+		log.Errorf("got error %+v", err)
+	}
+	c.JSON(200, rspAssignment)
+}
@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type ReqSearchRecent struct {
+	DbID   int       `json:"dbID"`
+	Xq     []float32 `json:"xq"`
+	Window int       `json:"window"`
+	TopK   int       `json:"topK"`
+}
+
+type RspSearchRecent struct {
+	Results []SearchHit `json:"results"`
+	Err     string      `json:"err"`
+}
+
+// @Description Search among the latest vectors added to the given vectodblite by brute force. It requires RecentSize to be set.
+// @Description Only the vectors added since the current node acquired the vectodblite are searched, and window is capped by RecentSize.
+// @Accept  json
+// @Produce  json
+// @Param   searchRecent	body	cluster.ReqSearchRecent	true 	"ReqSearchRecent"
+// @Success 200 {object} cluster.RspSearchRecent "RspSearchRecent. Results are in descending order of distance, the ones beyond the distance threshold are flagged relaxed."
+// @Failure 307 "redirection"
+// @Failure 400
+// @Failure 503 "memory pressure or draining"
+// @Router /api/v1/search_recent [post]
+func (ctl *Controller) HandleSearchRecent(c *gin.Context) {
+	var reqSearchRecent ReqSearchRecent
+	var err error
+	if err = c.ShouldBind(&reqSearchRecent); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	} else if err = ctl.conf.validateQuery(reqSearchRecent.Xq); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	var rspSearchRecent RspSearchRecent
+	var dbl *vectodb.VectoDBLite
+	ctl.rwlock.RLock()
+	defer ctl.rwlock.RUnlock()
+	if dbl, err = ctl.getVectoDBLite(c, reqSearchRecent.DbID); isUnavailable(err) {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		rspSearchRecent.Err = err.Error()
+		log.Errorf("got error %+v", err)
+		c.JSON(200, rspSearchRecent)
+		return
+	} else if dbl == nil {
+		//already return a response
+		return
+	}
+	var rsts []vectodb.SearchResult
+	if rsts, err = dbl.SearchRecent(reqSearchRecent.Xq, reqSearchRecent.Window, reqSearchRecent.TopK); err != nil {
+		rspSearchRecent.Err = err.Error()
+		log.Errorf("got error %+v", err)
+		c.JSON(200, rspSearchRecent)
+		return
+	}
+	rspSearchRecent.Results = make([]SearchHit, len(rsts))
+	for i, rst := range rsts {
+		rspSearchRecent.Results[i] = SearchHit{
+			Xid:      rst.Xid,
+			Distance: rst.Distance,
+			Group:    rst.Group,
+			Relaxed:  rst.Relaxed,
+		}
+	}
+	c.JSON(200, rspSearchRecent)
+}
@@ -0,0 +1,131 @@
+package cluster
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cespare/xxhash"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// RingReplicas is the number of virtual nodes per node on the hash ring, which smooths the share of each node.
+const RingReplicas = 100
+
+// hashRing places dbIDs on nodes by consistent hashing, so that a node joining or leaving only moves about 1/n of dbIDs.
+type hashRing struct {
+	points []uint64          // hashes of virtual nodes in ascending order
+	nodes  map[uint64]string // virtual node hash -> node address
+}
+
+func newHashRing(nodeAddrs []string) (ring *hashRing) {
+	ring = &hashRing{
+		points: make([]uint64, 0, len(nodeAddrs)*RingReplicas),
+		nodes:  make(map[uint64]string, len(nodeAddrs)*RingReplicas),
+	}
+	for _, nodeAddr := range nodeAddrs {
+		for i := 0; i < RingReplicas; i++ {
+			h := xxhash.Sum64String(fmt.Sprintf("%s#%d", nodeAddr, i))
+			// a collision is resolved by the address, so that every node builds the same ring
+			if prev, ok := ring.nodes[h]; ok && prev < nodeAddr {
+				continue
+			} else if !ok {
+				ring.points = append(ring.points, h)
+			}
+			ring.nodes[h] = nodeAddr
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return
+}
+
+// owner returns the node which the dbID is placed on, or empty if the ring has no node.
+func (ring *hashRing) owner(dbID int) string {
+	if len(ring.points) == 0 {
+		return ""
+	}
+	h := xxhash.Sum64String(strconv.Itoa(dbID))
+	i := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= h })
+	if i == len(ring.points) {
+		i = 0
+	}
+	return ring.nodes[ring.points[i]]
+}
+
+// getRing returns the hash ring of alive nodes, or nil if HashPlacement is off or the ring is stale.
+func (ctl *Controller) getRing() *hashRing {
+	ctl.ringLock.RLock()
+	defer ctl.ringLock.RUnlock()
+	return ctl.ring
+}
+
+func (ctl *Controller) setRing(ring *hashRing) {
+	ctl.ringLock.Lock()
+	ctl.ring = ring
+	ctl.ringLock.Unlock()
+}
+
+// servRing keeps the hash ring in sync with the node keys in etcd, which are the member list of the cluster.
+// The ring is dropped while the watch is broken, so that placement falls back to acquiring via the leader.
+func (ctl *Controller) servRing(ctx context.Context) {
+	for {
+		if err := ctl.watchRing(ctx); err != nil {
+			log.Errorf("hash ring is stale, error %+v", err)
+		}
+		ctl.setRing(nil)
+		select {
+		case <-ctx.Done():
+			log.Info("servRing goroutine exited due to context done")
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (ctl *Controller) watchRing(ctx context.Context) (err error) {
+	pfx := fmt.Sprintf("%s/node", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	members := make(map[string]bool, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		members[filepath.Base(string(item.Key))] = true
+	}
+	ctl.setRing(newHashRingOf(members))
+	watchCh := ctl.etcdCli.Watch(ctx, pfx, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	for wr := range watchCh {
+		if err = wr.Err(); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+		for _, e := range wr.Events {
+			nodeAddr := filepath.Base(string(e.Kv.Key))
+			if e.Type == clientv3.EventTypeDelete {
+				delete(members, nodeAddr)
+			} else {
+				members[nodeAddr] = true
+			}
+		}
+		ctl.setRing(newHashRingOf(members))
+		log.Infof("hash ring changed, members %v", len(members))
+	}
+	if ctx.Err() == nil {
+		err = errors.New("watch of node keys is closed")
+	}
+	return
+}
+
+func newHashRingOf(members map[string]bool) *hashRing {
+	nodeAddrs := make([]string, 0, len(members))
+	for nodeAddr := range members {
+		nodeAddrs = append(nodeAddrs, nodeAddr)
+	}
+	return newHashRing(nodeAddrs)
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"net/http"
+	_ "net/http/pprof" // registers /debug/pprof handlers to http.DefaultServeMux
+
+	"github.com/gin-gonic/gin"
+	ginSwagger "github.com/swaggo/gin-swagger"                // gin-swagger middleware
+	swaggerFiles "github.com/swaggo/gin-swagger/swaggerFiles" // swagger embed files
+)
+
+// SetupRouters registers data endpoints to r, and mgmt and debug endpoints to admin. They could be the same engine.
+// Debug endpoints are registered only if AdminAddr is set.
+func SetupRouters(ctl *Controller, r, admin *gin.Engine) {
+	api := r.Group("/api/v1", ctl.instrument, ctl.authAPI, ctl.rateLimit, ctl.backpressure, ctl.injectFault)
+	api.POST("/add", ctl.HandleAdd)
+	api.POST("/batch_add", ctl.HandleBatchAdd)
+	api.POST("/search", ctl.HandleSearch)
+	api.POST("/batch_search", ctl.HandleBatchSearch)
+	api.POST("/ingest", ctl.HandleIngest)
+	api.POST("/contains", ctl.HandleContains)
+	api.POST("/delete", ctl.HandleDelete)
+	api.POST("/delete_ids", ctl.HandleDeleteIds)
+	api.POST("/search_intersect", ctl.HandleSearchIntersect)
+	api.POST("/search_recent", ctl.HandleSearchRecent)
+	r.GET("/status", ctl.HandleStatus)
+	r.GET("/health", ctl.HandleHealth)
+	r.GET("/healthz", ctl.HandleHealthz)
+	r.GET("/readyz", ctl.HandleReadyz)
+	r.GET("/metrics", ctl.metricsHandler())
+	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+
+	mgmt := admin.Group("/mgmt/v1", ctl.authMgmt)
+	mgmt.POST("/acquire", ctl.HandleAcquire)
+	mgmt.POST("/precreate", ctl.HandlePrecreate)
+	mgmt.POST("/release", ctl.HandleRelease)
+	mgmt.GET("/assignment", ctl.HandleAssignment)
+	mgmt.GET("/leader", ctl.HandleLeader)
+	mgmt.GET("/owned", ctl.HandleOwned)
+	mgmt.POST("/split", ctl.HandleSplit)
+	mgmt.POST("/takeover", ctl.HandleTakeover)
+	mgmt.POST("/distribution", ctl.HandleDistribution)
+	mgmt.POST("/drain", ctl.HandleDrain)
+	mgmt.POST("/standby", ctl.HandleStandby)
+	mgmt.POST("/readonly", ctl.HandleReadOnly)
+	mgmt.GET("/config", ctl.HandleConfig)
+	mgmt.GET("/export", ctl.HandleExport)
+	mgmt.POST("/import", ctl.HandleImport)
+	mgmt.GET("/fault_injection", ctl.HandleFaultInjection)
+	mgmt.PUT("/fault_injection", ctl.HandleFaultInjection)
+	// profiles are served only on a dedicated admin address, which could be firewalled apart from data traffic
+	if ctl.conf.AdminAddr != "" {
+		admin.GET("/debug/pprof/*any", ctl.authMgmt, gin.WrapH(http.DefaultServeMux))
+	}
+}
@@ -0,0 +1,520 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gin-gonic/gin"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// A vectodblite which grows to SplitThreshold vectors is split into two by xid range. The leader allocates a sub-shard,
+// places it at another node, and records the split as etcd key <etcdPath>/split/<dbID>. The owner migrates the vectors
+// whose xids are at least the pivot to the sub-shard via the export and import path. Afterwards adds and deletes of such
+// xids are forwarded to the sub-shard, and searches fan out to it except paged ones, whose first pages are rejected.
+// Sub-shards have negative dbIDs so that they never collide with the ones of clients. A vectodblite splits at most once,
+// and sub-shards never split.
+
+var errPagedSplit = errors.New("paged searches of split vectodblites are unsupported")
+
+type shardSplit struct {
+	Child    int    `json:"child"`
+	Pivot    uint64 `json:"pivot"`
+	NodeAddr string `json:"nodeAddr"` // owner of the sub-shard when it's split
+}
+
+type ReqSplit struct {
+	DbID     int    `json:"dbID"`
+	Pivot    uint64 `json:"pivot"`
+	NodeAddr string `json:"nodeAddr"`
+}
+
+type RspSplit struct {
+	DbID     int    `json:"dbID"`
+	Child    int    `json:"child"`
+	Pivot    uint64 `json:"pivot"`
+	NodeAddr string `json:"nodeAddr"`
+	Err      string `json:"err"`
+}
+
+func (ctl *Controller) splitKey(dbID int) string {
+	return fmt.Sprintf("%s/split/%d", ctl.conf.etcdPath(), dbID)
+}
+
+// getSplit returns the split of the given vectodblite known by this node, nil if it isn't split.
+func (ctl *Controller) getSplit(dbID int) *shardSplit {
+	ctl.splitLock.RLock()
+	defer ctl.splitLock.RUnlock()
+	return ctl.splits[dbID]
+}
+
+// loadSplit loads the split of the given vectodblite from etcd, so that a new owner keeps routing to its sub-shard.
+func (ctl *Controller) loadSplit(ctx context.Context, dbID int) (err error) {
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, ctl.splitKey(dbID)); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	} else if len(resp.Kvs) == 0 {
+		return
+	}
+	var split shardSplit
+	if err = json.Unmarshal(resp.Kvs[0].Value, &split); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	ctl.splitLock.Lock()
+	ctl.splits[dbID] = &split
+	ctl.splitLock.Unlock()
+	return
+}
+
+// routeLock returns the lock which xid-keyed writes of the vectodblite hold for reading from routing them to writing them
+// into the vectodblite itself, and deletes of xids at least the pivot hold until they're deleted from the sub-shard as well.
+// split locks it to wait for the writes routed before the split is known.
+func (ctl *Controller) routeLock(dbID int) (rl *sync.RWMutex) {
+	ctl.splitLock.Lock()
+	defer ctl.splitLock.Unlock()
+	if rl = ctl.routeLocks[dbID]; rl == nil {
+		if ctl.routeLocks == nil {
+			ctl.routeLocks = make(map[int]*sync.RWMutex)
+		}
+		rl = &sync.RWMutex{}
+		ctl.routeLocks[dbID] = rl
+	}
+	return
+}
+
+// xidShard returns the sub-shard which the xid belongs to, ok is false if it belongs to the vectodblite itself.
+func (ctl *Controller) xidShard(dbID int, xid uint64) (shard int, ok bool) {
+	if split := ctl.getSplit(dbID); split != nil && xid >= split.Pivot {
+		shard, ok = split.Child, true
+	}
+	return
+}
+
+// addShard returns the sub-shard which the add shall be forwarded to, ok is false if it's added to the vectodblite itself.
+// The xids generated by id strategies other than IdStrategyHash aren't known beforehand, so such vectors stay in the vectodblite.
+func (ctl *Controller) addShard(reqAdd *ReqAdd) (shard int, ok bool) {
+	xid := reqAdd.Xid
+	if xid == 0 || xid == ^uint64(0) {
+		if ctl.idGen != nil {
+			return
+		}
+		xid = vectodb.HashVector(reqAdd.Xb)
+	}
+	return ctl.xidShard(reqAdd.DbID, xid)
+}
+
+// maybeSplit starts splitting the vectodblite in background if it has grown to SplitThreshold. assumes RLock is holded
+// Sub-shards never split, since their adds come through HandleAdd as well.
+func (ctl *Controller) maybeSplit(dbID int, dbl *vectodb.VectoDBLite) {
+	if ctl.conf.SplitThreshold == 0 || dbID < 0 || dbl.Size() < ctl.conf.SplitThreshold {
+		return
+	}
+	ctl.splitLock.Lock()
+	defer ctl.splitLock.Unlock()
+	if _, ok := ctl.splits[dbID]; ok || ctl.splitting[dbID] {
+		return
+	}
+	ctl.splitting[dbID] = true
+	go func() {
+		if err := ctl.split(dbID); err != nil {
+			log.Errorf("failed to split vectodblite %d, error %+v", dbID, err)
+		}
+		ctl.splitLock.Lock()
+		delete(ctl.splitting, dbID)
+		ctl.splitLock.Unlock()
+	}()
+}
+
+// split splits the given vectodblite owned by this node. It's resumed by the next add if it fails halfway,
+// since the vectors are deleted from the vectodblite only after they're imported to the sub-shard.
+func (ctl *Controller) split(dbID int) (err error) {
+	ctl.rwlock.RLock()
+	dbl, ok := ctl.dbls[dbID]
+	ctl.rwlock.RUnlock()
+	if !ok {
+		err = errors.Errorf("vectodblite %v is released meanwhile", dbID)
+		return
+	}
+	var pivot uint64
+	if pivot, err = dbl.MedianXid(); err != nil {
+		return
+	}
+	var split *shardSplit
+	if split, err = ctl.requestSplit(ctl.ctx, dbID, pivot); err != nil {
+		return
+	}
+	log.Infof("splitting vectodblite %d at xid %016x to %d at %s", dbID, split.Pivot, split.Child, split.NodeAddr)
+	migrated := make(map[uint64]bool)
+	if err = ctl.migrateShard(dbl, split, migrated); err != nil {
+		return
+	}
+	ctl.splitLock.Lock()
+	ctl.splits[dbID] = split
+	ctl.splitLock.Unlock()
+	// Wait for the writes routed to the vectodblite itself before the split is known, and block the ones of xids at least the
+	// pivot until the migration completes, so that a delete can't slip between the export and the import.
+	rl := ctl.routeLock(dbID)
+	rl.Lock()
+	defer rl.Unlock()
+	if err = ctl.migrateShard(dbl, split, migrated); err != nil {
+		return
+	}
+	// Vectors deleted from the vectodblite before the split is known could have been migrated already.
+	xids := make([]uint64, 0, len(migrated))
+	var gone []uint64
+	for xid := range migrated {
+		xids = append(xids, xid)
+		if !dbl.Contains(xid) {
+			gone = append(gone, xid)
+		}
+	}
+	if len(gone) != 0 {
+		if _, err = ctl.forwardDeleteIds(ctl.ctx, ReqDeleteIds{Xids: gone}, split.Child); err != nil {
+			return
+		}
+	}
+	if err = ctl.withVectoDBLite(dbID, func(dbl *vectodb.VectoDBLite) (err error) {
+		_, _, err = dbl.DeleteIds(xids)
+		return
+	}); err != nil {
+		return
+	}
+	log.Infof("split vectodblite %d, migrated %d vectors to %d", dbID, len(xids), split.Child)
+	return
+}
+
+// requestSplit asks the leader to allocate the sub-shard of the given vectodblite. The split recorded already is returned if any.
+func (ctl *Controller) requestSplit(ctx context.Context, dbID int, pivot uint64) (split *shardSplit, err error) {
+	if ctl.isLeader {
+		return ctl.allocSplit(ctx, dbID, pivot, ctl.conf.ListenAddr)
+	}
+	curLeader := ctl.curLeader
+	if curLeader == "" {
+		err = errors.Errorf("Need to send split request to the leader. However the leader is unknown.")
+		return
+	}
+	var adminAddr string
+	if adminAddr, err = ctl.getAdminAddr(ctx, curLeader); err != nil {
+		return
+	}
+	reqSplit := ReqSplit{
+		DbID:     dbID,
+		Pivot:    pivot,
+		NodeAddr: ctl.conf.ListenAddr,
+	}
+	rspSplit := &RspSplit{}
+	if err = ctl.postMgmt(ctx, fmt.Sprintf("%s://%s/mgmt/v1/split", ctl.conf.scheme(), adminAddr), reqSplit, rspSplit); err != nil {
+		return
+	} else if rspSplit.Err != "" {
+		err = errors.New(rspSplit.Err)
+		return
+	}
+	split = &shardSplit{Child: rspSplit.Child, Pivot: rspSplit.Pivot, NodeAddr: rspSplit.NodeAddr}
+	return
+}
+
+// migrateShard exports the vectors of dbl whose xids are at least the pivot and not migrated yet, and imports them to the sub-shard.
+// migrated is updated only if the import succeeds.
+func (ctl *Controller) migrateShard(dbl *vectodb.VectoDBLite, split *shardSplit, migrated map[uint64]bool) (err error) {
+	var adminAddr string
+	if adminAddr, err = ctl.getAdminAddr(ctl.ctx, split.NodeAddr); err != nil {
+		return
+	}
+	pr, pw := io.Pipe()
+	xidsCh := make(chan []uint64, 1)
+	go func() {
+		var xids []uint64
+		w := bufio.NewWriter(pw)
+		_, err := dbl.ExportFilter(w, func(xid uint64) bool {
+			if xid < split.Pivot || migrated[xid] {
+				return false
+			}
+			xids = append(xids, xid)
+			return true
+		})
+		if err == nil {
+			err = errors.Wrap(w.Flush(), "")
+		}
+		pw.CloseWithError(err)
+		xidsCh <- xids
+	}()
+	err = ctl.postImport(ctl.ctx, fmt.Sprintf("%s://%s/mgmt/v1/import?dbID=%d", ctl.conf.scheme(), adminAddr, split.Child), pr)
+	// unblock the exporter if the import stopped halfway
+	pr.CloseWithError(io.ErrClosedPipe)
+	xids := <-xidsCh
+	if err != nil {
+		return
+	}
+	for _, xid := range xids {
+		migrated[xid] = true
+	}
+	return
+}
+
+// postImport streams an export to the import endpoint of another node with MigrateTimeout, so that a stuck peer doesn't hang the migration.
+func (ctl *Controller) postImport(ctx context.Context, servURL string, r io.Reader) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ctl.conf.MigrateTimeout)*time.Millisecond)
+	defer cancel()
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodPost, servURL, r); err != nil {
+		err = errors.Wrapf(err, "servURL %+v", servURL)
+		return
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	var rsp *http.Response
+	if rsp, err = ctl.hc.Do(req.WithContext(ctx)); err != nil {
+		err = errors.Wrapf(err, "servURL %+v", servURL)
+		return
+	}
+	rspBody, err := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if err != nil {
+		err = errors.Wrapf(err, "servURL %+v", servURL)
+		return
+	}
+	var rspImport RspImport
+	if err = json.Unmarshal(rspBody, &rspImport); err != nil {
+		err = errors.Wrapf(err, "servURL %+v, status %v, failed to decode rspBody: %+v", servURL, rsp.StatusCode, string(rspBody))
+		return
+	} else if rspImport.Err != "" {
+		err = errors.Errorf("servURL %+v, imported %v vectors, error %v", servURL, rspImport.Count, rspImport.Err)
+	}
+	return
+}
+
+// @Description Split a vectodblite into two by xid range. Only the leader node supports this API. It's sent by the owner of the vectodblite once it grows to the configured split threshold.
+// @Accept  json
+// @Produce json
+// @Param   split		body	cluster.ReqSplit	true 	"ReqSplit. The vectors whose xids are at least pivot move to the sub-shard."
+// @Success 200 {object} cluster.RspSplit "RspSplit. child is the dbID of the sub-shard, and nodeAddr is its owner. The split recorded already is returned if any."
+// @Failure 307 "redirection"
+// @Failure 400
+// @Router /mgmt/v1/split [post]
+func (ctl *Controller) HandleSplit(c *gin.Context) {
+	var reqSplit ReqSplit
+	var err error
+	if err = c.ShouldBind(&reqSplit); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+	} else if !ctl.isLeader && ctl.curLeader != "" {
+		var adminAddr string
+		if adminAddr, err = ctl.getAdminAddr(c.Request.Context(), ctl.curLeader); err != nil {
+			log.Errorf("got error %+v", err)
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		dstURL := *c.Request.URL
+		dstURL.Host = adminAddr
+		c.Redirect(http.StatusTemporaryRedirect, dstURL.String())
+	} else {
+		rspSplit := RspSplit{
+			DbID: reqSplit.DbID,
+		}
+		var split *shardSplit
+		if split, err = ctl.allocSplit(c.Request.Context(), reqSplit.DbID, reqSplit.Pivot, reqSplit.NodeAddr); err != nil {
+			rspSplit.Err = err.Error()
+			log.Errorf("got error %+v", err)
+		} else {
+			rspSplit.Child, rspSplit.Pivot, rspSplit.NodeAddr = split.Child, split.Pivot, split.NodeAddr
+		}
+		c.JSON(200, rspSplit)
+	}
+}
+
+// allocSplit allocates a sub-shard of the given vectodblite owned by nodeAddr, places it at the least loaded other node, and records the split.
+func (ctl *Controller) allocSplit(ctx context.Context, dbID int, pivot uint64, nodeAddr string) (split *shardSplit, err error) {
+	if !ctl.isLeader {
+		err = errors.Errorf("not capable to split since I'm not the leader")
+		return
+	}
+	k := ctl.splitKey(dbID)
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, k); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	split = &shardSplit{}
+	if len(resp.Kvs) != 0 {
+		if err = json.Unmarshal(resp.Kvs[0].Value, split); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+	} else {
+		split.Pivot = pivot
+		if split.Child, err = ctl.nextShardID(ctx); err != nil {
+			return
+		}
+		if split.NodeAddr, err = ctl.pickShardNode(ctx, nodeAddr); err != nil {
+			return
+		}
+		val, _ := json.Marshal(split)
+		txn := ctl.etcdCli.Txn(ctx).If(clientv3.Compare(clientv3.CreateRevision(k), "=", 0))
+		txn = txn.Then(clientv3.OpPut(k, string(val)))
+		txn = txn.Else(clientv3.OpGet(k))
+		var txnResp *clientv3.TxnResponse
+		if txnResp, err = txn.Commit(); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+		if !txnResp.Succeeded {
+			// another split request won
+			kv := txnResp.Responses[0].GetResponseRange().Kvs[0]
+			if err = json.Unmarshal(kv.Value, split); err != nil {
+				err = errors.Wrap(err, "")
+				return
+			}
+		}
+	}
+	// The sub-shard may have moved since the split, its current owner is returned.
+	if split.NodeAddr, err = ctl.acquireOnce(ctx, split.Child, split.NodeAddr); err != nil {
+		return
+	}
+	return
+}
+
+// nextShardID allocates the dbID of a sub-shard from etcd key <etcdPath>/split_seq.
+func (ctl *Controller) nextShardID(ctx context.Context) (dbID int, err error) {
+	k := fmt.Sprintf("%s/split_seq", ctl.conf.etcdPath())
+	for {
+		var resp *clientv3.GetResponse
+		if resp, err = ctl.etcdCli.Get(ctx, k); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+		var seq int
+		var rev int64
+		if len(resp.Kvs) != 0 {
+			if seq, err = strconv.Atoi(string(resp.Kvs[0].Value)); err != nil {
+				err = errors.Wrap(err, "")
+				return
+			}
+			rev = resp.Kvs[0].ModRevision
+		}
+		seq++
+		txn := ctl.etcdCli.Txn(ctx).If(clientv3.Compare(clientv3.ModRevision(k), "=", rev))
+		var txnResp *clientv3.TxnResponse
+		if txnResp, err = txn.Then(clientv3.OpPut(k, strconv.Itoa(seq))).Commit(); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+		if txnResp.Succeeded {
+			dbID = -seq
+			return
+		}
+	}
+}
+
+// pickShardNode returns the least loaded alive node other than nodeAddr, or nodeAddr if it's the only one.
+func (ctl *Controller) pickShardNode(ctx context.Context, nodeAddr string) (target string, err error) {
+	target = nodeAddr
+	pfx := fmt.Sprintf("%s/node", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	var load map[string][]int
+	if load, err = ctl.getLoad(); err != nil {
+		return
+	}
+	minLoad := -1
+	for _, item := range resp.Kvs {
+		addr := filepath.Base(string(item.Key))
+		if addr != nodeAddr && (minLoad < 0 || len(load[addr]) < minLoad) {
+			target = addr
+			minLoad = len(load[addr])
+		}
+	}
+	return
+}
+
+// forwardAdd forwards the add to the sub-shard which the xid belongs to. The request is sent to this node, which redirects it to the owner.
+func (ctl *Controller) forwardAdd(ctx context.Context, reqAdd ReqAdd, shard int) (rspAdd RspAdd, err error) {
+	reqAdd.DbID = shard
+	err = ctl.postData(ctx, fmt.Sprintf("%s://%s/api/v1/add", ctl.conf.scheme(), ctl.conf.ListenAddr), reqAdd, &rspAdd)
+	return
+}
+
+// forwardDelete deletes the vector from the sub-shard which the xid belongs to, the same as forwardAdd.
+func (ctl *Controller) forwardDelete(ctx context.Context, reqDelete ReqDelete, shard int) (rspDelete RspDelete, err error) {
+	reqDelete.DbID = shard
+	if err = ctl.postData(ctx, fmt.Sprintf("%s://%s/api/v1/delete", ctl.conf.scheme(), ctl.conf.ListenAddr), reqDelete, &rspDelete); err == nil && rspDelete.Err != "" {
+		err = errors.Errorf("sub-shard %v, error %v", shard, rspDelete.Err)
+	}
+	return
+}
+
+// forwardDeleteIds deletes the vectors from the sub-shard which the xids belong to, the same as forwardAdd.
+func (ctl *Controller) forwardDeleteIds(ctx context.Context, reqDeleteIds ReqDeleteIds, shard int) (rspDeleteIds RspDeleteIds, err error) {
+	reqDeleteIds.DbID = shard
+	if err = ctl.postData(ctx, fmt.Sprintf("%s://%s/api/v1/delete_ids", ctl.conf.scheme(), ctl.conf.ListenAddr), reqDeleteIds, &rspDeleteIds); err == nil && rspDeleteIds.Err != "" {
+		err = errors.Errorf("sub-shard %v, error %v", shard, rspDeleteIds.Err)
+	}
+	return
+}
+
+// goSearchShard searches the sub-shard the same as the request in background. The request is sent to this node, which redirects it to the owner.
+// The response shall be waited without holding RLock, since this node serves it.
+func (ctl *Controller) goSearchShard(ctx context.Context, reqSearch ReqSearch, shard int) <-chan *RspSearch {
+	rspCh := make(chan *RspSearch, 1)
+	reqSearch.DbID = shard
+	reqSearch.Debug = false
+	go func() {
+		rspSearch := &RspSearch{}
+		if err := ctl.postData(ctx, fmt.Sprintf("%s://%s/api/v1/search", ctl.conf.scheme(), ctl.conf.ListenAddr), reqSearch, rspSearch); err != nil {
+			rspSearch.Err = err.Error()
+		} else if rspSearch.Err != "" {
+			rspSearch.Err = fmt.Sprintf("sub-shard %v, error %v", shard, rspSearch.Err)
+		}
+		rspCh <- rspSearch
+	}()
+	return rspCh
+}
+
+// mergeSearch merges the search response of a sub-shard into rspSearch. Results are deduplicated by xid,
+// since a vector is present in both during migration.
+func mergeSearch(reqSearch *ReqSearch, rspSearch, rspShard *RspSearch) {
+	rspSearch.Count += rspShard.Count
+	if rspShard.Xid != ^uint64(0) && (rspSearch.Xid == ^uint64(0) || rspShard.Distance > rspSearch.Distance) {
+		rspSearch.Xid, rspSearch.Distance, rspSearch.Xb = rspShard.Xid, rspShard.Distance, rspShard.Xb
+	}
+	if len(rspShard.Results) == 0 {
+		return
+	}
+	hits := append(rspSearch.Results, rspShard.Results...)
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Distance > hits[j].Distance })
+	seen := make(map[uint64]bool, len(hits))
+	perGroup := make(map[uint64]int)
+	rspSearch.Results = hits[:0]
+	for _, hit := range hits {
+		if seen[hit.Xid] {
+			continue
+		}
+		seen[hit.Xid] = true
+		if reqSearch.GroupBy {
+			if perGroup[hit.Group] >= reqSearch.GroupTopK {
+				continue
+			}
+			perGroup[hit.Group]++
+		} else if reqSearch.MinResults > 0 && len(rspSearch.Results) >= reqSearch.MinResults {
+			break
+		}
+		rspSearch.Results = append(rspSearch.Results, hit)
+	}
+}
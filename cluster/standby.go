@@ -0,0 +1,235 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gin-gonic/gin"
+	"github.com/infinivision/vectodb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// The leader tracks warm standbys of a vectodblite as etcd keys <etcdPath>/standby/<dbID>/<nodeAddr>.
+// If the owner dies, the ownership is reassigned to an alive standby, which promotes itself on the next request.
+
+type ReqStandby struct {
+	DbID int  `json:"dbID"`
+	Drop bool `json:"drop"` // drop the standby rather than start it
+}
+
+type RspStandby struct {
+	DbID int    `json:"dbID"`
+	Err  string `json:"err"`
+}
+
+// @Description Start or drop a warm standby of the given vectodblite on this node. The leader assigns standbys after a vectodblite is acquired.
+// @Accept  json
+// @Produce json
+// @Param   standby		body	cluster.ReqStandby	true 	"ReqStandby"
+// @Success 200 {object} cluster.RspStandby "RspStandby"
+// @Failure 400
+// @Router /mgmt/v1/standby [post]
+func (ctl *Controller) HandleStandby(c *gin.Context) {
+	var reqStandby ReqStandby
+	var err error
+	if err = c.ShouldBind(&reqStandby); err != nil {
+		err = errors.Wrap(err, "")
+		log.Infof("failed to parse request body, error %+v", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	rspStandby := RspStandby{
+		DbID: reqStandby.DbID,
+	}
+	if reqStandby.Drop {
+		err = ctl.dropStandby(reqStandby.DbID)
+	} else {
+		err = ctl.startStandby(reqStandby.DbID)
+	}
+	if err != nil {
+		log.Errorf("got error %+v", err)
+		rspStandby.Err = err.Error()
+	}
+	c.JSON(200, rspStandby)
+}
+
+// startStandby loads a warm standby of the vectodblite. It's a no-op if this node owns it or already has a standby of it.
+func (ctl *Controller) startStandby(dbID int) (err error) {
+	ctl.rwlock.RLock()
+	_, owned := ctl.dbls[dbID]
+	_, ok := ctl.standbys[dbID]
+	ctl.rwlock.RUnlock()
+	if owned || ok {
+		return
+	}
+	if pressure, _ := ctl.underMemPressure(); pressure {
+		err = errMemPressure
+		return
+	}
+	var standby *vectodb.VectoDBLite
+	if standby, err = vectodb.NewVectoDBLiteStandbyWithRedisOptions(ctl.conf.redisOptions(), dbID, ctl.conf.Dim, float32(ctl.conf.DisThr), ctl.conf.SizeLimit, ctl.conf.indexKey(dbID)); err != nil {
+		return
+	}
+	standby.SetAllowZeroQuery(ctl.conf.AllowZeroQuery)
+	standby.EnableRecent(ctl.conf.RecentSize)
+	// takes effect once it's promoted
+	standby.SetPublishChanges(true)
+	ctl.rwlock.Lock()
+	defer ctl.rwlock.Unlock()
+	_, owned = ctl.dbls[dbID]
+	if _, ok = ctl.standbys[dbID]; owned || ok {
+		err = standby.Destroy()
+		return
+	}
+	ctl.standbys[dbID] = standby
+	log.Infof("started warm standby of vectodblite %d", dbID)
+	return
+}
+
+// dropStandby destroys the warm standby of the vectodblite. It's a no-op if there's none.
+func (ctl *Controller) dropStandby(dbID int) (err error) {
+	ctl.rwlock.Lock()
+	defer ctl.rwlock.Unlock()
+	if standby, ok := ctl.standbys[dbID]; ok {
+		delete(ctl.standbys, dbID)
+		if err = standby.Destroy(); err != nil {
+			return
+		}
+		log.Infof("dropped warm standby of vectodblite %d", dbID)
+	}
+	return
+}
+
+// requestStandby asks the given node to start or drop a warm standby.
+func (ctl *Controller) requestStandby(ctx context.Context, nodeAddr string, dbID int, drop bool) (err error) {
+	if nodeAddr == ctl.conf.ListenAddr {
+		if drop {
+			return ctl.dropStandby(dbID)
+		}
+		return ctl.startStandby(dbID)
+	}
+	var adminAddr string
+	if adminAddr, err = ctl.getAdminAddr(ctx, nodeAddr); err != nil {
+		return
+	}
+	reqStandby := ReqStandby{
+		DbID: dbID,
+		Drop: drop,
+	}
+	rspStandby := &RspStandby{}
+	if err = ctl.postMgmt(ctx, fmt.Sprintf("%s://%s/mgmt/v1/standby", ctl.conf.scheme(), adminAddr), reqStandby, rspStandby); err != nil {
+		return
+	} else if rspStandby.Err != "" {
+		err = errors.New(rspStandby.Err)
+		return
+	}
+	return
+}
+
+func (ctl *Controller) standbyKey(dbID int, nodeAddr string) string {
+	return fmt.Sprintf("%s/standby/%d/%s", ctl.conf.etcdPath(), dbID, nodeAddr)
+}
+
+// getStandbys returns the nodes which are assigned warm standbys of the vectodblite.
+func (ctl *Controller) getStandbys(ctx context.Context, dbID int) (nodeAddrs []string, err error) {
+	pfx := fmt.Sprintf("%s/standby/%d/", ctl.conf.etcdPath(), dbID)
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	for _, item := range resp.Kvs {
+		nodeAddrs = append(nodeAddrs, filepath.Base(string(item.Key)))
+	}
+	return
+}
+
+// assignStandbys keeps WarmStandbyCount warm standbys of the vectodblite on the least loaded alive nodes other than the owner.
+// Existing standbys on alive nodes are kept. The owner's key is removed since it has promoted its standby.
+func (ctl *Controller) assignStandbys(ctx context.Context, dbID int, owner string) (err error) {
+	pfx := fmt.Sprintf("%s/node", ctl.conf.etcdPath())
+	var resp *clientv3.GetResponse
+	if resp, err = ctl.etcdCli.Get(ctx, pfx, clientv3.WithPrefix()); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	alive := make(map[string]bool)
+	for _, item := range resp.Kvs {
+		alive[filepath.Base(string(item.Key))] = true
+	}
+	var standbys []string
+	if standbys, err = ctl.getStandbys(ctx, dbID); err != nil {
+		return
+	}
+	kept := make(map[string]bool)
+	for _, nodeAddr := range standbys {
+		if nodeAddr != owner && alive[nodeAddr] && len(kept) < ctl.conf.WarmStandbyCount {
+			kept[nodeAddr] = true
+			continue
+		}
+		if nodeAddr != owner && alive[nodeAddr] {
+			if err = ctl.requestStandby(ctx, nodeAddr, dbID, true); err != nil {
+				log.Errorf("failed to drop warm standby of vectodblite %d on %s, error %+v", dbID, nodeAddr, err)
+			}
+		}
+		if _, err = ctl.etcdCli.Delete(ctx, ctl.standbyKey(dbID, nodeAddr)); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+	}
+	var load map[string][]int
+	if load, err = ctl.getLoad(); err != nil {
+		return
+	}
+	candidates := make([]string, 0, len(alive))
+	for nodeAddr := range alive {
+		if nodeAddr != owner && !kept[nodeAddr] {
+			candidates = append(candidates, nodeAddr)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return len(load[candidates[i]]) < len(load[candidates[j]]) })
+	for _, nodeAddr := range candidates {
+		if len(kept) >= ctl.conf.WarmStandbyCount {
+			break
+		}
+		if err = ctl.requestStandby(ctx, nodeAddr, dbID, false); err != nil {
+			log.Errorf("failed to start warm standby of vectodblite %d on %s, error %+v", dbID, nodeAddr, err)
+			continue
+		}
+		if _, err = ctl.etcdCli.Put(ctx, ctl.standbyKey(dbID, nodeAddr), ""); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+		kept[nodeAddr] = true
+	}
+	err = nil
+	log.Infof("vectodblite %d owned by %s has %d warm standbys", dbID, owner, len(kept))
+	return
+}
+
+// pickLiveStandby returns an alive node which has a warm standby of the vectodblite, or empty if there's none.
+func (ctl *Controller) pickLiveStandby(ctx context.Context, dbID int, aliveNodes map[string]int) (standby string, err error) {
+	var standbys []string
+	if standbys, err = ctl.getStandbys(ctx, dbID); err != nil {
+		return
+	}
+	for _, nodeAddr := range standbys {
+		if _, ok := aliveNodes[nodeAddr]; ok {
+			standby = nodeAddr
+			return
+		}
+	}
+	return
+}
+
+// replenishStandbys runs assignStandbys in background, and logs the error.
+func (ctl *Controller) replenishStandbys(dbID int, owner string) {
+	if err := ctl.assignStandbys(ctl.ctx, dbID, owner); err != nil {
+		log.Errorf("failed to assign warm standbys of vectodblite %d, error %+v", dbID, err)
+	}
+}
@@ -0,0 +1,132 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+var errAcquireBusy = errors.New("too many acquires are queued, retry later")
+
+type AcquireStat struct {
+	Queued    int    `json:"queued"`    // number of acquires waiting to be sent to the leader
+	Throttled uint64 `json:"throttled"` // number of acquires which have waited
+	WaitMs    int64  `json:"waitMs"`    // total time of waiting, in milliseconds
+}
+
+// acquireLimiter spaces out acquire requests sent to the leader at AcquireRate, so that a storm of cold dbIDs doesn't overwhelm it.
+// Requests beyond the rate are queued, and the ones beyond AcquireQueueSize are rejected with errAcquireBusy.
+type acquireLimiter struct {
+	interval  time.Duration
+	maxQueued int
+	lock      sync.Mutex
+	next      time.Time // the earliest time the next request could be sent
+	queued    int
+	throttled uint64
+	waitNs    int64
+}
+
+// newAcquireLimiter returns nil if rate is 0, which is unlimited.
+func newAcquireLimiter(rate, maxQueued int) *acquireLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &acquireLimiter{
+		interval:  time.Second / time.Duration(rate),
+		maxQueued: maxQueued,
+	}
+}
+
+// wait blocks until the request is allowed to be sent, or ctx is done.
+func (l *acquireLimiter) wait(ctx context.Context) (err error) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	if delay > 0 {
+		if l.queued >= l.maxQueued {
+			l.lock.Unlock()
+			err = errAcquireBusy
+			return
+		}
+		l.queued++
+		l.throttled++
+	}
+	l.next = l.next.Add(l.interval)
+	l.lock.Unlock()
+	if delay <= 0 {
+		return
+	}
+	defer func() {
+		waited := time.Since(now)
+		acquireWaitDuration.Observe(waited.Seconds())
+		l.lock.Lock()
+		l.queued--
+		l.waitNs += int64(waited)
+		l.lock.Unlock()
+	}()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "waiting to send acquire")
+	}
+	return
+}
+
+func (l *acquireLimiter) stat() (st AcquireStat) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	st.Queued = l.queued
+	st.Throttled = l.throttled
+	st.WaitMs = l.waitNs / int64(time.Millisecond)
+	return
+}
+
+// acquireCall is an acquire in progress at the leader. Concurrent acquires of the same dbID wait for it rather than hitting etcd again.
+type acquireCall struct {
+	done        chan struct{}
+	dstNodeAddr string
+	err         error
+}
+
+// acquireOnce coalesces concurrent acquires of the same dbID. They all get the owner settled by the first one.
+func (ctl *Controller) acquireOnce(ctx context.Context, dbID int, nodeAddr string) (dstNodeAddr string, err error) {
+	ctl.acquireLock.Lock()
+	if call, ok := ctl.acquiring[dbID]; ok {
+		ctl.acquireLock.Unlock()
+		select {
+		case <-call.done:
+			return call.dstNodeAddr, call.err
+		case <-ctx.Done():
+			err = errors.Wrap(ctx.Err(), "waiting for acquire in progress")
+			return
+		}
+	}
+	if ctl.acquiring == nil {
+		ctl.acquiring = make(map[int]*acquireCall)
+	}
+	call := &acquireCall{done: make(chan struct{})}
+	ctl.acquiring[dbID] = call
+	ctl.acquireLock.Unlock()
+	defer func() {
+		ctl.acquireLock.Lock()
+		delete(ctl.acquiring, dbID)
+		ctl.acquireLock.Unlock()
+		close(call.done)
+	}()
+	dstNodeAddr, err = ctl.acquire(ctx, dbID, nodeAddr)
+	call.dstNodeAddr, call.err = dstNodeAddr, err
+	return
+}
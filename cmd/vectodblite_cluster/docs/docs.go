@@ -48,7 +48,7 @@ var doc = `{
                         "required": true,
                         "schema": {
                             "type": "object",
-                            "$ref": "#/definitions/main.ReqAdd"
+                            "$ref": "#/definitions/cluster.ReqAdd"
                         }
                     }
                 ],
@@ -57,10 +57,10 @@ var doc = `{
                         "description": "RspAdd",
                         "schema": {
                             "type": "object",
-                            "$ref": "#/definitions/main.RspAdd"
+                            "$ref": "#/definitions/cluster.RspAdd"
                         }
                     },
-                    "308": {
+                    "307": {
                         "description": "redirection"
                     },
                     "400": {}
@@ -84,7 +84,7 @@ var doc = `{
                         "required": true,
                         "schema": {
                             "type": "object",
-                            "$ref": "#/definitions/main.ReqSearch"
+                            "$ref": "#/definitions/cluster.ReqSearch"
                         }
                     }
                 ],
@@ -93,10 +93,10 @@ var doc = `{
                         "description": "RspSearch",
                         "schema": {
                             "type": "object",
-                            "$ref": "#/definitions/main.RspSearch"
+                            "$ref": "#/definitions/cluster.RspSearch"
                         }
                     },
-                    "308": {
+                    "307": {
                         "description": "redirection"
                     },
                     "400": {}
@@ -114,7 +114,7 @@ var doc = `{
                         "description": "Health",
                         "schema": {
                             "type": "object",
-                            "$ref": "#/definitions/main.Health"
+                            "$ref": "#/definitions/cluster.Health"
                         }
                     }
                 }
@@ -137,7 +137,7 @@ var doc = `{
                         "required": true,
                         "schema": {
                             "type": "object",
-                            "$ref": "#/definitions/main.ReqAcquire"
+                            "$ref": "#/definitions/cluster.ReqAcquire"
                         }
                     }
                 ],
@@ -146,10 +146,10 @@ var doc = `{
                         "description": "RspAcquire",
                         "schema": {
                             "type": "object",
-                            "$ref": "#/definitions/main.RspAcquire"
+                            "$ref": "#/definitions/cluster.RspAcquire"
                         }
                     },
-                    "308": {
+                    "307": {
                         "description": "redirection"
                     },
                     "400": {}
@@ -173,7 +173,7 @@ var doc = `{
                         "required": true,
                         "schema": {
                             "type": "object",
-                            "$ref": "#/definitions/main.ReqRelease"
+                            "$ref": "#/definitions/cluster.ReqRelease"
                         }
                     }
                 ],
@@ -182,10 +182,10 @@ var doc = `{
                         "description": "RspRelease",
                         "schema": {
                             "type": "object",
-                            "$ref": "#/definitions/main.RspRelease"
+                            "$ref": "#/definitions/cluster.RspRelease"
                         }
                     },
-                    "308": {
+                    "307": {
                         "description": "redirection"
                     },
                     "400": {}
@@ -203,7 +203,7 @@ var doc = `{
                         "description": "Status",
                         "schema": {
                             "type": "object",
-                            "$ref": "#/definitions/main.Status"
+                            "$ref": "#/definitions/cluster.Status"
                         }
                     }
                 }
@@ -211,7 +211,7 @@ var doc = `{
         }
     },
     "definitions": {
-        "main.Health": {
+        "cluster.Health": {
             "type": "object",
             "properties": {
                 "description": {
@@ -222,7 +222,7 @@ var doc = `{
                 }
             }
         },
-        "main.ReqAcquire": {
+        "cluster.ReqAcquire": {
             "type": "object",
             "properties": {
                 "dbID": {
@@ -233,7 +233,7 @@ var doc = `{
                 }
             }
         },
-        "main.ReqAdd": {
+        "cluster.ReqAdd": {
             "type": "object",
             "properties": {
                 "dbID": {
@@ -250,7 +250,7 @@ var doc = `{
                 }
             }
         },
-        "main.ReqRelease": {
+        "cluster.ReqRelease": {
             "type": "object",
             "properties": {
                 "dbID": {
@@ -258,7 +258,7 @@ var doc = `{
                 }
             }
         },
-        "main.ReqSearch": {
+        "cluster.ReqSearch": {
             "type": "object",
             "properties": {
                 "dbID": {
@@ -272,7 +272,7 @@ var doc = `{
                 }
             }
         },
-        "main.RspAcquire": {
+        "cluster.RspAcquire": {
             "type": "object",
             "properties": {
                 "dbID": {
@@ -286,7 +286,7 @@ var doc = `{
                 }
             }
         },
-        "main.RspAdd": {
+        "cluster.RspAdd": {
             "type": "object",
             "properties": {
                 "err": {
@@ -297,7 +297,7 @@ var doc = `{
                 }
             }
         },
-        "main.RspRelease": {
+        "cluster.RspRelease": {
             "type": "object",
             "properties": {
                 "dbID": {
@@ -308,7 +308,7 @@ var doc = `{
                 }
             }
         },
-        "main.RspSearch": {
+        "cluster.RspSearch": {
             "type": "object",
             "properties": {
                 "distance": {
@@ -322,7 +322,7 @@ var doc = `{
                 }
             }
         },
-        "main.Status": {
+        "cluster.Status": {
             "type": "object",
             "properties": {
                 "status": {
@@ -4,14 +4,18 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
+	"github.com/infinivision/vectodb/cluster"
 	_ "github.com/infinivision/vectodb/cmd/vectodblite_cluster/docs" // docs is generated by Swag CLI, you have to import it.
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	ginSwagger "github.com/swaggo/gin-swagger"                // gin-swagger middleware
-	swaggerFiles "github.com/swaggo/gin-swagger/swaggerFiles" // swagger embed files
 )
 
 // @title VectoDBLite Cluster API
@@ -36,15 +40,61 @@ var (
 	BuildTime = "Not provided (use ./build.sh instead of go build)"
 )
 
-func parseConfig() (conf *ControllerConf) {
-	conf = NewControllerConf()
+func parseConfig() (conf *cluster.ControllerConf) {
+	conf = cluster.NewControllerConf()
 	flag.StringVar(&conf.ListenAddr, "listen-addr", conf.ListenAddr, "Addr: listen address")
+	flag.StringVar(&conf.AdminAddr, "admin-addr", conf.AdminAddr, "Addr: listen address of mgmt and debug endpoints. Mgmt endpoints are served at listen address if it's empty, and debug endpoints are not served then")
+	flag.StringVar(&conf.CertFile, "cert-file", conf.CertFile, "TLS certificate file. The cluster is served with HTTPS if it's set")
+	flag.StringVar(&conf.KeyFile, "key-file", conf.KeyFile, "TLS key file of --cert-file")
+	flag.StringVar(&conf.CAFile, "ca-file", conf.CAFile, "CA certificates file which requests to other nodes trust, the system pool if empty")
+	apiKeys := flag.String("api-keys", "", "Keys seperated by comma, one of which data requests shall carry as a bearer token or in X-API-Key header. Empty allows all")
+	mgmtKeys := flag.String("mgmt-keys", "", "Keys seperated by comma, one of which mgmt and debug requests shall carry. They shall be at least 32 characters, and are required along with --api-keys")
+	apiKeysFile := flag.String("api-keys-file", "", "File holding --api-keys if it's empty, otherwise they're read from env "+envAPIKeys)
+	mgmtKeysFile := flag.String("mgmt-keys-file", "", "File holding --mgmt-keys if it's empty, otherwise they're read from env "+envMgmtKeys)
 	flag.StringVar(&conf.EtcdAddr, "etcd-addr", conf.EtcdAddr, "Addr: etcd address")
+	flag.StringVar(&conf.EtcdPrefix, "etcd-prefix", conf.EtcdPrefix, "Prefix of etcd keys. Clusters sharing one etcd shall have different prefixes")
 	flag.StringVar(&conf.RedisAddr, "redis-addr", conf.RedisAddr, "Addr: redis address")
+	flag.StringVar(&conf.RedisPassword, "redis-password", conf.RedisPassword, "Redis AUTH password, empty means no AUTH")
+	redisPasswordFile := flag.String("redis-password-file", "", "File holding --redis-password if it's empty, otherwise it's read from env "+envRedisPassword)
+	flag.IntVar(&conf.RedisDB, "redis-db", conf.RedisDB, "Redis logical db number")
+	flag.BoolVar(&conf.RedisTLS, "redis-tls", conf.RedisTLS, "Connect redis with TLS")
+	flag.StringVar(&conf.RedisKeyPrefix, "redis-key-prefix", conf.RedisKeyPrefix, "Prefix of every redis key, for example vdbl:app1:, so that clusters sharing one redis are isolated. Empty keeps the unprefixed keys")
 	flag.IntVar(&conf.Dim, "dim", conf.Dim, "VectoDBLite dimension")
 	flag.Float64Var(&conf.DisThr, "distance-threshold", conf.DisThr, "VectoDBLite distance threshold")
 	flag.IntVar(&conf.SizeLimit, "size-limit", conf.SizeLimit, "VectoDBLite size limit")
+	flag.StringVar(&conf.IndexKey, "index-key", conf.IndexKey, "VectoDBLite faiss index_factory key, for example Flat, IVF16,Flat")
+	indexKeys := flag.String("index-keys", "", "Per-dbID VectoDBLite index keys which override --index-key, for example \"1=Flat;2=IVF16,Flat\"")
 	flag.IntVar(&conf.BalanceInterval, "balance-interval", conf.BalanceInterval, "Time interval (in seconds) to balance the cluster load")
+	flag.IntVar(&conf.MaxNprobe, "max-nprobe", conf.MaxNprobe, "Upper bound of per-request nprobe, 0 means no limit")
+	flag.IntVar(&conf.MaxRSSMB, "max-rss-mb", conf.MaxRSSMB, "Reject adds and new vectodblites if the process RSS (in MB) goes above it, 0 means no limit")
+	flag.IntVar(&conf.MinAvailMemMB, "min-avail-mem-mb", conf.MinAvailMemMB, "Reject adds and new vectodblites if the host available memory (in MB) goes below it, 0 means no limit")
+	flag.IntVar(&conf.AcquireTimeout, "acquire-timeout", conf.AcquireTimeout, "Timeout (in milliseconds) of acquire and release requests to other nodes")
+	flag.IntVar(&conf.DataTimeout, "data-timeout", conf.DataTimeout, "Timeout (in milliseconds) of data requests proxied to other nodes")
+	flag.IntVar(&conf.MigrateTimeout, "migrate-timeout", conf.MigrateTimeout, "Timeout (in milliseconds) of each import of vectors migrated to a sub-shard on another node")
+	flag.BoolVar(&conf.HandoffOnClose, "handoff-on-close", conf.HandoffOnClose, "Hand off vectodblites to peers on shutdown, so that there's no query gap during rolling restart")
+	flag.BoolVar(&conf.AllowZeroQuery, "allow-zero-query", conf.AllowZeroQuery, "Accept all-zero query vectors, whose result order is arbitrary with inner product metric")
+	flag.StringVar(&conf.DebugToken, "debug-token", conf.DebugToken, "Token which requests shall carry in X-Debug-Token header to enable per-request debug logs, empty disables them")
+	flag.StringVar(&conf.IdStrategy, "id-strategy", conf.IdStrategy, "How to generate xids of vectors added without one: hash (of the vector), sequential (per-dbID counter in redis), random, snowflake")
+	flag.IntVar(&conf.SnowflakeNode, "snowflake-node", conf.SnowflakeNode, "Node id of snowflake id strategy, [0, 1023], shall be unique in the cluster")
+	flag.IntVar(&conf.RecentSize, "recent-size", conf.RecentSize, "Number of latest vectors per vectodblite kept for recent searches, 0 disables them")
+	flag.BoolVar(&conf.PenalizeWeight, "penalize-weight", conf.PenalizeWeight, "Weighted searches divide distances by weights rather than multiply, so that a larger weight penalizes a vector")
+	flag.IntVar(&conf.WarmStandbyCount, "warm-standby-count", conf.WarmStandbyCount, "Number of warm standbys per vectodblite which tail its changes and take over at once if the owner dies, 0 disables them")
+	flag.IntVar(&conf.CompressMinBytes, "compress-min-bytes", conf.CompressMinBytes, "Gzip search responses of at least this size (in bytes) if the client accepts it, 0 disables compression")
+	flag.BoolVar(&conf.BackpressureHeaders, "backpressure-headers", conf.BackpressureHeaders, "Add X-Vectodb-Flat-Backlog, X-Vectodb-Build-Queue and X-Vectodb-InFlight headers to data responses, so that clients could throttle themselves")
+	flag.IntVar(&conf.PageTTL, "page-ttl", conf.PageTTL, "How long (in seconds) the result set of a paged search is kept for following pages")
+	flag.IntVar(&conf.MaxPages, "max-pages", conf.MaxPages, "Max result sets of paged searches kept, the ones expiring first are evicted beyond it")
+	flag.IntVar(&conf.MaxBatchSize, "max-batch-size", conf.MaxBatchSize, "Max vectors of a batch add, or queries of a batch search")
+	flag.IntVar(&conf.MaxBatchResults, "max-batch-results", conf.MaxBatchResults, "Max results of a batch search, i.e. the number of queries times minResults")
+	flag.IntVar(&conf.MaxVectorFloats, "max-vector-floats", conf.MaxVectorFloats, "Max floats of vectors returned by a search or batch search with includeVectors, i.e. the number of results times dim")
+	flag.Float64Var(&conf.ClientRate, "client-rate", conf.ClientRate, "Max data requests per second of each client, identified by its API key or IP. The ones beyond it are responded with 429, 0 is unlimited")
+	flag.IntVar(&conf.ClientBurst, "client-burst", conf.ClientBurst, "Max data requests of each client in a burst above --client-rate")
+	flag.IntVar(&conf.AcquireRate, "acquire-rate", conf.AcquireRate, "Max acquires per second sent to the leader by this node, 0 is unlimited")
+	flag.IntVar(&conf.AcquireQueueSize, "acquire-queue-size", conf.AcquireQueueSize, "Max acquires waiting for the acquire rate, the ones beyond it are responded with 503")
+	flag.Float64Var(&conf.QPSWeight, "qps-weight", conf.QPSWeight, "Number of owned vectodblites one QPS of a node weighs as when the leader places acquired vectodblites, 0 disables QPS weighting")
+	flag.IntVar(&conf.QPSInterval, "qps-interval", conf.QPSInterval, "Time interval (in seconds) for a node to publish its recent QPS")
+	flag.IntVar(&conf.SplitThreshold, "split-threshold", conf.SplitThreshold, "Number of vectors at which a vectodblite is split into two by xid range, and half of them move to another node, 0 disables splitting")
+	flag.BoolVar(&conf.HashPlacement, "hash-placement", conf.HashPlacement, "Place vectodblites on nodes by consistent hashing of dbIDs, so that nodes redirect requests without asking the leader")
+	flag.BoolVar(&conf.FreshOnAcquire, "fresh-on-acquire", conf.FreshOnAcquire, "Wipe vectors of a vectodblite on acquiring it, rather than loading them from redis")
 
 	flag.StringVar(&conf.EurekaAddr, "eureka-addr", conf.EurekaAddr, "eureka server address list, seperated by comma.")
 	flag.StringVar(&conf.EurekaApp, "eureka-app", conf.EurekaApp, "VectoDBLite cluster service name which will be registered with eureka.")
@@ -60,6 +110,24 @@ func parseConfig() (conf *ControllerConf) {
 		fmt.Printf("Go OS/Arch: %s/%s\n", runtime.GOOS, runtime.GOARCH)
 		os.Exit(0)
 	}
+	var err error
+	if conf.IndexKeys, err = cluster.ParseIndexKeys(*indexKeys); err != nil {
+		log.Fatalf("invalid config: %+v", err)
+	}
+	// secrets in files or env don't show up in the process list
+	if conf.RedisPassword, err = readSecret(conf.RedisPassword, *redisPasswordFile, envRedisPassword); err != nil {
+		log.Fatalf("invalid config: %+v", err)
+	}
+	if *apiKeys, err = readSecret(*apiKeys, *apiKeysFile, envAPIKeys); err != nil {
+		log.Fatalf("invalid config: %+v", err)
+	}
+	if *mgmtKeys, err = readSecret(*mgmtKeys, *mgmtKeysFile, envMgmtKeys); err != nil {
+		log.Fatalf("invalid config: %+v", err)
+	}
+	conf.APIKeys, conf.MgmtKeys = splitKeys(*apiKeys), splitKeys(*mgmtKeys)
+	if err = conf.Validate(); err != nil {
+		log.Fatalf("invalid config: %+v", err)
+	}
 	if *isDebug {
 		log.SetLevel(log.DebugLevel)
 		gin.SetMode(gin.DebugMode)
@@ -70,19 +138,80 @@ func parseConfig() (conf *ControllerConf) {
 	return
 }
 
+// env vars of secrets which are not passed with flags
+const (
+	envRedisPassword = "VECTODB_REDIS_PASSWORD"
+	envAPIKeys       = "VECTODB_API_KEYS"
+	envMgmtKeys      = "VECTODB_MGMT_KEYS"
+)
+
+// readSecret returns the secret passed with a flag, or else the content of file if it's set, or else the env var.
+func readSecret(val, file, env string) (secret string, err error) {
+	if val != "" {
+		return val, nil
+	}
+	if file == "" {
+		return os.Getenv(env), nil
+	}
+	var b []byte
+	if b, err = ioutil.ReadFile(file); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	secret = strings.TrimSpace(string(b))
+	return
+}
+
+// splitKeys splits comma seperated keys, and drops empty ones.
+func splitKeys(s string) (keys []string) {
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
 func main() {
 	conf := parseConfig()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	ctl := NewController(conf, ctx)
+	ctl := cluster.NewController(conf, ctx)
 	r := gin.Default()
-	r.POST("/api/v1/add", ctl.HandleAdd)
-	r.POST("/api/v1/search", ctl.HandleSearch)
-	r.POST("/mgmt/v1/acquire", ctl.HandleAcquire)
-	r.POST("/mgmt/v1/release", ctl.HandleRelease)
-	r.GET("/status", ctl.HandleStatus)
-	r.GET("/health", ctl.HandleHealth)
-	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	r.Run(conf.ListenAddr)
+	admin := r
+	if conf.AdminAddr != "" {
+		admin = gin.Default()
+	}
+	cluster.SetupRouters(ctl, r, admin)
+	if conf.AdminAddr != "" {
+		go func() {
+			if err := runEngine(admin, conf.AdminAddr, conf); err != nil {
+				log.Fatalf("got error %+v", err)
+			}
+		}()
+	}
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigCh
+		log.Infof("got signal %v, shutting down", sig)
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cluster.ShutdownTimeout)
+		defer shutdownCancel()
+		if err := ctl.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("got error %+v", err)
+		}
+		os.Exit(0)
+	}()
+	if err := runEngine(r, conf.ListenAddr, conf); err != nil {
+		log.Fatalf("got error %+v", err)
+	}
+}
+
+// runEngine serves the engine at addr, with HTTPS if the certificate is configured.
+func runEngine(engine *gin.Engine, addr string, conf *cluster.ControllerConf) (err error) {
+	if conf.CertFile != "" {
+		return engine.RunTLS(addr, conf.CertFile, conf.KeyFile)
+	}
+	return engine.Run(addr)
 }
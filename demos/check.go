@@ -40,7 +40,7 @@ func main() {
 	if err := vectodb.VectodbClearWorkDir(*path); err != nil {
 		log.Fatalf("VectodbClearWorkDir failed, error:%+v", err)
 	}
-	db, err := vectodb.NewVectoDB(*path, siftDim, 0, "IVF4096,PQ32", "nprobe=256,ht=256", float32(*distThr), *flatThr)
+	db, err := vectodb.NewVectoDB(*path, siftDim, 0, "IVF4096,PQ32", "nprobe=256,ht=256", float32(*distThr), *flatThr, 0)
 	if err != nil {
 		log.Fatalf("init vector db failed, error:%+v", err)
 	}
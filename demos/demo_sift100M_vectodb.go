@@ -106,17 +106,19 @@ func ivecs_read(fname string) (x []int32, d, n int, err error) {
 
 func builderLoop(ctx context.Context, vdb *vectodb.VectoDB) {
 	ticker := time.Tick(5 * time.Second)
-	var err error
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker:
-			log.Infof("build iteration begin")
-			if err = vdb.UpdateIndex(); err != nil {
-				log.Fatalf("%+v", err)
-			}
-			log.Infof("build iteration done")
+			vdb.UpdateIndexAsync(func(done bool, err error) {
+				if err != nil {
+					log.Fatalf("%+v", err)
+				}
+				if done {
+					log.Infof("build iteration done")
+				}
+			})
 		}
 	}
 }
@@ -162,7 +164,7 @@ func main() {
 	//if err = vectodb.VectodbClearWorkDir(workDir); err != nil {
 	//	log.Fatalf("%+v", err)
 	//}
-	if vdb, err = vectodb.NewVectoDB(workDir, siftDim, siftMetric, siftIndexKey, siftQueryParams, distThr, flatThreshold); err != nil {
+	if vdb, err = vectodb.NewVectoDB(workDir, siftDim, siftMetric, siftIndexKey, siftQueryParams, distThr, flatThreshold, 0); err != nil {
 		log.Fatalf("%+v", err)
 	}
 
@@ -111,17 +111,19 @@ func ivecs_read(fname string) (x []int32, d, n int, err error) {
 
 func builderLoop(ctx context.Context, vdb *vectodb.VectoDB) {
 	ticker := time.Tick(5 * time.Second)
-	var err error
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker:
-			log.Infof("build iteration begin")
-			if err = vdb.UpdateIndex(); err != nil {
-				log.Fatalf("%+v", err)
-			}
-			log.Infof("build iteration done")
+			vdb.UpdateIndexAsync(func(done bool, err error) {
+				if err != nil {
+					log.Fatalf("%+v", err)
+				}
+				if done {
+					log.Infof("build iteration done")
+				}
+			})
 		}
 	}
 }
@@ -167,7 +169,7 @@ func benchmarkAdd() {
 	if err = vectodb.VectodbClearWorkDir(workDir); err != nil {
 		log.Fatalf("%+v", err)
 	}
-	if vdb, err = vectodb.NewVectoDB(workDir, siftDim, siftMetric, siftIndexKey, siftQueryParams, distThr, flatThreshold); err != nil {
+	if vdb, err = vectodb.NewVectoDB(workDir, siftDim, siftMetric, siftIndexKey, siftQueryParams, distThr, flatThreshold, 0); err != nil {
 		log.Fatalf("%+v", err)
 	}
 
@@ -232,7 +234,7 @@ func main() {
 	//if err = vectodb.VectodbClearWorkDir(workDir); err != nil {
 	//	log.Fatalf("%+v", err)
 	//}
-	if vdb, err = vectodb.NewVectoDB(workDir, siftDim, siftMetric, siftIndexKey, siftQueryParams, distThr, flatThreshold); err != nil {
+	if vdb, err = vectodb.NewVectoDB(workDir, siftDim, siftMetric, siftIndexKey, siftQueryParams, distThr, flatThreshold, 0); err != nil {
 		log.Fatalf("%+v", err)
 	}
 
@@ -70,7 +70,7 @@ func main() {
 
 	var err error
 	var vdbl *vectodb.VectoDBLite
-	if vdbl, err = vectodb.NewVectoDBLite(redisAddr, 0, siftDim, distThr, sizeLimit); err != nil {
+	if vdbl, err = vectodb.NewVectoDBLite(redisAddr, 0, siftDim, distThr, sizeLimit, false); err != nil {
 		err = errors.Wrapf(err, "")
 		log.Fatalf("%+v", err)
 	}
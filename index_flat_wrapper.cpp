@@ -1,6 +1,10 @@
 
 #include "index_flat_wrapper.h"
+#include "faiss/AutoTune.h"
 #include "faiss/IndexFlat.h"
+#include "faiss/IndexIVF.h"
+#include "faiss/VectorTransform.h"
+#include <algorithm>
 #include <boost/thread/shared_mutex.hpp>
 #include <mutex>
 #include <pthread.h>
@@ -13,10 +17,15 @@ using mtxlock = unique_lock<mutex>;
 using rlock = unique_lock<boost::shared_mutex>;
 using wlock = boost::shared_lock<boost::shared_mutex>;
 
+// faiss warns if there're less than 39 training points per centroid.
+const long MIN_POINTS_PER_CENTROID = 39L;
+// the number of training points of indexes other than IVF, the same as VectoDB.
+const long MIN_NTRAIN = 10000L;
+
 struct IndexFlatWrapper {
     float dist_threshold;
     boost::shared_mutex rw_flat;
-    faiss::IndexFlat* flat;
+    faiss::Index* flat;
     unordered_map<uint64_t, uint64_t> xid2num;
     vector<uint64_t> xids; //vector of xid of all vectors
 };
@@ -29,6 +38,63 @@ void* IndexFlatNew(long dim, float dist_threshold)
     return ifw;
 }
 
+// getIndexIVF returns the IVF index of the given index, or nullptr if it's not an IVF one.
+static faiss::IndexIVF* getIndexIVF(faiss::Index* index)
+{
+    auto index_pt = dynamic_cast<faiss::IndexPreTransform*>(index);
+    if (index_pt != nullptr)
+        index = index_pt->index;
+    return dynamic_cast<faiss::IndexIVF*>(index);
+}
+
+void* IndexFlatNewWithKey(long dim, float dist_threshold, char* index_key)
+{
+    faiss::Index* index = nullptr;
+    try {
+        index = faiss::index_factory(dim, index_key, faiss::METRIC_INNER_PRODUCT);
+    } catch (std::exception& e) {
+        return nullptr;
+    }
+    auto index_ivf = getIndexIVF(index);
+    if (index_ivf != nullptr) {
+        index_ivf->cp.min_points_per_centroid = 5; //quiet warning
+    }
+    IndexFlatWrapper* ifw = new IndexFlatWrapper();
+    ifw->dist_threshold = dist_threshold;
+    ifw->flat = index;
+    return ifw;
+}
+
+long IndexFlatMinTrain(void* ifwIn)
+{
+    IndexFlatWrapper* ifw = static_cast<IndexFlatWrapper*>(ifwIn);
+    rlock r{ ifw->rw_flat };
+    if (ifw->flat->is_trained)
+        return 0;
+    auto index_ivf = getIndexIVF(ifw->flat);
+    if (index_ivf != nullptr)
+        return MIN_POINTS_PER_CENTROID * index_ivf->nlist;
+    return MIN_NTRAIN;
+}
+
+void IndexFlatTrain(void* ifwIn, long n, float* x)
+{
+    IndexFlatWrapper* ifw = static_cast<IndexFlatWrapper*>(ifwIn);
+    wlock w{ ifw->rw_flat };
+    ifw->flat->train(n, x);
+}
+
+int IndexFlatValidateKey(long dim, char* index_key)
+{
+    try {
+        faiss::Index* index = faiss::index_factory(dim, index_key, faiss::METRIC_INNER_PRODUCT);
+        delete index;
+    } catch (std::exception& e) {
+        return 0;
+    }
+    return 1;
+}
+
 void IndexFlatDelete(void* ifwIn)
 {
     IndexFlatWrapper* ifw = static_cast<IndexFlatWrapper*>(ifwIn);
@@ -64,3 +130,38 @@ void IndexFlatSearch(void* ifwIn, long nq, float* xq, float* distances, unsigned
         }
     }
 }
+
+void IndexFlatSearchTopK(void* ifwIn, long nq, float* xq, long k, long nprobe, float* distances, unsigned long* xids)
+{
+    IndexFlatWrapper* ifw = static_cast<IndexFlatWrapper*>(ifwIn);
+    rlock r{ ifw->rw_flat };
+    // rlock is exclusive, so the nprobe of the index can be overridden for this search only.
+    auto index_ivf = getIndexIVF(ifw->flat);
+    size_t nprobe_old = 0;
+    if (index_ivf != nullptr && nprobe > 0) {
+        nprobe_old = index_ivf->nprobe;
+        index_ivf->nprobe = std::min((size_t)nprobe, index_ivf->nlist);
+    }
+    ifw->flat->search(nq, xq, k, distances, (long*)xids);
+    if (nprobe_old != 0)
+        index_ivf->nprobe = nprobe_old;
+    for (long i = 0; i < nq * k; i++) {
+        long num = (long)xids[i];
+        if (num < 0) {
+            xids[i] = uint64_t(-1);
+        } else {
+            xids[i] = ifw->xids[num];
+        }
+    }
+}
+
+long IndexFlatGetXids(void* ifwIn, long capacity, unsigned long* xids)
+{
+    IndexFlatWrapper* ifw = static_cast<IndexFlatWrapper*>(ifwIn);
+    rlock r{ ifw->rw_flat };
+    long ntotal = ifw->xids.size();
+    for (long i = 0; i < ntotal && i < capacity; i++) {
+        xids[i] = ifw->xids[i];
+    }
+    return ntotal;
+}
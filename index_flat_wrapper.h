@@ -4,11 +4,25 @@
 extern "C" {
 #endif
 
-// IndexFlatWrapper is a thin wrapper of faiss::IndexFlat. Only supports metric type 0 - METRIC_INNER_PRODUCT.
+// IndexFlatWrapper is a thin wrapper of faiss::IndexFlat, or any faiss index built by faiss::index_factory. Only supports metric type 0 - METRIC_INNER_PRODUCT.
 void* IndexFlatNew(long dim, float dist_threshold);
+// IndexFlatNewWithKey builds the index with faiss::index_factory. Returns NULL if index_key is invalid for the dim.
+void* IndexFlatNewWithKey(long dim, float dist_threshold, char* index_key);
+// IndexFlatMinTrain returns the number of vectors needed to train the index. It's 0 if the index needn't training.
+long IndexFlatMinTrain(void* ifw);
+void IndexFlatTrain(void* ifw, long n, float* x);
+// IndexFlatValidateKey returns 1 if index_key is valid for the dim, otherwise 0.
+int IndexFlatValidateKey(long dim, char* index_key);
 void IndexFlatDelete(void* ifw);
 void IndexFlatAddWithIds(void* ifw, long nb, float* xb, unsigned long* xids);
 void IndexFlatSearch(void* ifw, long nq, float* xq, float* distances, unsigned long* xids);
+// IndexFlatSearchTopK searches k nearest neighbors of each query without distance threshold.
+// Results of each query are in descending order of distance. Missing neighbors' xid are uint64(-1).
+// nprobe overrides the nprobe of an IVF index for this search, it's ignored if <= 0 or the index is not IVF.
+void IndexFlatSearchTopK(void* ifw, long nq, float* xq, long k, long nprobe, float* distances, unsigned long* xids);
+// IndexFlatGetXids copies at most capacity xids of the vectors in the index, and returns the number of vectors.
+// A xid added multiple times appears multiple times.
+long IndexFlatGetXids(void* ifw, long capacity, unsigned long* xids);
 
 #ifdef __cplusplus
 }
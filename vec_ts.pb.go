@@ -1,15 +1,6 @@
 // Code generated by protoc-gen-gogo. DO NOT EDIT.
 // source: vec_ts.proto
 
-/*
-	Package vectodb is a generated protocol buffer package.
-
-	It is generated from these files:
-		vec_ts.proto
-
-	It has these top-level messages:
-		VecTimestamp
-*/
 package vectodb
 
 import proto "github.com/golang/protobuf/proto"
@@ -33,14 +24,49 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type VecTimestamp struct {
-	Vec      []float32 `protobuf:"fixed32,1,rep,packed,name=Vec,json=vec" json:"Vec,omitempty"`
-	ExpireAt int64     `protobuf:"varint,2,opt,name=ExpireAt,json=expireAt,proto3" json:"ExpireAt,omitempty"`
+	Vec                  []float32 `protobuf:"fixed32,1,rep,packed,name=Vec,json=vec,proto3" json:"Vec,omitempty"`
+	ExpireAt             int64     `protobuf:"varint,2,opt,name=ExpireAt,json=expireAt,proto3" json:"ExpireAt,omitempty"`
+	Group                uint64    `protobuf:"varint,3,opt,name=Group,json=group,proto3" json:"Group,omitempty"`
+	Deleted              bool      `protobuf:"varint,4,opt,name=Deleted,json=deleted,proto3" json:"Deleted,omitempty"`
+	Weight               float32   `protobuf:"fixed32,5,opt,name=Weight,json=weight,proto3" json:"Weight,omitempty"`
+	Ttl                  int64     `protobuf:"varint,6,opt,name=Ttl,json=ttl,proto3" json:"Ttl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *VecTimestamp) Reset()         { *m = VecTimestamp{} }
+func (m *VecTimestamp) String() string { return proto.CompactTextString(m) }
+func (*VecTimestamp) ProtoMessage()    {}
+func (*VecTimestamp) Descriptor() ([]byte, []int) {
+	return fileDescriptor_vec_ts_cd961e57fc655b55, []int{0}
+}
+func (m *VecTimestamp) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *VecTimestamp) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_VecTimestamp.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (dst *VecTimestamp) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_VecTimestamp.Merge(dst, src)
+}
+func (m *VecTimestamp) XXX_Size() int {
+	return m.Size()
+}
+func (m *VecTimestamp) XXX_DiscardUnknown() {
+	xxx_messageInfo_VecTimestamp.DiscardUnknown(m)
 }
 
-func (m *VecTimestamp) Reset()                    { *m = VecTimestamp{} }
-func (m *VecTimestamp) String() string            { return proto.CompactTextString(m) }
-func (*VecTimestamp) ProtoMessage()               {}
-func (*VecTimestamp) Descriptor() ([]byte, []int) { return fileDescriptorVecTs, []int{0} }
+var xxx_messageInfo_VecTimestamp proto.InternalMessageInfo
 
 func init() {
 	proto.RegisterType((*VecTimestamp)(nil), "vectodb.VecTimestamp")
@@ -75,6 +101,35 @@ func (m *VecTimestamp) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintVecTs(dAtA, i, uint64(m.ExpireAt))
 	}
+	if m.Group != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintVecTs(dAtA, i, uint64(m.Group))
+	}
+	if m.Deleted {
+		dAtA[i] = 0x20
+		i++
+		if m.Deleted {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.Weight != 0 {
+		dAtA[i] = 0x2d
+		i++
+		encoding_binary.LittleEndian.PutUint32(dAtA[i:], uint32(math.Float32bits(float32(m.Weight))))
+		i += 4
+	}
+	if m.Ttl != 0 {
+		dAtA[i] = 0x30
+		i++
+		i = encodeVarintVecTs(dAtA, i, uint64(m.Ttl))
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
 	return i, nil
 }
 
@@ -88,6 +143,9 @@ func encodeVarintVecTs(dAtA []byte, offset int, v uint64) int {
 	return offset + 1
 }
 func (m *VecTimestamp) Size() (n int) {
+	if m == nil {
+		return 0
+	}
 	var l int
 	_ = l
 	if len(m.Vec) > 0 {
@@ -96,6 +154,21 @@ func (m *VecTimestamp) Size() (n int) {
 	if m.ExpireAt != 0 {
 		n += 1 + sovVecTs(uint64(m.ExpireAt))
 	}
+	if m.Group != 0 {
+		n += 1 + sovVecTs(uint64(m.Group))
+	}
+	if m.Deleted {
+		n += 2
+	}
+	if m.Weight != 0 {
+		n += 5
+	}
+	if m.Ttl != 0 {
+		n += 1 + sovVecTs(uint64(m.Ttl))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
 	return n
 }
 
@@ -174,6 +247,11 @@ func (m *VecTimestamp) Unmarshal(dAtA []byte) error {
 				if postIndex > l {
 					return io.ErrUnexpectedEOF
 				}
+				var elementCount int
+				elementCount = packedLen / 4
+				if elementCount != 0 && len(m.Vec) == 0 {
+					m.Vec = make([]float32, 0, elementCount)
+				}
 				for iNdEx < postIndex {
 					var v uint32
 					if (iNdEx + 4) > l {
@@ -206,6 +284,75 @@ func (m *VecTimestamp) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Group", wireType)
+			}
+			m.Group = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowVecTs
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Group |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Deleted", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowVecTs
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Deleted = bool(v != 0)
+		case 5:
+			if wireType != 5 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Weight", wireType)
+			}
+			var v uint32
+			if (iNdEx + 4) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint32(encoding_binary.LittleEndian.Uint32(dAtA[iNdEx:]))
+			iNdEx += 4
+			m.Weight = float32(math.Float32frombits(v))
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Ttl", wireType)
+			}
+			m.Ttl = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowVecTs
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Ttl |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipVecTs(dAtA[iNdEx:])
@@ -218,6 +365,7 @@ func (m *VecTimestamp) Unmarshal(dAtA []byte) error {
 			if (iNdEx + skippy) > l {
 				return io.ErrUnexpectedEOF
 			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
 			iNdEx += skippy
 		}
 	}
@@ -332,18 +480,22 @@ var (
 	ErrIntOverflowVecTs   = fmt.Errorf("proto: integer overflow")
 )
 
-func init() { proto.RegisterFile("vec_ts.proto", fileDescriptorVecTs) }
+func init() { proto.RegisterFile("vec_ts.proto", fileDescriptor_vec_ts_cd961e57fc655b55) }
 
-var fileDescriptorVecTs = []byte{
-	// 145 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x29, 0x4b, 0x4d, 0x8e,
-	0x2f, 0x29, 0xd6, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2f, 0x4b, 0x4d, 0x2e, 0xc9, 0x4f,
-	0x49, 0x92, 0x12, 0x49, 0xcf, 0x4f, 0xcf, 0x07, 0x8b, 0xe9, 0x83, 0x58, 0x10, 0x69, 0x25, 0x1b,
-	0x2e, 0x9e, 0xb0, 0xd4, 0xe4, 0x90, 0xcc, 0xdc, 0xd4, 0xe2, 0x92, 0xc4, 0xdc, 0x02, 0x21, 0x01,
-	0x2e, 0xe6, 0xb0, 0xd4, 0x64, 0x09, 0x46, 0x05, 0x66, 0x0d, 0xa6, 0x20, 0xe6, 0xb2, 0xd4, 0x64,
-	0x21, 0x29, 0x2e, 0x0e, 0xd7, 0x8a, 0x82, 0xcc, 0xa2, 0x54, 0xc7, 0x12, 0x09, 0x26, 0x05, 0x46,
-	0x0d, 0xe6, 0x20, 0x8e, 0x54, 0x28, 0xdf, 0x49, 0xe4, 0xc4, 0x43, 0x39, 0x86, 0x13, 0x8f, 0xe4,
-	0x18, 0x2f, 0x3c, 0x92, 0x63, 0x7c, 0xf0, 0x48, 0x8e, 0x71, 0xc6, 0x63, 0x39, 0x86, 0x24, 0x36,
-	0xb0, 0xd1, 0xc6, 0x80, 0x00, 0x00, 0x00, 0xff, 0xff, 0x16, 0xce, 0xf0, 0x91, 0x89, 0x00, 0x00,
-	0x00,
+var fileDescriptor_vec_ts_cd961e57fc655b55 = []byte{
+	// 218 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x2c, 0xce, 0xc1, 0x4a, 0xc4, 0x30,
+	0x10, 0x06, 0xe0, 0x9d, 0x66, 0x9b, 0x96, 0xb0, 0x07, 0x09, 0x45, 0xc2, 0x1e, 0x42, 0xf0, 0x94,
+	0x93, 0x1e, 0x7c, 0x02, 0x45, 0xf1, 0x1e, 0x96, 0xf5, 0x28, 0x6e, 0x3a, 0xc4, 0x42, 0x97, 0x84,
+	0xee, 0x58, 0x7d, 0x12, 0xf1, 0x91, 0xf6, 0xe8, 0x23, 0x68, 0x7d, 0x11, 0x69, 0xd6, 0xdb, 0x7c,
+	0x33, 0x30, 0xff, 0x2f, 0x56, 0x23, 0xfa, 0x27, 0x3a, 0x5c, 0xa6, 0x21, 0x52, 0x94, 0xd5, 0x88,
+	0x9e, 0x62, 0xbb, 0x5b, 0x37, 0x21, 0x86, 0x98, 0x77, 0x57, 0xf3, 0x74, 0x3a, 0x5f, 0x7c, 0x80,
+	0x58, 0x6d, 0xd1, 0x6f, 0xba, 0x3d, 0x1e, 0xe8, 0x79, 0x9f, 0xe4, 0x99, 0x60, 0x5b, 0xf4, 0x0a,
+	0x0c, 0xb3, 0x85, 0x63, 0x23, 0x7a, 0xb9, 0x16, 0xf5, 0xfd, 0x7b, 0xea, 0x06, 0xbc, 0x21, 0x55,
+	0x18, 0xb0, 0xcc, 0xd5, 0xf8, 0x6f, 0xd9, 0x88, 0xf2, 0x61, 0x88, 0xaf, 0x49, 0x31, 0x03, 0x76,
+	0xe9, 0xca, 0x30, 0x43, 0x2a, 0x51, 0xdd, 0x61, 0x8f, 0x84, 0xad, 0x5a, 0x1a, 0xb0, 0xb5, 0xab,
+	0xda, 0x13, 0xe5, 0xb9, 0xe0, 0x8f, 0xd8, 0x85, 0x17, 0x52, 0xa5, 0x01, 0x5b, 0x38, 0xfe, 0x96,
+	0x35, 0xa7, 0x6e, 0xa8, 0x57, 0x3c, 0xbf, 0x67, 0x44, 0xfd, 0x6d, 0x73, 0xfc, 0xd1, 0x8b, 0xe3,
+	0xa4, 0xe1, 0x6b, 0xd2, 0xf0, 0x3d, 0x69, 0xf8, 0xfc, 0xd5, 0x8b, 0x1d, 0xcf, 0xad, 0xaf, 0xff,
+	0x06, 0x00, 0x4f, 0x93, 0x19, 0x7e, 0xe4, 0x00, 0x00, 0x00,
 }
@@ -2,10 +2,17 @@
 #include "vectodb.h"
 
 #include "faiss/AutoTune.h"
+#include "faiss/AuxIndexStructures.h"
+#include "faiss/FaissException.h"
 #include "faiss/IndexFlat.h"
 #include "faiss/IndexHNSW.h"
 #include "faiss/IndexIVFFlat.h"
+#include "faiss/IndexIVFPQ.h"
+#include "faiss/IndexPQ.h"
+#include "faiss/IndexScalarQuantizer.h"
+#include "faiss/VectorTransform.h"
 #include "faiss/index_io.h"
+#include "faiss/utils.h"
 
 #include <boost/filesystem.hpp>
 #include <boost/system/system_error.hpp>
@@ -22,13 +29,18 @@
 #include <mutex>
 #include <pthread.h>
 #include <sstream>
+#include <stdexcept>
 #include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
 #include <string>
 #include <sys/mman.h>
 #include <sys/stat.h>
 #include <sys/time.h>
 #include <system_error>
+#include <thread>
 #include <unordered_map>
+#include <unordered_set>
 #include <vector>
 
 using namespace std;
@@ -39,6 +51,13 @@ using wlock = boost::shared_lock<boost::shared_mutex>;
 
 const long MIN_NTRAIN = 10000L;
 const long MAX_NTRAIN = 160000L; //the number of training points which IVF4096 needs for 1M dataset
+// The default number of threads of a flat scan is the number of CPUs, capped so that concurrent searches don't oversubscribe big hosts.
+const long FLAT_MAX_DEFAULT_THREADS = 8L;
+
+static long defaultFlatThreads()
+{
+    return std::max(1L, std::min(FLAT_MAX_DEFAULT_THREADS, long(std::thread::hardware_concurrency())));
+}
 
 struct DbState {
     DbState()
@@ -49,6 +68,10 @@ struct DbState {
         , index(nullptr)
         , flat(nullptr)
         , flat_start_num(0)
+        , nremoved(0)
+        , rerank_float64(false)
+        , normalize(false)
+        , flat_threads(defaultFlatThreads())
     {
     }
     ~DbState()
@@ -66,6 +89,9 @@ struct DbState {
     // Main activities in decreasing priority: insert, search, build and activate index.
     // Normally index is large, the read-lock (search) time is long(~26s for 10K searchs of sift),
     // the write-lock (activate index) just protects a pointer assignment.
+    // A search holds it across searching index and flat, and activating an index swaps index and flat together under it.
+    // So a search during building sees either the old pair or the new pair, never a mix of them. Lock order: rw_index, rw_flat.
+    // Plain searches share it, so that they search the index in parallel. Anything mutating the index holds it with unique_lock.
     boost::shared_mutex rw_index;
     long ntrain; // the number of training points of the index
     faiss::Index* index;
@@ -80,29 +106,43 @@ struct DbState {
     boost::shared_mutex rw_xids;
     unordered_map<long, long> xid2num;
     vector<long> xids; //vector of xid of all vectors
+    unordered_set<long> removed; //line nums of removed vectors, whose count at base.fvecs is 0
+    atomic<long> nremoved;
 
     mutex m_update;
     std::fstream fs_update; //for append, sequential read and truncate of update.fvecs
 
     mutex m_base2;
     std::fstream fs_base2; //for random write of base.fvecs
+
+    atomic<bool> rerank_float64; //compute distances of the reranked candidates in float64
+    atomic<bool> normalize; //L2-normalize added and query vectors
+    atomic<long> flat_threads; //number of threads splitting a flat scan
 };
 
+// A thread of flat scans takes at least this many vectors, so that small flats are not slowed down by spawning threads.
+const long FLAT_MIN_PER_THREAD = 4096;
+
+// A snapshot is: <magic> <dim> <metric_type> <len_index_key> <index_key> <ntrain> {<len_file> <file>} of the index, base.fvecs and update.fvecs.
+const char SNAPSHOT_MAGIC[8] = { 'V', 'D', 'B', 'S', 'N', 'A', 'P', '1' };
+
 struct VecExt {
     long count;
     vector<float> vec;
 };
 
-VectoDB::VectoDB(const char* work_dir_in, long dim_in, int metric_type_in, const char* index_key_in, const char* query_params_in, float dist_threshold_in)
+VectoDB::VectoDB(const char* work_dir_in, long dim_in, int metric_type_in, const char* index_key_in, const char* query_params_in, float dist_threshold_in, int seed_in)
     : work_dir(work_dir_in)
     , dim(dim_in)
     , len_vec(dim * sizeof(float))
     , len_base_line(2 * sizeof(long) + len_vec)
     , len_upd_line(sizeof(long) + len_vec)
-    , metric_type(metric_type_in)
+    , metric_type(metric_type_in == METRIC_COSINE ? 0 : metric_type_in)
+    , cosine(metric_type_in == METRIC_COSINE)
     , dist_threshold(dist_threshold_in)
     , index_key(index_key_in)
     , query_params(query_params_in)
+    , seed(seed_in)
 {
     static_assert(sizeof(float) == 4, "sizeof(float) must be 4");
     static_assert(sizeof(long) > 4, "sizeof(long) must be larger than 4");
@@ -112,11 +152,15 @@ VectoDB::VectoDB(const char* work_dir_in, long dim_in, int metric_type_in, const
 
     auto st{ std::make_unique<DbState>() }; //Make DbState be exception safe
     state = std::move(st); // equivalent to state.reset(st.release());
+    state->normalize = cosine;
     fs::create_directories(dir);
     //filename spec: base.fvecs, <index_key>.<ntrain>.index
     //line spec of base.fvecs: <xid> <count> {<dim>}<float>
     //line spec of update.fvecs: <line_num_at_base> {<dim>}<float>
     const string& fp_base = getBaseFp();
+    // xids are appended to base.fvecs along with vectors, so base.fvecs is also the log of the xid map.
+    // A crash in the middle of appending could leave a partial line which shall be dropped before appending more.
+    truncatePartialLine(fp_base, len_base_line);
     //Loading database
     //https://stackoverflow.com/questions/31483349/how-can-i-open-a-file-for-reading-writing-creating-it-if-it-does-not-exist-w
     state->fs_base.exceptions(std::ios::failbit | std::ios::badbit);
@@ -138,11 +182,19 @@ VectoDB::VectoDB(const char* work_dir_in, long dim_in, int metric_type_in, const
     vector<long> xids;
     readXids(state->data, state->total, 0, xids);
     for (long i = 0; i < (long)xids.size(); i++) {
+        if (*(long*)(state->data + i * len_base_line + sizeof(long)) == 0) {
+            state->removed.insert(i);
+            continue;
+        }
         state->xid2num[xids[i]] = i;
     }
     state->xids = std::move(xids);
+    state->nremoved = state->removed.size();
+    if (!state->removed.empty())
+        removeFromIndex(state->index, state->flat_start_num, vector<long>(state->removed.begin(), state->removed.end()));
 
     const string& fp_update = getUpdateFp();
+    truncatePartialLine(fp_update, len_upd_line);
     state->fs_update.exceptions(std::ios::failbit | std::ios::badbit);
     state->fs_update.open(fp_update, std::fstream::out | std::fstream::app);
     state->fs_update.close();
@@ -167,10 +219,16 @@ VectoDB::~VectoDB()
 }
 
 void VectoDB::BuildIndex(long cur_ntrain, long cur_nsize, faiss::Index*& index_out, long& ntrain) const
+{
+    BuildIndex(index_key, query_params, cur_ntrain, cur_nsize, index_out, ntrain);
+}
+
+void VectoDB::BuildIndex(const string& index_key, const string& query_params, long cur_ntrain, long cur_nsize, faiss::Index*& index_out, long& ntrain) const
 {
     index_out = nullptr;
     ntrain = 0;
     if (0 == index_key.compare("Flat")) {
+        // all vectors stay in the flat and are searched by brute force, there's nothing to train or build.
         return;
     }
 
@@ -205,10 +263,36 @@ void VectoDB::BuildIndex(long cur_ntrain, long cur_nsize, faiss::Index*& index_o
         LOG(INFO) << "Training on " << nt << " vectors. cur_ntrain is " << cur_ntrain;
         index = faiss::index_factory(dim, index_key.c_str(), metric_type == 0 ? faiss::METRIC_INNER_PRODUCT : faiss::METRIC_L2);
         // according to faiss/benchs/bench_hnsw.py, ivf_hnsw_quantizer.
-        auto index_ivf = dynamic_cast<faiss::IndexIVFFlat*>(index);
+        faiss::IndexIVF* index_ivf = dynamic_cast<faiss::IndexIVFFlat*>(index);
+        if (index_ivf == nullptr)
+            index_ivf = dynamic_cast<faiss::IndexIVFScalarQuantizer*>(index);
         if (index_ivf != nullptr) {
             index_ivf->cp.min_points_per_centroid = 5; //quiet warning
             index_ivf->quantizer_trains_alone = 2;
+            // index_factory makes IVFFlat spherical for inner product, but not IVFSQ.
+            index_ivf->cp.spherical = metric_type == 0;
+        }
+        if (quantizer != nullptr) {
+            auto ivf = dynamic_cast<faiss::IndexIVF*>(index);
+            if (ivf != nullptr && quantizer->d == dim && ivf->nlist == (size_t)quantizer->ntotal) {
+                // train_q1 skips a quantizer which is trained and has nlist centroids
+                LOG(INFO) << "Reusing the given quantizer of " << quantizer->ntotal << " centroids";
+                if (ivf->own_fields)
+                    delete ivf->quantizer;
+                ivf->quantizer = faiss::clone_index(quantizer.get());
+                ivf->own_fields = true;
+            } else {
+                LOG(WARNING) << "Ignored the given quantizer of " << quantizer->ntotal << " centroids since it doesn't match index_key " << index_key;
+            }
+        }
+        if (seed != 0) {
+            // k-means samples initial centroids randomly. The training points are always the first nt ones.
+            auto ivf = dynamic_cast<faiss::IndexIVF*>(index);
+            if (ivf != nullptr)
+                ivf->cp.seed = seed;
+            auto ivfpq = dynamic_cast<faiss::IndexIVFPQ*>(index);
+            if (ivfpq != nullptr)
+                ivfpq->pq.cp.seed = seed;
         }
         // Training
         vector<float> base;
@@ -234,6 +318,51 @@ quit:
 }
 
 void VectoDB::ActivateIndex(faiss::Index* index, long ntrain)
+{
+    activateIndex(index, ntrain, "");
+}
+
+int VectoDB::LoadIndex(const char* fp)
+{
+    // Load the copy in work_dir rather than mapping fp, so that the index doesn't change along with fp, nor break if fp is removed.
+    const string fp_staged = work_dir + "/load.index.tmp";
+    faiss::Index* index = nullptr;
+    try {
+        fs::remove(fp_staged);
+        fs::copy_file(fp, fp_staged);
+        index = faiss::read_index(fp_staged.c_str());
+    } catch (const std::exception& e) {
+        LOG(ERROR) << "LoadIndex " << work_dir << " failed to read " << fp << ". " << e.what();
+        fs::remove(fp_staged);
+        return -1;
+    }
+    // Labels of the index are line numbers of base.fvecs, so that it shall be built over a prefix of the base.
+    const faiss::MetricType metric = metric_type == 0 ? faiss::METRIC_INNER_PRODUCT : faiss::METRIC_L2;
+    const long total = state->total;
+    if (index->d != dim || index->metric_type != metric || !index->is_trained) {
+        LOG(ERROR) << "LoadIndex " << work_dir << " " << fp << " has dim " << index->d << ", metric " << index->metric_type << ", is_trained " << index->is_trained
+                   << ", want dim " << dim << ", metric " << metric;
+        delete index;
+        fs::remove(fp_staged);
+        return 0;
+    }
+    if (index->ntotal > total) {
+        LOG(ERROR) << "LoadIndex " << work_dir << " " << fp << " has ntotal " << index->ntotal << ", want at most " << total;
+        delete index;
+        fs::remove(fp_staged);
+        return -2;
+    }
+    // the same as the one BuildIndex trains over ntotal vectors, so that later builds reuse the index
+    const long nb = index->ntotal;
+    const long ntrain = std::min(nb, std::max(nb / 10, MAX_NTRAIN));
+    LOG(INFO) << "LoadIndex " << work_dir << " " << fp << ", ntotal " << nb << ", ntrain " << ntrain;
+    activateIndex(index, ntrain, fp_staged);
+    fs::remove(fp_staged);
+    return 1;
+}
+
+// activateIndex is the same as ActivateIndex, except that the index file is copied from fp_index if it's not empty, rather than written from index.
+void VectoDB::activateIndex(faiss::Index* index, long ntrain, const string& fp_index)
 {
     const string& fp_base = getBaseFp();
     mtxlock m{ state->m_base };
@@ -247,30 +376,116 @@ void VectoDB::ActivateIndex(faiss::Index* index, long ntrain)
 
     long index_size = 0;
     if (index != nullptr) {
+        const string& fp_tmp = getIndexFp(ntrain) + ".tmp";
+        if (!fp_index.empty()) {
+            // fp_index could be one of the index files to clear
+            fs::remove(fp_tmp);
+            fs::copy_file(fp_index, fp_tmp);
+        }
         clearIndexFiles();
         // Output index
-        faiss::write_index(index, getIndexFp(ntrain).c_str());
+        if (fp_index.empty())
+            faiss::write_index(index, getIndexFp(ntrain).c_str());
+        else
+            fs::rename(fp_tmp, getIndexFp(ntrain));
         index_size = index->ntotal;
+        // The index file keeps removed vectors, which are filtered out by searches after loading.
+        if (!state->removed.empty())
+            removeFromIndex(index, index_size, vector<long>(state->removed.begin(), state->removed.end()));
     }
 
-    {
-        wlock w{ state->rw_index };
-        delete state->index;
-        state->ntrain = ntrain;
-        state->index = index;
-    }
-
+    // Adds are blocked by m_base, so the new flat contains all vectors not in the index.
     faiss::Index* flat = new faiss::IndexFlat(dim, metric_type == 0 ? faiss::METRIC_INNER_PRODUCT : faiss::METRIC_L2);
     vector<float> base;
     readBase(state->data, nb, index_size, base);
     flat->add(base.size() / dim, &base[0]);
 
+    unique_lock<boost::shared_mutex> w{ state->rw_index };
     wlock l{ state->rw_flat };
+    delete state->index;
+    state->ntrain = ntrain;
+    state->index = index;
     delete state->flat;
     state->flat = flat;
     state->flat_start_num = index_size;
 }
 
+void VectoDB::SetIndexKey(const char* index_key_in, const char* query_params_in)
+{
+    // index files of the former index_key would be picked up on reopening if it's changed back
+    clearIndexFiles();
+    index_key = index_key_in;
+    query_params = query_params_in;
+}
+
+void VectoDB::Reset()
+{
+    mtxlock m1{ state->m_base };
+    mtxlock m2{ state->m_update };
+    mtxlock m3{ state->m_base2 };
+    rlock r1{ state->rw_index };
+    rlock r2{ state->rw_flat };
+    rlock r3{ state->rw_data };
+    rlock r4{ state->rw_xids };
+    const string& fp_base = getBaseFp();
+    munmapFile(fp_base, state->data, state->len_data);
+    state->fs_base.close();
+    state->fs_base.open(fp_base, std::fstream::in | std::fstream::out | std::fstream::binary | std::fstream::trunc);
+    state->fs_base2.close();
+    state->fs_base2.open(fp_base, std::fstream::in | std::fstream::out | std::fstream::binary);
+    state->fs_update.close();
+    state->fs_update.open(getUpdateFp(), std::fstream::in | std::fstream::out | std::fstream::binary | std::fstream::trunc);
+    clearIndexFiles();
+
+    delete state->index;
+    state->index = nullptr;
+    state->ntrain = 0;
+    delete state->flat;
+    state->flat = new faiss::IndexFlat(dim, metric_type == 0 ? faiss::METRIC_INNER_PRODUCT : faiss::METRIC_L2);
+    state->flat_start_num = 0;
+    state->total = 0;
+    state->xids.clear();
+    state->xid2num.clear();
+    state->removed.clear();
+    state->nremoved = 0;
+    LOG(INFO) << "Reset " << work_dir;
+}
+
+// indexMemoryUsage estimates the memory of the vectors, codes and trained tables of index.
+static long indexMemoryUsage(const faiss::Index* index)
+{
+    if (index == nullptr)
+        return 0;
+    if (auto flat = dynamic_cast<const faiss::IndexFlat*>(index))
+        return flat->xb.size() * sizeof(float);
+    if (auto ivf = dynamic_cast<const faiss::IndexIVF*>(index)) {
+        // centroids of the coarse quantizer, and codes along with ids of the inverted lists
+        long bytes = indexMemoryUsage(ivf->quantizer);
+        for (size_t i = 0; i < ivf->nlist; i++)
+            bytes += ivf->invlists->list_size(i) * (ivf->code_size + sizeof(faiss::Index::idx_t));
+        if (auto ivfpq = dynamic_cast<const faiss::IndexIVFPQ*>(index))
+            bytes += (ivfpq->pq.centroids.size() + ivfpq->precomputed_table.size()) * sizeof(float);
+        if (auto ivfsq = dynamic_cast<const faiss::IndexIVFScalarQuantizer*>(index))
+            bytes += ivfsq->sq.trained.size() * sizeof(float);
+        return bytes;
+    }
+    if (auto hnsw = dynamic_cast<const faiss::IndexHNSW*>(index))
+        return hnsw->hnsw.neighbors.size() * sizeof(faiss::HNSW::storage_idx_t) + indexMemoryUsage(hnsw->storage);
+    if (auto pq = dynamic_cast<const faiss::IndexPQ*>(index))
+        return pq->codes.size() + pq->pq.centroids.size() * sizeof(float);
+    if (auto sq = dynamic_cast<const faiss::IndexScalarQuantizer*>(index))
+        return sq->codes.size() + sq->sq.trained.size() * sizeof(float);
+    // other index types are assumed to keep vectors uncompressed
+    return index->ntotal * index->d * sizeof(float);
+}
+
+long VectoDB::GetMemoryUsage() const
+{
+    rlock r{ state->rw_index };
+    rlock l{ state->rw_flat };
+    return indexMemoryUsage(state->index) + indexMemoryUsage(state->flat);
+}
+
 void VectoDB::GetIndexSize(long& ntrain, long& nsize) const
 {
     rlock r{ state->rw_index };
@@ -279,7 +494,8 @@ void VectoDB::GetIndexSize(long& ntrain, long& nsize) const
         nsize = 0;
     } else {
         ntrain = state->ntrain;
-        nsize = state->index->ntotal;
+        // index->ntotal excludes the removed vectors
+        nsize = state->flat_start_num;
     }
 }
 
@@ -296,8 +512,20 @@ long VectoDB::GetFlatSize()
     return nflat;
 }
 
+long VectoDB::GetTotalSize() const
+{
+    rlock r{ state->rw_index };
+    rlock l{ state->rw_flat };
+    long ntotal = state->flat->ntotal;
+    if (state->index != nullptr)
+        ntotal += state->index->ntotal;
+    return ntotal;
+}
+
 void VectoDB::AddWithIds(long nb, const float* xb, const long* xids)
 {
+    vector<float> normalized_buf;
+    xb = normalized(nb, xb, normalized_buf);
     long len_buf = nb * len_base_line;
     std::vector<char> buf(len_buf);
     for (long i = 0; i < nb; i++) {
@@ -327,6 +555,8 @@ void VectoDB::AddWithIds(long nb, const float* xb, const long* xids)
 
 void VectoDB::UpdateWithIds(long nb, const float* xb, const long* xids)
 {
+    vector<float> normalized_buf;
+    xb = normalized(nb, xb, normalized_buf);
     long len_buf = nb * len_upd_line;
     std::vector<char> buf(len_buf);
     int pos = 0;
@@ -400,6 +630,10 @@ long VectoDB::UpdateBase()
             long line_pos = line_num * len_base_line;
             long pos = line_pos + sizeof(long);
             long curCnt = *(long*)(data + pos);
+            if (curCnt == 0) {
+                // removed meanwhile
+                continue;
+            }
             update->count += curCnt;
             pos += sizeof(long);
             //LOG(INFO) << "Playing update, line_num " << line_num << " updates";
@@ -422,8 +656,73 @@ long VectoDB::UpdateBase()
     return played;
 }
 
+// IndexParamsGuard applies per-call query params to an index, and restores the ones they could change on destruction.
+// The caller holds rw_index exclusively, i.e. with unique_lock, so that other searches neither see the params nor interleave
+// their own save and restore with it. Searches without params share rw_index and don't need a guard.
+class IndexParamsGuard {
+public:
+    IndexParamsGuard(faiss::Index* index, const string& params)
+        : ok(true)
+        , ivf(nullptr)
+        , ivfpq(nullptr)
+        , pq(nullptr)
+    {
+        if (index == nullptr || params.empty())
+            return;
+        faiss::Index* sub = index;
+        while (auto pt = dynamic_cast<faiss::IndexPreTransform*>(sub))
+            sub = pt->index;
+        if ((ivf = dynamic_cast<faiss::IndexIVF*>(sub)) != nullptr) {
+            nprobe = ivf->nprobe;
+            max_codes = ivf->max_codes;
+        }
+        if ((ivfpq = dynamic_cast<faiss::IndexIVFPQ*>(sub)) != nullptr)
+            ht = ivfpq->polysemous_ht;
+        if ((pq = dynamic_cast<faiss::IndexPQ*>(sub)) != nullptr) {
+            search_type = pq->search_type;
+            ht = pq->polysemous_ht;
+        }
+        try {
+            faiss::ParameterSpace().set_index_parameters(index, params.c_str());
+        } catch (const faiss::FaissException& e) {
+            LOG(ERROR) << "Invalid query params \"" << params << "\". " << e.what();
+            ok = false;
+        }
+    }
+    ~IndexParamsGuard()
+    {
+        if (ivf != nullptr) {
+            ivf->nprobe = nprobe;
+            ivf->max_codes = max_codes;
+        }
+        if (ivfpq != nullptr)
+            ivfpq->polysemous_ht = ht;
+        if (pq != nullptr) {
+            pq->search_type = search_type;
+            pq->polysemous_ht = ht;
+        }
+    }
+    bool ok; // false if params are invalid
+
+private:
+    faiss::IndexIVF* ivf;
+    faiss::IndexIVFPQ* ivfpq;
+    faiss::IndexPQ* pq;
+    size_t nprobe;
+    size_t max_codes;
+    int ht;
+    faiss::IndexPQ::Search_type_t search_type;
+};
+
 long VectoDB::Search(long nq, const float* xq, float* distances, long* xids)
 {
+    return SearchWithParams(nq, xq, "", distances, xids);
+}
+
+long VectoDB::SearchWithParams(long nq, const float* xq, const char* params, float* distances, long* xids)
+{
+    vector<float> normalized_buf;
+    xq = normalized(nq, xq, normalized_buf);
     for (int i = 0; i < nq; i++) {
         xids[i] = long(-1);
     }
@@ -445,42 +744,111 @@ long VectoDB::Search(long nq, const float* xq, float* distances, long* xids)
     faiss::Index::idx_t I2[k];
     */
 
-    long index_size = 0;
+    const bool rerank_float64 = state->rerank_float64;
+    vector<double> D64(nq); //distances in float64 of the current best neighbors if rerank_float64 is set
     {
-        rlock r{ state->rw_index };
-        if (state->index != nullptr) {
-            index_size = state->index->ntotal;
+        // Hold rw_index until flat is searched, see DbState::rw_index. It's held exclusively only if there're params,
+        // since guard mutates the index. Otherwise it's shared, so that plain searches run in parallel.
+        const bool has_params = params != nullptr && params[0] != '\0';
+        boost::shared_lock<boost::shared_mutex> rs;
+        unique_lock<boost::shared_mutex> ru;
+        unique_ptr<IndexParamsGuard> guard;
+        if (has_params) {
+            ru = unique_lock<boost::shared_mutex>{ state->rw_index };
+            guard.reset(new IndexParamsGuard(state->index, params));
+            if (!guard->ok)
+                return -1;
+        } else {
+            rs = boost::shared_lock<boost::shared_mutex>{ state->rw_index };
+        }
+        if (state->index != nullptr && rerank_float64) {
+            state->index->search(nq, xq, k, &D[0], &I[0]);
+
+            // Refine result in float64
+            rlock r{ state->rw_data };
+            for (int i = 0; i < nq; i++) {
+                for (int j = 0; j < k; j++) {
+                    long line_num = I[i * k + j];
+                    if (line_num < 0 || isRemoved(line_num))
+                        continue;
+                    double dis = distance64(xq + i * dim, (const float*)&state->data[len_base_line * line_num + 2 * sizeof(long)]);
+                    if (xids[i] < 0 || CompareDistance(metric_type, dis, D64[i])) {
+                        D64[i] = dis;
+                        distances[i] = float(dis);
+                        xids[i] = line_num;
+                    }
+                }
+            }
+        } else if (state->index != nullptr) {
             // Perform a search
             state->index->search(nq, xq, k, &D[0], &I[0]);
 
-            // Refine result
+            // Refine result. The index pads I with -1 if there are less than k candidates.
             faiss::Index* index2 = new faiss::IndexFlat(dim, metric_type == 0 ? faiss::METRIC_INNER_PRODUCT : faiss::METRIC_L2);
+            vector<long> line_nums(k);
             for (int i = 0; i < nq; i++) {
+                long nvalid = 0;
                 {
                     rlock r{ state->rw_data };
                     for (int j = 0; j < k; j++) {
                         long line_num = I[i * k + j];
-                        memcpy(&xb2[j * dim], &state->data[len_base_line * line_num + 2 * sizeof(long)], len_vec);
+                        if (line_num < 0 || isRemoved(line_num))
+                            continue;
+                        memcpy(&xb2[nvalid * dim], &state->data[len_base_line * line_num + 2 * sizeof(long)], len_vec);
+                        line_nums[nvalid++] = line_num;
                     }
                 }
-                index2->add(k, &xb2[0]);
-                index2->search(1, xq + i * dim, k, &D2[0], &I2[0]);
+                if (nvalid == 0)
+                    continue;
+                index2->add(nvalid, &xb2[0]);
+                index2->search(1, xq + i * dim, 1, &D2[0], &I2[0]);
                 index2->reset();
                 distances[i] = D2[0];
-                xids[i] = I[i * k + I2[0]];
+                xids[i] = line_nums[I2[0]];
             }
             delete index2;
         }
-    }
 
-    {
-        rlock r{ state->rw_flat };
+        rlock r2{ state->rw_flat };
+        // fetch more to skip the removed vectors in the flat
+        const long kf = k + countRemovedFlat();
+        vector<float> Df;
+        vector<faiss::Index::idx_t> If;
         if (state->flat->ntotal != 0) {
-            state->flat->search(nq, xq, k, &D[0], &I[0]);
+            Df.resize(nq * kf);
+            If.resize(nq * kf);
+        }
+        if (state->flat->ntotal != 0 && rerank_float64) {
+            searchFlat(nq, xq, kf, &Df[0], &If[0]);
+            const float* xb_flat = static_cast<faiss::IndexFlat*>(state->flat)->xb.data();
             for (int i = 0; i < nq; i++) {
-                if (0 == index_size || CompareDistance(metric_type, D[i * k], distances[i])) {
-                    distances[i] = D[i * k];
-                    xids[i] = I[i * k] + state->flat_start_num;
+                for (int j = 0; j < kf; j++) {
+                    long num = If[i * kf + j];
+                    if (num < 0 || isRemoved(num + state->flat_start_num))
+                        continue;
+                    double dis = distance64(xq + i * dim, xb_flat + num * dim);
+                    if (xids[i] < 0 || CompareDistance(metric_type, dis, D64[i])) {
+                        D64[i] = dis;
+                        distances[i] = float(dis);
+                        xids[i] = num + state->flat_start_num;
+                    }
+                }
+            }
+        } else if (state->flat->ntotal != 0) {
+            searchFlat(nq, xq, kf, &Df[0], &If[0]);
+            for (int i = 0; i < nq; i++) {
+                // the nearest one which is not removed
+                for (int j = 0; j < kf; j++) {
+                    long num = If[i * kf + j];
+                    if (num < 0)
+                        break;
+                    if (isRemoved(num + state->flat_start_num))
+                        continue;
+                    if (xids[i] < 0 || CompareDistance(metric_type, Df[i * kf + j], distances[i])) {
+                        distances[i] = Df[i * kf + j];
+                        xids[i] = num + state->flat_start_num;
+                    }
+                    break;
                 }
             }
         }
@@ -489,7 +857,7 @@ long VectoDB::Search(long nq, const float* xq, float* distances, long* xids)
     {
         rlock r{ state->rw_xids };
         for (int i = 0; i < nq; i++) {
-            if (CompareDistance(metric_type, distances[i], dist_threshold)) {
+            if (xids[i] >= 0 && CompareDistance(metric_type, distances[i], dist_threshold)) {
                 xids[i] = state->xids[xids[i]];
             } else {
                 xids[i] = long(-1);
@@ -500,6 +868,71 @@ long VectoDB::Search(long nq, const float* xq, float* distances, long* xids)
     return total;
 }
 
+long VectoDB::SearchTopK(long nq, const float* xq, long k, float* distances, long* xids)
+{
+    vector<float> normalized_buf;
+    xq = normalized(nq, xq, normalized_buf);
+    for (long i = 0; i < nq * k; i++) {
+        xids[i] = long(-1);
+    }
+    long total = state->total;
+    if (total <= 0 || k <= 0)
+        return total;
+    // candidates fetched from the index to be reranked
+    const long k2 = std::max(k, 100L);
+    vector<float> D(nq * k2);
+    vector<faiss::Index::idx_t> I(nq * k2);
+    // (distance, line_num) of candidates of each query
+    vector<vector<pair<float, long>>> cands(nq);
+    {
+        // Hold rw_index until flat is searched, see DbState::rw_index.
+        rlock r{ state->rw_index };
+        if (state->index != nullptr) {
+            state->index->search(nq, xq, k2, &D[0], &I[0]);
+            // Rerank with exact distances since the index ones could be approximate. The index pads I with -1 if there are less than k2 candidates.
+            rlock r{ state->rw_data };
+            for (long i = 0; i < nq; i++) {
+                for (long j = 0; j < k2; j++) {
+                    long line_num = I[i * k2 + j];
+                    if (line_num < 0 || isRemoved(line_num))
+                        continue;
+                    double dis = distance64(xq + i * dim, (const float*)&state->data[len_base_line * line_num + 2 * sizeof(long)]);
+                    cands[i].emplace_back(float(dis), line_num);
+                }
+            }
+        }
+        rlock r2{ state->rw_flat };
+        if (state->flat->ntotal != 0) {
+            // fetch more to skip the removed vectors in the flat
+            const long kf = k + countRemovedFlat();
+            vector<float> Df(nq * kf);
+            vector<faiss::Index::idx_t> If(nq * kf);
+            searchFlat(nq, xq, kf, &Df[0], &If[0]);
+            for (long i = 0; i < nq; i++) {
+                for (long j = 0; j < kf; j++) {
+                    long num = If[i * kf + j];
+                    if (num < 0 || isRemoved(num + state->flat_start_num))
+                        continue;
+                    cands[i].emplace_back(Df[i * kf + j], num + state->flat_start_num);
+                }
+            }
+        }
+    }
+
+    rlock r{ state->rw_xids };
+    for (long i = 0; i < nq; i++) {
+        auto& cand = cands[i];
+        std::sort(cand.begin(), cand.end(), [this](const pair<float, long>& a, const pair<float, long>& b) {
+            return CompareDistance(metric_type, a.first, b.first);
+        });
+        for (long j = 0; j < k && j < (long)cand.size(); j++) {
+            distances[i * k + j] = cand[j].first;
+            xids[i * k + j] = state->xids[cand[j].second];
+        }
+    }
+    return total;
+}
+
 std::string VectoDB::getBaseFp() const
 {
     ostringstream oss;
@@ -586,6 +1019,629 @@ void VectoDB::readXids(const uint8_t* data, long num_line, long start_num, vecto
     }
 }
 
+long VectoDB::RemoveIds(long n, const long* xids)
+{
+    vector<long> line_nums;
+    // Adds and index activations are blocked by m_base.
+    mtxlock m{ state->m_base };
+    {
+        wlock w{ state->rw_xids };
+        for (long i = 0; i < n; i++) {
+            auto it = state->xid2num.find(xids[i]);
+            if (it == state->xid2num.end())
+                continue;
+            line_nums.push_back(it->second);
+            state->removed.insert(it->second);
+            state->xid2num.erase(it);
+        }
+        state->nremoved = state->removed.size();
+    }
+    if (line_nums.empty())
+        return 0;
+    // Persist the removal by zeroing the count of the lines, which could be still buffered by fs_base.
+    state->fs_base.flush();
+    {
+        mtxlock m2{ state->m_base2 };
+        const long zero = 0;
+        for (long line_num : line_nums) {
+            state->fs_base2.seekp(line_num * len_base_line + sizeof(long), ios_base::beg);
+            state->fs_base2.write((const char*)&zero, sizeof(long));
+        }
+        state->fs_base2.flush();
+    }
+    {
+        // only the newly removed ones, the former ones are gone from the index already
+        unique_lock<boost::shared_mutex> w{ state->rw_index };
+        removeFromIndex(state->index, state->flat_start_num, line_nums);
+    }
+    return line_nums.size();
+}
+
+// removeFromIndex removes the given line nums which are in the index, i.e. less than index_size.
+void VectoDB::removeFromIndex(faiss::Index* index, long index_size, const vector<long>& line_nums) const
+{
+    if (index == nullptr)
+        return;
+    vector<faiss::Index::idx_t> ids;
+    for (long line_num : line_nums) {
+        if (line_num < index_size)
+            ids.push_back(line_num);
+    }
+    if (ids.empty())
+        return;
+    try {
+        faiss::IDSelectorBatch sel(ids.size(), &ids[0]);
+        index->remove_ids(sel);
+    } catch (const faiss::FaissException& e) {
+        // i.e. HNSW doesn't implement remove_ids. The removed vectors are filtered out by searches.
+        LOG(INFO) << "RemoveIds " << work_dir << " failed to remove ids from index, they're filtered out by searches. " << e.what();
+    }
+}
+
+bool VectoDB::isRemoved(long line_num) const
+{
+    if (state->nremoved == 0)
+        return false;
+    rlock r{ state->rw_xids };
+    return state->removed.count(line_num) != 0;
+}
+
+// countRemovedFlat returns the number of removed vectors which are still in the flat. A flat search fetches this many more than k,
+// so that the removed ones, which are skipped, never push alive ones out of the results. The caller holds rw_flat.
+long VectoDB::countRemovedFlat() const
+{
+    if (state->nremoved == 0)
+        return 0;
+    rlock r{ state->rw_xids };
+    long count = 0;
+    for (long line_num : state->removed) {
+        if (line_num >= state->flat_start_num)
+            count++;
+    }
+    return count;
+}
+
+long VectoDB::countAlive(const faiss::RangeSearchResult& res, long start_num) const
+{
+    long count = 0;
+    for (size_t j = 0; j < res.lims[1]; j++) {
+        if (!isRemoved(res.labels[j] + start_num))
+            count++;
+    }
+    return count;
+}
+
+void VectoDB::SetRerankFloat64(bool on)
+{
+    state->rerank_float64 = on;
+}
+
+void VectoDB::SetNormalize(bool on)
+{
+    state->normalize = on || cosine;
+}
+
+void VectoDB::SetFlatThreads(long n)
+{
+    if (n <= 0)
+        n = defaultFlatThreads();
+    state->flat_threads = n;
+}
+
+// searchFlat is the same as state->flat->search, except that it's split into flat_threads threads. The caller holds rw_flat.
+void VectoDB::searchFlat(long nq, const float* xq, long k, float* distances, long* labels) const
+{
+    auto flat = static_cast<faiss::IndexFlat*>(state->flat);
+    long ntotal = flat->ntotal;
+    long nthreads = std::min(long(state->flat_threads), ntotal / FLAT_MIN_PER_THREAD);
+    if (nthreads <= 1) {
+        flat->search(nq, xq, k, distances, labels);
+        return;
+    }
+    // Each thread scans a range of the flat, then results of the ranges are merged.
+    long chunk = (ntotal + nthreads - 1) / nthreads;
+    vector<float> Ds(nthreads * nq * k);
+    vector<long> Is(nthreads * nq * k);
+    vector<std::thread> threads;
+    for (long t = 0; t < nthreads; t++) {
+        threads.emplace_back([&, t]() {
+            long start = t * chunk;
+            long ny = std::min(chunk, ntotal - start);
+            float* D = &Ds[t * nq * k];
+            long* I = &Is[t * nq * k];
+            if (metric_type == 0) {
+                faiss::float_minheap_array_t res = { size_t(nq), size_t(k), I, D };
+                faiss::knn_inner_product(xq, flat->xb.data() + start * dim, dim, nq, ny, &res);
+            } else {
+                faiss::float_maxheap_array_t res = { size_t(nq), size_t(k), I, D };
+                faiss::knn_L2sqr(xq, flat->xb.data() + start * dim, dim, nq, ny, &res);
+            }
+            for (long j = 0; j < nq * k; j++) {
+                if (I[j] >= 0)
+                    I[j] += start;
+            }
+        });
+    }
+    for (auto& th : threads)
+        th.join();
+    vector<pair<float, long>> cand(nthreads * k);
+    for (long i = 0; i < nq; i++) {
+        long ncand = 0;
+        for (long t = 0; t < nthreads; t++) {
+            for (long j = 0; j < k; j++) {
+                long pos = (t * nq + i) * k + j;
+                if (Is[pos] >= 0)
+                    cand[ncand++] = make_pair(Ds[pos], Is[pos]);
+            }
+        }
+        long nres = std::min(k, ncand);
+        std::partial_sort(cand.begin(), cand.begin() + nres, cand.begin() + ncand, [this](const pair<float, long>& a, const pair<float, long>& b) {
+            return CompareDistance(metric_type, a.first, b.first);
+        });
+        for (long j = 0; j < k; j++) {
+            distances[i * k + j] = j < nres ? cand[j].first : 0;
+            labels[i * k + j] = j < nres ? cand[j].second : -1;
+        }
+    }
+}
+
+double VectoDB::distance64(const float* x, const float* y) const
+{
+    double dis = 0;
+    if (metric_type == 0) {
+        for (long d = 0; d < dim; d++)
+            dis += double(x[d]) * double(y[d]);
+    } else {
+        for (long d = 0; d < dim; d++) {
+            double diff = double(x[d]) - double(y[d]);
+            dis += diff * diff;
+        }
+    }
+    return dis;
+}
+
+bool VectoDB::ExistsWithin(const float* xq, float thr, float& distance, long& xid)
+{
+    vector<float> normalized_buf;
+    xq = normalized(1, xq, normalized_buf);
+    xid = long(-1);
+    long line_num = long(-1);
+    // Removed vectors could hide the nearest alive one from the index, so that more are fetched.
+    const long k = state->nremoved == 0 ? 1 : 16;
+    vector<float> D(k);
+    vector<faiss::Index::idx_t> I(k);
+    // The flat is searched first since it's small and contains the most recent vectors.
+    // Hold rw_index until index is searched, see DbState::rw_index.
+    {
+        rlock r{ state->rw_index };
+        {
+            rlock r2{ state->rw_flat };
+            if (state->flat->ntotal != 0) {
+                // fetch more to skip the removed vectors in the flat
+                const long kf = 1 + countRemovedFlat();
+                vector<float> Df(kf);
+                vector<faiss::Index::idx_t> If(kf);
+                searchFlat(1, xq, kf, &Df[0], &If[0]);
+                for (long j = 0; j < kf && If[j] >= 0 && CompareDistance(metric_type, Df[j], thr); j++) {
+                    if (!isRemoved(If[j] + state->flat_start_num)) {
+                        line_num = If[j] + state->flat_start_num;
+                        distance = Df[j];
+                        break;
+                    }
+                }
+            }
+        }
+        if (line_num < 0 && state->index != nullptr) {
+            state->index->search(1, xq, k, &D[0], &I[0]);
+            for (long j = 0; j < k && I[j] >= 0 && CompareDistance(metric_type, D[j], thr); j++) {
+                if (!isRemoved(I[j])) {
+                    line_num = I[j];
+                    distance = D[j];
+                    break;
+                }
+            }
+        }
+    }
+    if (line_num < 0)
+        return false;
+    {
+        rlock r{ state->rw_xids };
+        xid = state->xids[line_num];
+    }
+    return true;
+}
+
+long VectoDB::RangeSearch(long nq, const float* xq, float radius, vector<long>& lims, vector<float>& distances, vector<long>& xids)
+{
+    vector<float> normalized_buf;
+    xq = normalized(nq, xq, normalized_buf);
+    // (distance, line number) of the neighbors of each query
+    vector<vector<pair<float, long>>> hits(nq);
+    long total = state->total;
+    {
+        // Hold rw_index until flat is searched, see DbState::rw_index.
+        rlock r{ state->rw_index };
+        if (state->index != nullptr) {
+            try {
+                faiss::RangeSearchResult res(nq);
+                state->index->range_search(nq, xq, radius, &res);
+                for (long i = 0; i < nq; i++) {
+                    for (size_t j = res.lims[i]; j < res.lims[i + 1]; j++) {
+                        if (!isRemoved(res.labels[j]))
+                            hits[i].emplace_back(res.distances[j], res.labels[j]);
+                    }
+                }
+            } catch (const faiss::FaissException& e) {
+                // i.e. IVFPQ and HNSW don't implement range_search. Scan the indexed vectors by brute force.
+                rlock r{ state->rw_data };
+                for (long line_num = 0; line_num < state->flat_start_num; line_num++) {
+                    if (isRemoved(line_num))
+                        continue;
+                    const float* xb = (const float*)&state->data[len_base_line * line_num + 2 * sizeof(long)];
+                    for (long i = 0; i < nq; i++) {
+                        double dis = distance64(xq + i * dim, xb);
+                        if (CompareDistance(metric_type, dis, double(radius)))
+                            hits[i].emplace_back(float(dis), line_num);
+                    }
+                }
+            }
+        }
+        rlock r2{ state->rw_flat };
+        if (state->flat->ntotal != 0) {
+            faiss::RangeSearchResult res(nq);
+            state->flat->range_search(nq, xq, radius, &res);
+            for (long i = 0; i < nq; i++) {
+                for (size_t j = res.lims[i]; j < res.lims[i + 1]; j++) {
+                    long line_num = res.labels[j] + state->flat_start_num;
+                    if (!isRemoved(line_num))
+                        hits[i].emplace_back(res.distances[j], line_num);
+                }
+            }
+        }
+    }
+
+    lims.assign(nq + 1, 0);
+    for (long i = 0; i < nq; i++)
+        lims[i + 1] = lims[i] + hits[i].size();
+    distances.resize(lims[nq]);
+    xids.resize(lims[nq]);
+    rlock r{ state->rw_xids };
+    for (long i = 0; i < nq; i++) {
+        auto& hit = hits[i];
+        std::sort(hit.begin(), hit.end(), [this](const pair<float, long>& a, const pair<float, long>& b) {
+            return CompareDistance(metric_type, a.first, b.first);
+        });
+        for (size_t j = 0; j < hit.size(); j++) {
+            distances[lims[i] + j] = hit[j].first;
+            xids[lims[i] + j] = state->xids[hit[j].second];
+        }
+    }
+    return total;
+}
+
+long VectoDB::CountWithin(const float* xq, float thr)
+{
+    vector<float> normalized_buf;
+    xq = normalized(1, xq, normalized_buf);
+    long count = 0;
+    // Hold rw_index until flat is counted, see DbState::rw_index.
+    rlock r{ state->rw_index };
+    if (state->index != nullptr) {
+        try {
+            faiss::RangeSearchResult res(1);
+            state->index->range_search(1, xq, thr, &res);
+            count += countAlive(res, 0);
+        } catch (const faiss::FaissException& e) {
+            // i.e. IVFPQ and HNSW don't implement range_search. Scan the indexed vectors by brute force.
+            rlock r{ state->rw_data };
+            for (long line_num = 0; line_num < state->flat_start_num; line_num++) {
+                if (isRemoved(line_num))
+                    continue;
+                double dis = distance64(xq, (const float*)&state->data[len_base_line * line_num + 2 * sizeof(long)]);
+                if (CompareDistance(metric_type, dis, double(thr)))
+                    count++;
+            }
+        }
+    }
+    rlock r2{ state->rw_flat };
+    if (state->flat->ntotal != 0) {
+        faiss::RangeSearchResult res(1);
+        state->flat->range_search(1, xq, thr, &res);
+        count += countAlive(res, state->flat_start_num);
+    }
+    return count;
+}
+
+int VectoDB::Reconstruct(long xid, float* vec) const
+{
+    rlock r{ state->rw_index };
+    rlock l{ state->rw_flat };
+    long line_num;
+    {
+        rlock r2{ state->rw_xids };
+        auto it = state->xid2num.find(xid);
+        if (it == state->xid2num.end())
+            return 0;
+        line_num = it->second;
+    }
+    try {
+        if (line_num >= state->flat_start_num) {
+            state->flat->reconstruct(line_num - state->flat_start_num, vec);
+            return 1;
+        }
+        auto index_ivf = dynamic_cast<faiss::IndexIVF*>(state->index);
+        if (index_ivf != nullptr)
+            return reconstructIVF(index_ivf, line_num, vec) ? 1 : 0;
+        state->index->reconstruct(line_num, vec);
+    } catch (const faiss::FaissException& e) {
+        LOG(ERROR) << "Reconstruct " << work_dir << " failed to reconstruct xid " << xid << ". " << e.what();
+        return -1;
+    }
+    return 1;
+}
+
+bool VectoDB::Contains(long xid) const
+{
+    rlock r{ state->rw_xids };
+    return state->xid2num.count(xid) > 0;
+}
+
+bool VectoDB::reconstructIVF(const faiss::IndexIVF* index_ivf, long line_num, float* vec) const
+{
+    // IndexIVF::reconstruct_n is not used since it rejects ids beyond ntotal, which shrinks after remove_ids.
+    for (long list_no = 0; list_no < index_ivf->nlist; list_no++) {
+        size_t list_size = index_ivf->invlists->list_size(list_no);
+        const faiss::Index::idx_t* ids = index_ivf->invlists->get_ids(list_no);
+        for (size_t offset = 0; offset < list_size; offset++) {
+            if (ids[offset] == line_num) {
+                index_ivf->reconstruct_from_offset(list_no, offset, vec);
+                return true;
+            }
+        }
+    }
+    return false;
+}
+
+long VectoDB::EstimateMatches(const float* xq, float thr)
+{
+    vector<float> normalized_buf;
+    xq = normalized(1, xq, normalized_buf);
+    long count = 0;
+    {
+        rlock r{ state->rw_index };
+        auto index_ivf = dynamic_cast<faiss::IndexIVF*>(state->index);
+        if (index_ivf != nullptr) {
+            // Count whole lists whose centroids are within thr. Centroids are sorted by distance.
+            long nlist = index_ivf->nlist;
+            vector<float> D(nlist);
+            vector<faiss::Index::idx_t> I(nlist);
+            index_ivf->quantizer->search(1, xq, nlist, &D[0], &I[0]);
+            for (long i = 0; i < nlist && I[i] >= 0 && CompareDistance(metric_type, D[i], thr); i++)
+                count += index_ivf->invlists->list_size(I[i]);
+        } else if (state->index != nullptr) {
+            count = -1;
+        }
+    }
+    if (count < 0) {
+        // There're no cluster statistics.
+        return CountWithin(xq, thr);
+    }
+    rlock r{ state->rw_flat };
+    if (state->flat->ntotal != 0) {
+        faiss::RangeSearchResult res(1);
+        state->flat->range_search(1, xq, thr, &res);
+        count += countAlive(res, state->flat_start_num);
+    }
+    return count;
+}
+
+long VectoDB::ExplainSearch(const float* xq, long capacity, long* list_nos, float* list_dists, long* list_sizes) const
+{
+    vector<float> normalized_buf;
+    xq = normalized(1, xq, normalized_buf);
+    rlock r{ state->rw_index };
+    auto index_ivf = dynamic_cast<faiss::IndexIVF*>(state->index);
+    if (index_ivf == nullptr)
+        return 0;
+    long nprobe = std::min((long)index_ivf->nprobe, (long)index_ivf->nlist);
+    vector<float> D(nprobe);
+    vector<faiss::Index::idx_t> I(nprobe);
+    index_ivf->quantizer->search(1, xq, nprobe, &D[0], &I[0]);
+    for (long i = 0; i < nprobe && i < capacity; i++) {
+        list_nos[i] = I[i];
+        list_dists[i] = D[i];
+        list_sizes[i] = I[i] < 0 ? 0 : index_ivf->invlists->list_size(I[i]);
+    }
+    return nprobe;
+}
+
+bool VectoDB::SetQuantizer(const uint8_t* data, long len)
+{
+    // faiss index_io only supports files, so data is read via an in-memory FILE.
+    FILE* f = fmemopen(const_cast<uint8_t*>(data), len, "rb");
+    if (f == nullptr)
+        return false;
+    faiss::Index* q = nullptr;
+    try {
+        q = faiss::read_index(f);
+    } catch (const std::exception& e) {
+        LOG(ERROR) << "SetQuantizer " << work_dir << " failed to read quantizer: " << e.what();
+    }
+    fclose(f);
+    if (q == nullptr)
+        return false;
+    if (q->d != dim || !q->is_trained) {
+        LOG(ERROR) << "SetQuantizer " << work_dir << " invalid quantizer, dim " << q->d << ", is_trained " << q->is_trained;
+        delete q;
+        return false;
+    }
+    quantizer.reset(q);
+    return true;
+}
+
+bool VectoDB::ExportQuantizer(std::vector<uint8_t>& data) const
+{
+    rlock r{ state->rw_index };
+    auto index_ivf = dynamic_cast<faiss::IndexIVF*>(state->index);
+    if (index_ivf == nullptr)
+        return false;
+    char* buf = nullptr;
+    size_t len = 0;
+    FILE* f = open_memstream(&buf, &len);
+    if (f == nullptr)
+        return false;
+    faiss::write_index(index_ivf->quantizer, f);
+    fclose(f);
+    data.assign(buf, buf + len);
+    free(buf);
+    return true;
+}
+
+// appendFile writes the length and the first len bytes of fp to out. len < 0 means the whole file, an empty fp means an empty file.
+static void appendFile(const string& fp, long len, std::ofstream& out)
+{
+    if (fp.empty())
+        len = 0;
+    else if (len < 0)
+        len = fs::file_size(fp);
+    out.write((const char*)&len, sizeof(long));
+    if (len == 0)
+        return;
+    std::ifstream in;
+    in.exceptions(std::ios::failbit | std::ios::badbit);
+    in.open(fp, std::ifstream::binary);
+    vector<char> buf(1 << 20);
+    for (long left = len; left > 0;) {
+        long n = std::min(left, (long)buf.size());
+        in.read(&buf[0], n);
+        out.write(&buf[0], n);
+        left -= n;
+    }
+}
+
+// extractFile reads a file written by appendFile from in to fp. An empty fp means to skip it.
+static void extractFile(std::ifstream& in, const string& fp)
+{
+    long len = 0;
+    in.read((char*)&len, sizeof(long));
+    if (len < 0)
+        throw std::runtime_error("invalid file length");
+    std::ofstream out;
+    if (!fp.empty()) {
+        out.exceptions(std::ios::failbit | std::ios::badbit);
+        out.open(fp, std::ofstream::binary | std::ofstream::trunc);
+    }
+    vector<char> buf(1 << 20);
+    for (long left = len; left > 0;) {
+        long n = std::min(left, (long)buf.size());
+        in.read(&buf[0], n);
+        if (!fp.empty())
+            out.write(&buf[0], n);
+        left -= n;
+    }
+}
+
+bool VectoDB::ValidateIndexKey(long dim, int metric_type, const char* index_key)
+{
+    if (dim <= 0 || metric_type < 0 || metric_type > METRIC_COSINE)
+        return false;
+    try {
+        faiss::Index* index = faiss::index_factory(dim, index_key, metric_type == 1 ? faiss::METRIC_L2 : faiss::METRIC_INNER_PRODUCT);
+        delete index;
+    } catch (const std::exception& e) {
+        LOG(WARNING) << "ValidateIndexKey dim " << dim << ", metric " << metric_type << ", index_key \"" << index_key << "\" is invalid. " << e.what();
+        return false;
+    }
+    return true;
+}
+
+bool VectoDB::Snapshot(const char* path)
+{
+    const string fp_tmp = string(path) + ".tmp";
+    try {
+        std::ofstream out;
+        out.exceptions(std::ios::failbit | std::ios::badbit);
+        out.open(fp_tmp, std::ofstream::binary | std::ofstream::trunc);
+        // Adds, removes and index activations are blocked by m_base, updates by m_update, and playing updates by m_base2.
+        mtxlock m1{ state->m_base };
+        mtxlock m2{ state->m_update };
+        mtxlock m3{ state->m_base2 };
+        state->fs_base.flush();
+        state->fs_update.flush();
+        long ntrain = 0;
+        {
+            rlock r{ state->rw_index };
+            if (state->index != nullptr)
+                ntrain = state->ntrain;
+        }
+        const long metric = cosine ? METRIC_COSINE : metric_type;
+        const long len_index_key = index_key.length();
+        out.write(SNAPSHOT_MAGIC, sizeof(SNAPSHOT_MAGIC));
+        out.write((const char*)&dim, sizeof(long));
+        out.write((const char*)&metric, sizeof(long));
+        out.write((const char*)&len_index_key, sizeof(long));
+        out.write(index_key.data(), len_index_key);
+        out.write((const char*)&ntrain, sizeof(long));
+        // The index file is written by faiss::write_index at ActivateIndex. It's copied rather than the index in memory,
+        // which has removed vectors dropped so that its ntotal no longer tells where the flat starts.
+        appendFile(ntrain > 0 ? getIndexFp(ntrain) : "", -1, out);
+        appendFile(getBaseFp(), state->total * len_base_line, out);
+        appendFile(getUpdateFp(), state->fs_update.tellp(), out);
+        out.close();
+        fs::rename(fp_tmp, path);
+    } catch (const std::exception& e) {
+        LOG(ERROR) << "Snapshot " << work_dir << " to " << path << " failed. " << e.what();
+        boost::system::error_code ec;
+        fs::remove(fp_tmp, ec);
+        return false;
+    }
+    LOG(INFO) << "Snapshot " << work_dir << " to " << path << " done";
+    return true;
+}
+
+int VectoDB::RestoreSnapshot(const char* path, const char* work_dir, long dim, int metric_type, const char* index_key)
+{
+    try {
+        std::ifstream in;
+        in.exceptions(std::ios::failbit | std::ios::badbit);
+        in.open(path, std::ifstream::binary);
+        char magic[sizeof(SNAPSHOT_MAGIC)];
+        in.read(magic, sizeof(magic));
+        if (memcmp(magic, SNAPSHOT_MAGIC, sizeof(magic)) != 0) {
+            LOG(ERROR) << "RestoreSnapshot " << path << " is not a snapshot";
+            return -1;
+        }
+        long snap_dim = 0, snap_metric = 0, len_index_key = 0, ntrain = 0;
+        in.read((char*)&snap_dim, sizeof(long));
+        in.read((char*)&snap_metric, sizeof(long));
+        in.read((char*)&len_index_key, sizeof(long));
+        if (len_index_key < 0 || len_index_key > 1024) {
+            LOG(ERROR) << "RestoreSnapshot " << path << " has an invalid index_key length " << len_index_key;
+            return -1;
+        }
+        string snap_index_key(len_index_key, '\0');
+        in.read(&snap_index_key[0], len_index_key);
+        in.read((char*)&ntrain, sizeof(long));
+        if (snap_dim != dim || snap_metric != metric_type || snap_index_key != index_key) {
+            LOG(ERROR) << "RestoreSnapshot " << path << " is taken with dim " << snap_dim << ", metric " << snap_metric << ", index_key \"" << snap_index_key
+                       << "\", want dim " << dim << ", metric " << metric_type << ", index_key \"" << index_key << "\"";
+            return 0;
+        }
+        ClearWorkDir(work_dir);
+        fs::path dir{ work_dir };
+        string fp_index;
+        if (ntrain > 0)
+            fp_index = (dir / (snap_index_key + "." + std::to_string(ntrain) + ".index")).string();
+        extractFile(in, fp_index);
+        extractFile(in, (dir / "base.fvecs").string());
+        extractFile(in, (dir / "update.fvecs").string());
+    } catch (const std::exception& e) {
+        LOG(ERROR) << "RestoreSnapshot " << path << " to " << work_dir << " failed. " << e.what();
+        return -1;
+    }
+    return 1;
+}
+
 void VectoDB::ClearWorkDir(const char* work_dir)
 {
     fs::create_directories(work_dir);
@@ -622,6 +1678,37 @@ void VectoDB::Normalize(std::vector<float>& vec)
     }
 }
 
+const float* VectoDB::normalized(long n, const float* x, vector<float>& buf) const
+{
+    if (!state->normalize)
+        return x;
+    buf.assign(x, x + n * dim);
+    for (long i = 0; i < n; i++) {
+        float* v = &buf[i * dim];
+        double l = 0;
+        for (long j = 0; j < dim; j++)
+            l += double(v[j]) * double(v[j]);
+        if (l == 0)
+            continue; //an all-zero vector has no direction, leave it as is
+        l = sqrt(l);
+        for (long j = 0; j < dim; j++)
+            v[j] = (float)(double(v[j]) / l);
+    }
+    return buf.data();
+}
+
+void VectoDB::truncatePartialLine(const string& fp, long len_line) const
+{
+    if (!fs::exists(fp))
+        return;
+    long len_f = fs::file_size(fp);
+    long remained = len_f % len_line;
+    if (remained != 0) {
+        LOG(WARNING) << "Truncating partial line at the end of " << fp << ". file size " << len_f << ", len_line " << len_line << ", remained " << remained << ".";
+        fs::resize_file(fp, len_f - remained);
+    }
+}
+
 long VectoDB::getNumLines(long len_data, long len_base_line) const
 {
     long nb = len_data / len_base_line;
@@ -665,9 +1752,9 @@ void VectoDB::munmapFile(const string& fp, uint8_t*& data, long& len_data)
  * C wrappers.
  */
 
-void* VectodbNew(char* work_dir, long dim, int metric_type, char* index_key, char* query_params, float dist_threshold)
+void* VectodbNew(char* work_dir, long dim, int metric_type, char* index_key, char* query_params, float dist_threshold, int seed)
 {
-    VectoDB* vdb = new VectoDB(work_dir, dim, metric_type, index_key, query_params, dist_threshold);
+    VectoDB* vdb = new VectoDB(work_dir, dim, metric_type, index_key, query_params, dist_threshold, seed);
     return vdb;
 }
 
@@ -683,6 +1770,18 @@ void* VectodbBuildIndex(void* vdb, long cur_ntrain, long cur_nsize, long* ntrain
     return index;
 }
 
+void* VectodbBuildIndexWithKey(void* vdb, char* index_key, char* query_params, long* ntrain)
+{
+    faiss::Index* index = nullptr;
+    static_cast<VectoDB*>(vdb)->BuildIndex(index_key, query_params, 0, 0, index, *ntrain);
+    return index;
+}
+
+void VectodbSetIndexKey(void* vdb, char* index_key, char* query_params)
+{
+    static_cast<VectoDB*>(vdb)->SetIndexKey(index_key, query_params);
+}
+
 void VectodbAddWithIds(void* vdb, long nb, float* xb, long* xids)
 {
     static_cast<VectoDB*>(vdb)->AddWithIds(nb, xb, xids);
@@ -708,11 +1807,31 @@ long VectodbGetFlatSize(void* vdb)
     return static_cast<VectoDB*>(vdb)->GetFlatSize();
 }
 
+long VectodbGetMemoryUsage(void* vdb)
+{
+    return static_cast<VectoDB*>(vdb)->GetMemoryUsage();
+}
+
+long VectodbGetTotalSize(void* vdb)
+{
+    return static_cast<VectoDB*>(vdb)->GetTotalSize();
+}
+
 void VectodbActivateIndex(void* vdb, void* index, long ntrain)
 {
     static_cast<VectoDB*>(vdb)->ActivateIndex(static_cast<faiss::Index*>(index), ntrain);
 }
 
+int VectodbLoadIndex(void* vdb, char* fp)
+{
+    return static_cast<VectoDB*>(vdb)->LoadIndex(fp);
+}
+
+void VectodbReset(void* vdb)
+{
+    static_cast<VectoDB*>(vdb)->Reset();
+}
+
 void VectodbGetIndexSize(void* vdb, long* ntrain, long* ntotal)
 {
     static_cast<VectoDB*>(vdb)->GetIndexSize(*ntrain, *ntotal);
@@ -723,6 +1842,118 @@ long VectodbSearch(void* vdb, long nq, float* xq, float* distances, long* xids)
     return static_cast<VectoDB*>(vdb)->Search(nq, xq, distances, xids);
 }
 
+long VectodbSearchWithParams(void* vdb, long nq, float* xq, char* params, float* distances, long* xids)
+{
+    return static_cast<VectoDB*>(vdb)->SearchWithParams(nq, xq, params, distances, xids);
+}
+
+long VectodbSearchTopK(void* vdb, long nq, float* xq, long k, float* distances, long* xids)
+{
+    return static_cast<VectoDB*>(vdb)->SearchTopK(nq, xq, k, distances, xids);
+}
+
+struct RangeSearchOutput {
+    vector<float> distances;
+    vector<long> xids;
+};
+
+void* VectodbRangeSearch(void* vdb, long nq, float* xq, float radius, long* lims)
+{
+    RangeSearchOutput* res = new RangeSearchOutput();
+    vector<long> lims2;
+    static_cast<VectoDB*>(vdb)->RangeSearch(nq, xq, radius, lims2, res->distances, res->xids);
+    std::copy(lims2.begin(), lims2.end(), lims);
+    return res;
+}
+
+void VectodbRangeSearchFetch(void* resIn, float* distances, long* xids)
+{
+    RangeSearchOutput* res = static_cast<RangeSearchOutput*>(resIn);
+    std::copy(res->distances.begin(), res->distances.end(), distances);
+    std::copy(res->xids.begin(), res->xids.end(), xids);
+    delete res;
+}
+
+long VectodbRemoveIds(void* vdb, long n, long* xids)
+{
+    return static_cast<VectoDB*>(vdb)->RemoveIds(n, xids);
+}
+
+void VectodbSetFlatThreads(void* vdb, long n)
+{
+    static_cast<VectoDB*>(vdb)->SetFlatThreads(n);
+}
+
+void VectodbSetNormalize(void* vdb, int on)
+{
+    static_cast<VectoDB*>(vdb)->SetNormalize(on != 0);
+}
+
+void VectodbSetRerankFloat64(void* vdb, int on)
+{
+    static_cast<VectoDB*>(vdb)->SetRerankFloat64(on != 0);
+}
+
+int VectodbExistsWithin(void* vdb, float* xq, float thr, float* distance, long* xid)
+{
+    return static_cast<VectoDB*>(vdb)->ExistsWithin(xq, thr, *distance, *xid);
+}
+
+long VectodbCountWithin(void* vdb, float* xq, float thr)
+{
+    return static_cast<VectoDB*>(vdb)->CountWithin(xq, thr);
+}
+
+long VectodbEstimateMatches(void* vdb, float* xq, float thr)
+{
+    return static_cast<VectoDB*>(vdb)->EstimateMatches(xq, thr);
+}
+
+int VectodbReconstruct(void* vdb, long xid, float* vec)
+{
+    return static_cast<VectoDB*>(vdb)->Reconstruct(xid, vec);
+}
+
+int VectodbContains(void* vdb, long xid)
+{
+    return static_cast<VectoDB*>(vdb)->Contains(xid) ? 1 : 0;
+}
+
+long VectodbExplainSearch(void* vdb, float* xq, long capacity, long* list_nos, float* list_dists, long* list_sizes)
+{
+    return static_cast<VectoDB*>(vdb)->ExplainSearch(xq, capacity, list_nos, list_dists, list_sizes);
+}
+
+int VectodbSetQuantizer(void* vdb, unsigned char* data, long len)
+{
+    return static_cast<VectoDB*>(vdb)->SetQuantizer(data, len) ? 1 : 0;
+}
+
+long VectodbExportQuantizer(void* vdb, unsigned char** data)
+{
+    vector<uint8_t> buf;
+    if (!static_cast<VectoDB*>(vdb)->ExportQuantizer(buf) || buf.empty())
+        return 0;
+    *data = static_cast<unsigned char*>(malloc(buf.size()));
+    memcpy(*data, &buf[0], buf.size());
+    return buf.size();
+}
+
+int VectodbSnapshot(void* vdb, char* path)
+{
+    return static_cast<VectoDB*>(vdb)->Snapshot(path) ? 1 : 0;
+}
+
+int VectodbRestoreSnapshot(char* path, char* work_dir, long dim, int metric_type, char* index_key)
+{
+    return VectoDB::RestoreSnapshot(path, work_dir, dim, metric_type, index_key);
+}
+
+int VectodbValidateIndexKey(long dim, int metric_type, char* index_key)
+{
+    return VectoDB::ValidateIndexKey(dim, metric_type, index_key) ? 1 : 0;
+}
+
 void VectodbClearWorkDir(char* work_dir)
 {
     VectoDB::ClearWorkDir(work_dir);
@@ -10,62 +10,381 @@ package vectodb
 import "C"
 
 import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+// ErrZeroVector is returned when searching an empty query vector, or an all-zero one with inner product or cosine metric whose result order is arbitrary.
+var ErrZeroVector = errors.New("zero or empty query vector")
+
+// ErrXidNotFound is returned by Reconstruct if the xid is absent or removed.
+var ErrXidNotFound = errors.New("xid not found")
+
+// ErrIndexAhead is returned by NewVectoDBFromIndex if the index covers more vectors than the db, i.e. it's built over a later copy of the db.
+var ErrIndexAhead = errors.New("index covers more vectors than the db")
+
+// VectoDB is safe for concurrent use. Searches and other reads run in parallel, though inside the C layer only Search and
+// SearchWithParams without params search the index in parallel, while SearchTopK, SearchWithParams with params and the scans
+// of the flat serialize with each other. AddWithIds, UpdateWithIds, RemoveIds, Reset and LoadSnapshot serialize with each other
+// and with reads. UpdateIndex and Reindex build the index without holding rwlock, since the build reads the base file through
+// its own mapping, so that neither searches nor mutations wait for it. They block mutations only while playing updates and
+// swapping in the new index. Reset, LoadSnapshot and Destroy wait for a running build.
 type VectoDB struct {
+	rwlock        sync.RWMutex // protects vdbC against mutations, see the doc of VectoDB
+	buildLock     sync.Mutex   // serializes index builds of UpdateIndex and Reindex, and with Reset, LoadSnapshot and Destroy. Lock order: buildLock, swapLock, rwlock
+	swapLock      sync.Mutex   // serializes swapping in an index, which rewrites the index files and meta, with Snapshot
 	vdbC          unsafe.Pointer
 	dim           int
+	metricType    int
+	allowZero     int32 // 1 if all-zero query vectors are allowed with inner product metric
+	sqrtL2        int32 // 1 if Search returns true L2 distances rather than squared ones
 	workDir       string
+	indexKey      string
+	queryParams   string
+	distThreshold float32
+	seed          int
 	flatThreshold int
+	generation    uint64     // bumped on every mutation
+	cache         *lru.Cache // Search results, nil if disabled
+	cacheHits     uint64
+	cacheMisses   uint64
+	building      int32 // 1 if UpdateIndexAsync is running
 }
 
-func NewVectoDB(workDir string, dimIn int, metricType int, indexKey string, queryParams string, distThreshold float32, flatThreshold int) (vdb *VectoDB, err error) {
+// IndexKeyFlat is the index key of exact brute-force search. It requires no training, ignores queryParams such as nprobe,
+// and keeps all vectors in the flat, so that the recall is 100%. It's recommended if there're less than FlatMaxSize vectors.
+const IndexKeyFlat = "Flat"
+
+// FlatMaxSize is the number of vectors under which brute force is cheap enough, and IVF or PQ indexes are not worth it.
+const FlatMaxSize = 100000
+
+// NewVectoDB creates a VectoDB at workDir, loading the vectors and index there if any.
+// metricType is 0 - inner product, 1 - L2, or 2 - cosine. Cosine L2-normalizes both added and query vectors before an inner product search,
+// so that distances returned are in [-1, 1], higher is closer. The vectors stored are the normalized ones.
+// seed is the random seed of index training, 0 means faiss default. Index builds over the same data with the same seed produce the same index and search results.
+func NewVectoDB(workDir string, dimIn int, metricType int, indexKey string, queryParams string, distThreshold float32, flatThreshold int, seed int) (vdb *VectoDB, err error) {
 	log.Infof("creating VectoDB %v", workDir)
+	if indexKey == IndexKeyFlat && queryParams != "" {
+		log.Infof("%s: query params %v are ignored by %s", workDir, queryParams, IndexKeyFlat)
+	}
+	if err = ValidateIndexKey(dimIn, metricType, indexKey); err != nil {
+		return
+	}
+	if err = verifyMeta(workDir, dimIn, metricType, indexKey); err != nil {
+		return
+	}
 	wordDirC := C.CString(workDir)
 	indexKeyC := C.CString(indexKey)
 	queryParamsC := C.CString(queryParams)
-	vdbC := C.VectodbNew(wordDirC, C.long(dimIn), C.int(metricType), indexKeyC, queryParamsC, C.float(distThreshold))
+	vdbC := C.VectodbNew(wordDirC, C.long(dimIn), C.int(metricType), indexKeyC, queryParamsC, C.float(distThreshold), C.int(seed))
 	vdb = &VectoDB{
 		vdbC:          vdbC,
 		dim:           dimIn,
+		metricType:    metricType,
 		workDir:       workDir,
+		indexKey:      indexKey,
+		queryParams:   queryParams,
+		distThreshold: distThreshold,
+		seed:          seed,
 		flatThreshold: flatThreshold,
 	}
 	C.free(unsafe.Pointer(wordDirC))
 	C.free(unsafe.Pointer(indexKeyC))
 	C.free(unsafe.Pointer(queryParamsC))
+	if _, err = os.Stat(filepath.Join(workDir, metaFileName)); os.IsNotExist(err) {
+		// record the config of a new db, so that reopening it with a different one fails
+		if err = vdb.saveMeta(); err != nil {
+			vdb.Destroy()
+			vdb = nil
+		}
+	} else if err != nil {
+		err = errors.Wrap(err, "")
+		vdb.Destroy()
+		vdb = nil
+	}
+	return
+}
+
+// NewVectoDBFromQuantizer is the same as NewVectoDB, except that index builds reuse the given trained coarse quantizer rather than training one.
+// It saves CPU when many shards share the same data distribution. quantizer is exported by ExportQuantizer of an index with the same index key.
+func NewVectoDBFromQuantizer(workDir string, quantizer []byte, dimIn int, metricType int, indexKey string, queryParams string, distThreshold float32, flatThreshold int, seed int) (vdb *VectoDB, err error) {
+	if len(quantizer) == 0 {
+		err = errors.Errorf("%s: empty quantizer", workDir)
+		return
+	}
+	if vdb, err = NewVectoDB(workDir, dimIn, metricType, indexKey, queryParams, distThreshold, flatThreshold, seed); err != nil {
+		return
+	}
+	if C.VectodbSetQuantizer(vdb.vdbC, (*C.uchar)(&quantizer[0]), C.long(len(quantizer))) == 0 {
+		vdb.Destroy()
+		vdb = nil
+		err = errors.Errorf("%s: invalid quantizer for dim %v", workDir, dimIn)
+		return
+	}
+	return
+}
+
+// NewVectoDBFromIndex is the same as NewVectoDB, except that it activates the prebuilt index file at indexPath rather than building one.
+// The index shall be built by UpdateIndex over the same vectors added in the same order, i.e. offline on a copy of the db, since its labels
+// are the positions of vectors. The index file is copied to workDir and loaded from there, so that indexPath could be removed afterwards.
+// ErrConfigMismatch is returned if the index doesn't match dimIn and metricType, or isn't trained. ErrIndexAhead is returned if it covers
+// more vectors than the db.
+func NewVectoDBFromIndex(workDir string, indexPath string, dimIn int, metricType int, indexKey string, queryParams string, distThreshold float32, flatThreshold int, seed int) (vdb *VectoDB, err error) {
+	if vdb, err = NewVectoDB(workDir, dimIn, metricType, indexKey, queryParams, distThreshold, flatThreshold, seed); err != nil {
+		return
+	}
+	indexPathC := C.CString(indexPath)
+	ret := C.VectodbLoadIndex(vdb.vdbC, indexPathC)
+	C.free(unsafe.Pointer(indexPathC))
+	if ret <= 0 {
+		vdb.Destroy()
+		vdb = nil
+		if ret == 0 {
+			err = errors.Wrapf(ErrConfigMismatch, "%s: index %v", workDir, indexPath)
+		} else if ret == -2 {
+			err = errors.Wrapf(ErrIndexAhead, "%s: index %v", workDir, indexPath)
+		} else {
+			err = errors.Errorf("%s: invalid index %v", workDir, indexPath)
+		}
+		return
+	}
+	vdb.bumpGeneration()
+	if err = vdb.saveMeta(); err != nil {
+		vdb.Destroy()
+		vdb = nil
+	}
+	return
+}
+
+// ExportQuantizer serializes the trained coarse quantizer of the current IVF index, for NewVectoDBFromQuantizer.
+func (vdb *VectoDB) ExportQuantizer() (quantizer []byte, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	var dataC *C.uchar
+	length := C.VectodbExportQuantizer(vdb.vdbC, &dataC)
+	if length == 0 {
+		err = errors.Errorf("%s: there's no IVF index to export quantizer from", vdb.workDir)
+		return
+	}
+	quantizer = C.GoBytes(unsafe.Pointer(dataC), C.int(length))
+	C.free(unsafe.Pointer(dataC))
+	return
+}
+
+// Snapshot writes the index file, vectors and xids to a single file at path, which is consistent and atomically replaced.
+// Adds, removes and updates are blocked meanwhile, while searches are not. It's for backup, and for shipping a built index to
+// read-only replicas, which load it with LoadSnapshot.
+func (vdb *VectoDB) Snapshot(path string) (err error) {
+	vdb.swapLock.Lock()
+	defer vdb.swapLock.Unlock()
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	pathC := C.CString(path)
+	defer C.free(unsafe.Pointer(pathC))
+	if C.VectodbSnapshot(vdb.vdbC, pathC) == 0 {
+		err = errors.Errorf("%s: failed to snapshot to %v", vdb.workDir, path)
+	}
+	return
+}
+
+// LoadSnapshot replaces the content of the db with the snapshot at path, which shall be taken from a db of the same dim, metric type
+// and index key, otherwise ErrConfigMismatch is returned. The content is kept if the snapshot fails to be restored or swapped in.
+// It reopens the db, so that SetNormalize, SetRerankFloat64, SetFlatThreads and the quantizer of NewVectoDBFromQuantizer shall be set again.
+func (vdb *VectoDB) LoadSnapshot(path string) (err error) {
+	// an index built or swapped in meanwhile would read or rewrite the files being replaced
+	vdb.buildLock.Lock()
+	defer vdb.buildLock.Unlock()
+	vdb.swapLock.Lock()
+	defer vdb.swapLock.Unlock()
+	vdb.rwlock.Lock()
+	defer vdb.rwlock.Unlock()
+	log.Infof("%s: loading snapshot %v", vdb.workDir, path)
+	// restore to a temporary dir first, so that an invalid snapshot doesn't destroy the current content
+	tmpDir := vdb.workDir + ".snapshot"
+	if err = os.RemoveAll(tmpDir); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	pathC := C.CString(path)
+	tmpDirC := C.CString(tmpDir)
+	indexKeyC := C.CString(vdb.indexKey)
+	ret := C.VectodbRestoreSnapshot(pathC, tmpDirC, C.long(vdb.dim), C.int(vdb.metricType), indexKeyC)
+	C.free(unsafe.Pointer(pathC))
+	C.free(unsafe.Pointer(tmpDirC))
+	C.free(unsafe.Pointer(indexKeyC))
+	if ret == 0 {
+		err = errors.Wrapf(ErrConfigMismatch, "%s: snapshot %v", vdb.workDir, path)
+		return
+	} else if ret < 0 {
+		err = errors.Errorf("%s: failed to restore snapshot %v", vdb.workDir, path)
+		return
+	}
+	var fis []os.FileInfo
+	if fis, err = ioutil.ReadDir(tmpDir); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+
+	// Move the current content aside rather than clearing it, so that it's moved back if the snapshot fails to be swapped in halfway.
+	// The meta file of the current content would fail to verify the restored one, so that it's moved aside as well.
+	backupDir := vdb.workDir + ".old"
+	if err = os.RemoveAll(backupDir); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	if err = os.MkdirAll(backupDir, 0700); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	defer os.RemoveAll(backupDir)
+	var names, moved, swapped []string
+	if names, err = contentFileNames(vdb.workDir); err != nil {
+		return
+	}
+	rollback := func() {
+		for _, name := range swapped {
+			os.Remove(filepath.Join(vdb.workDir, name))
+		}
+		for _, name := range moved {
+			if err2 := os.Rename(filepath.Join(backupDir, name), filepath.Join(vdb.workDir, name)); err2 != nil {
+				log.Errorf("%s: failed to move back %v, error %+v", vdb.workDir, name, errors.Wrap(err2, ""))
+			}
+		}
+	}
+	for _, name := range names {
+		if err = os.Rename(filepath.Join(vdb.workDir, name), filepath.Join(backupDir, name)); err != nil {
+			err = errors.Wrap(err, "")
+			rollback()
+			return
+		}
+		moved = append(moved, name)
+	}
+	for _, fi := range fis {
+		if err = os.Rename(filepath.Join(tmpDir, fi.Name()), filepath.Join(vdb.workDir, fi.Name())); err != nil {
+			err = errors.Wrap(err, "")
+			rollback()
+			return
+		}
+		swapped = append(swapped, fi.Name())
+	}
+
+	C.VectodbDelete(vdb.vdbC)
+	vdb.vdbC = nil
+	workDirC := C.CString(vdb.workDir)
+	indexKeyC = C.CString(vdb.indexKey)
+	queryParamsC := C.CString(vdb.queryParams)
+	vdb.vdbC = C.VectodbNew(workDirC, C.long(vdb.dim), C.int(vdb.metricType), indexKeyC, queryParamsC, C.float(vdb.distThreshold), C.int(vdb.seed))
+	C.free(unsafe.Pointer(workDirC))
+	C.free(unsafe.Pointer(indexKeyC))
+	C.free(unsafe.Pointer(queryParamsC))
+	vdb.bumpGeneration()
+	err = vdb.saveMeta()
+	return
+}
+
+// contentFileNames returns the names of the files of the db content in workDir, which are cleared by VectodbClearWorkDir.
+func contentFileNames(workDir string) (names []string, err error) {
+	var fis []os.FileInfo
+	if fis, err = ioutil.ReadDir(workDir); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.Mode().IsRegular() && (name == "base.fvecs" || name == "update.fvecs" || name == metaFileName || strings.HasSuffix(name, ".index")) {
+			names = append(names, name)
+		}
+	}
 	return
 }
 
 func (vdb *VectoDB) Destroy() (err error) {
+	// a running build reads vdbC without rwlock
+	vdb.buildLock.Lock()
+	defer vdb.buildLock.Unlock()
+	vdb.rwlock.Lock()
+	defer vdb.rwlock.Unlock()
 	log.Infof("destroying VectoDB %+v", vdb)
 	C.VectodbDelete(vdb.vdbC)
 	vdb.vdbC = nil
 	return
 }
 
+// Reset removes all vectors, pending updates and the index, as if the db is created at an empty workDir.
+// It keeps the config and settings, which saves reopening the db when rebuilding it from scratch.
+func (vdb *VectoDB) Reset() (err error) {
+	// a running build maps the base file which is truncated here
+	vdb.buildLock.Lock()
+	defer vdb.buildLock.Unlock()
+	vdb.rwlock.Lock()
+	defer vdb.rwlock.Unlock()
+	log.Infof("%s: resetting", vdb.workDir)
+	C.VectodbReset(vdb.vdbC)
+	vdb.bumpGeneration()
+	err = vdb.saveMeta()
+	return
+}
+
 func (vdb *VectoDB) AddWithIds(xb []float32, xids []int64) (err error) {
+	vdb.rwlock.Lock()
+	defer vdb.rwlock.Unlock()
 	nb := len(xids)
 	if len(xb) != nb*vdb.dim {
 		log.Fatalf("invalid length of xb, want %v, have %v", nb*vdb.dim, len(xb))
 	}
+	done := cgoCall(CgoOpAddWithIds)
+	defer func() { done(err) }()
 	C.VectodbAddWithIds(vdb.vdbC, C.long(nb), (*C.float)(&xb[0]), (*C.long)(&xids[0]))
+	vdb.bumpGeneration()
 	return
 }
 
 func (vdb *VectoDB) UpdateWithIds(xb []float32, xids []int64) (err error) {
+	vdb.rwlock.Lock()
+	defer vdb.rwlock.Unlock()
 	nb := len(xids)
 	if len(xb) != nb*vdb.dim {
 		log.Fatalf("invalid length of xb, want %v, have %v", nb*vdb.dim, len(xb))
 	}
 	C.VectodbUpdateWithIds(vdb.vdbC, C.long(nb), (*C.float)(&xb[0]), (*C.long)(&xids[0]))
+	vdb.bumpGeneration()
+	return
+}
+
+// RemoveIds removes the vectors of the given xids from both the flat and the index, and returns the number of vectors removed.
+// The absent xids are ignored, so that nremoved is less than len(xids) if there're any. Indexes which don't support removal (i.e. HNSW)
+// keep the removed vectors until the next build, and searches filter them out. The removal survives reopening the db.
+func (vdb *VectoDB) RemoveIds(xids []int64) (nremoved int, err error) {
+	vdb.rwlock.Lock()
+	defer vdb.rwlock.Unlock()
+	if len(xids) == 0 {
+		return
+	}
+	nremoved = int(C.VectodbRemoveIds(vdb.vdbC, C.long(len(xids)), (*C.long)(&xids[0])))
+	if nremoved != 0 {
+		vdb.bumpGeneration()
+		// the removal zeroes counts in the checksummed prefix of the base file
+		err = vdb.saveMeta()
+	}
 	return
 }
 
 func (vdb *VectoDB) UpdateIndex() (err error) {
+	vdb.buildLock.Lock()
+	defer vdb.buildLock.Unlock()
+	done := cgoCall(CgoOpUpdateIndex)
+	defer func() { done(err) }()
 	var needBuild bool
 	var index unsafe.Pointer
 	var curNtrain, curNsize, ntrain, nflat, played int
@@ -93,16 +412,66 @@ func (vdb *VectoDB) UpdateIndex() (err error) {
 		if index, ntrain, err = vdb.buildIndex(curNtrain, curNsize); err != nil {
 			return
 		}
-		if ntrain != 0 {
-			if err = vdb.activateIndex(index, ntrain); err != nil {
-				return
-			}
+		if err = vdb.swapIndex(index, ntrain); err != nil {
+			return
 		}
 		log.Infof("%s: UpdateIndex done", vdb.workDir)
 	}
 	return
 }
 
+// Reindex rebuilds all vectors into a new index of newIndexKey and newQueryParams, i.e. a finer one after data grows.
+// Vectors are read from the base file which keeps them uncompressed, so that xids and vectors are kept exactly.
+// Searches keep serving against the previous index until the new one is swapped in. Reopen the db with newIndexKey afterwards.
+func (vdb *VectoDB) Reindex(newIndexKey, newQueryParams string) (err error) {
+	if err = ValidateIndexKey(vdb.dim, vdb.metricType, newIndexKey); err != nil {
+		return
+	}
+	vdb.buildLock.Lock()
+	defer vdb.buildLock.Unlock()
+	log.Infof("%s: reindexing from %v to %v", vdb.workDir, vdb.indexKey, newIndexKey)
+	if _, err = vdb.updateBase(); err != nil {
+		return
+	}
+	indexKeyC := C.CString(newIndexKey)
+	queryParamsC := C.CString(newQueryParams)
+	defer C.free(unsafe.Pointer(indexKeyC))
+	defer C.free(unsafe.Pointer(queryParamsC))
+	var ntrainC C.long
+	index := C.VectodbBuildIndexWithKey(vdb.vdbC, indexKeyC, queryParamsC, &ntrainC)
+
+	vdb.swapLock.Lock()
+	defer vdb.swapLock.Unlock()
+	vdb.rwlock.Lock()
+	C.VectodbSetIndexKey(vdb.vdbC, indexKeyC, queryParamsC)
+	vdb.indexKey = newIndexKey
+	vdb.queryParams = newQueryParams
+	vdb.rwlock.Unlock()
+	// a nil index, i.e. of Flat or too few vectors, moves all vectors to the flat
+	if err = vdb.swapIndexLocked(index, int(ntrainC)); err != nil {
+		return
+	}
+	log.Infof("%s: reindexing to %v done", vdb.workDir, newIndexKey)
+	return
+}
+
+// UpdateIndexAsync runs UpdateIndex on a background goroutine, and invokes cb with done true and the error of UpdateIndex once it completes.
+// Searches keep serving against the previous index meanwhile. If a previous one is still running, it's skipped and cb is invoked with done false
+// immediately, so that a periodic caller doesn't pile up builds.
+func (vdb *VectoDB) UpdateIndexAsync(cb func(done bool, err error)) {
+	if !atomic.CompareAndSwapInt32(&vdb.building, 0, 1) {
+		cb(false, nil)
+		return
+	}
+	go func() {
+		err := vdb.UpdateIndex()
+		atomic.StoreInt32(&vdb.building, 0)
+		cb(true, err)
+	}()
+}
+
+// buildIndex doesn't hold rwlock, so that a writer waiting for it doesn't block searches during a long build.
+// The C++ side maps the base file on its own, and buildLock held by the caller keeps Reset, LoadSnapshot and Destroy out.
 func (vdb *VectoDB) buildIndex(cur_ntrain, cur_ntotal int) (index unsafe.Pointer, ntrain int, err error) {
 	var ntrainC C.long
 	index = C.VectodbBuildIndex(vdb.vdbC, C.long(cur_ntrain), C.long(cur_ntotal), &ntrainC)
@@ -111,25 +480,107 @@ func (vdb *VectoDB) buildIndex(cur_ntrain, cur_ntotal int) (index unsafe.Pointer
 }
 
 func (vdb *VectoDB) updateBase() (played int, err error) {
+	vdb.rwlock.Lock()
+	defer vdb.rwlock.Unlock()
 	playedC := C.VectodbUpdateBase(vdb.vdbC)
 	played = int(playedC)
+	if played != 0 {
+		vdb.bumpGeneration()
+	}
 	return
 }
 
 func (vdb *VectoDB) GetTotal() (total int, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
 	totalC := C.VectodbGetFlatSize(vdb.vdbC)
 	total = int(totalC)
 	return
 }
 
 func (vdb *VectoDB) GetFlatSize() (nsize int, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
 	nsizeC := C.VectodbGetFlatSize(vdb.vdbC)
 	nsize = int(nsizeC)
 	return
 }
 
+// GetTotalSize returns the number of vectors in the index plus the flat. Unlike GetFlatSize, it doesn't drop when UpdateIndex moves
+// the flat into the index, so it tracks the growth of the database.
+func (vdb *VectoDB) GetTotalSize() (ntotal int, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	ntotalC := C.VectodbGetTotalSize(vdb.vdbC)
+	ntotal = int(ntotalC)
+	return
+}
+
+// IsTrained returns true if there's a trained index, i.e. UpdateIndex has built one. Searches scan the flat by brute force until then.
+// It's always false with IndexKeyFlat, and with less than 10000 vectors which are too few to train an index.
+func (vdb *VectoDB) IsTrained() (trained bool, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	var ntrain int
+	if ntrain, _, err = vdb.getIndexSize(); err != nil {
+		return
+	}
+	trained = ntrain != 0
+	return
+}
+
+// GetMemoryUsage estimates the memory of the index and the flat in bytes: vectors, PQ and SQ codes, IVF centroids and inverted lists, and HNSW links.
+// It's for budgeting RAM of many dbs per host. The base file, which is memory-mapped, and the xid map are excluded.
+func (vdb *VectoDB) GetMemoryUsage() (bytes int64, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	bytes = int64(C.VectodbGetMemoryUsage(vdb.vdbC))
+	return
+}
+
+// swapIndex writes the index file, rebuilds the flat, swaps them in, and saves meta. ntrain 0 means nothing is built.
+// It holds RLock only, since mutations are blocked meanwhile while searches aren't, and the C++ side locks the pointer swap.
+func (vdb *VectoDB) swapIndex(index unsafe.Pointer, ntrain int) (err error) {
+	vdb.swapLock.Lock()
+	defer vdb.swapLock.Unlock()
+	if ntrain == 0 {
+		vdb.rwlock.RLock()
+		defer vdb.rwlock.RUnlock()
+		err = vdb.saveMeta()
+		return
+	}
+	err = vdb.swapIndexLocked(index, ntrain)
+	return
+}
+
+// swapIndexLocked is the same as swapIndex, assumes swapLock is held.
+func (vdb *VectoDB) swapIndexLocked(index unsafe.Pointer, ntrain int) (err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	if err = vdb.activateIndex(index, ntrain); err != nil {
+		return
+	}
+	err = vdb.saveMeta()
+	return
+}
+
 func (vdb *VectoDB) activateIndex(index unsafe.Pointer, ntrain int) (err error) {
 	C.VectodbActivateIndex(vdb.vdbC, index, C.long(ntrain))
+	vdb.bumpGeneration()
+	return
+}
+
+// saveMeta recomputes checksums of the current index file and base file.
+func (vdb *VectoDB) saveMeta() (err error) {
+	var ntrain int
+	if ntrain, _, err = vdb.getIndexSize(); err != nil {
+		return
+	}
+	var indexFile string
+	if ntrain != 0 {
+		indexFile = getIndexFileName(vdb.indexKey, ntrain)
+	}
+	err = writeMeta(vdb.workDir, indexFile, vdb.dim, vdb.metricType, vdb.indexKey)
 	return
 }
 
@@ -141,19 +592,356 @@ func (vdb *VectoDB) getIndexSize() (ntrain, nsize int, err error) {
 	return
 }
 
+// Search searches the nearest neighbor of each query vector. xids[i] is -1 if nothing is found within the distance threshold.
+// It's safe to call concurrently with UpdateIndex. Searches see the old index until the new one is swapped in, never a partial one.
 func (vdb *VectoDB) Search(xq []float32, distances []float32, xids []int64) (ntotal int, err error) {
+	ntotal, err = vdb.SearchWithParams(xq, distances, xids, "")
+	return
+}
+
+// SearchWithParams is the same as Search, except that the faiss query params, i.e. "nprobe=64", apply to this call rather than
+// the queryParams of NewVectoDB. It trades recall for latency per call without reopening the db. params are ignored if there's
+// no index yet, and an error is returned if they're invalid for the index. Results of non-empty params are not cached.
+// Non-empty params are applied to the shared index and restored afterwards, so that such a call excludes other searches.
+func (vdb *VectoDB) SearchWithParams(xq []float32, distances []float32, xids []int64, params string) (ntotal int, err error) {
+	if params != "" {
+		vdb.rwlock.Lock()
+		defer vdb.rwlock.Unlock()
+	} else {
+		vdb.rwlock.RLock()
+		defer vdb.rwlock.RUnlock()
+	}
 	nq := len(xids)
+	if nq == 0 {
+		err = errors.Wrap(ErrZeroVector, "")
+		return
+	}
 	if len(xq) != nq*vdb.dim {
 		log.Fatalf("invalid length of xq, want %v, have %v", nq*vdb.dim, len(xq))
 	}
 	if len(distances) != nq {
 		log.Fatalf("invalid length of distances, want %v, have %v", nq, len(distances))
 	}
-	ntotalC := C.VectodbSearch(vdb.vdbC, C.long(nq), (*C.float)(&xq[0]), (*C.float)(&distances[0]), (*C.long)(&xids[0]))
+	if vdb.metricType != 1 && atomic.LoadInt32(&vdb.allowZero) == 0 {
+		for i := 0; i < nq; i++ {
+			if IsZeroVector(xq[i*vdb.dim : (i+1)*vdb.dim]) {
+				err = errors.Wrapf(ErrZeroVector, "xq[%d]", i)
+				return
+			}
+		}
+	}
+	var key searchCacheKey
+	cache := vdb.cache
+	if params != "" {
+		cache = nil
+	}
+	if cache != nil {
+		key = vdb.searchCacheKey(xq, nq)
+		var ok bool
+		if ntotal, ok = vdb.getCached(key, distances, xids); ok {
+			vdb.sqrtDistances(distances, xids)
+			return
+		}
+	}
+	done := cgoCall(CgoOpSearch)
+	paramsC := C.CString(params)
+	ntotalC := C.VectodbSearchWithParams(vdb.vdbC, C.long(nq), (*C.float)(&xq[0]), paramsC, (*C.float)(&distances[0]), (*C.long)(&xids[0]))
+	C.free(unsafe.Pointer(paramsC))
+	ntotal = int(ntotalC)
+	if ntotal < 0 {
+		ntotal = 0
+		err = errors.Errorf("%s: invalid query params %v for index key %v", vdb.workDir, params, vdb.indexKey)
+		done(err)
+		return
+	}
+	done(nil)
+	if cache != nil {
+		vdb.putCached(key, distances, xids, ntotal)
+	}
+	vdb.sqrtDistances(distances, xids)
+	return
+}
+
+// SearchTopK searches the k nearest neighbors of each of the nq query vectors in xq. D[i] and I[i] are the distances and xids of
+// the neighbors of the i-th query, in the order of distance (ascending for L2, descending for inner product). The distance threshold
+// doesn't apply. There're less than k of them if there're less than k vectors. It's safe to call concurrently with UpdateIndex as Search.
+func (vdb *VectoDB) SearchTopK(xq []float32, k int) (D [][]float32, I [][]int64, ntotal int, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	if len(xq) == 0 {
+		err = errors.Wrap(ErrZeroVector, "")
+		return
+	}
+	if len(xq)%vdb.dim != 0 {
+		log.Fatalf("invalid length of xq, want a multiple of %v, have %v", vdb.dim, len(xq))
+	}
+	if k <= 0 {
+		err = errors.Errorf("invalid k %v, want > 0", k)
+		return
+	}
+	nq := len(xq) / vdb.dim
+	if vdb.metricType != 1 && atomic.LoadInt32(&vdb.allowZero) == 0 {
+		for i := 0; i < nq; i++ {
+			if IsZeroVector(xq[i*vdb.dim : (i+1)*vdb.dim]) {
+				err = errors.Wrapf(ErrZeroVector, "xq[%d]", i)
+				return
+			}
+		}
+	}
+	distances := make([]float32, nq*k)
+	xids := make([]int64, nq*k)
+	done := cgoCall(CgoOpSearchTopK)
+	ntotalC := C.VectodbSearchTopK(vdb.vdbC, C.long(nq), (*C.float)(&xq[0]), C.long(k), (*C.float)(&distances[0]), (*C.long)(&xids[0]))
 	ntotal = int(ntotalC)
+	done(nil)
+	vdb.sqrtDistances(distances, xids)
+	D = make([][]float32, nq)
+	I = make([][]int64, nq)
+	for i := 0; i < nq; i++ {
+		n := 0
+		for n < k && xids[i*k+n] != -1 {
+			n++
+		}
+		D[i] = distances[i*k : i*k+n : i*k+n]
+		I[i] = xids[i*k : i*k+n : i*k+n]
+	}
 	return
 }
 
+// SearchBatch is the same as SearchTopK, except that the number of queries nq is given explicitly and checked against the length
+// of xq, so that a wrong stride is reported as an error rather than silently searching other vectors. D[i][j] and I[i][j] are the
+// distance and xid of the j-th nearest neighbor of the i-th query.
+func (vdb *VectoDB) SearchBatch(xq []float32, nq, k int) (D [][]float32, I [][]int64, err error) {
+	if nq < 0 || len(xq) != nq*vdb.dim {
+		err = errors.Errorf("invalid length of xq, want %v*%v, have %v", nq, vdb.dim, len(xq))
+		return
+	}
+	D, I, _, err = vdb.SearchTopK(xq, k)
+	return
+}
+
+// RangeSearch searches all neighbors within radius of each of the nq query vectors in xq, rather than a fixed number of them.
+// The neighbors of the i-th query are D[lims[i]:lims[i+1]] and I[lims[i]:lims[i+1]], in the order of distance as SearchTopK.
+// radius follows the metric the same as the distance threshold: inner product above it, or squared L2 below it.
+func (vdb *VectoDB) RangeSearch(xq []float32, radius float32) (lims []int, D []float32, I []int64, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	if len(xq) == 0 {
+		err = errors.Wrap(ErrZeroVector, "")
+		return
+	}
+	if len(xq)%vdb.dim != 0 {
+		log.Fatalf("invalid length of xq, want a multiple of %v, have %v", vdb.dim, len(xq))
+	}
+	nq := len(xq) / vdb.dim
+	if vdb.metricType != 1 && atomic.LoadInt32(&vdb.allowZero) == 0 {
+		for i := 0; i < nq; i++ {
+			if IsZeroVector(xq[i*vdb.dim : (i+1)*vdb.dim]) {
+				err = errors.Wrapf(ErrZeroVector, "xq[%d]", i)
+				return
+			}
+		}
+	}
+	limsC := make([]int64, nq+1)
+	res := C.VectodbRangeSearch(vdb.vdbC, C.long(nq), (*C.float)(&xq[0]), C.float(radius), (*C.long)(&limsC[0]))
+	n := limsC[nq]
+	D = make([]float32, n)
+	I = make([]int64, n)
+	if n == 0 {
+		// fetch frees res
+		C.VectodbRangeSearchFetch(res, nil, nil)
+	} else {
+		C.VectodbRangeSearchFetch(res, (*C.float)(&D[0]), (*C.long)(&I[0]))
+	}
+	lims = make([]int, nq+1)
+	for i, lim := range limsC {
+		lims[i] = int(lim)
+	}
+	vdb.sqrtDistances(D, I)
+	return
+}
+
+// SetSqrtL2 sets whether Search returns true Euclidean distances with L2 metric. By default they're squared as faiss computes them,
+// which saves a sqrt per result. The distance threshold always applies to squared distances. It has no effect with inner product metric.
+func (vdb *VectoDB) SetSqrtL2(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&vdb.sqrtL2, v)
+}
+
+// sqrtDistances converts squared L2 distances of found neighbors to true ones if SetSqrtL2.
+func (vdb *VectoDB) sqrtDistances(distances []float32, xids []int64) {
+	if vdb.metricType != 1 || atomic.LoadInt32(&vdb.sqrtL2) == 0 {
+		return
+	}
+	for i, xid := range xids {
+		if xid == -1 {
+			continue
+		}
+		// rounding errors could make a squared distance slightly negative
+		distances[i] = float32(math.Sqrt(math.Max(float64(distances[i]), 0)))
+	}
+}
+
+// SetAllowZeroQuery sets whether Search accepts all-zero query vectors with inner product metric. They're rejected with ErrZeroVector by default.
+func (vdb *VectoDB) SetAllowZeroQuery(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&vdb.allowZero, v)
+}
+
+// IsZeroVector returns true if x is empty or all-zero.
+func IsZeroVector(x []float32) bool {
+	for _, v := range x {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SetRerankFloat64 sets whether Search computes distances of the reranked candidates in float64.
+// The ANN search still uses float32. This makes ordering of near-duplicate vectors stable.
+func (vdb *VectoDB) SetRerankFloat64(on bool) {
+	var onC C.int
+	if on {
+		onC = 1
+	}
+	C.VectodbSetRerankFloat64(vdb.vdbC, onC)
+	vdb.bumpGeneration()
+}
+
+// SetNormalize sets whether vectors passed to AddWithIds, UpdateWithIds and searches are L2-normalized before they're stored or queried,
+// which makes inner product scores comparable when the input isn't normalized upstream. Vectors already stored are kept as they are,
+// so set it before adding any. It's always on with cosine metric.
+func (vdb *VectoDB) SetNormalize(on bool) {
+	var onC C.int
+	if on {
+		onC = 1
+	}
+	C.VectodbSetNormalize(vdb.vdbC, onC)
+	vdb.bumpGeneration()
+}
+
+// SetFlatThreads sets the number of threads which split a brute-force scan of the flat, which holds the vectors not indexed yet
+// and all vectors of a Flat index. It speeds up searches of fresh vectors on big flats. n <= 0 means the default, i.e. the number of CPUs
+// capped at 8, so that concurrent searches don't oversubscribe big hosts. Lower it if searches are many and mostly concurrent.
+// A thread takes at least 4096 vectors, so that small flats are scanned by one thread.
+func (vdb *VectoDB) SetFlatThreads(n int) {
+	C.VectodbSetFlatThreads(vdb.vdbC, C.long(n))
+}
+
+// ExistsWithin returns true if there's a vector closer than thr to xq, along with its xid.
+// It stops at the first neighbor found, which is cheaper than Search when a match is likely.
+func (vdb *VectoDB) ExistsWithin(xq []float32, thr float32) (exists bool, xid int64, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	if len(xq) != vdb.dim {
+		log.Fatalf("invalid length of xq, want %v, have %v", vdb.dim, len(xq))
+	}
+	var distanceC C.float
+	var xidC C.long
+	exists = C.VectodbExistsWithin(vdb.vdbC, (*C.float)(&xq[0]), C.float(thr), &distanceC, &xidC) != 0
+	xid = int64(xidC)
+	return
+}
+
+// CountWithin returns the number of vectors closer than thr to xq, without materializing the neighbors.
+// It range searches the index, and falls back to brute force if the index type doesn't support range search.
+func (vdb *VectoDB) CountWithin(xq []float32, thr float32) (count int, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	if len(xq) != vdb.dim {
+		log.Fatalf("invalid length of xq, want %v, have %v", vdb.dim, len(xq))
+	}
+	count = int(C.VectodbCountWithin(vdb.vdbC, (*C.float)(&xq[0]), C.float(thr)))
+	return
+}
+
+// EstimateMatches estimates the number of vectors closer than thr to xq, for labels like "about N results".
+// With an IVF index, it counts every vector of the inverted lists whose centroids are within thr, which only compares the nlist centroids
+// rather than the vectors. So the over-count is at most the sizes of the boundary lists, i.e. those whose centroids are within thr while
+// some of their members are not, and the under-count is at most the members within thr of lists whose centroids are beyond thr.
+// Removed vectors inflate the count until the index is rebuilt. The vectors not indexed yet are counted exactly, and without an IVF index
+// it's the same as CountWithin.
+func (vdb *VectoDB) EstimateMatches(xq []float32, thr float32) (count int, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	if len(xq) != vdb.dim {
+		log.Fatalf("invalid length of xq, want %v, have %v", vdb.dim, len(xq))
+	}
+	count = int(C.VectodbEstimateMatches(vdb.vdbC, (*C.float)(&xq[0]), C.float(thr)))
+	return
+}
+
+// Reconstruct returns the vector of the given xid decoded from the index or the flat, so that no separate copy of raw vectors is needed.
+// It's lossy if the index encodes vectors, i.e. PQ. An IVF index is scanned list by list, so it's for occasional use rather than hot paths.
+func (vdb *VectoDB) Reconstruct(xid int64) (vec []float32, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	vec = make([]float32, vdb.dim)
+	switch C.VectodbReconstruct(vdb.vdbC, C.long(xid), (*C.float)(&vec[0])) {
+	case 0:
+		vec = nil
+		err = errors.Wrapf(ErrXidNotFound, "xid %v", xid)
+	case -1:
+		vec = nil
+		err = errors.Errorf("index %v doesn't support reconstructing xid %v", vdb.indexKey, xid)
+	}
+	return
+}
+
+// Contains returns true if xid is present, either in the index or in the flat, and not removed. It looks up the xid map
+// rather than searching, so that it's exact regardless of the index and cheap enough to decide between AddWithIds and UpdateWithIds.
+func (vdb *VectoDB) Contains(xid int64) (found bool, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	found = C.VectodbContains(vdb.vdbC, C.long(xid)) != 0
+	return
+}
+
+// ProbedList is an inverted list of the IVF index probed by a search.
+type ProbedList struct {
+	ListNo     int64   // id of the coarse centroid, -1 if there're less lists than nprobe
+	Distance   float32 // distance between the query and the centroid
+	Candidates int     // number of vectors in the list, which are compared with the query
+}
+
+// ExplainSearch returns the inverted lists which Search of xq probes, in the order of probing. There're nprobe of them.
+// It helps to tell if a missed neighbor is in an unprobed list. It returns nothing if there's no IVF index (Flat, or not built yet).
+// The vectors not indexed yet are always compared since they're searched by brute force.
+func (vdb *VectoDB) ExplainSearch(xq []float32) (probes []ProbedList, err error) {
+	vdb.rwlock.RLock()
+	defer vdb.rwlock.RUnlock()
+	if len(xq) != vdb.dim {
+		log.Fatalf("invalid length of xq, want %v, have %v", vdb.dim, len(xq))
+	}
+	capacity := 64
+	for {
+		listNos := make([]int64, capacity)
+		listDists := make([]float32, capacity)
+		listSizes := make([]int64, capacity)
+		nprobe := int(C.VectodbExplainSearch(vdb.vdbC, (*C.float)(&xq[0]), C.long(capacity), (*C.long)(&listNos[0]), (*C.float)(&listDists[0]), (*C.long)(&listSizes[0])))
+		if nprobe > capacity {
+			capacity = nprobe
+			continue
+		}
+		probes = make([]ProbedList, nprobe)
+		for i := range probes {
+			probes[i] = ProbedList{
+				ListNo:     listNos[i],
+				Distance:   listDists[i],
+				Candidates: int(listSizes[i]),
+			}
+		}
+		return
+	}
+}
+
 /**
  * Static methods.
  */
@@ -163,10 +951,28 @@ func VectodbClearWorkDir(workDir string) (err error) {
 	wordDirC := C.CString(workDir)
 	C.VectodbClearWorkDir(wordDirC)
 	C.free(unsafe.Pointer(wordDirC))
+	if err = os.Remove(filepath.Join(workDir, metaFileName)); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		} else {
+			err = errors.Wrap(err, "")
+		}
+	}
+	return
+}
+
+// ValidateIndexKey checks if faiss index_factory accepts the index key for the dim and metric type, so that a bad config
+// is rejected with a clean error rather than failing deep in faiss.
+func ValidateIndexKey(dim int, metricType int, indexKey string) (err error) {
+	indexKeyC := C.CString(indexKey)
+	defer C.free(unsafe.Pointer(indexKeyC))
+	if C.VectodbValidateIndexKey(C.long(dim), C.int(metricType), indexKeyC) == 0 {
+		err = errors.Errorf("invalid index key %v for dim %v metric type %v", indexKey, dim, metricType)
+	}
 	return
 }
 
 // VectodbCompareDistance returns true if dis1 is closer then dis2.
 func VectodbCompareDistance(metricType int, dis1, dis2 float32) bool {
-	return (metricType == 0) == (dis1 > dis2)
+	return (metricType != 1) == (dis1 > dis2)
 }
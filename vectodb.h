@@ -7,24 +7,50 @@ extern "C" {
 /**
  * Constructor and destructor methods.
  */
-void* VectodbNew(char* work_dir, long dim, int metric_type, char* index_key, char* query_params, float dist_threshold);
+void* VectodbNew(char* work_dir, long dim, int metric_type, char* index_key, char* query_params, float dist_threshold, int seed);
 void VectodbDelete(void* vdb);
 
 void* VectodbBuildIndex(void* vdb, long cur_ntrain, long cur_ntotal, long* ntrain);
+void* VectodbBuildIndexWithKey(void* vdb, char* index_key, char* query_params, long* ntrain);
+void VectodbSetIndexKey(void* vdb, char* index_key, char* query_params);
 void VectodbAddWithIds(void* vdb, long nb, float* xb, long* xids);
 void VectodbUpdateWithIds(void* vdb, long nb, float* xb, long* xids);
+long VectodbRemoveIds(void* vdb, long n, long* xids);
 long VectodbUpdateBase(void* vdb);
 long VectodbGetTotal(void* vdb);
 long VectodbGetFlatSize(void* vdb);
+long VectodbGetTotalSize(void* vdb);
+long VectodbGetMemoryUsage(void* vdb);
 
 void VectodbActivateIndex(void* vdb, void* index, long ntrain);
 void VectodbGetIndexSize(void* vdb, long* ntrain, long* nsize);
+int VectodbLoadIndex(void* vdb, char* fp);
+void VectodbReset(void* vdb);
 long VectodbSearch(void* vdb, long nq, float* xq, float* distances, long* xids);
+long VectodbSearchWithParams(void* vdb, long nq, float* xq, char* params, float* distances, long* xids);
+long VectodbSearchTopK(void* vdb, long nq, float* xq, long k, float* distances, long* xids);
+// VectodbRangeSearch fills lims (size nq + 1), and returns the results which shall be copied and freed by VectodbRangeSearchFetch.
+void* VectodbRangeSearch(void* vdb, long nq, float* xq, float radius, long* lims);
+void VectodbRangeSearchFetch(void* res, float* distances, long* xids);
+void VectodbSetRerankFloat64(void* vdb, int on);
+void VectodbSetNormalize(void* vdb, int on);
+void VectodbSetFlatThreads(void* vdb, long n);
+int VectodbExistsWithin(void* vdb, float* xq, float thr, float* distance, long* xid);
+long VectodbCountWithin(void* vdb, float* xq, float thr);
+long VectodbEstimateMatches(void* vdb, float* xq, float thr);
+long VectodbExplainSearch(void* vdb, float* xq, long capacity, long* list_nos, float* list_dists, long* list_sizes);
+int VectodbReconstruct(void* vdb, long xid, float* vec);
+int VectodbContains(void* vdb, long xid);
+int VectodbSetQuantizer(void* vdb, unsigned char* data, long len);
+long VectodbExportQuantizer(void* vdb, unsigned char** data);
+int VectodbSnapshot(void* vdb, char* path);
 
 /**
  * Static methods.
  */
 void VectodbClearWorkDir(char* work_dir);
+int VectodbValidateIndexKey(long dim, int metric_type, char* index_key);
+int VectodbRestoreSnapshot(char* path, char* work_dir, long dim, int metric_type, char* index_key);
 
 #ifdef __cplusplus
 }
@@ -7,6 +7,8 @@
 class DbState;
 namespace faiss {
 class Index;
+struct IndexIVF;
+struct RangeSearchResult;
 };
 //class faiss::Index;
 
@@ -17,12 +19,13 @@ public:
      *
      * @param work_dir      input working direcotry
      * @param dim           input dimension of vector
-     * @param metric_type   input faiss metric, 0 - METRIC_INNER_PRODUCT, 1 - METRIC_L2
+     * @param metric_type   input faiss metric, 0 - METRIC_INNER_PRODUCT, 1 - METRIC_L2, 2 - METRIC_COSINE
      * @param index_key     input faiss index_key
      * @param query_params  input faiss selected params of auto-tuning
      * @param dist_threshold   input distance threshold
+     * @param seed          input random seed of index training, 0 means faiss default. Builds over the same data with the same seed result in the same index.
      */
-    VectoDB(const char* work_dir, long dim, int metric_type = 0, const char* index_key = "IVF4096,PQ32", const char* query_params = "nprobe=256,ht=256", float dist_threshold = 0.6f);
+    VectoDB(const char* work_dir, long dim, int metric_type = 0, const char* index_key = "IVF4096,PQ32", const char* query_params = "nprobe=256,ht=256", float dist_threshold = 0.6f, int seed = 0);
 
     /** 
      * Deconstruct a VectoDB.
@@ -38,6 +41,17 @@ public:
      */
     void BuildIndex(long cur_ntrain, long cur_nsize, faiss::Index*& index, long& ntrain) const;
 
+    /** 
+     * Build index with the given index_key and query_params rather than the current ones, i.e. to reindex with SetIndexKey and ActivateIndex.
+     */
+    void BuildIndex(const std::string& index_key, const std::string& query_params, long cur_ntrain, long cur_nsize, faiss::Index*& index, long& ntrain) const;
+
+    /** 
+     * Change the index_key and query_params of later builds and index files, and remove the current index files.
+     * The caller shall activate an index built with them right after, and make sure there's no build or index file access meanwhile.
+     */
+    void SetIndexKey(const char* index_key, const char* query_params);
+
     /** 
      * Add n vectors of dimension d to the index.
      * The upper layer does memory management for xb, xids.
@@ -56,6 +70,16 @@ public:
      */
     void UpdateWithIds(long nb, const float* xb, const long* xids);
 
+    /** 
+     * Remove vectors. They're removed from the index if it supports remove_ids (i.e. IVF), and filtered out by searches otherwise.
+     * The removal is persisted at base.fvecs, and the space is not reclaimed. GetTotal still counts them.
+     *
+     * @param n             input the number of xids
+     * @param xids          input xids of the vectors to remove
+     * @return              the number of vectors removed, xids which are absent are ignored
+     */
+    long RemoveIds(long n, const long* xids);
+
     /** 
      * Play update backlog and return the number of played updates.
      * Assuming this operation is rare, i.e. once every 15 minutes.
@@ -75,6 +99,18 @@ public:
      */
     long GetFlatSize();
 
+    /** 
+     * Get total size, which is the number of vectors in the index plus the flat.
+     * Vectors removed from an index which doesn't support remove_ids, and the ones removed from the flat, are still counted.
+     */
+    long GetTotalSize() const;
+
+    /** 
+     * Estimate the memory of the index and the flat, i.e. vectors, PQ codes, IVF centroids and inverted lists, and HNSW links.
+     * The base file, which is memory-mapped, and the xid map are excluded.
+     */
+    long GetMemoryUsage() const;
+
     /** 
      * Get update size.
      *
@@ -85,11 +121,27 @@ public:
      * Activate index built with TryBuildIndex or BuildIndex.
      * If upper layer decide not to activate an index, it shall delete the index to reclaim resource.
      * If index_key is Flat, then TryBuildIndex, BuildIndex, ActivateIndex can be skipped.
+     * The index and the flat are swapped atomically with regard to Search.
      * @param index     input index
      * @param ntrain    input the number of training points of the index
      */
     void ActivateIndex(faiss::Index* index, long ntrain);
 
+    /** 
+     * Activate a prebuilt index file, whose labels shall be line numbers of the base, i.e. the one built by BuildIndex over the same base.
+     * The file is copied to work_dir for reopening, and the copy is loaded, so that fp could be changed or removed afterwards.
+     *
+     * @param fp            input path of the index file
+     * @return              1 if activated, 0 if the index doesn't match dim or metric or isn't trained, -1 if it's invalid, -2 if it covers more vectors than the base
+     */
+    int LoadIndex(const char* fp);
+
+    /** 
+     * Remove all vectors, pending updates and the index, while keeping the config and settings.
+     * It blocks all other operations meanwhile.
+     */
+    void Reset();
+
     /** 
      * Get index size.
      *
@@ -101,6 +153,8 @@ public:
     /** 
      * Query n vectors of dimension d to the index.
      * The upper layer does memory management for xq, distances, xids.
+     * It's safe to call during BuildIndex and ActivateIndex. It sees the old index until it's swapped, never a partial one.
+     * xids[i] is -1 if there's no neighbor within the distance threshold.
      *
      * @param nq            input the number of vectors to search
      * @param xq            input vectors to search, size nq * d
@@ -109,6 +163,153 @@ public:
      */
     long Search(long nq, const float* xq, float* distances, long* xids);
 
+    /** 
+     * The same as Search, except that the given faiss query params, i.e. "nprobe=64", apply to this call rather than the ones of the constructor.
+     * They're ignored if there's no index yet.
+     *
+     * @param params        input faiss selected params of auto-tuning, empty means the ones of the constructor
+     * @return              -1 if params are invalid for the index
+     */
+    long SearchWithParams(long nq, const float* xq, const char* params, float* distances, long* xids);
+
+    /** 
+     * Query the k nearest neighbors of n vectors, in the order of distance (ascending for L2, descending for IP).
+     * The distance threshold doesn't apply. Candidates from the index are reranked with exact distances.
+     * xids[i*k+j] is -1 if there're less than j+1 vectors.
+     *
+     * @param nq            input the number of vectors to search
+     * @param xq            input vectors to search, size nq * d
+     * @param k             input the number of neighbors per query
+     * @param distances     output pairwise distances, size nq * k
+     * @param xids          output labels of the k-NNs, size nq * k
+     */
+    long SearchTopK(long nq, const float* xq, long k, float* distances, long* xids);
+
+    /** 
+     * Query all neighbors within radius of n vectors. The results of the i-th query are [lims[i], lims[i+1]) of distances and xids,
+     * in the order of distance. IVF indexes only search the probed lists. Indexes which don't implement range search are scanned by brute force.
+     *
+     * @param nq            input the number of vectors to search
+     * @param xq            input vectors to search, size nq * d
+     * @param radius        input inner product above it or squared L2 below it, the same as the distance threshold
+     * @param lims          output offsets of the results of each query, size nq + 1
+     * @param distances     output distances of the neighbors, size lims[nq]
+     * @param xids          output labels of the neighbors, size lims[nq]
+     */
+    long RangeSearch(long nq, const float* xq, float radius, std::vector<long>& lims, std::vector<float>& distances, std::vector<long>& xids);
+
+    /** 
+     * Compute distances of the reranked candidates in float64 rather than float32. The ANN search still uses float32.
+     * This makes ordering of near-duplicate vectors stable.
+     *
+     * @param on            input whether to rerank in float64
+     */
+    void SetRerankFloat64(bool on);
+
+    /** 
+     * L2-normalize vectors passed to AddWithIds, UpdateWithIds and searches before they're stored or queried.
+     * Vectors already stored are not affected. It's always on with METRIC_COSINE.
+     *
+     * @param on            input whether to normalize
+     */
+    void SetNormalize(bool on);
+
+    /** 
+     * Set the number of threads which split a brute-force scan of the flat. A thread takes at least 4096 vectors.
+     *
+     * @param n             input the number of threads, non-positive means the default, i.e. the number of CPUs capped at 8
+     */
+    void SetFlatThreads(long n);
+
+    /** 
+     * Check if there's a vector closer than thr to xq. It stops at the first neighbor found, which is cheaper than Search.
+     *
+     * @param xq            input vector to search, size d
+     * @param thr           input distance threshold
+     * @param distance      output distance of the neighbor found
+     * @param xid           output label of the neighbor found, -1 if nothing is found
+     */
+    bool ExistsWithin(const float* xq, float thr, float& distance, long& xid);
+
+    /** 
+     * Count vectors closer than thr to xq without materializing them. It's cheaper than Search for analytics.
+     * IVF indexes only count the probed lists. Indexes which don't implement range search are scanned by brute force.
+     *
+     * @param xq            input vector to search, size d
+     * @param thr           input distance threshold, inner product above it or squared L2 below it
+     * @return              the number of vectors within thr
+     */
+    long CountWithin(const float* xq, float thr);
+
+    /** 
+     * Estimate the number of vectors closer than thr to xq with IVF cluster statistics. It's much cheaper than CountWithin since only
+     * the centroids are compared. Every vector of a list, removed or not, is counted iff its centroid is within thr. So it over-counts at most
+     * the lists whose centroids are within thr but members are not, and under-counts at most the members within thr of the other lists.
+     * The flat is counted exactly, and indexes other than IVF are counted exactly.
+     *
+     * @param xq            input vector to search, size d
+     * @param thr           input distance threshold, inner product above it or squared L2 below it
+     * @return              the estimated number of vectors within thr
+     */
+    long EstimateMatches(const float* xq, float thr);
+
+    /** 
+     * Explain which inverted lists of the IVF index a search of xq probes. It's for debugging recall.
+     *
+     * @param xq            input vector to search, size d
+     * @param capacity      input capacity of the output arrays
+     * @param list_nos      output ids of the probed lists (coarse centroids), in the order of probing, size min(nprobe, capacity)
+     * @param list_dists    output distances between xq and the probed centroids
+     * @param list_sizes    output number of candidates of each probed list
+     * @return              nprobe, 0 if there's no IVF index
+     */
+    long ExplainSearch(const float* xq, long capacity, long* list_nos, float* list_dists, long* list_sizes) const;
+
+    /** 
+     * Reconstruct the vector of the given xid from the index or the flat. It's lossy if the index encodes vectors, i.e. PQ.
+     * An IVF index is scanned list by list since it doesn't maintain the direct map.
+     *
+     * @param xid           input xid of the vector
+     * @param vec           output reconstructed vector, size d
+     * @return              1 if reconstructed, 0 if xid is absent or removed, -1 if the index doesn't support reconstruction
+     */
+    int Reconstruct(long xid, float* vec) const;
+
+    /** 
+     * Tell if the given xid is present, either in the index or in the flat. Removed xids are absent.
+     *
+     * @param xid           input xid of the vector
+     * @return              true if present
+     */
+    bool Contains(long xid) const;
+
+    /** 
+     * Reuse a trained coarse quantizer for later index builds, rather than training one. Other parts of the index, i.e. PQ, are still trained.
+     * It's ignored if the index_key is not IVF, or the number of centroids doesn't match.
+     *
+     * @param data          input quantizer serialized by ExportQuantizer
+     * @param len           input length of data
+     * @return              false if data is not a valid index of the dimension
+     */
+    bool SetQuantizer(const uint8_t* data, long len);
+
+    /** 
+     * Serialize the coarse quantizer of the current IVF index.
+     *
+     * @param data          output serialized quantizer
+     * @return              false if there's no IVF index
+     */
+    bool ExportQuantizer(std::vector<uint8_t>& data) const;
+
+    /** 
+     * Write the index file, base and pending updates to a single file, which is consistent and atomically replaced.
+     * Adds, removes and updates are blocked meanwhile, while searches are not.
+     *
+     * @param path          input path of the snapshot
+     * @return              false if failed to write the snapshot
+     */
+    bool Snapshot(const char* path);
+
 public:
     /** 
      * Remove base and index files under the given work directory.
@@ -117,6 +318,28 @@ public:
      */
     static void ClearWorkDir(const char* work_dir);
 
+    /** 
+     * Check if faiss index_factory accepts the index_key for the dim and metric.
+     *
+     * @param dim           input dimension of vector
+     * @param metric_type   input metric, 0 - METRIC_INNER_PRODUCT, 1 - METRIC_L2, 2 - METRIC_COSINE
+     * @param index_key     input faiss index_key
+     * @return              false if the index_key, dim or metric is invalid
+     */
+    static bool ValidateIndexKey(long dim, int metric_type, const char* index_key);
+
+    /** 
+     * Clear the given work directory and restore a snapshot written by Snapshot there.
+     *
+     * @param path          input path of the snapshot
+     * @param work_dir      input working direcotry
+     * @param dim           input dimension of vector, which shall match the snapshot
+     * @param metric_type   input metric, which shall match the snapshot
+     * @param index_key     input faiss index_key, which shall match the snapshot
+     * @return              1 if restored, 0 if the snapshot doesn't match the config, -1 if it's invalid
+     */
+    static int RestoreSnapshot(const char* path, const char* work_dir, long dim, int metric_type, const char* index_key);
+
     /** 
      * Compare distance. Return true if dis1 is closer then dis2.
      *
@@ -125,7 +348,13 @@ public:
     {
         return (metric_type == 0) == (dis1 > dis2);
     }
+    static bool CompareDistance(int metric_type, double dis1, double dis2)
+    {
+        return (metric_type == 0) == (dis1 > dis2);
+    }
     static void Normalize(std::vector<float>& vec);
+    // METRIC_COSINE is inner product over L2-normalized vectors. Both added and query vectors are normalized, so that distances are in [-1, 1].
+    static const int METRIC_COSINE = 2;
     static void mmapFile(const std::string& fp, uint8_t*& data, long& len_data);
     static void munmapFile(const std::string& fp, uint8_t*& data, long& len_data);
 
@@ -134,6 +363,16 @@ private:
     std::string getIndexFp(long ntrain) const;
     std::string getUpdateFp() const;
     long getNumLines(long len_data, long len_base_line) const;
+    void truncatePartialLine(const std::string& fp, long len_line) const;
+    double distance64(const float* x, const float* y) const;
+    void searchFlat(long nq, const float* xq, long k, float* distances, long* labels) const;
+    void activateIndex(faiss::Index* index, long ntrain, const std::string& fp_index);
+    void removeFromIndex(faiss::Index* index, long index_size, const std::vector<long>& line_nums) const;
+    bool isRemoved(long line_num) const;
+    long countRemovedFlat() const;
+    const float* normalized(long n, const float* x, std::vector<float>& buf) const;
+    bool reconstructIVF(const faiss::IndexIVF* index_ivf, long line_num, float* vec) const;
+    long countAlive(const faiss::RangeSearchResult& res, long start_num) const;
     long getIndexFpNtrain() const;
     void clearIndexFiles();
     void readBase(const uint8_t* data, long len_data, long start_num, std::vector<float>& base) const;
@@ -145,9 +384,12 @@ private:
     long len_vec;
     long len_base_line;
     long len_upd_line;
-    int metric_type;
+    int metric_type; // faiss metric, METRIC_COSINE is mapped to 0
+    bool cosine; // METRIC_COSINE, which always normalizes vectors
     float dist_threshold;
     std::string index_key;
     std::string query_params;
+    int seed;
+    std::unique_ptr<faiss::Index> quantizer; // the coarse quantizer to reuse, nullptr if it's trained per build
     std::unique_ptr<DbState> state;
 };
@@ -0,0 +1,81 @@
+package vectodb
+
+import (
+	"sync/atomic"
+
+	"github.com/cespare/xxhash"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pkg/errors"
+)
+
+// searchCacheKey identifies a Search call. Every mutation of VectoDB bumps its generation,
+// so entries of former generations are never hit again and age out of the LRU.
+type searchCacheKey struct {
+	hash       uint64 // hash of xq
+	nq         int
+	generation uint64
+}
+
+type searchCacheVal struct {
+	distances []float32
+	xids      []int64
+	ntotal    int
+}
+
+// SetSearchCacheSize enables caching of Search results with a LRU of the given number of entries. Zero disables it.
+// It shall be called before serving searches.
+func (vdb *VectoDB) SetSearchCacheSize(size int) (err error) {
+	if size <= 0 {
+		vdb.cache = nil
+		return
+	}
+	if vdb.cache, err = lru.New(size); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	return
+}
+
+// SearchCacheStats returns the number of Search calls which hit and missed the cache.
+func (vdb *VectoDB) SearchCacheStats() (hits, misses uint64) {
+	return atomic.LoadUint64(&vdb.cacheHits), atomic.LoadUint64(&vdb.cacheMisses)
+}
+
+// bumpGeneration invalidates cached Search results.
+func (vdb *VectoDB) bumpGeneration() {
+	atomic.AddUint64(&vdb.generation, 1)
+}
+
+func (vdb *VectoDB) searchCacheKey(xq []float32, nq int) searchCacheKey {
+	return searchCacheKey{
+		hash:       allocateXid(xxhash.New(), xq),
+		nq:         nq,
+		generation: atomic.LoadUint64(&vdb.generation),
+	}
+}
+
+// getCached copies the cached result into distances and xids.
+func (vdb *VectoDB) getCached(key searchCacheKey, distances []float32, xids []int64) (ntotal int, ok bool) {
+	var valInf interface{}
+	if valInf, ok = vdb.cache.Get(key); !ok {
+		atomic.AddUint64(&vdb.cacheMisses, 1)
+		return
+	}
+	atomic.AddUint64(&vdb.cacheHits, 1)
+	val := valInf.(*searchCacheVal)
+	copy(distances, val.distances)
+	copy(xids, val.xids)
+	ntotal = val.ntotal
+	return
+}
+
+func (vdb *VectoDB) putCached(key searchCacheKey, distances []float32, xids []int64, ntotal int) {
+	val := &searchCacheVal{
+		distances: make([]float32, len(distances)),
+		xids:      make([]int64, len(xids)),
+		ntotal:    ntotal,
+	}
+	copy(val.distances, distances)
+	copy(val.xids, xids)
+	vdb.cache.Add(key, val)
+}
@@ -0,0 +1,167 @@
+package vectodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cespare/xxhash"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	metaFileName = "meta.json"
+	baseFileName = "base.fvecs"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrConfigMismatch is returned when reopening a db with a different dim, metric type or index key than it's built with.
+var ErrConfigMismatch = errors.New("config mismatch")
+
+// vdbMeta is persisted as <workDir>/meta.json.
+// base.fvecs is append-only except when playing updates, so only its prefix which existed at UpdateIndex is checksummed.
+type vdbMeta struct {
+	IndexFile     string `json:"indexFile"` // empty if there's no index
+	IndexChecksum uint64 `json:"indexChecksum"`
+	BaseLen       int64  `json:"baseLen"`
+	BaseChecksum  uint64 `json:"baseChecksum"` // checksum of the first BaseLen bytes of base.fvecs
+
+	// Dim is 0 in meta files written before the config is recorded, whose config isn't verified.
+	Dim        int    `json:"dim,omitempty"`
+	MetricType int    `json:"metricType"`
+	IndexKey   string `json:"indexKey"`
+}
+
+// getLenBaseLine returns the length of a line of base.fvecs: <xid> <count> {<dim>}<float>
+func getLenBaseLine(dim int) int64 {
+	return int64(16 + 4*dim)
+}
+
+func getIndexFileName(indexKey string, ntrain int) string {
+	return fmt.Sprintf("%s.%d.index", indexKey, ntrain)
+}
+
+// checksumFile returns the xxhash of the first n bytes of the given file. n < 0 means the whole file.
+func checksumFile(fp string, n int64) (sum uint64, err error) {
+	var f *os.File
+	if f, err = os.Open(fp); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	defer f.Close()
+	h64 := xxhash.New()
+	if n < 0 {
+		_, err = io.Copy(h64, f)
+	} else {
+		_, err = io.CopyN(h64, f, n)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %v", fp)
+		return
+	}
+	sum = h64.Sum64()
+	return
+}
+
+// writeMeta checksums the current index file and base file, and saves them to the meta file atomically along with the config.
+// The partial line at the end of base file is excluded since it's dropped on load.
+func writeMeta(workDir, indexFile string, dim, metricType int, indexKey string) (err error) {
+	lenBaseLine := getLenBaseLine(dim)
+	meta := vdbMeta{
+		IndexFile:  indexFile,
+		Dim:        dim,
+		MetricType: metricType,
+		IndexKey:   indexKey,
+	}
+	if indexFile != "" {
+		if meta.IndexChecksum, err = checksumFile(filepath.Join(workDir, indexFile), -1); err != nil {
+			return
+		}
+	}
+	fpBase := filepath.Join(workDir, baseFileName)
+	var fi os.FileInfo
+	if fi, err = os.Stat(fpBase); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	meta.BaseLen = fi.Size() - fi.Size()%lenBaseLine
+	if meta.BaseChecksum, err = checksumFile(fpBase, meta.BaseLen); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = json.Marshal(&meta); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	fp := filepath.Join(workDir, metaFileName)
+	if err = ioutil.WriteFile(fp+".tmp", data, 0600); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	if err = os.Rename(fp+".tmp", fp); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	return
+}
+
+// verifyMeta verifies the config against the one in the meta file, and the index file and base file against checksums in the meta file.
+// It's a no-op if the meta file doesn't exist.
+func verifyMeta(workDir string, dim, metricType int, indexKey string) (err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(filepath.Join(workDir, metaFileName)); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		} else {
+			err = errors.Wrap(err, "")
+		}
+		return
+	}
+	var meta vdbMeta
+	if err = json.Unmarshal(data, &meta); err != nil {
+		err = errors.Wrapf(err, "%s: invalid meta file", workDir)
+		return
+	}
+	if meta.Dim != 0 && (meta.Dim != dim || meta.MetricType != metricType || meta.IndexKey != indexKey) {
+		err = errors.Wrapf(ErrConfigMismatch, "%s: want dim %v metric type %v index key %v, have dim %v metric type %v index key %v",
+			workDir, dim, metricType, indexKey, meta.Dim, meta.MetricType, meta.IndexKey)
+		return
+	}
+	var sum uint64
+	if meta.IndexFile != "" {
+		fpIndex := filepath.Join(workDir, meta.IndexFile)
+		if _, err = os.Stat(fpIndex); os.IsNotExist(err) {
+			// the process could exit after activating a new index and before saving the meta file
+			log.Warnf("%s: index file %v in meta file is absent, skipped verifying it", workDir, meta.IndexFile)
+			err = nil
+		} else if sum, err = checksumFile(fpIndex, -1); err != nil {
+			return
+		} else if sum != meta.IndexChecksum {
+			err = errors.Wrapf(ErrChecksumMismatch, "%s: index file %v, want %016x, have %016x", workDir, meta.IndexFile, meta.IndexChecksum, sum)
+			return
+		}
+	}
+	fpBase := filepath.Join(workDir, baseFileName)
+	var fi os.FileInfo
+	if fi, err = os.Stat(fpBase); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	if fi.Size() < meta.BaseLen {
+		err = errors.Wrapf(ErrChecksumMismatch, "%s: base file is truncated, want at least %v bytes, have %v", workDir, meta.BaseLen, fi.Size())
+		return
+	}
+	if sum, err = checksumFile(fpBase, meta.BaseLen); err != nil {
+		return
+	}
+	if sum != meta.BaseChecksum {
+		err = errors.Wrapf(ErrChecksumMismatch, "%s: base file, want %016x, have %016x", workDir, meta.BaseChecksum, sum)
+		return
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package vectodb
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operations of cgo calls, which label the cgo metrics.
+const (
+	CgoOpSearch      = "search"
+	CgoOpSearchTopK  = "search_top_k"
+	CgoOpAddWithIds  = "add_with_ids"
+	CgoOpUpdateIndex = "update_index"
+)
+
+// The cgo metrics are registered to the prometheus default registry. Comparing them with the request latency tells
+// whether the time goes to Go or FAISS.
+var (
+	cgoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vectodb",
+		Name:      "cgo_duration_seconds",
+		Help:      "Duration of cgo calls into FAISS.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10), // 100us ~ 26s
+	}, []string{"op"})
+	cgoErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vectodb",
+		Name:      "cgo_errors_total",
+		Help:      "Number of failed cgo calls into FAISS.",
+	}, []string{"op"})
+	cgoInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "vectodb",
+		Name:      "cgo_in_flight",
+		Help:      "Number of cgo calls into FAISS in flight.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(cgoDuration, cgoErrors, cgoInFlight)
+}
+
+// cgoCall tracks a cgo call of op. The returned func shall be called with the result of the call once it returns.
+func cgoCall(op string) (done func(err error)) {
+	cgoInFlight.Inc()
+	start := time.Now()
+	done = func(err error) {
+		cgoInFlight.Dec()
+		cgoDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+		if err != nil {
+			cgoErrors.WithLabelValues(op).Inc()
+		}
+	}
+	return
+}
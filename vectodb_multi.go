@@ -120,7 +120,7 @@ func NewVectodbMulti(workDir string, dim int, metricType int, indexKey string, q
 	sort.Ints(seqs)
 	for _, seq := range seqs {
 		dp := filepath.Join(workDir, getWorkDir(seq))
-		vdb, err = NewVectoDB(dp, dim, metricType, indexKey, queryParams, distThr, vm.sizeLimit/200)
+		vdb, err = NewVectoDB(dp, dim, metricType, indexKey, queryParams, distThr, vm.sizeLimit/200, 0)
 		vm.vdbs = append(vm.vdbs, vdb)
 	}
 	vm.maxSeq = seqs[len(seqs)-1]
@@ -184,7 +184,7 @@ func (vm *VectodbMulti) AddWithIds(xb []float32, xids []int64) (err error) {
 		} else {
 			vm.maxSeq++
 			dp := filepath.Join(vm.workDir, getWorkDir(vm.maxSeq))
-			if vdb, err = NewVectoDB(dp, vm.dim, vm.metricType, vm.indexKey, vm.queryParams, vm.distThr, vm.sizeLimit/200); err != nil {
+			if vdb, err = NewVectoDB(dp, vm.dim, vm.metricType, vm.indexKey, vm.queryParams, vm.distThr, vm.sizeLimit/200, 0); err != nil {
 				return
 			}
 			vm.vdbs = append(vm.vdbs, vdb)
@@ -2,10 +2,20 @@ package vectodb
 
 import (
 	"fmt"
+	"io/ioutil"
 	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 )
 
@@ -26,7 +36,7 @@ const (
 func TestVectodbNew(t *testing.T) {
 	var err error
 	VectodbClearWorkDir(workDir)
-	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
 	require.NoError(t, err)
 	err = vdb.Destroy()
 	require.NoError(t, err)
@@ -68,7 +78,7 @@ func diff(d int, xb []float32, xids, I []int64, D []float32) {
 func TestVectodbUpdate(t *testing.T) {
 	var err error
 	VectodbClearWorkDir(workDir)
-	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
 	require.NoError(t, err)
 
 	const nb int = 100
@@ -89,7 +99,7 @@ func TestVectodbUpdate(t *testing.T) {
 		require.Equal(t, dis, float32(0))
 	}
 
-	err = vdb.AddWithIds(nb, xb, xids)
+	err = vdb.AddWithIds(xb, xids)
 	require.NoError(t, err)
 
 	total, err := vdb.GetTotal()
@@ -99,7 +109,7 @@ func TestVectodbUpdate(t *testing.T) {
 	D := make([]float32, nb)
 	I := make([]int64, nb)
 
-	total, err = vdb.Search(nb, xb, D, I)
+	total, err = vdb.Search(xb, D, I)
 	require.NoError(t, err)
 	require.Equal(t, nb, total)
 	fmt.Printf("D: %+v\n", D)
@@ -108,7 +118,7 @@ func TestVectodbUpdate(t *testing.T) {
 	require.Equal(t, xids, I)
 
 	// update with the same vector
-	err = vdb.UpdateWithIds(nb, xb, xids)
+	err = vdb.UpdateWithIds(xb, xids)
 	require.NoError(t, err)
 
 	err = vdb.UpdateIndex()
@@ -117,7 +127,7 @@ func TestVectodbUpdate(t *testing.T) {
 	D2 := make([]float32, nb)
 	I2 := make([]int64, nb)
 
-	total2, err := vdb.Search(nb, xb, D2, I2)
+	total2, err := vdb.Search(xb, D2, I2)
 	require.NoError(t, err)
 	require.Equal(t, nb, total2)
 	fmt.Printf("D2: %+v\n", D2)
@@ -128,9 +138,9 @@ func TestVectodbUpdate(t *testing.T) {
 	err = vdb.Destroy()
 	require.NoError(t, err)
 
-	vdb2, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr)
+	vdb2, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
 	require.NoError(t, err)
-	total3, err := vdb2.Search(nb, xb, D2, I2)
+	total3, err := vdb2.Search(xb, D2, I2)
 	require.NoError(t, err)
 	require.Equal(t, nb, total3)
 	fmt.Printf("D2: %+v\n", D2)
@@ -141,3 +151,1808 @@ func TestVectodbUpdate(t *testing.T) {
 	err = vdb2.Destroy()
 	require.NoError(t, err)
 }
+
+func TestVectodbChecksum(t *testing.T) {
+	var err error
+	const ivfIndexKey string = "IVF16,Flat"
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	// the index is built only if there are at least 10000 vectors
+	const nb int = 10000
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb; i++ {
+		for j := 0; j < dim; j++ {
+			xb[i*dim+j] = rand.Float32()
+		}
+		normalizeInplace(dim, xb[i*dim:(i+1)*dim])
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	ntrain, _, err := vdb.getIndexSize()
+	require.NoError(t, err)
+	require.NotEqual(t, 0, ntrain)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	// reopen an intact work dir
+	vdb, err = NewVectoDB(workDir, dim, metric, ivfIndexKey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	// flip a byte in the index file
+	fpIndex := filepath.Join(workDir, getIndexFileName(ivfIndexKey, ntrain))
+	f, err := os.OpenFile(fpIndex, os.O_RDWR, 0)
+	require.NoError(t, err)
+	fi, err := f.Stat()
+	require.NoError(t, err)
+	b := make([]byte, 1)
+	off := fi.Size() / 2
+	_, err = f.ReadAt(b, off)
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, off)
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+
+	_, err = NewVectoDB(workDir, dim, metric, ivfIndexKey, queryParams, distThr, flatThr, 0)
+	require.Equal(t, ErrChecksumMismatch, errors.Cause(err))
+}
+
+func TestVectodbExistsWithin(t *testing.T) {
+	var err error
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	xb := []float32{1, 0, 0, 1}
+	xids := []int64{100, 101}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+
+	// l2 distance (squared) from xq to xb[0] is 0.01, to xb[1] is 1.81
+	xq := []float32{0.9, 0}
+	exists, xid, err := vdb.ExistsWithin(xq, 0.02)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, int64(100), xid)
+
+	exists, xid, err = vdb.ExistsWithin(xq, 0.005)
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Equal(t, int64(-1), xid)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbPartialLineRecovery(t *testing.T) {
+	var err error
+	// points of a high dimension are far from each other, so that each one is the nearest neighbor of itself
+	const dim int = 64
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	const nb int = 1000
+	const nb2 int = 10
+	xb := make([]float32, (nb+nb2)*dim)
+	xids := make([]int64, nb+nb2)
+	for i := 0; i < nb+nb2; i++ {
+		for j := 0; j < dim; j++ {
+			xb[i*dim+j] = rand.Float32()
+		}
+		normalizeInplace(dim, xb[i*dim:(i+1)*dim])
+		xids[i] = int64(1000 + i)
+	}
+	for i := 0; i < nb; i += 100 {
+		err = vdb.AddWithIds(xb[i*dim:(i+100)*dim], xids[i:i+100])
+		require.NoError(t, err)
+	}
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	// simulate a crash in the middle of appending a line
+	f, err := os.OpenFile(filepath.Join(workDir, baseFileName), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1, 2, 3, 4, 5, 6, 7})
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+
+	vdb, err = NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	total, err := vdb.GetTotal()
+	require.NoError(t, err)
+	require.Equal(t, nb, total)
+	// appending after recovery shall keep lines aligned
+	err = vdb.AddWithIds(xb[nb*dim:], xids[nb:])
+	require.NoError(t, err)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	vdb, err = NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	total, err = vdb.GetTotal()
+	require.NoError(t, err)
+	require.Equal(t, nb+nb2, total)
+	D := make([]float32, nb+nb2)
+	I := make([]int64, nb+nb2)
+	_, err = vdb.Search(xb, D, I)
+	require.NoError(t, err)
+	require.Equal(t, xids, I)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbRerankFloat64(t *testing.T) {
+	var err error
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, 2e6, flatThr, 0)
+	require.NoError(t, err)
+	vdb.SetRerankFloat64(true)
+
+	// l2 distances (squared) from xq are 1e6+4e-4 and 1e6+1e-4. They tie in float32.
+	xq := []float32{0, 0}
+	xb := []float32{1000, 0.02, 1000, 0.01}
+	xids := []int64{1, 2}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+
+	D := make([]float32, 1)
+	I := make([]int64, 1)
+	_, err = vdb.Search(xq, D, I)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), I[0])
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbSearchCache(t *testing.T) {
+	var err error
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	require.NoError(t, vdb.SetSearchCacheSize(16))
+
+	err = vdb.AddWithIds([]float32{1, 0}, []int64{100})
+	require.NoError(t, err)
+	xq := []float32{0.9, 0}
+	D := make([]float32, 1)
+	I := make([]int64, 1)
+	_, err = vdb.Search(xq, D, I)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), I[0])
+	hits, misses := vdb.SearchCacheStats()
+	require.Equal(t, uint64(0), hits)
+	require.Equal(t, uint64(1), misses)
+
+	// a repeated query hits the cache
+	I[0] = 0
+	_, err = vdb.Search(xq, D, I)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), I[0])
+	hits, misses = vdb.SearchCacheStats()
+	require.Equal(t, uint64(1), hits)
+	require.Equal(t, uint64(1), misses)
+
+	// an add invalidates the cache
+	err = vdb.AddWithIds([]float32{0.9, 0}, []int64{101})
+	require.NoError(t, err)
+	_, err = vdb.Search(xq, D, I)
+	require.NoError(t, err)
+	require.Equal(t, int64(101), I[0])
+	hits, misses = vdb.SearchCacheStats()
+	require.Equal(t, uint64(1), hits)
+	require.Equal(t, uint64(2), misses)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbZeroQuery(t *testing.T) {
+	D := make([]float32, 1)
+	I := make([]int64, 1)
+	for _, metricType := range []int{0, 1} {
+		VectodbClearWorkDir(workDir)
+		vdb, err := NewVectoDB(workDir, dim, metricType, indexkey, queryParams, distThr, flatThr, 0)
+		require.NoError(t, err)
+		err = vdb.AddWithIds([]float32{1, 0}, []int64{100})
+		require.NoError(t, err)
+
+		// empty query vectors are rejected under both metrics
+		_, err = vdb.Search([]float32{}, []float32{}, []int64{})
+		require.Equal(t, ErrZeroVector, errors.Cause(err))
+
+		// all-zero ones are rejected only with inner product metric, unless allowed
+		_, err = vdb.Search([]float32{0, 0}, D, I)
+		if metricType == 0 {
+			require.Equal(t, ErrZeroVector, errors.Cause(err))
+			vdb.SetAllowZeroQuery(true)
+			_, err = vdb.Search([]float32{0, 0}, D, I)
+		}
+		require.NoError(t, err)
+
+		err = vdb.Destroy()
+		require.NoError(t, err)
+	}
+}
+
+func TestVectodbSearchDuringBuild(t *testing.T) {
+	var err error
+	// random vectors of high dimension are far from each other
+	const dim2 int = 64
+	const ivfIndexKey string = "IVF16,Flat"
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim2, metric, ivfIndexKey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	genVecs := func(nb int, startXid int64) (xb []float32, xids []int64) {
+		xb = make([]float32, nb*dim2)
+		xids = make([]int64, nb)
+		for i := 0; i < nb; i++ {
+			for j := 0; j < dim2; j++ {
+				xb[i*dim2+j] = rand.Float32()
+			}
+			normalizeInplace(dim2, xb[i*dim2:(i+1)*dim2])
+			xids[i] = startXid + int64(i)
+		}
+		return
+	}
+	// the index is built only if there are at least 10000 vectors
+	const nb int = 10000
+	xb, xids := genVecs(nb, 0)
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+
+	stopCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		D := make([]float32, 1)
+		I := make([]int64, 1)
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			i := rand.Intn(nb)
+			if _, err := vdb.Search(xb[i*dim2:(i+1)*dim2], D, I); err != nil {
+				errCh <- err
+				return
+			}
+			if I[0] != xids[i] || D[0] < 0 || D[0] > 1e-4 {
+				errCh <- errors.Errorf("search xid %v, want xid %v distance 0, have xid %v distance %v", xids[i], xids[i], I[0], D[0])
+				return
+			}
+			// a search sees either the old index and flat or the new ones, so the neighbors are not mixed out of order
+			D2, I2, _, err := vdb.SearchTopK(xb[i*dim2:(i+1)*dim2], 10)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if len(I2[0]) != 10 || I2[0][0] != xids[i] {
+				errCh <- errors.Errorf("SearchTopK xid %v, want 10 neighbors starting with itself, have %v", xids[i], I2[0])
+				return
+			}
+			for j := 1; j < len(D2[0]); j++ {
+				if D2[0][j] < D2[0][j-1] {
+					errCh <- errors.Errorf("SearchTopK xid %v, distances are not ascending: %v", xids[i], D2[0])
+					return
+				}
+			}
+		}
+	}()
+	// a writer keeps adding meanwhile, so that builds race with mutations as well as searches
+	addErrCh := make(chan error, 1)
+	go func() {
+		defer close(addErrCh)
+		for xid := int64(1 << 20); ; xid += 10 {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			xb2, xids2 := genVecs(10, xid)
+			if err := vdb.AddWithIds(xb2, xids2); err != nil {
+				addErrCh <- err
+				return
+			}
+		}
+	}()
+	for round := 0; round < 5; round++ {
+		xb2, xids2 := genVecs(1000, int64(nb+round*1000))
+		err = vdb.AddWithIds(xb2, xids2)
+		require.NoError(t, err)
+		err = vdb.UpdateIndex()
+		require.NoError(t, err)
+	}
+	close(stopCh)
+	require.NoError(t, <-errCh)
+	require.NoError(t, <-addErrCh)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbDeterministicBuild(t *testing.T) {
+	const dim2 int = 64
+	const ivfIndexKey string = "IVF16,Flat"
+	const seed int = 42
+	const nb, nq int = 10000, 100
+	xb := make([]float32, nb*dim2)
+	xids := make([]int64, nb)
+	for i := 0; i < nb; i++ {
+		for j := 0; j < dim2; j++ {
+			xb[i*dim2+j] = rand.Float32()
+		}
+		normalizeInplace(dim2, xb[i*dim2:(i+1)*dim2])
+		xids[i] = int64(i)
+	}
+	xq := make([]float32, nq*dim2)
+	for i := range xq {
+		xq[i] = rand.Float32()
+	}
+
+	build := func(dp string) (D []float32, I []int64) {
+		VectodbClearWorkDir(dp)
+		vdb, err := NewVectoDB(dp, dim2, metric, ivfIndexKey, queryParams, 1e6, flatThr, seed)
+		require.NoError(t, err)
+		defer vdb.Destroy()
+		err = vdb.AddWithIds(xb, xids)
+		require.NoError(t, err)
+		err = vdb.UpdateIndex()
+		require.NoError(t, err)
+		nflat, err := vdb.GetFlatSize()
+		require.NoError(t, err)
+		require.Equal(t, 0, nflat)
+		D = make([]float32, nq)
+		I = make([]int64, nq)
+		_, err = vdb.Search(xq, D, I)
+		require.NoError(t, err)
+		return
+	}
+	D1, I1 := build(workDir)
+	D2, I2 := build(workDir + "2")
+	require.Equal(t, I1, I2)
+	require.Equal(t, D1, D2)
+	VectodbClearWorkDir(workDir + "2")
+}
+
+func TestVectodbExplainSearch(t *testing.T) {
+	const dim2 int = 64
+	const ivfIndexKey string = "IVF16,Flat"
+	const nprobe int = 4
+	const nb int = 10000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim2, metric, ivfIndexKey, fmt.Sprintf("nprobe=%d", nprobe), distThr, flatThr, 0)
+	require.NoError(t, err)
+	xb := make([]float32, nb*dim2)
+	xids := make([]int64, nb)
+	for i := 0; i < nb; i++ {
+		for j := 0; j < dim2; j++ {
+			xb[i*dim2+j] = rand.Float32()
+		}
+		normalizeInplace(dim2, xb[i*dim2:(i+1)*dim2])
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	// nothing is probed before the index is built
+	probes, err := vdb.ExplainSearch(xb[:dim2])
+	require.NoError(t, err)
+	require.Len(t, probes, 0)
+
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	probes, err = vdb.ExplainSearch(xb[:dim2])
+	require.NoError(t, err)
+	require.Len(t, probes, nprobe)
+	listNos := make(map[int64]bool)
+	for i, probe := range probes {
+		require.True(t, probe.ListNo >= 0 && probe.ListNo < 16)
+		require.False(t, listNos[probe.ListNo])
+		listNos[probe.ListNo] = true
+		if i > 0 {
+			// closer centroids are probed first
+			require.True(t, probe.Distance >= probes[i-1].Distance)
+		}
+	}
+	// the query itself is in the nearest list
+	require.True(t, probes[0].Candidates > 0)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbQuantizerReuse(t *testing.T) {
+	const dim2 int = 64
+	const ivfIndexKey string = "IVF16,Flat"
+	const nb int = 10000
+	workDir2 := workDir + "_2"
+	VectodbClearWorkDir(workDir)
+	VectodbClearWorkDir(workDir2)
+	defer os.RemoveAll(workDir2)
+	// two shards of the same distribution
+	xb := make([]float32, 2*nb*dim2)
+	xids := make([]int64, 2*nb)
+	for i := 0; i < 2*nb; i++ {
+		for j := 0; j < dim2; j++ {
+			xb[i*dim2+j] = rand.Float32()
+		}
+		normalizeInplace(dim2, xb[i*dim2:(i+1)*dim2])
+		xids[i] = int64(i)
+	}
+
+	vdb, err := NewVectoDB(workDir, dim2, metric, ivfIndexKey, "nprobe=4", distThr, flatThr, 0)
+	require.NoError(t, err)
+	// there's no quantizer before the index is built
+	_, err = vdb.ExportQuantizer()
+	require.Error(t, err)
+	err = vdb.AddWithIds(xb[:nb*dim2], xids[:nb])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	quantizer, err := vdb.ExportQuantizer()
+	require.NoError(t, err)
+	require.NotEmpty(t, quantizer)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	_, err = NewVectoDBFromQuantizer(workDir2, []byte("garbage"), dim2, metric, ivfIndexKey, "nprobe=4", distThr, flatThr, 0)
+	require.Error(t, err)
+	// the second shard reuses the quantizer trained with the first shard
+	vdb2, err := NewVectoDBFromQuantizer(workDir2, quantizer, dim2, metric, ivfIndexKey, "nprobe=4", distThr, flatThr, 0)
+	require.NoError(t, err)
+	err = vdb2.AddWithIds(xb[nb*dim2:], xids[nb:])
+	require.NoError(t, err)
+	err = vdb2.UpdateIndex()
+	require.NoError(t, err)
+	quantizer2, err := vdb2.ExportQuantizer()
+	require.NoError(t, err)
+	require.Equal(t, quantizer, quantizer2)
+	for i := nb; i < 2*nb; i += 500 {
+		distances := make([]float32, 1)
+		resXids := make([]int64, 1)
+		_, err = vdb2.Search(xb[i*dim2:(i+1)*dim2], distances, resXids)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), resXids[0])
+	}
+	err = vdb2.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbCountWithin(t *testing.T) {
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	// the first 10000 vectors are indexed, the others stay in the flat
+	const nb int = 12000
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb[:10000*dim], xids[:10000])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.AddWithIds(xb[10000*dim:], xids[10000:])
+	require.NoError(t, err)
+
+	for _, thr := range []float32{0.001, 0.01, 0.1} {
+		for q := 0; q < 10; q++ {
+			xq := []float32{rand.Float32(), rand.Float32()}
+			var want int
+			for i := 0; i < nb; i++ {
+				if l2distance(dim, xq, xb[i*dim:(i+1)*dim]) < thr {
+					want++
+				}
+			}
+			count, err := vdb.CountWithin(xq, thr)
+			require.NoError(t, err)
+			require.Equal(t, want, count, "xq %v, thr %v", xq, thr)
+		}
+	}
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbRangeSearch(t *testing.T) {
+	const ivfIndexKey string = "IVF256,Flat"
+	const nb int = 20000
+	VectodbClearWorkDir(workDir)
+	// probe all lists so that the range search is exact
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=256", distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	// the first 18000 vectors are indexed, the others stay in the flat
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb[:18000*dim], xids[:18000])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.AddWithIds(xb[18000*dim:], xids[18000:])
+	require.NoError(t, err)
+	_, err = vdb.RemoveIds([]int64{xids[0], xids[nb-1]})
+	require.NoError(t, err)
+
+	const nq int = 5
+	const radius float32 = 0.001
+	xq := make([]float32, 0, nq*dim)
+	for q := 0; q < nq; q++ {
+		// the neighbors of removed vectors shall be found except themselves
+		i := []int{0, nb - 1, rand.Intn(nb), rand.Intn(nb), rand.Intn(nb)}[q]
+		xq = append(xq, xb[i*dim:(i+1)*dim]...)
+	}
+	lims, D, I, err := vdb.RangeSearch(xq, radius)
+	require.NoError(t, err)
+	require.Len(t, lims, nq+1)
+	require.Equal(t, lims[nq], len(D))
+	require.Equal(t, lims[nq], len(I))
+	for q := 0; q < nq; q++ {
+		want := []int64{}
+		for i := 1; i < nb-1; i++ {
+			if l2distance(dim, xq[q*dim:(q+1)*dim], xb[i*dim:(i+1)*dim]) < radius {
+				want = append(want, xids[i])
+			}
+		}
+		have := append([]int64{}, I[lims[q]:lims[q+1]]...)
+		require.True(t, sort.SliceIsSorted(D[lims[q]:lims[q+1]], func(a, b int) bool { return D[lims[q]+a] < D[lims[q]+b] }))
+		sort.Slice(have, func(a, b int) bool { return have[a] < have[b] })
+		require.Equal(t, want, have, "query %d", q)
+	}
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	// inner product neighbors are above radius
+	VectodbClearWorkDir(workDir)
+	vdb2, err := NewVectoDB(workDir, dim, 0, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	err = vdb2.AddWithIds([]float32{1, 0, 0.6, 0.8, 0, 1}, []int64{1, 2, 3})
+	require.NoError(t, err)
+	lims, D, I, err = vdb2.RangeSearch([]float32{1, 0}, 0.5)
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 2}, lims)
+	require.Equal(t, []int64{1, 2}, I)
+	require.InDeltaSlice(t, []float32{1, 0.6}, D, 1e-6)
+	err = vdb2.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbEstimateMatches(t *testing.T) {
+	const ivfIndexKey string = "IVF256,Flat"
+	const nb int = 20000
+	VectodbClearWorkDir(workDir)
+	// probe all lists so that CountWithin is exact
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=256", distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	// the first 18000 vectors are indexed, the others stay in the flat
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb[:18000*dim], xids[:18000])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.AddWithIds(xb[18000*dim:], xids[18000:])
+	require.NoError(t, err)
+
+	for _, thr := range []float32{0.05, 0.1} {
+		for q := 0; q < 10; q++ {
+			// keep the threshold circle inside the unit square
+			xq := []float32{0.4 + 0.2*rand.Float32(), 0.4 + 0.2*rand.Float32()}
+			want, err := vdb.CountWithin(xq, thr)
+			require.NoError(t, err)
+			estimate, err := vdb.EstimateMatches(xq, thr)
+			require.NoError(t, err)
+			// Only the lists crossing the threshold are miscounted. Allow a few lists of slack, so that small counts don't fail spuriously.
+			require.True(t, estimate >= 0, "xq %v, thr %v, estimate %v", xq, thr, estimate)
+			require.InDelta(t, want, estimate, float64(want)/5+float64(2*nb/256), "xq %v, thr %v", xq, thr)
+		}
+	}
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbGetTotalSize(t *testing.T) {
+	const ivfIndexKey string = "IVF256,Flat"
+	const nb int = 20000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb[:18000*dim], xids[:18000])
+	require.NoError(t, err)
+	ntotal, err := vdb.GetTotalSize()
+	require.NoError(t, err)
+	require.Equal(t, 18000, ntotal)
+
+	// the flat shrinks after UpdateIndex, while the total size doesn't
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.AddWithIds(xb[18000*dim:], xids[18000:])
+	require.NoError(t, err)
+	nflat, err := vdb.GetFlatSize()
+	require.NoError(t, err)
+	require.Equal(t, 2000, nflat)
+	ntotal, err = vdb.GetTotalSize()
+	require.NoError(t, err)
+	require.Equal(t, nb, ntotal)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbGetMemoryUsage(t *testing.T) {
+	const ivfIndexKey string = "IVF256,Flat"
+	const nb int = 20000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb[:18000*dim], xids[:18000])
+	require.NoError(t, err)
+	bytes, err := vdb.GetMemoryUsage()
+	require.NoError(t, err)
+	require.Equal(t, int64(18000*dim*4), bytes)
+
+	// 256 centroids, plus a vector and an id per entry of the inverted lists, plus the flat
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.AddWithIds(xb[18000*dim:], xids[18000:])
+	require.NoError(t, err)
+	bytes, err = vdb.GetMemoryUsage()
+	require.NoError(t, err)
+	require.Equal(t, int64(256*dim*4+18000*(dim*4+8)+2000*dim*4), bytes)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbReconstruct(t *testing.T) {
+	const ivfIndexKey string = "IVF256,Flat"
+	const nb int = 20000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	// the first 18000 vectors are indexed, the others stay in the flat
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(1000 + i)
+	}
+	err = vdb.AddWithIds(xb[:18000*dim], xids[:18000])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.AddWithIds(xb[18000*dim:], xids[18000:])
+	require.NoError(t, err)
+
+	// IVF,Flat stores raw vectors, so reconstruction is exact
+	for _, i := range []int{0, 17999, 18000, nb - 1} {
+		vec, err := vdb.Reconstruct(xids[i])
+		require.NoError(t, err)
+		require.Equal(t, xb[i*dim:(i+1)*dim], vec)
+	}
+	_, err = vdb.Reconstruct(999)
+	require.Equal(t, ErrXidNotFound, errors.Cause(err))
+	nremoved, err := vdb.RemoveIds([]int64{xids[0], xids[nb-1]})
+	require.NoError(t, err)
+	require.Equal(t, 2, nremoved)
+	for _, i := range []int{0, nb - 1} {
+		_, err = vdb.Reconstruct(xids[i])
+		require.Equal(t, ErrXidNotFound, errors.Cause(err))
+	}
+	// ids beyond the shrunk ntotal are still found
+	vec, err := vdb.Reconstruct(xids[17999])
+	require.NoError(t, err)
+	require.Equal(t, xb[17999*dim:18000*dim], vec)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbContains(t *testing.T) {
+	const ivfIndexKey string = "IVF16,Flat"
+	const nb int = 10100
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	// the first 10000 vectors are indexed, the others stay in the flat
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := range xb {
+		xb[i] = rand.Float32()
+	}
+	for i := range xids {
+		xids[i] = int64(1000 + i)
+	}
+	err = vdb.AddWithIds(xb[:10000*dim], xids[:10000])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.AddWithIds(xb[10000*dim:], xids[10000:])
+	require.NoError(t, err)
+
+	for _, xid := range []int64{xids[0], xids[9999], xids[10000], xids[nb-1]} {
+		found, err := vdb.Contains(xid)
+		require.NoError(t, err)
+		require.True(t, found)
+	}
+	found, err := vdb.Contains(999)
+	require.NoError(t, err)
+	require.False(t, found)
+	_, err = vdb.RemoveIds([]int64{xids[0], xids[nb-1]})
+	require.NoError(t, err)
+	for _, xid := range []int64{xids[0], xids[nb-1]} {
+		found, err = vdb.Contains(xid)
+		require.NoError(t, err)
+		require.False(t, found)
+	}
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbSearchTopK(t *testing.T) {
+	const k int = 10
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	// less than k vectors
+	err = vdb.AddWithIds([]float32{0.1, 0.1, 0.2, 0.2, 0.3, 0.3}, []int64{1, 2, 3})
+	require.NoError(t, err)
+	D, I, _, err := vdb.SearchTopK([]float32{0.22, 0.22}, k)
+	require.NoError(t, err)
+	require.Equal(t, [][]int64{{2, 3, 1}}, I)
+	require.Len(t, D[0], 3)
+
+	// the first 1000 vectors are indexed, the others stay in the flat
+	const nb int = 1003
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb; i++ {
+		if i < 3 {
+			xb[i*dim], xb[i*dim+1] = 0.1*float32(i+1), 0.1*float32(i+1)
+		} else {
+			xb[i*dim], xb[i*dim+1] = rand.Float32(), rand.Float32()
+		}
+		xids[i] = int64(i + 1)
+	}
+	err = vdb.AddWithIds(xb[3*dim:1000*dim], xids[3:1000])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.AddWithIds(xb[1000*dim:], xids[1000:])
+	require.NoError(t, err)
+
+	const nq int = 5
+	xq := make([]float32, nq*dim)
+	for i := range xq {
+		xq[i] = rand.Float32()
+	}
+	D, I, _, err = vdb.SearchTopK(xq, k)
+	require.NoError(t, err)
+	require.Len(t, D, nq)
+	require.Len(t, I, nq)
+	for q := 0; q < nq; q++ {
+		order := make([]int, nb)
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(i, j int) bool {
+			return l2distance(dim, xq[q*dim:(q+1)*dim], xb[order[i]*dim:(order[i]+1)*dim]) < l2distance(dim, xq[q*dim:(q+1)*dim], xb[order[j]*dim:(order[j]+1)*dim])
+		})
+		require.Len(t, I[q], k)
+		for j := 0; j < k; j++ {
+			require.Equal(t, xids[order[j]], I[q][j])
+			require.InDelta(t, l2distance(dim, xq[q*dim:(q+1)*dim], xb[order[j]*dim:(order[j]+1)*dim]), D[q][j], 1e-5)
+			if j > 0 {
+				require.True(t, D[q][j] >= D[q][j-1])
+			}
+		}
+	}
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbSearchBatch(t *testing.T) {
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	err = vdb.AddWithIds([]float32{0.1, 0.1, 0.2, 0.2, 0.3, 0.3, 0.9, 0.9}, []int64{1, 2, 3, 4})
+	require.NoError(t, err)
+
+	D, I, err := vdb.SearchBatch([]float32{0.11, 0.11, 0.88, 0.88}, 2, 2)
+	require.NoError(t, err)
+	require.Equal(t, [][]int64{{1, 2}, {4, 3}}, I)
+	require.Len(t, D, 2)
+	require.Len(t, D[0], 2)
+	require.True(t, D[0][0] <= D[0][1])
+
+	// the stride doesn't match nq
+	_, _, err = vdb.SearchBatch([]float32{0.11, 0.11, 0.88, 0.88}, 1, 2)
+	require.Error(t, err)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbSQ8(t *testing.T) {
+	const ivfIndexKey string = "IVF16,SQ8"
+	const nb int = 10100
+	const nq int = 100
+	const k int = 10
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := range xb {
+		xb[i] = rand.Float32()
+	}
+	for i := range xids {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb[:10000*dim], xids[:10000])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	trained, err := vdb.IsTrained()
+	require.NoError(t, err)
+	require.True(t, trained)
+	// the vectors added after training are encoded into the trained index
+	err = vdb.AddWithIds(xb[10000*dim:], xids[10000:])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	nflat, err := vdb.GetFlatSize()
+	require.NoError(t, err)
+	require.Equal(t, 0, nflat)
+
+	xq := make([]float32, nq*dim)
+	for i := range xq {
+		xq[i] = rand.Float32()
+	}
+	_, I, err := vdb.SearchBatch(xq, nq, k)
+	require.NoError(t, err)
+	// recall 1@10: the exact nearest neighbor is among the k results despite quantization
+	var nfound int
+	for q := 0; q < nq; q++ {
+		var nearest int64
+		minDist := float32(math.MaxFloat32)
+		for i := 0; i < nb; i++ {
+			if d := l2distance(dim, xq[q*dim:(q+1)*dim], xb[i*dim:(i+1)*dim]); d < minDist {
+				minDist, nearest = d, xids[i]
+			}
+		}
+		for _, xid := range I[q] {
+			if xid == nearest {
+				nfound++
+				break
+			}
+		}
+	}
+	require.True(t, nfound >= nq*95/100, "recall %d/%d", nfound, nq)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbRemoveIds(t *testing.T) {
+	const ivfIndexKey string = "IVF16,Flat"
+	const nb int = 10100
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	// the first 10000 vectors are indexed, the others stay in the flat
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb[:10000*dim], xids[:10000])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.AddWithIds(xb[10000*dim:], xids[10000:])
+	require.NoError(t, err)
+
+	removed := []int64{5, 10050}
+	nremoved, err := vdb.RemoveIds([]int64{removed[0], removed[1], int64(nb)})
+	require.NoError(t, err)
+	require.Equal(t, 2, nremoved, "absent xids shall not be counted")
+	checkRemoved := func(vdb *VectoDB) {
+		for _, xid := range removed {
+			xq := xb[xid*int64(dim) : (xid+1)*int64(dim)]
+			distances := make([]float32, 1)
+			resXids := make([]int64, 1)
+			_, err := vdb.Search(xq, distances, resXids)
+			require.NoError(t, err)
+			require.NotEqual(t, xid, resXids[0])
+			_, I, _, err := vdb.SearchTopK(xq, 10)
+			require.NoError(t, err)
+			require.NotContains(t, I[0], xid)
+			count, err := vdb.CountWithin(xq, 1e-12)
+			require.NoError(t, err)
+			require.Equal(t, 0, count)
+		}
+	}
+	checkRemoved(vdb)
+	nremoved, err = vdb.RemoveIds(removed)
+	require.NoError(t, err)
+	require.Equal(t, 0, nremoved)
+
+	// the removal survives reopening, and index builds
+	err = vdb.Destroy()
+	require.NoError(t, err)
+	vdb, err = NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	checkRemoved(vdb)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	checkRemoved(vdb)
+
+	// a removed xid could be added again
+	err = vdb.AddWithIds(xb[5*dim:6*dim], []int64{5})
+	require.NoError(t, err)
+	distances := make([]float32, 1)
+	resXids := make([]int64, 1)
+	_, err = vdb.Search(xb[5*dim:6*dim], distances, resXids)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), resXids[0])
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbFlatExact(t *testing.T) {
+	const dim2 int = 16
+	const nb int = 2000
+	VectodbClearWorkDir(workDir)
+	// nprobe is ignored, and the threshold is large enough to return every nearest neighbor
+	vdb, err := NewVectoDB(workDir, dim2, metric, IndexKeyFlat, "nprobe=1", 100, flatThr, 0)
+	require.NoError(t, err)
+
+	xb := make([]float32, nb*dim2)
+	xids := make([]int64, nb)
+	for i := 0; i < nb; i++ {
+		for j := 0; j < dim2; j++ {
+			xb[i*dim2+j] = rand.Float32()
+		}
+		xids[i] = int64(1000 + i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	// there's no training step, all vectors stay in the flat
+	ntrain, nsize, err := vdb.getIndexSize()
+	require.NoError(t, err)
+	require.Equal(t, 0, ntrain)
+	require.Equal(t, 0, nsize)
+	nflat, err := vdb.GetFlatSize()
+	require.NoError(t, err)
+	require.Equal(t, nb, nflat)
+
+	distances := make([]float32, 1)
+	resXids := make([]int64, 1)
+	for q := 0; q < 50; q++ {
+		xq := make([]float32, dim2)
+		for j := range xq {
+			xq[j] = rand.Float32()
+		}
+		wantXid := int64(-1)
+		var wantDist float32
+		for i := 0; i < nb; i++ {
+			if d := l2distance(dim2, xq, xb[i*dim2:(i+1)*dim2]); wantXid < 0 || d < wantDist {
+				wantXid, wantDist = xids[i], d
+			}
+		}
+		_, err = vdb.Search(xq, distances, resXids)
+		require.NoError(t, err)
+		require.Equal(t, wantXid, resXids[0])
+		require.InDelta(t, wantDist, distances[0], 1e-4)
+	}
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbSqrtL2(t *testing.T) {
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	xb := []float32{1, 0, 0, 1}
+	xids := []int64{100, 101}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+
+	// the squared distance from xq to xb[0] is 0.25, which is within the threshold
+	xq := []float32{0.7, 0.4}
+	distances := make([]float32, 1)
+	resXids := make([]int64, 1)
+	_, err = vdb.Search(xq, distances, resXids)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), resXids[0])
+	require.InDelta(t, l2distance(dim, xq, xb[:dim]), distances[0], 1e-6)
+
+	vdb.SetSqrtL2(true)
+	_, err = vdb.Search(xq, distances, resXids)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), resXids[0])
+	require.InDelta(t, math.Sqrt(float64(l2distance(dim, xq, xb[:dim]))), distances[0], 1e-6)
+	require.InDelta(t, 0.5, distances[0], 1e-6)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbCosine(t *testing.T) {
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, 2, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	xb := []float32{1, 0, 0, 10}
+	xids := []int64{100, 101}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+
+	// inner product prefers xb[1] for its length, while cosine prefers xb[0] for its direction
+	xq := []float32{2, 1}
+	distances := make([]float32, 1)
+	resXids := make([]int64, 1)
+	_, err = vdb.Search(xq, distances, resXids)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), resXids[0])
+	require.InDelta(t, 2/math.Sqrt(5), distances[0], 1e-6)
+
+	// the stored vectors are the normalized ones
+	vec, err := vdb.Reconstruct(101)
+	require.NoError(t, err)
+	require.InDeltaSlice(t, []float32{0, 1}, vec, 1e-6)
+
+	_, err = vdb.Search([]float32{0, 0}, distances, resXids)
+	require.Equal(t, ErrZeroVector, errors.Cause(err))
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	_, err = NewVectoDB(workDir, dim, 0, indexkey, queryParams, distThr, flatThr, 0)
+	require.Equal(t, ErrConfigMismatch, errors.Cause(err))
+}
+
+func TestVectodbSetNormalize(t *testing.T) {
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, 0, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	vdb.SetNormalize(true)
+
+	xb := []float32{1, 0, 0, 10}
+	xids := []int64{100, 101}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+
+	xq := []float32{2, 1}
+	distances := make([]float32, 1)
+	resXids := make([]int64, 1)
+	_, err = vdb.Search(xq, distances, resXids)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), resXids[0])
+	require.InDelta(t, 2/math.Sqrt(5), distances[0], 1e-6)
+
+	// the stored vectors stay normalized, while the query is taken as is
+	vdb.SetNormalize(false)
+	_, err = vdb.Search(xq, distances, resXids)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), resXids[0])
+	require.InDelta(t, 2, distances[0], 1e-6)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbConfigMismatch(t *testing.T) {
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, 128, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	xb := make([]float32, 128)
+	for i := range xb {
+		xb[i] = rand.Float32()
+	}
+	err = vdb.AddWithIds(xb, []int64{1})
+	require.NoError(t, err)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	_, err = NewVectoDB(workDir, 256, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.Equal(t, ErrConfigMismatch, errors.Cause(err))
+	require.Contains(t, err.Error(), "want dim 256")
+	require.Contains(t, err.Error(), "have dim 128")
+	_, err = NewVectoDB(workDir, 128, 1-metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.Equal(t, ErrConfigMismatch, errors.Cause(err))
+	_, err = NewVectoDB(workDir, 128, metric, "IVF16,Flat", queryParams, distThr, flatThr, 0)
+	require.Equal(t, ErrConfigMismatch, errors.Cause(err))
+
+	vdb, err = NewVectoDB(workDir, 128, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func cgoCallCount(t *testing.T, op string) uint64 {
+	m := &dto.Metric{}
+	require.NoError(t, cgoDuration.WithLabelValues(op).(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestVectodbCgoMetrics(t *testing.T) {
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	defer vdb.Destroy()
+
+	numAdd := cgoCallCount(t, CgoOpAddWithIds)
+	numUpdate := cgoCallCount(t, CgoOpUpdateIndex)
+	numSearch := cgoCallCount(t, CgoOpSearch)
+
+	xb := []float32{0.6, 0.8, 0.8, 0.6}
+	err = vdb.AddWithIds(xb, []int64{0, 1})
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	distances := make([]float32, 2)
+	xids := make([]int64, 2)
+	_, err = vdb.Search(xb, distances, xids)
+	require.NoError(t, err)
+
+	require.Equal(t, numAdd+1, cgoCallCount(t, CgoOpAddWithIds))
+	require.Equal(t, numUpdate+1, cgoCallCount(t, CgoOpUpdateIndex))
+	require.Equal(t, numSearch+1, cgoCallCount(t, CgoOpSearch))
+	m := &dto.Metric{}
+	require.NoError(t, cgoInFlight.Write(m))
+	require.Equal(t, float64(0), m.GetGauge().GetValue())
+}
+
+func TestVectodbFlatThreads(t *testing.T) {
+	const nb int = 50000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	// all vectors stay in the flat
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+
+	const nq int = 10
+	xq := make([]float32, nq*dim)
+	for i := range xq {
+		xq[i] = rand.Float32()
+	}
+	vdb.SetFlatThreads(1)
+	D1, I1, _, err := vdb.SearchTopK(xq, 10)
+	require.NoError(t, err)
+	vdb.SetFlatThreads(8)
+	D8, I8, _, err := vdb.SearchTopK(xq, 10)
+	require.NoError(t, err)
+	require.Equal(t, I1, I8)
+	for i := range D1 {
+		require.InDeltaSlice(t, D1[i], D8[i], 1e-6)
+	}
+	// the nearest neighbor of an indexed vector is itself
+	distances := make([]float32, 1)
+	resXids := make([]int64, 1)
+	_, err = vdb.Search(xb[nb/2*dim:(nb/2+1)*dim], distances, resXids)
+	require.NoError(t, err)
+	require.Equal(t, int64(nb/2), resXids[0])
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func BenchmarkVectodbFlatSearch(b *testing.B) {
+	const dim2 int = 128
+	const nb int = 200000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim2, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(b, err)
+	defer vdb.Destroy()
+	xb := make([]float32, nb*dim2)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim2; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(b, err)
+	xq := xb[:dim2]
+	distances := make([]float32, 1)
+	resXids := make([]int64, 1)
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads-%d", threads), func(b *testing.B) {
+			vdb.SetFlatThreads(threads)
+			for i := 0; i < b.N; i++ {
+				if _, err := vdb.Search(xq, distances, resXids); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestVectodbSnapshot(t *testing.T) {
+	const ivfIndexKey string = "IVF16,Flat"
+	const nb int = 10100
+	const replicaDir string = workDir + "_replica"
+	snapshot := filepath.Join(os.TempDir(), "vectodb_test_go.snapshot")
+	VectodbClearWorkDir(workDir)
+	VectodbClearWorkDir(replicaDir)
+	os.Remove(filepath.Join(replicaDir, metaFileName))
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+
+	// the first 10000 vectors are indexed, the others stay in the flat
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb[:10000*dim], xids[:10000])
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.AddWithIds(xb[10000*dim:], xids[10000:])
+	require.NoError(t, err)
+	_, err = vdb.RemoveIds([]int64{5, 10050})
+	require.NoError(t, err)
+	err = vdb.Snapshot(snapshot)
+	require.NoError(t, err)
+	D, I, _, err := vdb.SearchTopK(xb[:100*dim], 5)
+	require.NoError(t, err)
+
+	replica, err := NewVectoDB(replicaDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	err = replica.AddWithIds([]float32{0.5, 0.5}, []int64{int64(nb)})
+	require.NoError(t, err)
+	err = replica.LoadSnapshot(snapshot)
+	require.NoError(t, err)
+	ntrain, _, err := replica.getIndexSize()
+	require.NoError(t, err)
+	require.NotEqual(t, 0, ntrain)
+	D2, I2, _, err := replica.SearchTopK(xb[:100*dim], 5)
+	require.NoError(t, err)
+	require.Equal(t, I, I2)
+	require.Equal(t, D, D2)
+	exists, _, err := replica.ExistsWithin([]float32{0.5, 0.5}, 1e-12)
+	require.NoError(t, err)
+	require.False(t, exists, "the content before loading shall be replaced")
+
+	// the restored content survives reopening
+	err = replica.Destroy()
+	require.NoError(t, err)
+	replica, err = NewVectoDB(replicaDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	D2, I2, _, err = replica.SearchTopK(xb[:100*dim], 5)
+	require.NoError(t, err)
+	require.Equal(t, I, I2)
+	require.Equal(t, D, D2)
+	err = replica.Destroy()
+	require.NoError(t, err)
+
+	// a snapshot of a different config is rejected, and the content is kept
+	VectodbClearWorkDir(replicaDir)
+	os.Remove(filepath.Join(replicaDir, metaFileName))
+	replica, err = NewVectoDB(replicaDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	err = replica.AddWithIds([]float32{0.5, 0.5}, []int64{int64(nb)})
+	require.NoError(t, err)
+	err = replica.LoadSnapshot(snapshot)
+	require.Equal(t, ErrConfigMismatch, errors.Cause(err))
+	exists, _, err = replica.ExistsWithin([]float32{0.5, 0.5}, 1e-12)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	err = replica.Destroy()
+	require.NoError(t, err)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbNewFromIndex(t *testing.T) {
+	const ivfIndexKey string = "IVF16,Flat"
+	const nb int = 10000
+	const replicaDir string = workDir + "_replica"
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	ntrain, _, err := vdb.getIndexSize()
+	require.NoError(t, err)
+	require.NotEqual(t, 0, ntrain)
+	indexPath := filepath.Join(os.TempDir(), "vectodb_test_go.index")
+	data, err := ioutil.ReadFile(filepath.Join(workDir, getIndexFileName(ivfIndexKey, ntrain)))
+	require.NoError(t, err)
+	err = ioutil.WriteFile(indexPath, data, 0600)
+	require.NoError(t, err)
+	D, I, _, err := vdb.SearchTopK(xb[:100*dim], 5)
+	require.NoError(t, err)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	// the replica has the same vectors added in the same order, along with newer ones
+	VectodbClearWorkDir(replicaDir)
+	os.Remove(filepath.Join(replicaDir, metaFileName))
+	replica, err := NewVectoDB(replicaDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	err = replica.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	err = replica.AddWithIds([]float32{10, 10}, []int64{int64(nb)})
+	require.NoError(t, err)
+	err = replica.Destroy()
+	require.NoError(t, err)
+	replica, err = NewVectoDBFromIndex(replicaDir, indexPath, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	_, nsize, err := replica.getIndexSize()
+	require.NoError(t, err)
+	require.Equal(t, nb, nsize)
+	nflat, err := replica.GetFlatSize()
+	require.NoError(t, err)
+	require.Equal(t, 1, nflat)
+	D2, I2, _, err := replica.SearchTopK(xb[:100*dim], 5)
+	require.NoError(t, err)
+	require.Equal(t, I, I2)
+	require.Equal(t, D, D2)
+	err = replica.Destroy()
+	require.NoError(t, err)
+
+	// the index covers more vectors than the db
+	VectodbClearWorkDir(replicaDir)
+	os.Remove(filepath.Join(replicaDir, metaFileName))
+	_, err = NewVectoDBFromIndex(replicaDir, indexPath, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.Equal(t, ErrIndexAhead, errors.Cause(err))
+	// the metric doesn't match
+	VectodbClearWorkDir(replicaDir)
+	os.Remove(filepath.Join(replicaDir, metaFileName))
+	replica, err = NewVectoDB(replicaDir, dim, 0, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	err = replica.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	err = replica.Destroy()
+	require.NoError(t, err)
+	_, err = NewVectoDBFromIndex(replicaDir, indexPath, dim, 0, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.Equal(t, ErrConfigMismatch, errors.Cause(err))
+}
+
+func TestVectodbReset(t *testing.T) {
+	const ivfIndexKey string = "IVF16,Flat"
+	const nb int = 10000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	err = vdb.UpdateWithIds(xb[:dim], xids[:1])
+	require.NoError(t, err)
+
+	err = vdb.Reset()
+	require.NoError(t, err)
+	ntrain, _, err := vdb.getIndexSize()
+	require.NoError(t, err)
+	require.Equal(t, 0, ntrain)
+	total, err := vdb.GetTotalSize()
+	require.NoError(t, err)
+	require.Equal(t, 0, total)
+	distances := make([]float32, 1)
+	resXids := make([]int64, 1)
+	_, err = vdb.Search(xb[:dim], distances, resXids)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), resXids[0])
+
+	// xids are available again, and the db is rebuilt as usual
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	ntrain, _, err = vdb.getIndexSize()
+	require.NoError(t, err)
+	require.NotEqual(t, 0, ntrain)
+	_, err = vdb.Search(xb[:dim], distances, resXids)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), resXids[0])
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	vdb, err = NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	total, err = vdb.GetTotalSize()
+	require.NoError(t, err)
+	require.Equal(t, nb, total)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbConcurrentAddSearch(t *testing.T) {
+	const nb int = 1000
+	const batch int = 10
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, indexkey, queryParams, distThr, flatThr, 0)
+	require.NoError(t, err)
+	xb := make([]float32, nb*dim)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*nb/batch)
+	for i := 0; i < nb; i += batch {
+		wg.Add(2)
+		go func(begin int) {
+			defer wg.Done()
+			xids := make([]int64, batch)
+			for j := range xids {
+				xids[j] = int64(begin + j)
+			}
+			errs <- vdb.AddWithIds(xb[begin*dim:(begin+batch)*dim], xids)
+		}(i)
+		go func(begin int) {
+			defer wg.Done()
+			distances := make([]float32, batch)
+			resXids := make([]int64, batch)
+			_, err := vdb.Search(xb[begin*dim:(begin+batch)*dim], distances, resXids)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	total, err := vdb.GetTotal()
+	require.NoError(t, err)
+	require.Equal(t, nb, total)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbUpdateIndexAsync(t *testing.T) {
+	const ivfIndexKey string = "IVF16,Flat"
+	const nb int = 10000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+
+	type result struct {
+		done bool
+		err  error
+	}
+	ch := make(chan result, 1)
+	cb := func(done bool, err error) { ch <- result{done, err} }
+	vdb.UpdateIndexAsync(cb)
+	rst := <-ch
+	require.True(t, rst.done)
+	require.NoError(t, rst.err)
+	ntrain, _, err := vdb.getIndexSize()
+	require.NoError(t, err)
+	require.NotEqual(t, 0, ntrain)
+
+	// a build is skipped while the previous one is running
+	atomic.StoreInt32(&vdb.building, 1)
+	vdb.UpdateIndexAsync(cb)
+	rst = <-ch
+	require.False(t, rst.done)
+	require.NoError(t, rst.err)
+	atomic.StoreInt32(&vdb.building, 0)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbAddDuringUpdateIndexAsync(t *testing.T) {
+	const ivfIndexKey string = "IVF16,Flat"
+	const nb int = 20000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, ivfIndexKey, "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+
+	doneCh := make(chan error, 1)
+	vdb.UpdateIndexAsync(func(done bool, err error) { doneCh <- err })
+	// a writer waiting for rwlock during the build shall not block searches
+	stopCh := make(chan struct{})
+	addErrCh := make(chan error, 1)
+	go func() {
+		defer close(addErrCh)
+		xb2 := make([]float32, dim)
+		for xid := int64(nb); ; xid++ {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			for j := 0; j < dim; j++ {
+				xb2[j] = rand.Float32()
+			}
+			if err := vdb.AddWithIds(xb2, []int64{xid}); err != nil {
+				addErrCh <- err
+				return
+			}
+		}
+	}()
+	D := make([]float32, 1)
+	I := make([]int64, 1)
+	for building := true; building; {
+		select {
+		case err = <-doneCh:
+			require.NoError(t, err)
+			building = false
+		default:
+		}
+		i := rand.Intn(nb)
+		searchCh := make(chan error, 1)
+		go func() {
+			_, err := vdb.Search(xb[i*dim:(i+1)*dim], D, I)
+			searchCh <- err
+		}()
+		select {
+		case err = <-searchCh:
+			require.NoError(t, err)
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "search is blocked during UpdateIndexAsync")
+		}
+	}
+	close(stopCh)
+	require.NoError(t, <-addErrCh)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbReindex(t *testing.T) {
+	const nb int = 10100
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, "IVF16,Flat", "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	_, err = vdb.RemoveIds([]int64{5})
+	require.NoError(t, err)
+
+	checkSelf := func(vdb *VectoDB) {
+		for i := 0; i < 100; i++ {
+			_, I, _, err := vdb.SearchTopK(xb[i*dim:(i+1)*dim], 1)
+			require.NoError(t, err)
+			if i == 5 {
+				require.NotEqual(t, int64(5), I[0][0])
+			} else {
+				require.Equal(t, int64(i), I[0][0])
+			}
+		}
+	}
+	err = vdb.Reindex("IVF32,Flat", "nprobe=32")
+	require.NoError(t, err)
+	ntrain, nsize, err := vdb.getIndexSize()
+	require.NoError(t, err)
+	require.NotEqual(t, 0, ntrain)
+	require.Equal(t, nb, nsize)
+	checkSelf(vdb)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+
+	_, err = NewVectoDB(workDir, dim, metric, "IVF16,Flat", "nprobe=16", distThr, flatThr, 0)
+	require.Equal(t, ErrConfigMismatch, errors.Cause(err))
+	vdb, err = NewVectoDB(workDir, dim, metric, "IVF32,Flat", "nprobe=32", distThr, flatThr, 0)
+	require.NoError(t, err)
+	checkSelf(vdb)
+
+	// all vectors move to the flat
+	err = vdb.Reindex(IndexKeyFlat, "")
+	require.NoError(t, err)
+	nflat, err := vdb.GetFlatSize()
+	require.NoError(t, err)
+	require.Equal(t, nb, nflat)
+	checkSelf(vdb)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbIsTrained(t *testing.T) {
+	const nb int = 10000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, "IVF16,Flat", "nprobe=16", distThr, flatThr, 0)
+	require.NoError(t, err)
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	trained, err := vdb.IsTrained()
+	require.NoError(t, err)
+	require.False(t, trained)
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	trained, err = vdb.IsTrained()
+	require.NoError(t, err)
+	require.True(t, trained)
+
+	// a Flat index is never trained
+	err = vdb.Reindex(IndexKeyFlat, "")
+	require.NoError(t, err)
+	trained, err = vdb.IsTrained()
+	require.NoError(t, err)
+	require.False(t, trained)
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbSearchWithParams(t *testing.T) {
+	const nb int = 20000
+	const nq int = 1000
+	VectodbClearWorkDir(workDir)
+	vdb, err := NewVectoDB(workDir, dim, metric, "IVF256,Flat", "nprobe=256", distThr, flatThr, 0)
+	require.NoError(t, err)
+	xb := make([]float32, nb*dim)
+	xids := make([]int64, nb)
+	for i := 0; i < nb*dim; i++ {
+		xb[i] = rand.Float32()
+	}
+	for i := 0; i < nb; i++ {
+		xids[i] = int64(i)
+	}
+	err = vdb.AddWithIds(xb, xids)
+	require.NoError(t, err)
+	distances := make([]float32, nq)
+	resXids := make([]int64, nq)
+
+	// params are ignored until there's an index
+	_, err = vdb.SearchWithParams(xb[:nq*dim], distances, resXids, "foo=1")
+	require.NoError(t, err)
+	require.Equal(t, xids[:nq], resXids)
+
+	err = vdb.UpdateIndex()
+	require.NoError(t, err)
+	_, err = vdb.SearchWithParams(xb[:nq*dim], distances, resXids, "nprobe=1")
+	require.NoError(t, err)
+	_, err = vdb.SearchWithParams(xb[:nq*dim], distances, resXids, "foo=1")
+	require.Error(t, err)
+	// the params of the constructor are restored after each call
+	_, err = vdb.Search(xb[:nq*dim], distances, resXids)
+	require.NoError(t, err)
+	require.Equal(t, xids[:nq], resXids)
+
+	err = vdb.Destroy()
+	require.NoError(t, err)
+}
+
+func TestVectodbValidateIndexKey(t *testing.T) {
+	require.NoError(t, ValidateIndexKey(dim, metric, IndexKeyFlat))
+	require.NoError(t, ValidateIndexKey(dim, 2, "IVF16,Flat"))
+	require.Error(t, ValidateIndexKey(dim, metric, "IVF16,Bogus"))
+	require.Error(t, ValidateIndexKey(dim, metric, "IVF16,PQ3"), "PQ3 doesn't divide dim")
+	require.Error(t, ValidateIndexKey(dim, 3, IndexKeyFlat))
+	require.Error(t, ValidateIndexKey(0, metric, IndexKeyFlat))
+
+	VectodbClearWorkDir(workDir)
+	_, err := NewVectoDB(workDir, dim, metric, "IVF16,Bogus", queryParams, distThr, flatThr, 0)
+	require.Error(t, err)
+}
@@ -8,9 +8,13 @@ import "C"
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"hash"
+	"math"
+	"math/rand"
 	"reflect"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -25,47 +29,154 @@ import (
 )
 
 const (
-	SIZEOF_FLOAT32       = 4
-	ValidSeconds   int64 = 365 * 24 * 60 * 60 // 1 year
+	SIZEOF_FLOAT32        = 4
+	ValidSeconds    int64 = 365 * 24 * 60 * 60 // 1 year
+	GroupOverFetch        = 10                 // grouped search fetches GroupTopK*GroupOverFetch neighbors
+	WeightOverFetch       = 4                  // weighted search fetches MinResults*WeightOverFetch neighbors before reranking
 )
 
-// VectoDBLite is tiny stateless non-updatable non-removable vector database. Only supports metric type 0 - METRIC_INNER_PRODUCT.
+// ErrReadOnly is returned when adding to or deleting from a read-only vectodblite.
+var ErrReadOnly = errors.New("vectodblite is read-only")
+
+// ErrSizeLimit is returned when adding new vectors to a vectodblite which is full and doesn't evict, see RejectAdds.
+var ErrSizeLimit = errors.New("vectodblite size limit exceeded")
+
+// EvictionPolicy decides what happens when an add would push the number of vectors past sizeLimit.
+type EvictionPolicy int32
+
+const (
+	// EvictLRU evicts the least recently added or searched vectors from redis, and from IndexFlat at the next rebuild. It's the default.
+	EvictLRU EvictionPolicy = iota
+	// RejectAdds rejects the adds with ErrSizeLimit instead. Overwriting present vectors is still allowed.
+	RejectAdds
+)
+
+// LiteIndexKeyFlat is the default index key of VectoDBLite, and the fallback of indexes which are not trained yet.
+const LiteIndexKeyFlat = "Flat"
+
+// VectoDBLite is tiny stateless non-updatable vector database. Removed vectors are kept as tombstones until next compaction. Only supports metric type 0 - METRIC_INNER_PRODUCT.
+// It holds a single db, so its methods take no dbID, unlike the requests of the cluster which are routed by dbID.
 type VectoDBLite struct {
-	redisAddr     string
 	dim           int
 	distThreshold float32
 	sizeLimit     int
+	indexKey      string // the index_factory key of flatC
+	minTrain      int    // non-zero if flatC falls back to Flat until there're minTrain vectors to train the index
 	dbKey         string
-	rcli          *redis.Client
-	lru           *lru.Cache //The three shall keep sync: redis, lru, flatC
+	store         LiteStore
+	rcli          *redis.Client // nil unless the store is redis. It's required by warm standbys, and to persist the read-only flag.
+	lru           *lru.Cache    //The three shall keep sync: store, lru, flatC
 	flatC         unsafe.Pointer
 	rwlock        sync.RWMutex // protect flatC
+	writeLock     sync.RWMutex // held for reading by writes of the store and lru, and for writing by Clear
+	purging       bool         // protected by writeLock, set while Clear purges lru so that onEvicted doesn't delete and publish vectors one by one
+	vtLock        sync.Mutex   // serializes copy-on-write updates of lru entries, see updateVts
 	h64           hash.Hash64
 	numEvicted    int32
+	numTombstones int32
+	hasTTL        int32       // non-zero if there're vectors added with a TTL, which are swept by servExpire
+	evictPolicy   int32       // EvictionPolicy
+	numDangling   int64       // number of search candidates skipped since they're in flatC but neither in lru nor redis, evicted ones included
+	allowZero     int32       // 1 if all-zero query vectors are allowed
+	recent        *recentRing // nil if recent search is disabled
+	recentLock    sync.Mutex  // protect recent
 	cancel        context.CancelFunc
+	publish       bool          // publish changed xids for warm standbys
+	standby       int32         // non-zero if it's a warm standby which tails the owner's changes
+	pubsub        *redis.PubSub // the subscription of a warm standby
+	readOnly      int32         // non-zero if adds and deletes are rejected, persisted in redis
+	trainSamples  []float32     // trains flatC if there're not enough vectors, protected by rwlock
 }
 
-func NewVectoDBLite(redisAddr string, dbID int, dimIn int, distThreshold float32, sizeLimit int) (vdbl *VectoDBLite, err error) {
-	dbKey := getDbKey(dbID)
-	log.Infof("vectodblite %s creating", dbKey)
-	rcli := redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		Password: "", // no password set
-		DB:       0,  // use default DB
+// NewVectoDBLite loads vectors of the given dbID from redis, so that a vectodblite reacquired by the same or another process
+// preserves previously added vectors. fresh indicates to wipe them instead, and start from an empty db.
+// sizeLimit is the maximum number of vectors, tombstones included. The ones beyond it are evicted, see SetEvictionPolicy.
+func NewVectoDBLite(redisAddr string, dbID int, dimIn int, distThreshold float32, sizeLimit int, fresh bool) (vdbl *VectoDBLite, err error) {
+	return NewVectoDBLiteWithIndexKey(redisAddr, dbID, dimIn, distThreshold, sizeLimit, LiteIndexKeyFlat, fresh)
+}
+
+// NewVectoDBLiteWithIndexKey is the same as NewVectoDBLite, except that flatC is built with the given faiss index_factory key.
+// An index which requires training falls back to Flat until there are enough vectors to train it.
+func NewVectoDBLiteWithIndexKey(redisAddr string, dbID int, dimIn int, distThreshold float32, sizeLimit int, indexKey string, fresh bool) (vdbl *VectoDBLite, err error) {
+	return NewVectoDBLiteWithRedisOptions(RedisOptions{Addr: redisAddr}, dbID, dimIn, distThreshold, sizeLimit, indexKey, fresh)
+}
+
+// NewVectoDBLiteWithRedisOptions is the same as NewVectoDBLiteWithIndexKey, except that redis is connected with the given options,
+// i.e. a password and TLS of a managed redis.
+func NewVectoDBLiteWithRedisOptions(redisOpts RedisOptions, dbID int, dimIn int, distThreshold float32, sizeLimit int, indexKey string, fresh bool) (vdbl *VectoDBLite, err error) {
+	rcli := redisOpts.NewClient()
+	return newVectoDBLite(NewRedisLiteStore(rcli, redisOpts.KeyPrefix, dbID), rcli, redisOpts.KeyPrefix, dbID, dimIn, distThreshold, sizeLimit, indexKey, fresh, false)
+}
+
+// NewVectoDBLiteWithStore is the same as NewVectoDBLiteWithIndexKey, except that vectors are persisted to the given store rather than redis.
+// The read-only flag is not persisted then, and the changes are not published for warm standbys.
+func NewVectoDBLiteWithStore(store LiteStore, dbID int, dimIn int, distThreshold float32, sizeLimit int, indexKey string, fresh bool) (vdbl *VectoDBLite, err error) {
+	return newVectoDBLite(store, nil, "", dbID, dimIn, distThreshold, sizeLimit, indexKey, fresh, false)
+}
+
+// RedisOptions are the options to connect redis. Only Addr is required, the others default to no AUTH, db 0 and plaintext.
+type RedisOptions struct {
+	Addr      string
+	Password  string      // AUTH password, empty means no AUTH
+	DB        int         // the logical db selected after connecting
+	TLSConfig *tls.Config // nil means plaintext. ServerName defaults to the host of Addr.
+	// KeyPrefix is prepended to every redis key of vectodblites, i.e. "vdbl:app1:", so that deployments sharing one redis are isolated.
+	// Keys are "vectodblite_<dbID>" and the ones derived from it without a prefix, which is the default.
+	KeyPrefix string
+}
+
+// NewClient returns a redis client connecting with the options.
+func (opts RedisOptions) NewClient() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:      opts.Addr,
+		Password:  opts.Password,
+		DB:        opts.DB,
+		TLSConfig: opts.TLSConfig,
 	})
+}
+
+func newVectoDBLite(store LiteStore, rcli *redis.Client, keyPrefix string, dbID int, dimIn int, distThreshold float32, sizeLimit int, indexKey string, fresh bool, standby bool) (vdbl *VectoDBLite, err error) {
+	if err = ValidateLiteIndexKey(dimIn, indexKey); err != nil {
+		return
+	}
+	dbKey := keyPrefix + getDbKey(dbID)
+	log.Infof("vectodblite %s creating", dbKey)
 	vdbl = &VectoDBLite{
-		redisAddr:     redisAddr,
 		dim:           dimIn,
 		distThreshold: distThreshold,
 		sizeLimit:     sizeLimit,
+		indexKey:      indexKey,
 		dbKey:         dbKey,
+		store:         store,
 		rcli:          rcli,
 		h64:           xxhash.New(),
 	}
+	if standby {
+		vdbl.standby = 1
+	}
+	if fresh {
+		log.Infof("vectodblite %s wiping existing vectors", dbKey)
+		var xidSs []string
+		if err = store.Range(func(xidS string, vtB []byte) bool {
+			xidSs = append(xidSs, xidS)
+			return true
+		}); err != nil {
+			return
+		}
+		if err = store.Delete(xidSs...); err != nil {
+			return
+		}
+	}
 	onEvicted := func(key, value interface{}) {
 		xidS := key.(string)
-		vdbl.rcli.HDel(vdbl.dbKey, xidS)
+		if !vdbl.isStandby() && !vdbl.purging {
+			vdbl.store.Delete(xidS)
+			vdbl.publishChange(xidS)
+		}
 		atomic.AddInt32(&vdbl.numEvicted, int32(1))
+		if value.(*VecTimestamp).Deleted {
+			atomic.AddInt32(&vdbl.numTombstones, int32(-1))
+		}
 	}
 	if vdbl.lru, err = lru.NewWithEvict(sizeLimit, onEvicted); err != nil {
 		err = errors.Wrapf(err, "")
@@ -74,42 +185,60 @@ func NewVectoDBLite(redisAddr string, dbID int, dimIn int, distThreshold float32
 	ctx, cancel := context.WithCancel(context.TODO())
 	vdbl.cancel = cancel
 	go vdbl.servExpire(ctx)
+	if err = vdbl.loadReadOnly(); err != nil {
+		return
+	}
+	if standby {
+		// subscribe before loading, so that no change in between is missed
+		if err = vdbl.subscribeChanges(); err != nil {
+			return
+		}
+	}
 	if err = vdbl.load(); err != nil {
 		return
 	}
+	if standby {
+		go vdbl.servTail(ctx, vdbl.pubsub.Channel())
+	}
 	return
 }
 
-// Init load data from redis
+// Init load data from the store
 func (vdbl *VectoDBLite) load() (err error) {
-	var vecMapS map[string]string
-	if vecMapS, err = vdbl.rcli.HGetAll(vdbl.dbKey).Result(); err != nil {
-		err = errors.Wrap(err, "")
-		return
-	}
-	log.Debugf("vectodblite %s HGetAll: %+v", vdbl.dbKey, vecMapS)
 	expiredXids := make([]string, 0)
 	now := time.Now().Unix()
-	for xidS, vtS := range vecMapS {
+	var errVt error
+	if err = vdbl.store.Range(func(xidS string, vtB []byte) bool {
 		vt := VecTimestamp{}
-		if err = vt.Unmarshal([]byte(vtS)); err != nil {
-			err = errors.Wrapf(err, "")
-			return
+		if errVt = vt.Unmarshal(vtB); errVt != nil {
+			errVt = errors.Wrapf(errVt, "")
+			return false
 		}
-		if vt.ExpireAt < now {
+		if len(vt.Vec) != vdbl.dim {
+			errVt = errors.Wrapf(ErrConfigMismatch, "vectodblite %s xid %v, want dim %v, have %v", vdbl.dbKey, xidS, vdbl.dim, len(vt.Vec))
+			return false
+		}
+		if vt.ExpireAt < now || vt.Deleted {
 			expiredXids = append(expiredXids, xidS)
 		} else {
 			vdbl.lru.Add(xidS, &vt)
+			if vt.Ttl != 0 {
+				atomic.StoreInt32(&vdbl.hasTTL, 1)
+			}
 		}
+		return true
+	}); err != nil {
+		return
+	}
+	if errVt != nil {
+		err = errVt
+		return
 	}
 
-	if len(expiredXids) != 0 {
-		log.Infof("vectodblite %s purging expired items from redis: %v", vdbl.dbKey, expiredXids)
-		for _, xidS := range expiredXids {
-			if _, err = vdbl.rcli.HDel(vdbl.dbKey, xidS).Result(); err != nil {
-				err = errors.Wrapf(err, "")
-				return
-			}
+	if len(expiredXids) != 0 && !vdbl.isStandby() {
+		log.Infof("vectodblite %s purging expired and deleted items from the store: %v", vdbl.dbKey, expiredXids)
+		if err = vdbl.store.Delete(expiredXids...); err != nil {
+			return
 		}
 	}
 
@@ -125,10 +254,13 @@ func (vdbl *VectoDBLite) rebuildFlatC() (err error) {
 	defer vdbl.rwlock.Unlock()
 	if vdbl.flatC != nil {
 		C.IndexFlatDelete(vdbl.flatC)
+		vdbl.flatC = nil
 	}
-	vdbl.flatC = C.IndexFlatNew(C.long(vdbl.dim), C.float(vdbl.distThreshold))
-	var xid uint64
-	for _, xidInf := range vdbl.lru.Keys() {
+	keys := vdbl.lru.Keys()
+	xids := make([]uint64, 0, len(keys))
+	xb := make([]float32, 0, len(keys)*vdbl.dim)
+	for _, xidInf := range keys {
+		var xid uint64
 		if xid, err = strconv.ParseUint(xidInf.(string), 16, 64); err != nil {
 			err = errors.Wrapf(err, "")
 			return
@@ -139,8 +271,141 @@ func (vdbl *VectoDBLite) rebuildFlatC() (err error) {
 			err = errors.Errorf("vectodblite %s vdbl.lru is corrupted, want %v be present, have absent", vdbl.dbKey, xidInf.(string))
 			return
 		}
-		vt := vtInf.(*VecTimestamp)
-		C.IndexFlatAddWithIds(vdbl.flatC, C.long(1), (*C.float)(&vt.Vec[0]), (*C.ulong)(&xid))
+		xids = append(xids, xid)
+		xb = append(xb, vtInf.(*VecTimestamp).Vec...)
+	}
+
+	indexKeyC := C.CString(vdbl.indexKey)
+	defer C.free(unsafe.Pointer(indexKeyC))
+	vdbl.flatC = C.IndexFlatNewWithKey(C.long(vdbl.dim), C.float(vdbl.distThreshold), indexKeyC)
+	vdbl.minTrain = 0
+	if minTrain := int(C.IndexFlatMinTrain(vdbl.flatC)); minTrain > 0 {
+		// prefer own vectors, and fall back to pretrain samples
+		trainXb := xb
+		if len(xids) < minTrain && len(vdbl.trainSamples) >= minTrain*vdbl.dim {
+			trainXb = vdbl.trainSamples
+		}
+		if len(trainXb) < minTrain*vdbl.dim {
+			log.Infof("vectodblite %s falls back to %s until there're %v vectors to train %s, have %v", vdbl.dbKey, LiteIndexKeyFlat, minTrain, vdbl.indexKey, len(xids))
+			C.IndexFlatDelete(vdbl.flatC)
+			vdbl.flatC = C.IndexFlatNew(C.long(vdbl.dim), C.float(vdbl.distThreshold))
+			vdbl.minTrain = minTrain
+		} else {
+			log.Infof("vectodblite %s training %s with %v vectors", vdbl.dbKey, vdbl.indexKey, len(trainXb)/vdbl.dim)
+			C.IndexFlatTrain(vdbl.flatC, C.long(len(trainXb)/vdbl.dim), (*C.float)(&trainXb[0]))
+		}
+	}
+	if len(xids) != 0 {
+		C.IndexFlatAddWithIds(vdbl.flatC, C.long(len(xids)), (*C.float)(&xb[0]), (*C.ulong)(&xids[0]))
+	}
+	return
+}
+
+// Pretrain trains flatC with the given samples if it falls back to Flat, so that it's searched with the index before there're enough vectors.
+// The samples are kept in memory for later rebuilds until there're enough vectors, and are not added. It's a no-op for Flat.
+func (vdbl *VectoDBLite) Pretrain(xbs [][]float32) (err error) {
+	samples := make([]float32, 0, len(xbs)*vdbl.dim)
+	for _, xb := range xbs {
+		if len(xb) != vdbl.dim {
+			err = errors.Errorf("vectodblite %s invalid length of sample, want %v, have %v", vdbl.dbKey, vdbl.dim, len(xb))
+			return
+		}
+		samples = append(samples, xb...)
+	}
+	vdbl.rwlock.Lock()
+	vdbl.trainSamples = samples
+	minTrain := vdbl.minTrain
+	vdbl.rwlock.Unlock()
+	if minTrain == 0 {
+		return
+	}
+	if err = vdbl.rebuildFlatC(); err != nil {
+		return
+	}
+	if vdbl.IndexKey() == LiteIndexKeyFlat {
+		err = errors.Errorf("vectodblite %s not enough samples to train %s, want %v, have %v", vdbl.dbKey, vdbl.indexKey, minTrain, len(xbs))
+	}
+	return
+}
+
+// needTrain returns true if flatC falls back to Flat and there're enough vectors to train the index now.
+func (vdbl *VectoDBLite) needTrain() bool {
+	vdbl.rwlock.RLock()
+	defer vdbl.rwlock.RUnlock()
+	return vdbl.minTrain != 0 && vdbl.lru.Len() >= vdbl.minTrain
+}
+
+// FlatBacklog returns the number of vectors searched by brute force. It's all of them if flatC is Flat or falls back to Flat, otherwise 0.
+func (vdbl *VectoDBLite) FlatBacklog() int {
+	vdbl.rwlock.RLock()
+	defer vdbl.rwlock.RUnlock()
+	if vdbl.minTrain == 0 && vdbl.indexKey != LiteIndexKeyFlat {
+		return 0
+	}
+	return vdbl.lru.Len()
+}
+
+// RebuildPending returns true if flatC is due to be rebuilt, due to evictions, tombstones, or enough vectors to train the index.
+func (vdbl *VectoDBLite) RebuildPending() bool {
+	return atomic.LoadInt32(&vdbl.numEvicted) != 0 || atomic.LoadInt32(&vdbl.numTombstones) != 0 || vdbl.needTrain()
+}
+
+// reportDangling reports and counts a search candidate which is in flatC but absent in lru. Evicted vectors are expected to be so until
+// the next rebuild. Otherwise flatC drifts from redis, i.e. an add failed halfway, and RepairDangling shall remove it.
+func (vdbl *VectoDBLite) reportDangling(xidS string) {
+	atomic.AddInt64(&vdbl.numDangling, 1)
+	if atomic.LoadInt32(&vdbl.numEvicted) != 0 {
+		log.Debugf("vectodblite %s xid %v in IndexFlat is evicted from LRU", vdbl.dbKey, xidS)
+		return
+	}
+	log.Warnf("vectodblite %s xid %v in IndexFlat is absent in LRU and redis, skipped it, RepairDangling shall remove it", vdbl.dbKey, xidS)
+}
+
+// NumDangling returns the number of search candidates skipped since they're in IndexFlat but absent in redis, evicted ones included.
+func (vdbl *VectoDBLite) NumDangling() int64 {
+	return atomic.LoadInt64(&vdbl.numDangling)
+}
+
+// RepairDangling removes the vectors which are in IndexFlat but absent in redis, by rebuilding IndexFlat from lru.
+// It returns the number of such vectors, and skips rebuilding if there's none.
+func (vdbl *VectoDBLite) RepairDangling() (numDangling int, err error) {
+	vdbl.rwlock.RLock()
+	ntotal := int(C.IndexFlatGetXids(vdbl.flatC, 0, nil))
+	xids := make([]uint64, ntotal)
+	if ntotal != 0 {
+		ntotal = MinInt(ntotal, int(C.IndexFlatGetXids(vdbl.flatC, C.long(ntotal), (*C.ulong)(&xids[0]))))
+	}
+	vdbl.rwlock.RUnlock()
+	dangling := make(map[uint64]bool)
+	for _, xid := range xids[:ntotal] {
+		if !vdbl.lru.Contains(getXidKey(xid)) {
+			dangling[xid] = true
+		}
+	}
+	if numDangling = len(dangling); numDangling == 0 {
+		return
+	}
+	log.Infof("vectodblite %s removing %v dangling xids from IndexFlat", vdbl.dbKey, numDangling)
+	err = vdbl.rebuildFlatC()
+	return
+}
+
+// IndexKey returns the index_factory key flatC is built with. It's Flat if the index is not trained yet.
+func (vdbl *VectoDBLite) IndexKey() string {
+	vdbl.rwlock.RLock()
+	defer vdbl.rwlock.RUnlock()
+	if vdbl.minTrain != 0 {
+		return LiteIndexKeyFlat
+	}
+	return vdbl.indexKey
+}
+
+// ValidateLiteIndexKey checks if faiss index_factory accepts the key for the dim.
+func ValidateLiteIndexKey(dim int, indexKey string) (err error) {
+	indexKeyC := C.CString(indexKey)
+	defer C.free(unsafe.Pointer(indexKeyC))
+	if C.IndexFlatValidateKey(C.long(dim), indexKeyC) == 0 {
+		err = errors.Errorf("invalid index key %v for dim %v", indexKey, dim)
 	}
 	return
 }
@@ -153,7 +418,13 @@ func (vdbl *VectoDBLite) servExpire(ctx context.Context) {
 			log.Infof("vectodblite %s servExpire goroutine exited", vdbl.dbKey)
 			return
 		case <-tickCh:
-			if atomic.SwapInt32(&vdbl.numEvicted, 0) != 0 {
+			if atomic.LoadInt32(&vdbl.numTombstones) != 0 {
+				vdbl.purgeTombstones()
+			}
+			if atomic.LoadInt32(&vdbl.hasTTL) != 0 {
+				vdbl.purgeExpired()
+			}
+			if atomic.SwapInt32(&vdbl.numEvicted, 0) != 0 || vdbl.needTrain() {
 				if err := vdbl.rebuildFlatC(); err != nil {
 					log.Errorf("vectodblite %s got error %+v", vdbl.dbKey, err)
 				}
@@ -162,9 +433,39 @@ func (vdbl *VectoDBLite) servExpire(ctx context.Context) {
 	}
 }
 
+// purgeTombstones removes deleted vectors from lru and redis. flatC shall be rebuilt later.
+func (vdbl *VectoDBLite) purgeTombstones() {
+	vdbl.writeLock.RLock()
+	defer vdbl.writeLock.RUnlock()
+	for _, xidInf := range vdbl.lru.Keys() {
+		vtInf, ok := vdbl.lru.Peek(xidInf)
+		if !ok || !vtInf.(*VecTimestamp).Deleted {
+			continue
+		}
+		// onEvicted deletes it from redis
+		vdbl.lru.Remove(xidInf)
+	}
+}
+
+// purgeExpired removes the vectors whose TTL expired from lru and redis. flatC shall be rebuilt later.
+func (vdbl *VectoDBLite) purgeExpired() {
+	vdbl.writeLock.RLock()
+	defer vdbl.writeLock.RUnlock()
+	now := time.Now().Unix()
+	for _, xidInf := range vdbl.lru.Keys() {
+		vtInf, ok := vdbl.lru.Peek(xidInf)
+		if !ok || vtInf.(*VecTimestamp).ExpireAt >= now {
+			continue
+		}
+		// onEvicted deletes it from redis
+		vdbl.lru.Remove(xidInf)
+	}
+}
+
 func (vdbl *VectoDBLite) Destroy() (err error) {
 	log.Infof("vectodblite %s destroying", vdbl.dbKey)
 	vdbl.cancel()
+	vdbl.unsubscribeChanges()
 	vdbl.rwlock.Lock()
 	defer vdbl.rwlock.Unlock()
 	if vdbl.flatC != nil {
@@ -182,63 +483,837 @@ func (vdbl *VectoDBLite) Add(xb []float32) (xid uint64, err error) {
 	return
 }
 
+// AddWithGroup is the same as Add, and additionally tags the vector with a group which is used by grouped search.
+func (vdbl *VectoDBLite) AddWithGroup(xb []float32, group uint64) (xid uint64, err error) {
+	return vdbl.AddWithGroupWeight(xb, group, 0)
+}
+
+// AddWithGroupWeight is the same as AddWithGroup, and additionally sets the weight of the vector in the same write, see SetWeight.
+func (vdbl *VectoDBLite) AddWithGroupWeight(xb []float32, group uint64, weight float32) (xid uint64, err error) {
+	xid = allocateXid(vdbl.h64, xb)
+	if err = vdbl.AddWithIdGroupWeight(xb, xid, group, weight); err != nil {
+		return
+	}
+	return
+}
+
 func (vdbl *VectoDBLite) AddWithId(xb []float32, xid uint64) (err error) {
-	if len(xb) != vdbl.dim {
-		err = errors.Errorf("vectodblite %s invalid length of xb, want %v, have %v", vdbl.dbKey, vdbl.dim, len(xb))
+	return vdbl.AddWithIdGroup(xb, xid, 0)
+}
+
+// AddWithIdGroup is the same as AddWithId, and additionally tags the vector with a group which is used by grouped search.
+func (vdbl *VectoDBLite) AddWithIdGroup(xb []float32, xid uint64, group uint64) (err error) {
+	return vdbl.AddWithIdGroupWeight(xb, xid, group, 0)
+}
+
+// AddWithIdGroupWeight is the same as AddWithIdGroup, and additionally sets the weight of the vector in the same write, see SetWeight.
+func (vdbl *VectoDBLite) AddWithIdGroupWeight(xb []float32, xid uint64, group uint64, weight float32) (err error) {
+	if err = vdbl.checkWeight(weight); err != nil {
 		return
 	}
-	xidS := getXidKey(xid)
 	vt := &VecTimestamp{
 		Vec:      xb,
 		ExpireAt: time.Now().Unix() + ValidSeconds,
+		Group:    group,
+		Weight:   weight,
+	}
+	err = vdbl.addOne(xid, vt)
+	return
+}
+
+// AddWithTTL is the same as Add, except that the vector expires ttl later, rather than ValidSeconds after the last search hits it.
+// Searches skip it once expired, and servExpire removes it from lru and redis at the next sweep. Redis can't expire a field of
+// the db hash, so it's not left to redis.
+func (vdbl *VectoDBLite) AddWithTTL(xb []float32, ttl time.Duration) (xid uint64, err error) {
+	if ttl <= 0 {
+		err = errors.Errorf("vectodblite %s invalid ttl %v, want > 0", vdbl.dbKey, ttl)
+		return
+	}
+	// round up to seconds, so that a vector never expires earlier than ttl
+	ttlSeconds := int64((ttl + time.Second - 1) / time.Second)
+	xid = allocateXid(vdbl.h64, xb)
+	vt := &VecTimestamp{
+		Vec:      xb,
+		ExpireAt: time.Now().Unix() + ttlSeconds,
+		Ttl:      ttlSeconds,
+	}
+	err = vdbl.addOne(xid, vt)
+	return
+}
+
+// addOne writes vt to redis, then adds it to lru and flatC.
+func (vdbl *VectoDBLite) addOne(xid uint64, vt *VecTimestamp) (err error) {
+	if err = vdbl.checkWritable(); err != nil {
+		return
+	}
+	vdbl.writeLock.RLock()
+	defer vdbl.writeLock.RUnlock()
+	if len(vt.Vec) != vdbl.dim {
+		err = errors.Errorf("vectodblite %s invalid length of xb, want %v, have %v", vdbl.dbKey, vdbl.dim, len(vt.Vec))
+		return
+	}
+	if err = vdbl.checkCapacity([]uint64{xid}); err != nil {
+		return
 	}
+	xidS := getXidKey(xid)
 	var vtB []byte
 	if vtB, err = vt.Marshal(); err != nil {
 		err = errors.Wrapf(err, "")
 		return
 	}
 
-	if _, err = vdbl.rcli.HSet(vdbl.dbKey, xidS, string(vtB)).Result(); err != nil {
-		err = errors.Wrapf(err, "")
+	if err = vdbl.store.Put([]string{xidS}, [][]byte{vtB}); err != nil {
 		return
 	}
+	if vtInf, ok := vdbl.lru.Peek(xidS); ok && vtInf.(*VecTimestamp).Deleted {
+		atomic.AddInt32(&vdbl.numTombstones, int32(-1))
+	}
+	if vt.Ttl != 0 {
+		atomic.StoreInt32(&vdbl.hasTTL, 1)
+	}
 	vdbl.lru.Add(xidS, vt)
+	vdbl.addRecent(xid, vt)
+	vdbl.addFlat([]uint64{xid}, vt.Vec)
+	vdbl.publishChange(xidS)
+	return
+}
+
+// AddBatchWithIds adds multiple vectors in one redis round trip. A xid of 0 or ^uint64(0) is replaced with a generated one in place.
+func (vdbl *VectoDBLite) AddBatchWithIds(xbs [][]float32, xids []uint64) (err error) {
+	if len(xbs) != len(xids) {
+		err = errors.Errorf("vectodblite %s invalid length of xids, want %v, have %v", vdbl.dbKey, len(xbs), len(xids))
+		return
+	}
+	h64 := xxhash.New()
+	expireAt := time.Now().Unix() + ValidSeconds
+	vts := make([]*VecTimestamp, len(xbs))
+	for i, xb := range xbs {
+		if len(xb) != vdbl.dim {
+			err = errors.Errorf("vectodblite %s invalid length of xbs[%d], want %v, have %v", vdbl.dbKey, i, vdbl.dim, len(xb))
+			return
+		}
+		if xids[i] == 0 || xids[i] == ^uint64(0) {
+			xids[i] = allocateXid(h64, xb)
+		}
+		vts[i] = &VecTimestamp{
+			Vec:      xb,
+			ExpireAt: expireAt,
+		}
+	}
+	err = vdbl.addBatch(xids, vts)
+	return
+}
+
+// AddBatch is the same as Add for multiple vectors in one redis round trip. xids[i] is the generated xid of xbs[i].
+func (vdbl *VectoDBLite) AddBatch(xbs [][]float32) (xids []uint64, err error) {
+	xids = make([]uint64, len(xbs))
+	if err = vdbl.AddBatchWithIds(xbs, xids); err != nil {
+		xids = nil
+	}
+	return
+}
+
+// addBatch writes vts to the store in one round trip, then adds them to lru and flatC.
+func (vdbl *VectoDBLite) addBatch(xids []uint64, vts []*VecTimestamp) (err error) {
+	if err = vdbl.checkWritable(); err != nil {
+		return
+	}
+	vdbl.writeLock.RLock()
+	defer vdbl.writeLock.RUnlock()
+	if len(vts) == 0 {
+		return
+	}
+	if err = vdbl.checkCapacity(xids); err != nil {
+		return
+	}
+	flat := make([]float32, 0, len(vts)*vdbl.dim)
+	xidSs := make([]string, len(vts))
+	vtBs := make([][]byte, len(vts))
+	for i, vt := range vts {
+		if vtBs[i], err = vt.Marshal(); err != nil {
+			err = errors.Wrapf(err, "")
+			return
+		}
+		xidSs[i] = getXidKey(xids[i])
+		flat = append(flat, vt.Vec...)
+	}
+	if err = vdbl.store.Put(xidSs, vtBs); err != nil {
+		return
+	}
+	vdbl.publishChanges(xidSs)
+	for i, vt := range vts {
+		xidS := getXidKey(xids[i])
+		if vtInf, ok := vdbl.lru.Peek(xidS); ok && vtInf.(*VecTimestamp).Deleted {
+			atomic.AddInt32(&vdbl.numTombstones, int32(-1))
+		}
+		if vt.Ttl != 0 {
+			atomic.StoreInt32(&vdbl.hasTTL, 1)
+		}
+		vdbl.lru.Add(xidS, vt)
+		vdbl.addRecent(xids[i], vt)
+	}
+	vdbl.addFlat(xids, flat)
+	return
+}
+
+// addFlat adds vectors to flatC. flat is the concatenation of the vectors.
+func (vdbl *VectoDBLite) addFlat(xids []uint64, flat []float32) {
 	vdbl.rwlock.Lock()
-	C.IndexFlatAddWithIds(vdbl.flatC, C.long(1), (*C.float)(&xb[0]), (*C.ulong)(&xid))
+	C.IndexFlatAddWithIds(vdbl.flatC, C.long(len(xids)), (*C.float)(&flat[0]), (*C.ulong)(&xids[0]))
 	vdbl.rwlock.Unlock()
+}
+
+// Delete marks the vector as deleted. It's excluded from searches, and is removed at next compaction.
+// It's a no-op if the vector is absent.
+func (vdbl *VectoDBLite) Delete(xid uint64) (err error) {
+	if err = vdbl.checkWritable(); err != nil {
+		return
+	}
+	vdbl.writeLock.RLock()
+	defer vdbl.writeLock.RUnlock()
+	xidS := getXidKey(xid)
+	var updated []string
+	if updated, err = vdbl.updateVts([]string{xidS}, func(vt *VecTimestamp) bool {
+		if vt.Deleted {
+			return false
+		}
+		vt.Deleted = true
+		return true
+	}); err != nil || len(updated) == 0 {
+		return
+	}
+	atomic.AddInt32(&vdbl.numTombstones, int32(1))
+	vdbl.publishChange(xidS)
+	return
+}
+
+// Remove deletes the vector from redis and lru right away rather than leaving a tombstone, so that it frees its slot of sizeLimit
+// at once. It's never returned by searches since then, even with IncludeDeleted, and it's removed from IndexFlat at the next rebuild
+// as an evicted one. It's a no-op if the vector is absent.
+func (vdbl *VectoDBLite) Remove(xid uint64) (err error) {
+	if err = vdbl.checkWritable(); err != nil {
+		return
+	}
+	vdbl.writeLock.RLock()
+	defer vdbl.writeLock.RUnlock()
+	xidS := getXidKey(xid)
+	if !vdbl.lru.Contains(xidS) {
+		return
+	}
+	if err = vdbl.store.Delete(xidS); err != nil {
+		return
+	}
+	// onEvicted publishes the change, and schedules the rebuild of IndexFlat
+	vdbl.lru.Remove(xidS)
 	return
 }
 
+// Clear removes all vectors from the store, lru and IndexFlat, i.e. to reload a db after retraining the embeddings.
+// Unlike deleting the keys out of band, the in-memory index is rebuilt empty at once. Concurrent writes are blocked meanwhile,
+// so that none of them survives partially.
+func (vdbl *VectoDBLite) Clear() (err error) {
+	if err = vdbl.checkWritable(); err != nil {
+		return
+	}
+	vdbl.writeLock.Lock()
+	defer vdbl.writeLock.Unlock()
+	var xidSs []string
+	if err = vdbl.store.Range(func(xidS string, vtB []byte) bool {
+		xidSs = append(xidSs, xidS)
+		return true
+	}); err != nil {
+		return
+	}
+	if err = vdbl.store.Delete(xidSs...); err != nil {
+		return
+	}
+	log.Infof("vectodblite %s cleared %v vectors", vdbl.dbKey, len(xidSs))
+	vdbl.purging = true
+	vdbl.lru.Purge()
+	vdbl.purging = false
+	vdbl.publishChanges(xidSs)
+	atomic.StoreInt32(&vdbl.hasTTL, 0)
+	vdbl.recentLock.Lock()
+	if vdbl.recent != nil {
+		vdbl.recent.next, vdbl.recent.size = 0, 0
+	}
+	vdbl.recentLock.Unlock()
+	atomic.StoreInt32(&vdbl.numEvicted, 0)
+	err = vdbl.rebuildFlatC()
+	return
+}
+
+// DeleteIds marks the vectors as deleted in one round trip of the store, like Delete. They're removed from IndexFlat at the next rebuild.
+// It returns the number of vectors deleted, and the xids which are absent or already deleted.
+func (vdbl *VectoDBLite) DeleteIds(xids []uint64) (numDeleted int, notFound []uint64, err error) {
+	if err = vdbl.checkWritable(); err != nil {
+		return
+	}
+	vdbl.writeLock.RLock()
+	defer vdbl.writeLock.RUnlock()
+	uniq := make([]uint64, 0, len(xids))
+	xidSs := make([]string, 0, len(xids))
+	seen := make(map[uint64]bool, len(xids))
+	for _, xid := range xids {
+		if seen[xid] {
+			continue
+		}
+		seen[xid] = true
+		uniq = append(uniq, xid)
+		xidSs = append(xidSs, getXidKey(xid))
+	}
+	var updated []string
+	if updated, err = vdbl.updateVts(xidSs, func(vt *VecTimestamp) bool {
+		if vt.Deleted {
+			return false
+		}
+		vt.Deleted = true
+		return true
+	}); err != nil {
+		return
+	}
+	deleted := make(map[string]bool, len(updated))
+	for _, xidS := range updated {
+		deleted[xidS] = true
+	}
+	for i, xid := range uniq {
+		if !deleted[xidSs[i]] {
+			notFound = append(notFound, xid)
+		}
+	}
+	if len(updated) == 0 {
+		return
+	}
+	vdbl.publishChanges(updated)
+	atomic.AddInt32(&vdbl.numTombstones, int32(len(updated)))
+	numDeleted = len(updated)
+	return
+}
+
+// SearchOptions tunes SearchWithOptions. The zero value searches the nearest neighbor within the distance threshold.
+type SearchOptions struct {
+	// MinResults is the minimum number of neighbors to return. If less neighbors are within the distance threshold,
+	// the threshold is dropped to backfill up to MinResults, and the backfilled neighbors are flagged Relaxed.
+	// Fewer neighbors are returned if there are fewer vectors stored. FAISS pads the result with -1 ids, they're never returned.
+	MinResults int
+	// IncludeVectors indicates to return a copy of the stored vector of each neighbor.
+	IncludeVectors bool
+	// GroupBy indicates to return the best GroupTopK neighbors of each group rather than the global best ones.
+	// Only the nearest max(MinResults, GroupTopK*GroupOverFetch) neighbors are bucketed, so that a group whose neighbors are all
+	// farther is missing, and a group could have fewer than GroupTopK neighbors. Raise MinResults to cover more groups.
+	GroupBy   bool
+	GroupTopK int
+	// IncludeDeleted indicates to return deleted neighbors which are not compacted yet. They're flagged Deleted.
+	IncludeDeleted bool
+	// Weighted indicates to rerank neighbors by their distances scaled with the weights set by SetWeight, which are returned as Distance.
+	// A distance is multiplied by the weight, or divided by it if PenalizeWeight. It's the other way around for a negative distance,
+	// so that a larger weight always ranks a neighbor higher, or lower if PenalizeWeight. The neighbors within the distance threshold
+	// (before scaling) still rank ahead of relaxed ones. It doesn't support GroupBy.
+	Weighted       bool
+	PenalizeWeight bool
+	// Nprobe overrides the number of inverted lists probed if the index is IVF. Zero means the default of the index.
+	Nprobe int
+}
+
+// SearchResult is a neighbor found by SearchWithOptions.
+type SearchResult struct {
+	Xid      uint64
+	Distance float32
+	Xb       []float32
+	Group    uint64
+	Relaxed  bool // the distance doesn't satisfy the distance threshold
+	Deleted  bool
+}
+
 func (vdbl *VectoDBLite) Search(xq []float32) (xid uint64, distance float32, err error) {
-	if len(xq) != vdbl.dim {
-		err = errors.Errorf("vectodblite %s invalid length of xq, want %v, have %v", vdbl.dbKey, vdbl.dim, len(xq))
+	xid = ^uint64(0)
+	var rsts []SearchResult
+	if rsts, err = vdbl.SearchWithOptions(xq, SearchOptions{}); err != nil || len(rsts) == 0 {
+		return
+	}
+	xid, distance = rsts[0].Xid, rsts[0].Distance
+	return
+}
+
+// SearchWithVector is the same as Search, and additionally returns a copy of the stored vector of the nearest neighbor.
+// xb is nil if nothing is found.
+func (vdbl *VectoDBLite) SearchWithVector(xq []float32) (xid uint64, distance float32, xb []float32, err error) {
+	xid = ^uint64(0)
+	var rsts []SearchResult
+	if rsts, err = vdbl.SearchWithOptions(xq, SearchOptions{IncludeVectors: true}); err != nil || len(rsts) == 0 {
+		return
+	}
+	xid, distance, xb = rsts[0].Xid, rsts[0].Distance, rsts[0].Xb
+	return
+}
+
+// SearchTopK searches at most k nearest neighbors of xq within the distance threshold, in descending order of distance.
+// Unlike SearchOptions.MinResults, the neighbors beyond the distance threshold are filtered out rather than backfilled.
+func (vdbl *VectoDBLite) SearchTopK(xq []float32, k int) (xids []uint64, distances []float32, err error) {
+	if k <= 0 {
+		err = errors.Errorf("vectodblite %s invalid k %v, want > 0", vdbl.dbKey, k)
+		return
+	}
+	var rsts []SearchResult
+	if rsts, err = vdbl.SearchWithOptions(xq, SearchOptions{MinResults: k}); err != nil {
 		return
 	}
+	for _, rst := range rsts {
+		if rst.Relaxed {
+			break
+		}
+		xids = append(xids, rst.Xid)
+		distances = append(distances, rst.Distance)
+	}
+	return
+}
+
+// SearchWithOptions searches neighbors of xq in descending order of distance.
+// An empty or all-zero xq is rejected with ErrZeroVector unless SetAllowZeroQuery.
+func (vdbl *VectoDBLite) SearchWithOptions(xq []float32, opts SearchOptions) (rsts []SearchResult, err error) {
+	var rstss [][]SearchResult
+	if rstss, err = vdbl.SearchBatchWithOptions([][]float32{xq}, opts); err != nil {
+		return
+	}
+	rsts = rstss[0]
+	return
+}
+
+// SearchBatchWithOptions is the same as SearchWithOptions for multiple queries in one search of IndexFlat. rstss[i] are the neighbors of xqs[i].
+func (vdbl *VectoDBLite) SearchBatchWithOptions(xqs [][]float32, opts SearchOptions) (rstss [][]SearchResult, err error) {
+	for _, xq := range xqs {
+		if len(xq) == 0 || (atomic.LoadInt32(&vdbl.allowZero) == 0 && IsZeroVector(xq)) {
+			err = errors.Wrapf(ErrZeroVector, "vectodblite %s", vdbl.dbKey)
+			return
+		}
+		if len(xq) != vdbl.dim {
+			err = errors.Errorf("vectodblite %s invalid length of xq, want %v, have %v", vdbl.dbKey, vdbl.dim, len(xq))
+			return
+		}
+	}
+	if len(xqs) == 0 {
+		return
+	}
+	k := MaxInt(1, opts.MinResults)
+	wantRsts := k
+	if opts.Weighted {
+		if opts.GroupBy {
+			err = errors.Errorf("vectodblite %s weighted search doesn't support GroupBy", vdbl.dbKey)
+			return
+		}
+		// Over-fetch since weights could lift farther neighbors above nearer ones.
+		k = MaxInt(k, MinInt(k*WeightOverFetch, vdbl.Size()))
+	}
+	if opts.GroupBy {
+		if opts.GroupTopK <= 0 {
+			err = errors.Errorf("vectodblite %s invalid GroupTopK %v, want > 0", vdbl.dbKey, opts.GroupTopK)
+			return
+		}
+		// Over-fetch since the number of groups is unknown. Neighbors are bucketed by group later.
+		k = MaxInt(k, MinInt(opts.GroupTopK*GroupOverFetch, vdbl.Size()))
+	}
+	// Over-fetch since tombstones are skipped later.
+	numRsts := k
+	k += int(atomic.LoadInt32(&vdbl.numTombstones))
+	nq := len(xqs)
+	xqFlat := make([]float32, 0, nq*vdbl.dim)
+	for _, xq := range xqs {
+		xqFlat = append(xqFlat, xq...)
+	}
+	distances := make([]float32, nq*k)
+	xids := make([]uint64, nq*k)
 	vdbl.rwlock.RLock()
-	C.IndexFlatSearch(vdbl.flatC, C.long(1), (*C.float)(&xq[0]), (*C.float)(&distance), (*C.ulong)(&xid))
+	C.IndexFlatSearchTopK(vdbl.flatC, C.long(nq), (*C.float)(&xqFlat[0]), C.long(k), C.long(opts.Nprobe), (*C.float)(&distances[0]), (*C.ulong)(&xids[0]))
 	vdbl.rwlock.RUnlock()
-	if xid != ^uint64(0) {
-		//search ok, update expireAt at lur, and redis.
-		xidS := getXidKey(xid)
+	rstss = make([][]SearchResult, nq)
+	var slides []string
+	for q := range rstss {
+		var qSlides []string
+		rstss[q], qSlides = vdbl.collectResults(xids[q*k:(q+1)*k], distances[q*k:(q+1)*k], opts, numRsts, wantRsts)
+		slides = append(slides, qSlides...)
+	}
+	if err = vdbl.slideExpireAt(slides); err != nil {
+		rstss = nil
+	}
+	return
+}
+
+// slideExpireAt postpones expireAt of the hits to ValidSeconds later, in one round trip of the store.
+func (vdbl *VectoDBLite) slideExpireAt(xidSs []string) (err error) {
+	if len(xidSs) == 0 {
+		return
+	}
+	vdbl.writeLock.RLock()
+	defer vdbl.writeLock.RUnlock()
+	expireAt := time.Now().Unix() + ValidSeconds
+	_, err = vdbl.updateVts(xidSs, func(vt *VecTimestamp) bool {
+		// a TTL doesn't slide
+		if vt.Deleted || vt.Ttl != 0 || vt.ExpireAt >= expireAt {
+			return false
+		}
+		vt.ExpireAt = expireAt
+		return true
+	})
+	return
+}
+
+// collectResults turns the k nearest candidates of a query into results. Tombstones, expired and dangling vectors are skipped.
+// slides are the hits whose expireAt shall slide, see slideExpireAt.
+func (vdbl *VectoDBLite) collectResults(xids []uint64, distances []float32, opts SearchOptions, numRsts, wantRsts int) (rsts []SearchResult, slides []string) {
+	var groupSizes map[uint64]int
+	if opts.GroupBy {
+		groupSizes = make(map[uint64]int)
+	}
+	now := time.Now().Unix()
+	for i := range xids {
+		if !opts.GroupBy && len(rsts) >= numRsts {
+			break
+		}
+		if xids[i] == ^uint64(0) {
+			// there are less than k vectors, the remaining distances are garbage
+			break
+		}
+		relaxed := distances[i] < vdbl.distThreshold
+		if relaxed && len(rsts) >= opts.MinResults {
+			break
+		}
+		xidS := getXidKey(xids[i])
 		var vtInf interface{}
 		var ok bool
-		if vtInf, ok = vdbl.lru.Get(xidS); !ok {
-			log.Infof("vectodblite %s xid %v in IndexFlat is absent in LRU", vdbl.dbKey, xidS)
-			xid = ^uint64(0)
-			return
+		if vtInf, ok = vdbl.lru.Peek(xidS); !ok {
+			vdbl.reportDangling(xidS)
+			continue
 		}
 		vt := vtInf.(*VecTimestamp)
-		vt.ExpireAt = time.Now().Unix() + ValidSeconds
+		if vt.Deleted && !opts.IncludeDeleted {
+			continue
+		}
+		if vt.ExpireAt < now {
+			// not swept yet
+			continue
+		}
+		if opts.GroupBy {
+			if groupSizes[vt.Group] >= opts.GroupTopK {
+				continue
+			}
+			groupSizes[vt.Group]++
+		}
+		if !relaxed && !vt.Deleted && vt.Ttl != 0 {
+			// search ok, but a TTL doesn't slide
+			vdbl.lru.Get(xidS)
+		} else if !relaxed && !vt.Deleted {
+			//search ok, update expireAt at lru, and redis.
+			vdbl.lru.Get(xidS)
+			slides = append(slides, xidS)
+		}
+		rst := SearchResult{
+			Xid:      xids[i],
+			Distance: distances[i],
+			Group:    vt.Group,
+			Relaxed:  relaxed,
+			Deleted:  vt.Deleted,
+		}
+		if opts.Weighted {
+			rst.Distance = weighDistance(rst.Distance, vt.Weight, opts.PenalizeWeight)
+		}
+		if opts.IncludeVectors {
+			rst.Xb = make([]float32, len(vt.Vec))
+			copy(rst.Xb, vt.Vec)
+		}
+		rsts = append(rsts, rst)
+	}
+	if opts.Weighted {
+		sort.SliceStable(rsts, func(i, j int) bool {
+			if rsts[i].Relaxed != rsts[j].Relaxed {
+				return !rsts[i].Relaxed
+			}
+			return rsts[i].Distance > rsts[j].Distance
+		})
+		if len(rsts) > wantRsts {
+			rsts = rsts[:wantRsts]
+		}
+	}
+	return
+}
+
+// weighDistance scales the distance with the weight. A zero weight is unset and means 1.
+// Inner products could be negative, whose scaling is inverted, so that a larger weight always ranks higher, or lower if penalize.
+func weighDistance(distance, weight float32, penalize bool) float32 {
+	if weight == 0 {
+		return distance
+	}
+	if penalize != (distance < 0) {
+		return distance / weight
+	}
+	return distance * weight
+}
+
+// SetWeight sets the weight of the vector for weighted searches, and persists it to redis. 0 resets it to the default weight 1.
+// The weight is reset if the vector is added again, unless it's added with AddWithIdGroupWeight.
+func (vdbl *VectoDBLite) SetWeight(xid uint64, weight float32) (err error) {
+	if err = vdbl.checkWritable(); err != nil {
+		return
+	}
+	if err = vdbl.checkWeight(weight); err != nil {
+		return
+	}
+	vdbl.writeLock.RLock()
+	defer vdbl.writeLock.RUnlock()
+	xidS := getXidKey(xid)
+	var updated []string
+	if updated, err = vdbl.updateVts([]string{xidS}, func(vt *VecTimestamp) bool {
+		vt.Weight = weight
+		return !vt.Deleted
+	}); err != nil {
+		return
+	} else if len(updated) == 0 {
+		err = errors.Errorf("vectodblite %s xid %v is absent", vdbl.dbKey, xidS)
+		return
+	}
+	vdbl.publishChange(xidS)
+	return
+}
+
+func (vdbl *VectoDBLite) checkWeight(weight float32) (err error) {
+	if weight < 0 || math.IsNaN(float64(weight)) || math.IsInf(float64(weight), 0) {
+		err = errors.Errorf("vectodblite %s invalid weight %v, want >= 0", vdbl.dbKey, weight)
+	}
+	return
+}
+
+// updateVts updates copies of the lru entries of xidSs, persists them to the store, and only then replaces the entries with them,
+// so that readers never see a change which isn't persisted. update returns false to skip the entry. Entries absent from lru are skipped.
+// updated are the xidSs whose entries are replaced. Assumes writeLock is held for reading.
+func (vdbl *VectoDBLite) updateVts(xidSs []string, update func(vt *VecTimestamp) bool) (updated []string, err error) {
+	vdbl.vtLock.Lock()
+	defer vdbl.vtLock.Unlock()
+	vts := make([]*VecTimestamp, 0, len(xidSs))
+	vtBs := make([][]byte, 0, len(xidSs))
+	for _, xidS := range xidSs {
+		vtInf, ok := vdbl.lru.Peek(xidS)
+		if !ok {
+			continue
+		}
+		vt := *vtInf.(*VecTimestamp)
+		if !update(&vt) {
+			continue
+		}
 		var vtB []byte
 		if vtB, err = vt.Marshal(); err != nil {
 			err = errors.Wrapf(err, "")
+			updated = nil
 			return
 		}
-		if _, err = vdbl.rcli.HSet(vdbl.dbKey, xidS, string(vtB)).Result(); err != nil {
+		updated = append(updated, xidS)
+		vts = append(vts, &vt)
+		vtBs = append(vtBs, vtB)
+	}
+	if len(updated) == 0 {
+		return
+	}
+	if err = vdbl.store.Put(updated, vtBs); err != nil {
+		updated = nil
+		return
+	}
+	for i, xidS := range updated {
+		// not to resurrect an entry evicted meanwhile
+		if vdbl.lru.Contains(xidS) {
+			vdbl.lru.Add(xidS, vts[i])
+		}
+	}
+	return
+}
+
+func (vdbl *VectoDBLite) readOnlyKey() string {
+	return vdbl.dbKey + "_readonly"
+}
+
+func (vdbl *VectoDBLite) loadReadOnly() (err error) {
+	if vdbl.rcli == nil {
+		return
+	}
+	var n int64
+	if n, err = vdbl.rcli.Exists(vdbl.readOnlyKey()).Result(); err != nil {
+		err = errors.Wrapf(err, "")
+		return
+	}
+	if n != 0 {
+		log.Infof("vectodblite %s is read-only", vdbl.dbKey)
+		atomic.StoreInt32(&vdbl.readOnly, 1)
+	} else {
+		atomic.StoreInt32(&vdbl.readOnly, 0)
+	}
+	return
+}
+
+// SetReadOnly sets whether adds and deletes are rejected with ErrReadOnly. Searches are served either way.
+// The flag is persisted in redis, so that it survives reloading the vectodblite. It's kept in memory only unless the store is redis.
+func (vdbl *VectoDBLite) SetReadOnly(on bool) (err error) {
+	if vdbl.rcli != nil {
+		if on {
+			_, err = vdbl.rcli.Set(vdbl.readOnlyKey(), "1", 0).Result()
+		} else {
+			_, err = vdbl.rcli.Del(vdbl.readOnlyKey()).Result()
+		}
+		if err != nil {
 			err = errors.Wrapf(err, "")
 			return
 		}
 	}
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&vdbl.readOnly, v)
+	log.Infof("vectodblite %s read-only %v", vdbl.dbKey, on)
+	return
+}
+
+func (vdbl *VectoDBLite) ReadOnly() bool {
+	return atomic.LoadInt32(&vdbl.readOnly) != 0
+}
+
+func (vdbl *VectoDBLite) checkWritable() (err error) {
+	if vdbl.ReadOnly() {
+		err = errors.Wrapf(ErrReadOnly, "vectodblite %s", vdbl.dbKey)
+	}
+	return
+}
+
+// SampleDistances returns distances of at most n random pairs of distinct vectors, in ascending order.
+func (vdbl *VectoDBLite) SampleDistances(n int) (distances []float32) {
+	keys := vdbl.lru.Keys()
+	if len(keys) < 2 {
+		return
+	}
+	distances = make([]float32, 0, n)
+	for tries := 0; len(distances) < n && tries < 2*n; tries++ {
+		i := rand.Intn(len(keys))
+		j := rand.Intn(len(keys) - 1)
+		if j >= i {
+			j++
+		}
+		vtInf1, ok1 := vdbl.lru.Peek(keys[i])
+		vtInf2, ok2 := vdbl.lru.Peek(keys[j])
+		if !ok1 || !ok2 || vtInf1.(*VecTimestamp).Deleted || vtInf2.(*VecTimestamp).Deleted {
+			// evicted meanwhile, or deleted
+			continue
+		}
+		var distance float32
+		for k, x := range vtInf1.(*VecTimestamp).Vec {
+			distance += x * vtInf2.(*VecTimestamp).Vec[k]
+		}
+		distances = append(distances, distance)
+	}
+	sort.Slice(distances, func(i, j int) bool { return distances[i] < distances[j] })
+	return
+}
+
+// CountWithin returns the number of non-deleted vectors whose inner product with xq is at least thr, without materializing them.
+// It scans the LRU by brute force, so stale duplicates in IndexFlat aren't counted.
+func (vdbl *VectoDBLite) CountWithin(xq []float32, thr float32) (count int, err error) {
+	if len(xq) == 0 || (atomic.LoadInt32(&vdbl.allowZero) == 0 && IsZeroVector(xq)) {
+		err = errors.Wrapf(ErrZeroVector, "vectodblite %s", vdbl.dbKey)
+		return
+	}
+	if len(xq) != vdbl.dim {
+		err = errors.Errorf("vectodblite %s invalid length of xq, want %v, have %v", vdbl.dbKey, vdbl.dim, len(xq))
+		return
+	}
+	for _, key := range vdbl.lru.Keys() {
+		vtInf, ok := vdbl.lru.Peek(key)
+		if !ok || vtInf.(*VecTimestamp).Deleted {
+			continue
+		}
+		var distance float32
+		for k, x := range vtInf.(*VecTimestamp).Vec {
+			distance += x * xq[k]
+		}
+		if distance >= thr {
+			count++
+		}
+	}
+	return
+}
+
+// Contains returns true if the vector is present and not deleted.
+func (vdbl *VectoDBLite) Contains(xid uint64) bool {
+	vtInf, ok := vdbl.lru.Peek(getXidKey(xid))
+	return ok && !vtInf.(*VecTimestamp).Deleted
+}
+
+// SetEvictionPolicy sets what happens when an add would push the number of vectors past sizeLimit. It's EvictLRU by default.
+func (vdbl *VectoDBLite) SetEvictionPolicy(policy EvictionPolicy) {
+	atomic.StoreInt32(&vdbl.evictPolicy, int32(policy))
+}
+
+// checkCapacity returns ErrSizeLimit if the policy is RejectAdds, and adding the xids which are absent would exceed sizeLimit.
+// Tombstones take room until they're purged. Concurrent adds could overshoot, and the excess is evicted as EvictLRU does.
+func (vdbl *VectoDBLite) checkCapacity(xids []uint64) (err error) {
+	if EvictionPolicy(atomic.LoadInt32(&vdbl.evictPolicy)) != RejectAdds {
+		return
+	}
+	numNew := 0
+	seen := make(map[uint64]bool, len(xids))
+	for _, xid := range xids {
+		if !seen[xid] && !vdbl.lru.Contains(getXidKey(xid)) {
+			numNew++
+		}
+		seen[xid] = true
+	}
+	if size := vdbl.lru.Len(); numNew != 0 && size+numNew > vdbl.sizeLimit {
+		err = errors.Wrapf(ErrSizeLimit, "vectodblite %s has %v vectors, adding %v more exceeds limit %v", vdbl.dbKey, size, numNew, vdbl.sizeLimit)
+	}
+	return
+}
+
+// SetAllowZeroQuery sets whether searches accept all-zero query vectors. They're rejected with ErrZeroVector by default.
+func (vdbl *VectoDBLite) SetAllowZeroQuery(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&vdbl.allowZero, v)
+}
+
+// Count returns the number of vectors except deleted ones. Unlike Size, tombstones which are not purged yet are not counted.
+func (vdbl *VectoDBLite) Count() (count int, err error) {
+	count = vdbl.lru.Len() - int(atomic.LoadInt32(&vdbl.numTombstones))
+	if count < 0 {
+		count = 0
+	}
+	return
+}
+
+// ListIds returns the xids of the vectors in the store except deleted and expired ones, in no particular order, for reconciliation
+// against another source of truth. The store is scanned in batches, i.e. HSCAN of redis.
+func (vdbl *VectoDBLite) ListIds() (xids []uint64, err error) {
+	seen := make(map[uint64]bool)
+	now := time.Now().Unix()
+	var errVt error
+	if err = vdbl.store.Range(func(xidS string, vtB []byte) bool {
+		vt := VecTimestamp{}
+		if errVt = vt.Unmarshal(vtB); errVt != nil {
+			errVt = errors.Wrapf(errVt, "")
+			return false
+		}
+		if vt.Deleted || vt.ExpireAt < now {
+			return true
+		}
+		var xid uint64
+		if xid, errVt = strconv.ParseUint(xidS, 16, 64); errVt != nil {
+			errVt = errors.Wrapf(errVt, "")
+			return false
+		}
+		if !seen[xid] {
+			seen[xid] = true
+			xids = append(xids, xid)
+		}
+		return true
+	}); err != nil {
+		return
+	}
+	err = errVt
 	return
 }
 
@@ -254,6 +1329,11 @@ func getDbKey(dbID int) string {
 	return fmt.Sprintf("vectodblite_%v", dbID)
 }
 
+// HashVector returns the hash of vec, which is the same as the generated xid of vec.
+func HashVector(vec []float32) uint64 {
+	return allocateXid(xxhash.New(), vec)
+}
+
 // allocateXid uses hash of vec as xid.
 func allocateXid(h64 hash.Hash64, vec []float32) (xid uint64) {
 	// https://stackoverflow.com/questions/11924196/convert-between-slices-of-different-types
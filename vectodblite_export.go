@@ -0,0 +1,173 @@
+package vectodb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// The export format of a vectodblite is a header followed by records, little endian.
+// header: <magic [4]byte><version uint32><dim uint32><metric uint32>
+// record: <length uint32><xid uint64><VecTimestamp protobuf>, length is the number of bytes following it.
+const (
+	ExportVersion             = 1
+	MetricInnerProduct        = 0
+	maxExportRecordLength     = 1 << 24
+	exportHeaderLength    int = 16
+)
+
+var exportMagic = []byte("VDBL")
+
+type ExportHeader struct {
+	Dim    int
+	Metric int
+}
+
+func WriteExportHeader(w io.Writer, hdr ExportHeader) (err error) {
+	buf := make([]byte, exportHeaderLength)
+	copy(buf, exportMagic)
+	binary.LittleEndian.PutUint32(buf[4:], ExportVersion)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(hdr.Dim))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(hdr.Metric))
+	if _, err = w.Write(buf); err != nil {
+		err = errors.Wrap(err, "")
+	}
+	return
+}
+
+func ReadExportHeader(r io.Reader) (hdr ExportHeader, err error) {
+	buf := make([]byte, exportHeaderLength)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		err = errors.Wrap(err, "failed to read export header")
+		return
+	}
+	if !bytes.Equal(buf[:4], exportMagic) {
+		err = errors.Errorf("invalid export magic %q, want %q", buf[:4], exportMagic)
+		return
+	}
+	if version := binary.LittleEndian.Uint32(buf[4:]); version != ExportVersion {
+		err = errors.Errorf("unsupported export version %v, want %v", version, ExportVersion)
+		return
+	}
+	hdr.Dim = int(binary.LittleEndian.Uint32(buf[8:]))
+	hdr.Metric = int(binary.LittleEndian.Uint32(buf[12:]))
+	return
+}
+
+func WriteExportRecord(w io.Writer, xid uint64, vt *VecTimestamp) (err error) {
+	buf := make([]byte, 12+vt.Size())
+	binary.LittleEndian.PutUint32(buf, uint32(8+vt.Size()))
+	binary.LittleEndian.PutUint64(buf[4:], xid)
+	if _, err = vt.MarshalTo(buf[12:]); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	if _, err = w.Write(buf); err != nil {
+		err = errors.Wrap(err, "")
+	}
+	return
+}
+
+// ReadExportRecord decodes a record written by WriteExportRecord. It returns io.EOF at a clean end of stream.
+func ReadExportRecord(r io.Reader) (xid uint64, vt *VecTimestamp, err error) {
+	var buf [4]byte
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		if err != io.EOF {
+			err = errors.Wrap(err, "failed to read record length")
+		}
+		return
+	}
+	length := binary.LittleEndian.Uint32(buf[:])
+	if length < 8 || length > maxExportRecordLength {
+		err = errors.Errorf("invalid record length %v", length)
+		return
+	}
+	data := make([]byte, length)
+	if _, err = io.ReadFull(r, data); err != nil {
+		err = errors.Wrap(err, "failed to read record")
+		return
+	}
+	xid = binary.LittleEndian.Uint64(data)
+	vt = &VecTimestamp{}
+	if err = vt.Unmarshal(data[8:]); err != nil {
+		err = errors.Wrap(err, "")
+		return
+	}
+	return
+}
+
+// Export writes all vectors except deleted ones, along with their ids, groups and expiration.
+func (vdbl *VectoDBLite) Export(w io.Writer) (count int, err error) {
+	return vdbl.ExportFilter(w, nil)
+}
+
+// ExportFilter is the same as Export, except that only the vectors whose xids are accepted by filter are written. nil filter accepts all.
+func (vdbl *VectoDBLite) ExportFilter(w io.Writer, filter func(xid uint64) bool) (count int, err error) {
+	if err = WriteExportHeader(w, ExportHeader{Dim: vdbl.dim, Metric: MetricInnerProduct}); err != nil {
+		return
+	}
+	for _, xidInf := range vdbl.lru.Keys() {
+		vtInf, ok := vdbl.lru.Peek(xidInf)
+		if !ok || vtInf.(*VecTimestamp).Deleted {
+			continue
+		}
+		var xid uint64
+		if xid, err = strconv.ParseUint(xidInf.(string), 16, 64); err != nil {
+			err = errors.Wrapf(err, "")
+			return
+		}
+		if filter != nil && !filter(xid) {
+			continue
+		}
+		if err = WriteExportRecord(w, xid, vtInf.(*VecTimestamp)); err != nil {
+			return
+		}
+		count++
+	}
+	return
+}
+
+// MedianXid returns the median xid of vectors except deleted ones, which splits them into two halves by xid range.
+func (vdbl *VectoDBLite) MedianXid() (xid uint64, err error) {
+	xids := make([]uint64, 0, vdbl.lru.Len())
+	for _, xidInf := range vdbl.lru.Keys() {
+		vtInf, ok := vdbl.lru.Peek(xidInf)
+		if !ok || vtInf.(*VecTimestamp).Deleted {
+			continue
+		}
+		var cur uint64
+		if cur, err = strconv.ParseUint(xidInf.(string), 16, 64); err != nil {
+			err = errors.Wrapf(err, "")
+			return
+		}
+		xids = append(xids, cur)
+	}
+	if len(xids) < 2 {
+		err = errors.Errorf("vectodblite %s has %v vectors, too few to split", vdbl.dbKey, len(xids))
+		return
+	}
+	sort.Slice(xids, func(i, j int) bool { return xids[i] < xids[j] })
+	xid = xids[len(xids)/2]
+	return
+}
+
+// ImportBatch adds exported records in one redis round trip. Unlike AddBatchWithIds, groups and expiration are preserved.
+func (vdbl *VectoDBLite) ImportBatch(xids []uint64, vts []*VecTimestamp) (err error) {
+	if len(vts) != len(xids) {
+		err = errors.Errorf("vectodblite %s invalid length of xids, want %v, have %v", vdbl.dbKey, len(vts), len(xids))
+		return
+	}
+	for i, vt := range vts {
+		if len(vt.Vec) != vdbl.dim {
+			err = errors.Errorf("vectodblite %s invalid length of vts[%d].Vec, want %v, have %v", vdbl.dbKey, i, vdbl.dim, len(vt.Vec))
+			return
+		}
+		vt.Deleted = false
+	}
+	err = vdbl.addBatch(xids, vts)
+	return
+}
@@ -0,0 +1,94 @@
+package vectodb
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+type recentEntry struct {
+	xid uint64
+	vt  *VecTimestamp
+}
+
+// recentRing keeps the most recently added vectors for SearchRecent.
+type recentRing struct {
+	entries []recentEntry
+	next    int // position of the next entry
+	size    int
+}
+
+// EnableRecent keeps a ring buffer of the latest capacity vectors added since then, which SearchRecent searches. Zero disables it.
+func (vdbl *VectoDBLite) EnableRecent(capacity int) {
+	vdbl.recentLock.Lock()
+	defer vdbl.recentLock.Unlock()
+	if capacity <= 0 {
+		vdbl.recent = nil
+		return
+	}
+	vdbl.recent = &recentRing{
+		entries: make([]recentEntry, capacity),
+	}
+}
+
+func (vdbl *VectoDBLite) addRecent(xid uint64, vt *VecTimestamp) {
+	vdbl.recentLock.Lock()
+	defer vdbl.recentLock.Unlock()
+	ring := vdbl.recent
+	if ring == nil {
+		return
+	}
+	ring.entries[ring.next] = recentEntry{xid: xid, vt: vt}
+	ring.next = (ring.next + 1) % len(ring.entries)
+	if ring.size < len(ring.entries) {
+		ring.size++
+	}
+}
+
+// SearchRecent brute-force searches the latest window vectors, and returns at most topk neighbors in descending order of distance.
+// The ones beyond the distance threshold are flagged Relaxed. Deleted and evicted vectors are skipped.
+func (vdbl *VectoDBLite) SearchRecent(xq []float32, window int, topk int) (rsts []SearchResult, err error) {
+	if len(xq) != vdbl.dim {
+		err = errors.Errorf("vectodblite %s invalid length of xq, want %v, have %v", vdbl.dbKey, vdbl.dim, len(xq))
+		return
+	}
+	if window <= 0 || topk <= 0 {
+		err = errors.Errorf("vectodblite %s invalid window %v or topk %v, want > 0", vdbl.dbKey, window, topk)
+		return
+	}
+	vdbl.recentLock.Lock()
+	ring := vdbl.recent
+	if ring == nil {
+		vdbl.recentLock.Unlock()
+		err = errors.Errorf("vectodblite %s recent search is disabled", vdbl.dbKey)
+		return
+	}
+	window = MinInt(window, ring.size)
+	entries := make([]recentEntry, window)
+	for i := 0; i < window; i++ {
+		entries[i] = ring.entries[(ring.next-1-i+len(ring.entries))%len(ring.entries)]
+	}
+	vdbl.recentLock.Unlock()
+
+	for _, entry := range entries {
+		// the xid could be overwritten with another vector since then
+		if vtInf, ok := vdbl.lru.Peek(getXidKey(entry.xid)); !ok || vtInf.(*VecTimestamp) != entry.vt || entry.vt.Deleted {
+			continue
+		}
+		var distance float32
+		for i, x := range entry.vt.Vec {
+			distance += x * xq[i]
+		}
+		rsts = append(rsts, SearchResult{
+			Xid:      entry.xid,
+			Distance: distance,
+			Group:    entry.vt.Group,
+			Relaxed:  distance < vdbl.distThreshold,
+		})
+	}
+	sort.SliceStable(rsts, func(i, j int) bool { return rsts[i].Distance > rsts[j].Distance })
+	if len(rsts) > topk {
+		rsts = rsts[:topk]
+	}
+	return
+}
@@ -0,0 +1,218 @@
+package vectodb
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// A warm standby keeps a copy of a vectodblite owned by another process, so that it can take over without a cold load from redis.
+// Redis keyspace notifications don't carry hash fields, so the owner publishes the xid of every changed vector to the changes channel,
+// and the standby fetches the vector from redis. Pub/sub is best effort: changes published while the standby is disconnected are lost,
+// so that Promote reconciles with a full scan of redis before the standby serves as the owner.
+
+// changesKey returns the redis channel the owner publishes changed xids to.
+func (vdbl *VectoDBLite) changesKey() string {
+	return vdbl.dbKey + "_changes"
+}
+
+// SetPublishChanges sets whether the owner publishes changed xids for warm standbys. It's off by default, and is ignored
+// unless the store is redis.
+func (vdbl *VectoDBLite) SetPublishChanges(on bool) {
+	vdbl.publish = on && vdbl.rcli != nil
+}
+
+func (vdbl *VectoDBLite) publishChange(xidS string) {
+	if !vdbl.publish || vdbl.isStandby() {
+		return
+	}
+	if err := vdbl.rcli.Publish(vdbl.changesKey(), xidS).Err(); err != nil {
+		log.Warnf("vectodblite %s failed to publish change of %v, error %+v", vdbl.dbKey, xidS, err)
+	}
+}
+
+// publishChanges is the same as publishChange for multiple xids in one redis round trip.
+func (vdbl *VectoDBLite) publishChanges(xidSs []string) {
+	if !vdbl.publish || vdbl.isStandby() {
+		return
+	}
+	pipe := vdbl.rcli.Pipeline()
+	defer pipe.Close()
+	for _, xidS := range xidSs {
+		pipe.Publish(vdbl.changesKey(), xidS)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		log.Warnf("vectodblite %s failed to publish changes of %v xids, error %+v", vdbl.dbKey, len(xidSs), err)
+	}
+}
+
+func (vdbl *VectoDBLite) isStandby() bool {
+	return atomic.LoadInt32(&vdbl.standby) != 0
+}
+
+// NewVectoDBLiteStandby loads vectors of the given dbID from redis, and tails changes published by the owner.
+// The standby never writes redis. It's searchable at any time, and shall be promoted before adding or deleting vectors.
+func NewVectoDBLiteStandby(redisAddr string, dbID int, dimIn int, distThreshold float32, sizeLimit int, indexKey string) (vdbl *VectoDBLite, err error) {
+	return NewVectoDBLiteStandbyWithRedisOptions(RedisOptions{Addr: redisAddr}, dbID, dimIn, distThreshold, sizeLimit, indexKey)
+}
+
+// NewVectoDBLiteStandbyWithRedisOptions is the same as NewVectoDBLiteStandby, except that redis is connected with the given options.
+func NewVectoDBLiteStandbyWithRedisOptions(redisOpts RedisOptions, dbID int, dimIn int, distThreshold float32, sizeLimit int, indexKey string) (vdbl *VectoDBLite, err error) {
+	rcli := redisOpts.NewClient()
+	return newVectoDBLite(NewRedisLiteStore(rcli, redisOpts.KeyPrefix, dbID), rcli, redisOpts.KeyPrefix, dbID, dimIn, distThreshold, sizeLimit, indexKey, false, true)
+}
+
+// Promote turns a warm standby into the owner. It stops tailing changes, reconciles with a full scan of the store since changes
+// could have been lost, and writes redis since then. If it fails, the vectodblite shall be destroyed and loaded from redis instead.
+func (vdbl *VectoDBLite) Promote() (err error) {
+	if atomic.SwapInt32(&vdbl.standby, 0) == 0 {
+		return
+	}
+	log.Infof("vectodblite %s promoting warm standby", vdbl.dbKey)
+	vdbl.unsubscribeChanges()
+	if err = vdbl.reconcile(); err != nil {
+		return
+	}
+	// the read-only flag could be changed after the standby is loaded
+	err = vdbl.loadReadOnly()
+	return
+}
+
+// reconcile syncs lru and flatC with all vectors in the store, the same as applying a change of each of them.
+func (vdbl *VectoDBLite) reconcile() (err error) {
+	vdbl.writeLock.RLock()
+	defer vdbl.writeLock.RUnlock()
+	stored := make(map[string]bool)
+	var errVt error
+	if err = vdbl.store.Range(func(xidS string, vtB []byte) bool {
+		var xid uint64
+		if xid, errVt = strconv.ParseUint(xidS, 16, 64); errVt != nil {
+			errVt = errors.Wrapf(errVt, "")
+			return false
+		}
+		vt := &VecTimestamp{}
+		if errVt = vt.Unmarshal(vtB); errVt != nil {
+			errVt = errors.Wrapf(errVt, "")
+			return false
+		}
+		stored[xidS] = true
+		vdbl.applyVt(xid, xidS, vt)
+		return true
+	}); err != nil {
+		return
+	}
+	if errVt != nil {
+		err = errVt
+		return
+	}
+	var numRemoved int
+	for _, xidInf := range vdbl.lru.Keys() {
+		if xidS := xidInf.(string); !stored[xidS] {
+			vdbl.lru.Remove(xidS)
+			numRemoved++
+		}
+	}
+	if numRemoved != 0 {
+		log.Infof("vectodblite %s removed %d vectors absent from the store on promotion", vdbl.dbKey, numRemoved)
+		err = vdbl.rebuildFlatC()
+	}
+	return
+}
+
+func (vdbl *VectoDBLite) subscribeChanges() (err error) {
+	vdbl.pubsub = vdbl.rcli.Subscribe(vdbl.changesKey())
+	// wait for the confirmation, otherwise the subscription is not established yet
+	if _, err = vdbl.pubsub.Receive(); err != nil {
+		err = errors.Wrapf(err, "")
+		return
+	}
+	return
+}
+
+func (vdbl *VectoDBLite) unsubscribeChanges() {
+	if vdbl.pubsub != nil {
+		// the channel returned by Channel() is closed, which stops servTail
+		vdbl.pubsub.Close()
+	}
+}
+
+func (vdbl *VectoDBLite) servTail(ctx context.Context, ch <-chan *redis.Message) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("vectodblite %s servTail goroutine exited", vdbl.dbKey)
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				log.Infof("vectodblite %s servTail goroutine exited", vdbl.dbKey)
+				return
+			}
+			if err := vdbl.applyChange(msg.Payload); err != nil {
+				log.Errorf("vectodblite %s got error %+v", vdbl.dbKey, err)
+			}
+		}
+	}
+}
+
+// applyChange syncs the vector of the given xid from redis to lru and flatC.
+func (vdbl *VectoDBLite) applyChange(xidS string) (err error) {
+	var xid uint64
+	if xid, err = strconv.ParseUint(xidS, 16, 64); err != nil {
+		err = errors.Wrapf(err, "")
+		return
+	}
+	var vtB []byte
+	if vtB, err = vdbl.store.Get(xidS); err != nil {
+		return
+	} else if vtB == nil {
+		// evicted or purged by the owner. onEvicted accounts it, and flatC shall be rebuilt later.
+		vdbl.lru.Remove(xidS)
+		return
+	}
+	vt := &VecTimestamp{}
+	if err = vt.Unmarshal(vtB); err != nil {
+		err = errors.Wrapf(err, "")
+		return
+	}
+	vdbl.writeLock.RLock()
+	vdbl.applyVt(xid, xidS, vt)
+	vdbl.writeLock.RUnlock()
+	return
+}
+
+// applyVt syncs the vector read from the store to lru and flatC.
+func (vdbl *VectoDBLite) applyVt(xid uint64, xidS string, vt *VecTimestamp) {
+	var vtOld *VecTimestamp
+	if vtInf, ok := vdbl.lru.Peek(xidS); ok {
+		vtOld = vtInf.(*VecTimestamp)
+	}
+	wasDeleted := vtOld != nil && vtOld.Deleted
+	if vt.Deleted && !wasDeleted {
+		atomic.AddInt32(&vdbl.numTombstones, int32(1))
+	} else if !vt.Deleted && wasDeleted {
+		atomic.AddInt32(&vdbl.numTombstones, int32(-1))
+	}
+	vdbl.lru.Add(xidS, vt)
+	if vtOld != nil && equalVec(vtOld.Vec, vt.Vec) {
+		// only the weight, group or deletion changed
+		return
+	}
+	vdbl.addRecent(xid, vt)
+	vdbl.addFlat([]uint64{xid}, vt.Vec)
+}
+
+func equalVec(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,221 @@
+package vectodb
+
+import (
+	"sync"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// LiteStore persists the vectors of a vectodblite. Keys are getXidKey(xid), and values are marshaled VecTimestamp.
+// VectoDBLite keeps every vector in memory as well, so a store is read only when loading, and when a warm standby tails changes.
+type LiteStore interface {
+	// Put writes the values of keys, in one round trip if the store supports it. values[i] is the value of keys[i].
+	Put(keys []string, values [][]byte) error
+	// Get returns a nil value if the key is absent.
+	Get(key string) (value []byte, err error)
+	// Delete ignores the keys which are absent.
+	Delete(keys ...string) error
+	// Range calls fn with every key and value, and stops once fn returns false. value is valid only until fn returns,
+	// and fn shall not call the store.
+	Range(fn func(key string, value []byte) bool) error
+}
+
+// redisScanCount is the COUNT hint of HSCAN, the number of vectors fetched per round trip.
+const redisScanCount = 1000
+
+// RedisLiteStore stores the vectors of a db in a redis hash. It's the store of NewVectoDBLite.
+type RedisLiteStore struct {
+	rcli  *redis.Client
+	dbKey string
+}
+
+// NewRedisLiteStore returns the store of the given dbID in redis. keyPrefix is RedisOptions.KeyPrefix.
+func NewRedisLiteStore(rcli *redis.Client, keyPrefix string, dbID int) *RedisLiteStore {
+	return &RedisLiteStore{rcli: rcli, dbKey: keyPrefix + getDbKey(dbID)}
+}
+
+func (s *RedisLiteStore) Put(keys []string, values [][]byte) (err error) {
+	if len(keys) == 1 {
+		if _, err = s.rcli.HSet(s.dbKey, keys[0], string(values[0])).Result(); err != nil {
+			err = errors.Wrapf(err, "")
+		}
+		return
+	}
+	pipe := s.rcli.Pipeline()
+	defer pipe.Close()
+	for i, key := range keys {
+		pipe.HSet(s.dbKey, key, string(values[i]))
+	}
+	if _, err = pipe.Exec(); err != nil {
+		err = errors.Wrapf(err, "")
+	}
+	return
+}
+
+func (s *RedisLiteStore) Get(key string) (value []byte, err error) {
+	var valueS string
+	if valueS, err = s.rcli.HGet(s.dbKey, key).Result(); err == redis.Nil {
+		err = nil
+		return
+	} else if err != nil {
+		err = errors.Wrapf(err, "")
+		return
+	}
+	value = []byte(valueS)
+	return
+}
+
+func (s *RedisLiteStore) Delete(keys ...string) (err error) {
+	if len(keys) == 0 {
+		return
+	}
+	if _, err = s.rcli.HDel(s.dbKey, keys...).Result(); err != nil {
+		err = errors.Wrapf(err, "")
+	}
+	return
+}
+
+// Range scans the hash with HSCAN in batches of redisScanCount, rather than fetching it in one giant reply of HGETALL.
+// A key could be passed to fn more than once if the hash is rehashed during the scan.
+func (s *RedisLiteStore) Range(fn func(key string, value []byte) bool) (err error) {
+	var cursor uint64
+	for {
+		var kvs []string
+		if kvs, cursor, err = s.rcli.HScan(s.dbKey, cursor, "", redisScanCount).Result(); err != nil {
+			err = errors.Wrap(err, "")
+			return
+		}
+		// kvs are fields interleaved with values
+		for i := 0; i+1 < len(kvs); i += 2 {
+			if !fn(kvs[i], []byte(kvs[i+1])) {
+				return
+			}
+		}
+		if cursor == 0 {
+			return
+		}
+	}
+}
+
+// MemLiteStore keeps the vectors in memory only, i.e. for tests and single-node deployments which needn't survive restarts.
+type MemLiteStore struct {
+	rwlock sync.RWMutex
+	values map[string][]byte
+}
+
+func NewMemLiteStore() *MemLiteStore {
+	return &MemLiteStore{values: make(map[string][]byte)}
+}
+
+func (s *MemLiteStore) Put(keys []string, values [][]byte) (err error) {
+	s.rwlock.Lock()
+	for i, key := range keys {
+		s.values[key] = values[i]
+	}
+	s.rwlock.Unlock()
+	return
+}
+
+func (s *MemLiteStore) Get(key string) (value []byte, err error) {
+	s.rwlock.RLock()
+	value = s.values[key]
+	s.rwlock.RUnlock()
+	return
+}
+
+func (s *MemLiteStore) Delete(keys ...string) (err error) {
+	s.rwlock.Lock()
+	for _, key := range keys {
+		delete(s.values, key)
+	}
+	s.rwlock.Unlock()
+	return
+}
+
+func (s *MemLiteStore) Range(fn func(key string, value []byte) bool) (err error) {
+	s.rwlock.RLock()
+	defer s.rwlock.RUnlock()
+	for key, value := range s.values {
+		if !fn(key, value) {
+			break
+		}
+	}
+	return
+}
+
+// BoltLiteStore stores the vectors of a db in a bucket of a BoltDB file, so that a single-node deployment persists vectors without redis.
+// Dbs could share the file, each has its own bucket. The caller opens and closes the file.
+type BoltLiteStore struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// NewBoltLiteStore returns the store of the given dbID in db, and creates its bucket if it's absent.
+func NewBoltLiteStore(db *bolt.DB, dbID int) (s *BoltLiteStore, err error) {
+	bucket := []byte(getDbKey(dbID))
+	if err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	}); err != nil {
+		err = errors.Wrapf(err, "")
+		return
+	}
+	s = &BoltLiteStore{db: db, bucket: bucket}
+	return
+}
+
+func (s *BoltLiteStore) Put(keys []string, values [][]byte) (err error) {
+	if err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for i, key := range keys {
+			if err := b.Put([]byte(key), values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		err = errors.Wrapf(err, "")
+	}
+	return
+}
+
+func (s *BoltLiteStore) Get(key string) (value []byte, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		// the value is valid only during the transaction
+		if v := tx.Bucket(s.bucket).Get([]byte(key)); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return
+}
+
+func (s *BoltLiteStore) Delete(keys ...string) (err error) {
+	if err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for _, key := range keys {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		err = errors.Wrapf(err, "")
+	}
+	return
+}
+
+func (s *BoltLiteStore) Range(fn func(key string, value []byte) bool) (err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !fn(string(k), v) {
+				break
+			}
+		}
+		return nil
+	})
+	return
+}
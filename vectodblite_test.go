@@ -0,0 +1,748 @@
+package vectodb
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	redisAddr string  = "127.0.0.1:6379"
+	liteDim   int     = 128
+	liteDbID  int     = 999
+	liteThr   float32 = 0.9
+	liteLimit int     = 100
+)
+
+func genLiteVec() (vec []float32) {
+	vec = make([]float32, liteDim)
+	for i := 0; i < liteDim; i++ {
+		vec[i] = rand.Float32()
+	}
+	normalizeInplace(liteDim, vec)
+	return
+}
+
+func newTestVectoDBLite(t *testing.T) (vdbl *VectoDBLite) {
+	// start from an empty db
+	vdbl, err := NewVectoDBLite(redisAddr, liteDbID, liteDim, liteThr, liteLimit, true)
+	require.NoError(t, err)
+	return
+}
+
+func TestVectoDBLiteSearchWithVector(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xbs := make([][]float32, 10)
+	xids := make([]uint64, 10)
+	var err error
+	for i := range xbs {
+		xbs[i] = genLiteVec()
+		xids[i], err = vdbl.Add(xbs[i])
+		require.NoError(t, err)
+	}
+	for i := range xbs {
+		xid, _, xb, err := vdbl.SearchWithVector(xbs[i])
+		require.NoError(t, err)
+		require.Equal(t, xids[i], xid)
+		require.Equal(t, xbs[i], xb)
+	}
+}
+
+func TestVectoDBLiteSearchMinResults(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	// random vectors of high dimension are far from each other
+	xbs := make([][]float32, 10)
+	var err error
+	var xid, xid0 uint64
+	for i := range xbs {
+		xbs[i] = genLiteVec()
+		xid, err = vdbl.Add(xbs[i])
+		require.NoError(t, err)
+		if i == 0 {
+			xid0 = xid
+		}
+	}
+
+	rsts, err := vdbl.SearchWithOptions(xbs[0], SearchOptions{})
+	require.NoError(t, err)
+	require.Len(t, rsts, 1)
+	require.Equal(t, xid0, rsts[0].Xid)
+	require.False(t, rsts[0].Relaxed)
+
+	rsts, err = vdbl.SearchWithOptions(xbs[0], SearchOptions{MinResults: 5})
+	require.NoError(t, err)
+	require.Len(t, rsts, 5)
+	require.Equal(t, xid0, rsts[0].Xid)
+	require.False(t, rsts[0].Relaxed)
+	for i := 1; i < len(rsts); i++ {
+		require.True(t, rsts[i].Relaxed)
+		require.True(t, rsts[i].Distance <= rsts[i-1].Distance)
+	}
+}
+
+func TestVectoDBLiteSearchTopK(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	// random vectors of high dimension are far from each other, except near duplicates of xbs[0]
+	const numDup int = 3
+	xbs := make([][]float32, 10+numDup)
+	var err error
+	for i := range xbs {
+		if i < 10 {
+			xbs[i] = genLiteVec()
+		} else {
+			xbs[i] = make([]float32, liteDim)
+			for j := range xbs[i] {
+				xbs[i][j] = xbs[0][j] + 0.01*rand.Float32()
+			}
+			normalizeInplace(liteDim, xbs[i])
+		}
+		_, err = vdbl.Add(xbs[i])
+		require.NoError(t, err)
+	}
+
+	xids, distances, err := vdbl.SearchTopK(xbs[0], 20)
+	require.NoError(t, err)
+	require.Len(t, xids, 1+numDup)
+	require.Len(t, distances, 1+numDup)
+	for i := range distances {
+		require.True(t, distances[i] >= liteThr)
+		if i > 0 {
+			require.True(t, distances[i] <= distances[i-1])
+		}
+	}
+	xids, _, err = vdbl.SearchTopK(xbs[0], 2)
+	require.NoError(t, err)
+	require.Len(t, xids, 2)
+	_, _, err = vdbl.SearchTopK(xbs[0], 0)
+	require.Error(t, err)
+}
+
+func TestVectoDBLiteSearchGroupBy(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	// vectors close to xq, tagged with group i%3
+	xq := genLiteVec()
+	for i := 0; i < 30; i++ {
+		xb := make([]float32, liteDim)
+		copy(xb, xq)
+		xb[i] += 0.01
+		normalizeInplace(liteDim, xb)
+		_, err := vdbl.AddWithGroup(xb, uint64(i%3))
+		require.NoError(t, err)
+	}
+
+	rsts, err := vdbl.SearchWithOptions(xq, SearchOptions{GroupBy: true, GroupTopK: 2})
+	require.NoError(t, err)
+	require.Len(t, rsts, 6)
+	groupSizes := make(map[uint64]int)
+	for i, rst := range rsts {
+		groupSizes[rst.Group]++
+		if i > 0 {
+			require.True(t, rst.Distance <= rsts[i-1].Distance)
+		}
+	}
+	require.Equal(t, map[uint64]int{0: 2, 1: 2, 2: 2}, groupSizes)
+}
+
+func TestVectoDBLiteSearchIncludeDeleted(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xb := genLiteVec()
+	xid, err := vdbl.Add(xb)
+	require.NoError(t, err)
+	// a neighbor within the threshold which shall take over once xid is deleted
+	xb2 := make([]float32, liteDim)
+	copy(xb2, xb)
+	xb2[0] += 0.01
+	normalizeInplace(liteDim, xb2)
+	xid2, err := vdbl.Add(xb2)
+	require.NoError(t, err)
+
+	require.NoError(t, vdbl.Delete(xid))
+	rsts, err := vdbl.SearchWithOptions(xb, SearchOptions{})
+	require.NoError(t, err)
+	require.Len(t, rsts, 1)
+	require.Equal(t, xid2, rsts[0].Xid)
+	require.False(t, rsts[0].Deleted)
+
+	rsts, err = vdbl.SearchWithOptions(xb, SearchOptions{IncludeDeleted: true})
+	require.NoError(t, err)
+	require.Len(t, rsts, 1)
+	require.Equal(t, xid, rsts[0].Xid)
+	require.True(t, rsts[0].Deleted)
+
+	// compaction removes the tombstone
+	vdbl.purgeTombstones()
+	require.NoError(t, vdbl.rebuildFlatC())
+	rsts, err = vdbl.SearchWithOptions(xb, SearchOptions{IncludeDeleted: true})
+	require.NoError(t, err)
+	require.Len(t, rsts, 1)
+	require.Equal(t, xid2, rsts[0].Xid)
+}
+
+func TestVectoDBLiteReacquire(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	xbs := make([][]float32, 10)
+	xids := make([]uint64, 10)
+	var err error
+	for i := range xbs {
+		xbs[i] = genLiteVec()
+		xids[i], err = vdbl.Add(xbs[i])
+		require.NoError(t, err)
+	}
+	require.NoError(t, vdbl.Destroy())
+
+	// reacquiring preserves previously added vectors
+	vdbl, err = NewVectoDBLite(redisAddr, liteDbID, liteDim, liteThr, liteLimit, false)
+	require.NoError(t, err)
+	require.Equal(t, len(xbs), vdbl.Size())
+	for i := range xbs {
+		xid, _, err := vdbl.Search(xbs[i])
+		require.NoError(t, err)
+		require.Equal(t, xids[i], xid)
+	}
+	require.NoError(t, vdbl.Destroy())
+
+	// fresh wipes them
+	vdbl, err = NewVectoDBLite(redisAddr, liteDbID, liteDim, liteThr, liteLimit, true)
+	require.NoError(t, err)
+	defer vdbl.Destroy()
+	require.Equal(t, 0, vdbl.Size())
+	xid, _, err := vdbl.Search(xbs[0])
+	require.NoError(t, err)
+	require.Equal(t, ^uint64(0), xid)
+}
+
+func TestVectoDBLiteSearchBeyondSize(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xids := make(map[uint64]bool)
+	for i := 0; i < 3; i++ {
+		xid, err := vdbl.Add(genLiteVec())
+		require.NoError(t, err)
+		xids[xid] = true
+	}
+	rsts, err := vdbl.SearchWithOptions(genLiteVec(), SearchOptions{MinResults: 10})
+	require.NoError(t, err)
+	require.Len(t, rsts, 3)
+	for i, rst := range rsts {
+		require.True(t, xids[rst.Xid])
+		if i > 0 {
+			require.True(t, rst.Distance <= rsts[i-1].Distance)
+		}
+	}
+}
+
+func TestVectoDBLiteZeroQuery(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+	_, err := vdbl.Add(genLiteVec())
+	require.NoError(t, err)
+
+	_, _, err = vdbl.Search([]float32{})
+	require.Equal(t, ErrZeroVector, errors.Cause(err))
+	_, _, err = vdbl.Search(make([]float32, liteDim))
+	require.Equal(t, ErrZeroVector, errors.Cause(err))
+
+	vdbl.SetAllowZeroQuery(true)
+	_, _, err = vdbl.Search(make([]float32, liteDim))
+	require.NoError(t, err)
+	_, _, err = vdbl.Search([]float32{})
+	require.Equal(t, ErrZeroVector, errors.Cause(err))
+}
+
+func TestVectoDBLiteSearchRecent(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+	_, err := vdbl.SearchRecent(genLiteVec(), 1, 1)
+	require.Error(t, err)
+
+	vdbl.EnableRecent(5)
+	var xbs [][]float32
+	var xids []uint64
+	for i := 0; i < 10; i++ {
+		xb := genLiteVec()
+		xid, err := vdbl.Add(xb)
+		require.NoError(t, err)
+		xbs = append(xbs, xb)
+		xids = append(xids, xid)
+	}
+
+	// the oldest vectors are out of the window
+	rsts, err := vdbl.SearchRecent(xbs[0], 3, 10)
+	require.NoError(t, err)
+	require.Len(t, rsts, 3)
+	for i, rst := range rsts {
+		require.Contains(t, xids[7:], rst.Xid)
+		if i > 0 {
+			require.True(t, rst.Distance <= rsts[i-1].Distance)
+		}
+	}
+
+	// window is capped by the ring capacity
+	rsts, err = vdbl.SearchRecent(xbs[6], 100, 10)
+	require.NoError(t, err)
+	require.Len(t, rsts, 5)
+	require.Equal(t, xids[6], rsts[0].Xid)
+	require.False(t, rsts[0].Relaxed)
+
+	rsts, err = vdbl.SearchRecent(xbs[9], 5, 1)
+	require.NoError(t, err)
+	require.Len(t, rsts, 1)
+	require.Equal(t, xids[9], rsts[0].Xid)
+}
+
+func TestVectoDBLiteWeightedSearch(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	// both vectors are at the same distance to xq
+	xq := make([]float32, liteDim)
+	xq[0] = 1
+	xb1 := make([]float32, liteDim)
+	xb1[0], xb1[1] = 1, 0.3
+	normalizeInplace(liteDim, xb1)
+	xb2 := make([]float32, liteDim)
+	xb2[0], xb2[2] = 1, 0.3
+	normalizeInplace(liteDim, xb2)
+	xid1, err := vdbl.Add(xb1)
+	require.NoError(t, err)
+	xid2, err := vdbl.Add(xb2)
+	require.NoError(t, err)
+	require.Error(t, vdbl.SetWeight(xid2, -1))
+	require.NoError(t, vdbl.SetWeight(xid2, 2))
+
+	rsts, err := vdbl.SearchWithOptions(xq, SearchOptions{MinResults: 1, Weighted: true})
+	require.NoError(t, err)
+	require.Len(t, rsts, 1)
+	require.Equal(t, xid2, rsts[0].Xid)
+	require.False(t, rsts[0].Relaxed)
+	require.InDelta(t, 2/math.Sqrt(1.09), rsts[0].Distance, 1e-5)
+
+	rsts, err = vdbl.SearchWithOptions(xq, SearchOptions{MinResults: 2, Weighted: true, PenalizeWeight: true})
+	require.NoError(t, err)
+	require.Len(t, rsts, 2)
+	require.Equal(t, xid1, rsts[0].Xid)
+	require.Equal(t, xid2, rsts[1].Xid)
+
+	// the weight is persisted to redis
+	vdbl2, err := NewVectoDBLite(redisAddr, liteDbID, liteDim, liteThr, liteLimit, false)
+	require.NoError(t, err)
+	defer vdbl2.Destroy()
+	rsts, err = vdbl2.SearchWithOptions(xq, SearchOptions{MinResults: 1, Weighted: true})
+	require.NoError(t, err)
+	require.Equal(t, xid2, rsts[0].Xid)
+
+	_, err = vdbl.SearchWithOptions(xq, SearchOptions{Weighted: true, GroupBy: true, GroupTopK: 1})
+	require.Error(t, err)
+
+	// the weight is set along with the add
+	xid3 := xid2 + 1
+	require.Error(t, vdbl.AddWithIdGroupWeight(xb1, xid3, 0, -1))
+	require.NoError(t, vdbl.AddWithIdGroupWeight(xb1, xid3, 0, 3))
+	rsts, err = vdbl.SearchWithOptions(xq, SearchOptions{MinResults: 1, Weighted: true})
+	require.NoError(t, err)
+	require.Equal(t, xid3, rsts[0].Xid)
+
+	// a larger weight ranks higher for negative distances as well
+	require.True(t, weighDistance(-0.5, 2, false) > weighDistance(-0.5, 1, false))
+	require.True(t, weighDistance(-0.5, 2, true) < weighDistance(-0.5, 1, true))
+}
+
+func TestVectoDBLiteConfigMismatch(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	_, err := vdbl.Add(genLiteVec())
+	require.NoError(t, err)
+	require.NoError(t, vdbl.Destroy())
+
+	_, err = NewVectoDBLite(redisAddr, liteDbID, 2*liteDim, liteThr, liteLimit, false)
+	require.Equal(t, ErrConfigMismatch, errors.Cause(err))
+}
+
+func TestVectoDBLiteAddBatch(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xbs := make([][]float32, 10)
+	for i := range xbs {
+		xbs[i] = genLiteVec()
+	}
+	xids, err := vdbl.AddBatch(xbs)
+	require.NoError(t, err)
+	require.Len(t, xids, len(xbs))
+	require.Equal(t, len(xbs), vdbl.Size())
+	for i := range xbs {
+		require.Equal(t, HashVector(xbs[i]), xids[i])
+		xid, _, err := vdbl.Search(xbs[i])
+		require.NoError(t, err)
+		require.Equal(t, xids[i], xid)
+	}
+
+	_, err = vdbl.AddBatch([][]float32{genLiteVec(), {1, 2}})
+	require.Error(t, err)
+}
+
+func TestVectoDBLiteAddWithTTL(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xb := genLiteVec()
+	xid, err := vdbl.Add(xb)
+	require.NoError(t, err)
+	xbTTL := genLiteVec()
+	xidTTL, err := vdbl.AddWithTTL(xbTTL, time.Second)
+	require.NoError(t, err)
+	_, err = vdbl.AddWithTTL(genLiteVec(), 0)
+	require.Error(t, err)
+
+	found, _, err := vdbl.Search(xbTTL)
+	require.NoError(t, err)
+	require.Equal(t, xidTTL, found)
+
+	// searches skip it at once after it expires, regardless of the sweep
+	time.Sleep(2100 * time.Millisecond)
+	found, _, err = vdbl.Search(xbTTL)
+	require.NoError(t, err)
+	require.Equal(t, ^uint64(0), found)
+	found, _, err = vdbl.Search(xb)
+	require.NoError(t, err)
+	require.Equal(t, xid, found)
+
+	vdbl.purgeExpired()
+	require.False(t, vdbl.Contains(xidTTL))
+	require.Equal(t, 1, vdbl.Size())
+	exists, err := vdbl.rcli.HExists(vdbl.dbKey, getXidKey(xidTTL)).Result()
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestVectoDBLiteEviction(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xbs := make([][]float32, liteLimit+1)
+	xids := make([]uint64, liteLimit+1)
+	var err error
+	for i := range xbs {
+		xbs[i] = genLiteVec()
+		xids[i], err = vdbl.Add(xbs[i])
+		require.NoError(t, err)
+	}
+	// the least recently used one is evicted
+	require.Equal(t, liteLimit, vdbl.Size())
+	require.False(t, vdbl.Contains(xids[0]))
+	for _, xid := range xids[1:] {
+		require.True(t, vdbl.Contains(xid))
+	}
+	size, err := vdbl.rcli.HLen(vdbl.dbKey).Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(liteLimit), size)
+
+	vdbl.SetEvictionPolicy(RejectAdds)
+	_, err = vdbl.Add(xbs[0])
+	require.Equal(t, ErrSizeLimit, errors.Cause(err))
+	_, err = vdbl.AddBatch([][]float32{xbs[0]})
+	require.Equal(t, ErrSizeLimit, errors.Cause(err))
+	// overwriting a present vector is fine
+	err = vdbl.AddWithId(xbs[1], xids[1])
+	require.NoError(t, err)
+	require.Equal(t, liteLimit, vdbl.Size())
+	require.True(t, vdbl.Contains(xids[1]))
+}
+
+func TestVectoDBLiteMemStore(t *testing.T) {
+	// no redis is needed
+	store := NewMemLiteStore()
+	vdbl, err := NewVectoDBLiteWithStore(store, liteDbID, liteDim, liteThr, liteLimit, LiteIndexKeyFlat, false)
+	require.NoError(t, err)
+	xbs := make([][]float32, 10)
+	for i := range xbs {
+		xbs[i] = genLiteVec()
+	}
+	xids, err := vdbl.AddBatch(xbs)
+	require.NoError(t, err)
+	require.NoError(t, vdbl.Delete(xids[0]))
+	require.NoError(t, vdbl.SetReadOnly(true))
+	require.NoError(t, vdbl.Destroy())
+
+	// reacquire from the store, the deleted one is purged
+	vdbl, err = NewVectoDBLiteWithStore(store, liteDbID, liteDim, liteThr, liteLimit, LiteIndexKeyFlat, false)
+	require.NoError(t, err)
+	require.Equal(t, len(xbs)-1, vdbl.Size())
+	require.False(t, vdbl.ReadOnly())
+	for i := 1; i < len(xbs); i++ {
+		xid, _, err := vdbl.Search(xbs[i])
+		require.NoError(t, err)
+		require.Equal(t, xids[i], xid)
+	}
+	vtB, err := store.Get(getXidKey(xids[0]))
+	require.NoError(t, err)
+	require.Nil(t, vtB)
+	require.NoError(t, vdbl.Destroy())
+
+	// fresh wipes the store
+	vdbl, err = NewVectoDBLiteWithStore(store, liteDbID, liteDim, liteThr, liteLimit, LiteIndexKeyFlat, true)
+	require.NoError(t, err)
+	defer vdbl.Destroy()
+	require.Equal(t, 0, vdbl.Size())
+	vtB, err = store.Get(getXidKey(xids[1]))
+	require.NoError(t, err)
+	require.Nil(t, vtB)
+}
+
+func TestVectoDBLiteKeyPrefix(t *testing.T) {
+	const keyPrefix = "vdbl:test:"
+	vdbl, err := NewVectoDBLiteWithRedisOptions(RedisOptions{Addr: redisAddr, KeyPrefix: keyPrefix}, liteDbID, liteDim, liteThr, liteLimit, LiteIndexKeyFlat, true)
+	require.NoError(t, err)
+	defer vdbl.Destroy()
+	_, err = vdbl.Add(genLiteVec())
+	require.NoError(t, err)
+	require.NoError(t, vdbl.SetReadOnly(true))
+
+	dbKey := keyPrefix + getDbKey(liteDbID)
+	size, err := vdbl.rcli.HLen(dbKey).Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), size)
+	n, err := vdbl.rcli.Exists(dbKey + "_readonly").Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+	_, err = vdbl.rcli.Del(dbKey, dbKey+"_readonly").Result()
+	require.NoError(t, err)
+}
+
+func TestVectoDBLiteCountListIds(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xbs := make([][]float32, liteLimit)
+	for i := range xbs {
+		xbs[i] = genLiteVec()
+	}
+	xids, err := vdbl.AddBatch(xbs)
+	require.NoError(t, err)
+	require.NoError(t, vdbl.Delete(xids[0]))
+
+	count, err := vdbl.Count()
+	require.NoError(t, err)
+	require.Equal(t, liteLimit-1, count)
+	require.Equal(t, liteLimit, vdbl.Size())
+	listed, err := vdbl.ListIds()
+	require.NoError(t, err)
+	sort.Slice(listed, func(i, j int) bool { return listed[i] < listed[j] })
+	want := append([]uint64(nil), xids[1:]...)
+	sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+	require.Equal(t, want, listed)
+}
+
+func TestVectoDBLiteSearchBatch(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xbs := make([][]float32, 10)
+	for i := range xbs {
+		xbs[i] = genLiteVec()
+	}
+	xids, err := vdbl.AddBatch(xbs)
+	require.NoError(t, err)
+
+	rstss, err := vdbl.SearchBatchWithOptions(xbs, SearchOptions{})
+	require.NoError(t, err)
+	require.Equal(t, len(xbs), len(rstss))
+	for i, rsts := range rstss {
+		require.NotEmpty(t, rsts)
+		require.Equal(t, xids[i], rsts[0].Xid)
+	}
+
+	_, err = vdbl.SearchBatchWithOptions([][]float32{xbs[0], make([]float32, liteDim)}, SearchOptions{})
+	require.Error(t, err)
+}
+
+func TestVectoDBLiteClear(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xb := genLiteVec()
+	xid, err := vdbl.Add(xb)
+	require.NoError(t, err)
+	_, err = vdbl.Add(genLiteVec())
+	require.NoError(t, err)
+
+	require.NoError(t, vdbl.Clear())
+	require.Equal(t, 0, vdbl.Size())
+	found, _, err := vdbl.Search(xb)
+	require.NoError(t, err)
+	require.Equal(t, ^uint64(0), found)
+	listed, err := vdbl.ListIds()
+	require.NoError(t, err)
+	require.Empty(t, listed)
+
+	// the db is usable after clearing
+	xid2, err := vdbl.Add(xb)
+	require.NoError(t, err)
+	require.Equal(t, xid, xid2)
+	found, _, err = vdbl.Search(xb)
+	require.NoError(t, err)
+	require.Equal(t, xid, found)
+}
+
+func TestVectoDBLiteDeleteIds(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xids := make([]uint64, 3)
+	for i := range xids {
+		xid, err := vdbl.Add(genLiteVec())
+		require.NoError(t, err)
+		xids[i] = xid
+	}
+	require.NoError(t, vdbl.Delete(xids[2]))
+
+	absent := xids[0] + 1000
+	numDeleted, notFound, err := vdbl.DeleteIds([]uint64{xids[0], xids[1], xids[2], absent, xids[0]})
+	require.NoError(t, err)
+	require.Equal(t, 2, numDeleted)
+	require.Equal(t, []uint64{xids[2], absent}, notFound)
+	require.False(t, vdbl.Contains(xids[0]))
+	require.False(t, vdbl.Contains(xids[1]))
+}
+
+// putFailingStore fails writes once failPut is set.
+type putFailingStore struct {
+	LiteStore
+	failPut bool
+}
+
+func (s *putFailingStore) Put(keys []string, values [][]byte) error {
+	if s.failPut {
+		return errors.New("put failed")
+	}
+	return s.LiteStore.Put(keys, values)
+}
+
+func TestVectoDBLiteDeleteStoreError(t *testing.T) {
+	store := &putFailingStore{LiteStore: NewMemLiteStore()}
+	vdbl, err := NewVectoDBLiteWithStore(store, liteDbID, liteDim, liteThr, liteLimit, LiteIndexKeyFlat, true)
+	require.NoError(t, err)
+	defer vdbl.Destroy()
+	xids := make([]uint64, 2)
+	for i := range xids {
+		xids[i], err = vdbl.Add(genLiteVec())
+		require.NoError(t, err)
+	}
+
+	// a deletion which isn't persisted isn't visible either
+	store.failPut = true
+	require.Error(t, vdbl.Delete(xids[0]))
+	_, _, err = vdbl.DeleteIds(xids)
+	require.Error(t, err)
+	for _, xid := range xids {
+		require.True(t, vdbl.Contains(xid))
+	}
+	count, err := vdbl.Count()
+	require.NoError(t, err)
+	require.Equal(t, len(xids), count)
+
+	store.failPut = false
+	require.NoError(t, vdbl.Delete(xids[0]))
+	require.False(t, vdbl.Contains(xids[0]))
+}
+
+func TestVectoDBLiteRemove(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xbs := make([][]float32, 3)
+	xids := make([]uint64, 3)
+	var err error
+	for i := range xbs {
+		xbs[i] = genLiteVec()
+		xids[i], err = vdbl.Add(xbs[i])
+		require.NoError(t, err)
+	}
+	require.NoError(t, vdbl.Remove(xids[0]))
+	require.NoError(t, vdbl.Remove(xids[0]))
+	require.Equal(t, 2, vdbl.Size())
+	require.False(t, vdbl.Contains(xids[0]))
+	exists, err := vdbl.rcli.HExists(vdbl.dbKey, getXidKey(xids[0])).Result()
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// it's still in IndexFlat until the next rebuild, but never found
+	rsts, err := vdbl.SearchWithOptions(xbs[0], SearchOptions{MinResults: 3, IncludeDeleted: true})
+	require.NoError(t, err)
+	require.Len(t, rsts, 2)
+	for _, rst := range rsts {
+		require.NotEqual(t, xids[0], rst.Xid)
+	}
+	// skipped candidates are counted even if they're evicted
+	require.Equal(t, int64(1), vdbl.NumDangling())
+	require.True(t, vdbl.RebuildPending())
+}
+
+func TestVectoDBLiteDangling(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+
+	xb := genLiteVec()
+	xid, err := vdbl.Add(xb)
+	require.NoError(t, err)
+	// an add which is applied to IndexFlat only
+	dangling := genLiteVec()
+	vdbl.addFlat([]uint64{xid + 1}, dangling)
+
+	rsts, err := vdbl.SearchWithOptions(dangling, SearchOptions{MinResults: 2})
+	require.NoError(t, err)
+	require.Len(t, rsts, 1)
+	require.Equal(t, xid, rsts[0].Xid)
+	require.Equal(t, int64(1), vdbl.NumDangling())
+
+	numDangling, err := vdbl.RepairDangling()
+	require.NoError(t, err)
+	require.Equal(t, 1, numDangling)
+	rsts, err = vdbl.SearchWithOptions(dangling, SearchOptions{MinResults: 2})
+	require.NoError(t, err)
+	require.Len(t, rsts, 1)
+	require.Equal(t, int64(1), vdbl.NumDangling(), "the dangling xid shall be removed")
+	numDangling, err = vdbl.RepairDangling()
+	require.NoError(t, err)
+	require.Equal(t, 0, numDangling)
+}
+
+func TestVectoDBLitePromote(t *testing.T) {
+	vdbl := newTestVectoDBLite(t)
+	defer vdbl.Destroy()
+	xid, err := vdbl.Add(genLiteVec())
+	require.NoError(t, err)
+	standby, err := NewVectoDBLiteStandby(redisAddr, liteDbID, liteDim, liteThr, liteLimit, LiteIndexKeyFlat)
+	require.NoError(t, err)
+	defer standby.Destroy()
+	require.True(t, standby.Contains(xid))
+
+	// changes aren't published, the same as lost ones
+	xb := genLiteVec()
+	xid2, err := vdbl.Add(xb)
+	require.NoError(t, err)
+	require.NoError(t, vdbl.Remove(xid))
+	require.False(t, standby.Contains(xid2))
+	require.NoError(t, standby.Promote())
+	require.True(t, standby.Contains(xid2))
+	require.False(t, standby.Contains(xid))
+	rsts, err := standby.SearchWithOptions(xb, SearchOptions{MinResults: 2})
+	require.NoError(t, err)
+	require.Len(t, rsts, 1)
+	require.Equal(t, xid2, rsts[0].Xid)
+}